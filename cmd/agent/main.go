@@ -37,25 +37,71 @@ func main() {
 	}()
 	config := agent.GetConfig()
 
-	collector := agent.NewRuntimeMetricsCollector()
+	collector, err := agent.NewCollector(config.GetCollector())
+	if err != nil {
+		customLoger.Fatalf("invalid collector: %v", err)
+	}
+
+	var sources []model.MetricsSource
+	if targets := config.GetScrapeTargets(); len(targets) > 0 {
+		scraper, err := agent.NewScrapeCollector(targets, config.GetScrapeTimeout())
+		if err != nil {
+			customLoger.Fatalf("invalid scrape targets: %v", err)
+		}
+		sources = append(sources, scraper)
+	}
+	if filter := config.GetProcessFilter(); !filter.IsEmpty() {
+		processes, err := agent.NewProcessCollector(filter)
+		if err != nil {
+			customLoger.Fatalf("invalid process filter: %v", err)
+		}
+		sources = append(sources, processes)
+	}
+	if path := config.GetCgroupPath(); path != "" {
+		cgroup, err := agent.NewCgroupCollector(path)
+		if err != nil {
+			customLoger.Fatalf("invalid cgroup path: %v", err)
+		}
+		sources = append(sources, cgroup)
+	}
+	if len(sources) > 0 {
+		collector = agent.NewCompositeCollector(collector, sources...)
+	}
 
 	var sender model.MetricsSender
-	var err error
 
-	if config.GetGRPCAddr() != "" {
-		sender, err = agent.NewGRPCSender(config.GetGRPCAddr())
+	if len(config.GetEndpoints()) > 0 {
+		var endpoints []agent.Endpoint
+		endpoints, err = config.NewEndpoints()
+		if err == nil {
+			sender, err = agent.NewMultiSender(config.GetSendMode(), endpoints...)
+		}
+	} else if config.GetGRPCAddr() != "" {
+		var grpcOpts []agent.GRPCOption
+		grpcOpts, err = config.GRPCOptions()
+		if err == nil {
+			sender, err = agent.NewGRPCSender(config.GetGRPCAddr(), grpcOpts...)
+		}
 	} else {
-		sender, err = agent.NewHTTPSender(
-			config.GetServerURL(),
-			config.GetHash(),
-			config.GetCryptoKey(),
-		)
+		sender, err = config.NewHTTPSender(config.GetServerURL())
 	}
 
 	if err != nil {
 		customLoger.Fatalf("failed to create sender: %v", err)
 	}
-	metricsAgent := agent.NewAgent(collector, sender, config)
+	var opts []agent.Option
+	if config.GetSelfTelemetry() {
+		opts = append(opts, agent.WithSelfTelemetry())
+	}
+	if config.GetLocalAddress() != "" || config.GetLocalSocket() != "" {
+		receiver, err := agent.NewLocalReceiver(config.GetLocalAddress(), config.GetLocalSocket())
+		if err != nil {
+			customLoger.Fatalf("invalid local receiver config: %v", err)
+		}
+		opts = append(opts, agent.WithLocalReceiver(receiver))
+	}
+
+	metricsAgent := agent.NewAgent(collector, sender, config, opts...)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 	defer stop()
@@ -0,0 +1,59 @@
+// cmd/auditverify/main.go
+//
+// Проверка целостности журнала аудита:
+//
+//	auditverify -file audit.log -key secret
+//
+// Проверяет цепочку хэшей записей и подписи контрольных точек во всех
+// ротированных сегментах журнала и в текущем файле, включая ссылки между
+// ними, и печатает отчёт в JSON. При нарушенной цепочке сообщает первую
+// сломанную запись и завершается с ненулевым кодом. Ключ можно задать
+// через AUDIT_SIGNING_KEY.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
+)
+
+var customLogger = logger.NewHTTPLogger().Logger.Sugar()
+
+var errChainBroken = errors.New("audit chain is broken")
+
+func main() {
+	file := flag.String("file", "", "путь к журналу аудита")
+	key := flag.String("key", os.Getenv("AUDIT_SIGNING_KEY"), "ключ подписи контрольных точек")
+	flag.Parse()
+
+	if err := run(*file, *key, os.Stdout); err != nil {
+		customLogger.Fatalf("%v", err)
+	}
+}
+
+// проверяет журнал и печатает отчёт в out
+func run(path, key string, out io.Writer) error {
+	if path == "" {
+		return errors.New("-file is required")
+	}
+	report, err := audit.VerifyJournal(path, key)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	if !report.OK {
+		return fmt.Errorf("%w: %s line %d, seq %d: %s", errChainBroken, report.BrokenFile, report.BrokenLine, report.BrokenSeq, report.Reason)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+)
+
+func writeLog(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: "secret", CheckpointEvery: 2}
+	for i := 0; i < 3; i++ {
+		if err := receiver.Notify(&audit.Event{Timestamp: int64(i), Action: audit.ActionUpdate, Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	path := writeLog(t)
+
+	var out bytes.Buffer
+	if err := run(path, "secret", &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), `"ok": true`) {
+		t.Fatalf("unexpected report: %s", out.String())
+	}
+}
+
+func TestRun_Broken(t *testing.T) {
+	path := writeLog(t)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte(`"ts":1`), []byte(`"ts":2`), 1)
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = run(path, "secret", &out)
+	if !errors.Is(err, errChainBroken) {
+		t.Fatalf("expected errChainBroken, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected broken line 2, got %v", err)
+	}
+}
+
+func TestRun_Segments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: "secret", MaxSize: 1}
+	for i := 0; i < 3; i++ {
+		if err := receiver.Notify(&audit.Event{Timestamp: int64(i), Action: audit.ActionUpdate, Status: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	receiver.Close()
+
+	var out bytes.Buffer
+	if err := run(path, "secret", &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), `"records": 3`) {
+		t.Fatalf("expected all segments verified: %s", out.String())
+	}
+
+	// подмена в ротированном сегменте, а не в текущем файле
+	segments, err := audit.Segments(path)
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("expected segments, got %v, %v", segments, err)
+	}
+	content, err := os.ReadFile(segments[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content = bytes.Replace(content, []byte(`"ts":0`), []byte(`"ts":7`), 1)
+	if err := os.WriteFile(segments[0].Path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = run(path, "secret", &bytes.Buffer{})
+	if !errors.Is(err, errChainBroken) {
+		t.Fatalf("expected errChainBroken, got %v", err)
+	}
+	if !strings.Contains(err.Error(), segments[0].Path) {
+		t.Fatalf("expected broken segment in error, got %v", err)
+	}
+}
+
+func TestRun_NoFile(t *testing.T) {
+	if err := run("", "", &bytes.Buffer{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -2,8 +2,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -11,14 +12,21 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
 	config "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/config"
 	db "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/config/db"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 	grpcserver "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/grpc"
 	httpserver "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
-	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
 	memory "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/memory"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/postgres"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
 	service "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/tlsconfig"
 	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 )
 
@@ -75,24 +83,121 @@ func main() {
 
 	svc := service.NewMetricsService(repo)
 
+	// шаблон имён и лимиты числа рядов
+	seriesPolicy, err := series.New(cfg.SeriesConfig())
+	if err != nil {
+		customLogger.Fatalf("invalid series policy: %v", err)
+	}
+
+	// ограничения приёма; отклонённые запросы периодически записываются как метрики сервера
+	reqLimits := limits.New(cfg.LimitsConfig())
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if metrics := reqLimits.Snapshot(); len(metrics) > 0 {
+					if err := svc.UpdateMetricsBatch(appCtx, metrics); err != nil {
+						customLogger.Warnf("Не удалось записать метрики ограничений: %v", err)
+					}
+				}
+			case <-appCtx.Done():
+				return
+			}
+		}
+	}()
+
+	// ключи подписи и расшифровки, перечитываются по SIGHUP для ротации без перезапуска
+	keys, err := keyring.New(cfg.KeyringConfig())
+	if err != nil {
+		customLogger.Fatalf("failed to load keys: %v", err)
+	}
+	authenticator, err := newAuthenticator(appCtx, cfg, usePostgreSQL)
+	if err != nil {
+		customLogger.Fatalf("failed to init token auth: %v", err)
+	}
+	if authenticator != nil {
+		customLogger.Infof("Доступ по API токенам включён, хранилище: %s", cfg.AuthStoreKind())
+	}
+
+	// заголовки с адресом клиента принимаются только от этих прокси
+	proxies, err := clientip.NewResolver(cfg.TrustedProxies)
+	if err != nil {
+		customLogger.Fatalf("invalid trusted proxies: %v", err)
+	}
+
+	// разрешённые и запрещённые подсети по классам маршрутов, перечитываются по SIGHUP
+	policy, err := netpolicy.New(cfg.SubnetPolicyConfig())
+	if err != nil {
+		customLogger.Fatalf("invalid subnet policy: %v", err)
+	}
+
+	// журнал аудита; по SIGHUP файл переоткрывается после внешней ротации
+	auditFile := cfg.AuditFileReceiver()
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+	go func() {
+		for range hupCh {
+			if err := keys.Reload(); err != nil {
+				customLogger.Errorf("Не удалось перечитать ключи, используются прежние: %v", err)
+			} else {
+				customLogger.Infof("Ключи перечитаны, приватные ключи: %v", keys.KeyIDs())
+			}
+			if err := policy.Reload(); err != nil {
+				customLogger.Errorf("Не удалось перечитать политику подсетей, используется прежняя: %v", err)
+			} else {
+				customLogger.Info("Политика подсетей перечитана")
+			}
+			if err := auditFile.Reopen(); err != nil {
+				customLogger.Errorf("Не удалось переоткрыть журнал аудита: %v", err)
+			}
+		}
+	}()
+
+	// хранилище восстанавливается до запуска слушателей: иначе запросы успели бы
+	// записать метрики до загрузки файла, а лимит рядов не знал бы о загруженных
+	if !usePostgreSQL && cfg.Restore && cfg.FileStoragePath != "" {
+		loadCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		customLogger.Infof("Загрузка метрик из файла: %s", cfg.FileStoragePath)
+		if err := svc.LoadFromFile(loadCtx, cfg.FileStoragePath); err != nil {
+			customLogger.Infof("Ошибка загрузки метрик: %v", err)
+		}
+		cancel()
+	}
+	// ряды, уже лежащие в хранилище, считаются существующими и всегда принимают обновления
+	seriesPolicy.Seed(repo.GetAll(appCtx))
+	customLogger.Infof("Известных рядов метрик: %d", seriesPolicy.Len())
+
 	var grpcSrv *grpcserver.Server
 	if cfg.GRPCAddress != "" {
 		grpcHandler := grpcserver.NewMetricsHandler(svc)
+		grpcHandler.SetLimits(reqLimits)
+		grpcHandler.SetSeriesPolicy(seriesPolicy)
 
-		// парсим trusted subnet в *net.IPNet
-		var subnet *net.IPNet
-		if cfg.TrustedSubnet != "" {
-			_, parsed, err := net.ParseCIDR(cfg.TrustedSubnet)
+		grpcOpts := []grpcserver.Option{
+			grpcserver.WithKeyring(keys),
+			grpcserver.WithAuthenticator(authenticator),
+			grpcserver.WithTrustedProxies(proxies),
+			grpcserver.WithSubnetPolicy(policy),
+			grpcserver.WithLimits(reqLimits),
+			grpcserver.WithReplayWindow(time.Duration(cfg.HashReplayWindow) * time.Second),
+		}
+		if cfg.UseTLS() {
+			tlsCfg, err := tlsconfig.Server(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
 			if err != nil {
-				customLogger.Fatalf("invalid trusted subnet: %v", err)
+				customLogger.Fatalf("invalid TLS config: %v", err)
 			}
-			subnet = parsed
+			grpcOpts = append(grpcOpts, grpcserver.WithTLS(tlsCfg))
 		}
 
 		grpcSrv = grpcserver.New(
 			cfg.GRPCAddress,
 			grpcHandler,
-			subnet,
+			nil,
+			grpcOpts...,
 		)
 
 		customLogger.Infof("gRPC сервер слушает %s", cfg.GRPCAddress)
@@ -103,24 +208,34 @@ func main() {
 		}()
 	}
 
-	if !usePostgreSQL && cfg.Restore && cfg.FileStoragePath != "" {
-		loadCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		customLogger.Infof("Загрузка метрик из файла: %s", cfg.FileStoragePath)
-		if err := svc.LoadFromFile(loadCtx, cfg.FileStoragePath); err != nil {
-			customLogger.Infof("Ошибка загрузки метрик: %v", err)
-		}
-		cancel()
+	h := httpserver.NewHandler(svc, httpserver.WithLimits(reqLimits), httpserver.WithSeriesPolicy(seriesPolicy))
+	var auditReceivers []audit.Receiver
+	if auditFile != nil {
+		auditReceivers = append(auditReceivers, auditFile)
 	}
-
-	h := httpserver.NewHandler(svc)
-	var auditReceivers []middleware.AuditReceiver
-	if cfg.AuditFile != "" {
-		auditReceivers = append(auditReceivers, &middleware.FileAuditReceiver{FilePath: cfg.AuditFile})
+	auditStore, err := newAuditStore(cfg, usePostgreSQL)
+	if err != nil {
+		customLogger.Fatalf("failed to init audit store: %v", err)
+	}
+	if pgStore, ok := auditStore.(*audit.PostgresStore); ok {
+		auditReceivers = append(auditReceivers, pgStore)
+	}
+	if (auditStore != nil || cfg.AuditFile != "") && authenticator == nil {
+		customLogger.Warn("API журнала аудита (/audit, /audit/verify) выключен: требуется доступ по токенам (AUTH_STORE)")
 	}
 	if cfg.AuditURL != "" {
-		auditReceivers = append(auditReceivers, &middleware.URLAuditReceiver{URL: cfg.AuditURL})
+		auditReceivers = append(auditReceivers, &audit.URLReceiver{URL: cfg.AuditURL, Retries: cfg.AuditURLRetries})
 	}
-	r := httpserver.NewRouter(h, cfg.HashKey, auditReceivers, cfg.CryptoKey, cfg.TrustedSubnet)
+	// события доставляются в фоне, при остановке очередь дописывается
+	auditor := audit.NewDispatcher(auditReceivers, cfg.AuditQueueSize)
+	r := httpserver.NewRouter(h, keys, auditor, policy, cfg.CryptoLegacy,
+		httpserver.WithHashOptions(cfg.HashOptions()...),
+		httpserver.WithAuth(authenticator),
+		httpserver.WithTrustedProxies(proxies),
+		httpserver.WithRequestLimits(reqLimits),
+		httpserver.WithAuditLog(cfg.AuditFile, cfg.AuditSigningKey),
+		httpserver.WithAuditStore(auditStore),
+	)
 
 	var ticker *time.Ticker
 	if !usePostgreSQL && cfg.FileStoragePath != "" {
@@ -142,10 +257,25 @@ func main() {
 		IdleTimeout:  time.Duration(cfg.IdleTimeout) * time.Second,
 	}
 
+	if cfg.UseTLS() {
+		tlsCfg, err := tlsconfig.Server(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			customLogger.Fatalf("invalid TLS config: %v", err)
+		}
+		server.TLSConfig = tlsCfg
+	}
+
 	errCh := make(chan error, 1)
 	go func() {
 		customLogger.Infof("Сервер запущен на %s", cfg.Address)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			// сертификаты уже загружены в TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			errCh <- err
 			return
 		}
@@ -179,6 +309,16 @@ func main() {
 		grpcSrv.Stop()
 	}
 
+	if err := auditor.Close(shutdownCtx); err != nil {
+		customLogger.Warnf("Не все события аудита доставлены: %v", err)
+	}
+	if dropped := auditor.Dropped(); dropped > 0 {
+		customLogger.Warnf("Событий аудита отброшено из-за переполнения очереди: %d", dropped)
+	}
+	if err := auditFile.Close(); err != nil {
+		customLogger.Errorf("Ошибка закрытия журнала аудита: %v", err)
+	}
+
 	if !usePostgreSQL && cfg.FileStoragePath != "" {
 		customLogger.Info("Сохранение метрик...")
 		if err := svc.SaveToFile(shutdownCtx, cfg.FileStoragePath); err != nil {
@@ -188,3 +328,69 @@ func main() {
 
 	customLogger.Info("Сервер остановлен")
 }
+
+// хранилище поиска событий аудита; nil, если поиск выключен.
+// события в postgres пишет само хранилище, поэтому оно же добавляется в получатели.
+func newAuditStore(cfg *config.Config, usePostgreSQL bool) (audit.Store, error) {
+	switch kind := cfg.AuditStoreKind(); kind {
+	case "":
+		return nil, nil
+	case "file":
+		if cfg.AuditFile == "" {
+			return nil, errors.New("file audit store requires AUDIT_FILE")
+		}
+		return &audit.FileStore{Path: cfg.AuditFile}, nil
+	case "postgres":
+		if !usePostgreSQL {
+			return nil, errors.New("postgres audit store requires a working DATABASE_DSN")
+		}
+		return audit.NewPostgresStore(db.GetDB()), nil
+	default:
+		return nil, fmt.Errorf("unknown audit store %q, expected file or postgres", kind)
+	}
+}
+
+// куда выводится значение созданного токена администратора
+var bootstrapTokenOutput io.Writer = os.Stderr
+
+// аутентификатор по хранилищу токенов из конфига; nil, если доступ по токенам выключен.
+// в пустом хранилище создаётся токен администратора, иначе выдать первый токен было бы некому.
+func newAuthenticator(ctx context.Context, cfg *config.Config, usePostgreSQL bool) (*auth.Authenticator, error) {
+	var store auth.Store
+	switch kind := cfg.AuthStoreKind(); kind {
+	case "":
+		return nil, nil
+	case "file":
+		if cfg.AuthTokensFile == "" {
+			return nil, errors.New("file token store requires AUTH_TOKENS_FILE")
+		}
+		fileStore, err := auth.NewFileStore(cfg.AuthTokensFile)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	case "postgres":
+		if !usePostgreSQL {
+			return nil, errors.New("postgres token store requires a working DATABASE_DSN")
+		}
+		store = auth.NewPostgresStore(db.GetDB())
+	default:
+		return nil, fmt.Errorf("unknown token store %q, expected file or postgres", kind)
+	}
+
+	a := auth.NewAuthenticator(store)
+	tokens, err := a.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		raw, token, err := a.Issue(ctx, "bootstrap", []auth.Scope{auth.ScopeAdmin}, "")
+		if err != nil {
+			return nil, err
+		}
+		// значение токена не попадает в журнал, который уходит в сбор и хранение логов
+		fmt.Fprintf(bootstrapTokenOutput, "Создан токен администратора, он показывается один раз: %s\n", raw)
+		logger.NewHTTPLogger().Logger.Sugar().Warnf("Создан токен администратора %s, значение выведено в stderr", token.ID)
+	}
+	return a, nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -14,6 +17,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/config"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/mocks"
 )
 
@@ -214,3 +218,26 @@ func TestTemporaryFiles(t *testing.T) {
 		assert.Greater(t, fileInfo.Size(), int64(0))
 	})
 }
+
+func TestNewAuthenticator_BootstrapToken(t *testing.T) {
+	var out bytes.Buffer
+	bootstrapTokenOutput = &out
+	t.Cleanup(func() { bootstrapTokenOutput = os.Stderr })
+
+	cfg := &config.Config{AuthTokensFile: filepath.Join(t.TempDir(), "tokens.json")}
+	a, err := newAuthenticator(context.Background(), cfg, false)
+	require.NoError(t, err)
+	require.NotNil(t, a)
+
+	// значение токена выводится только в bootstrapTokenOutput, один раз
+	line := strings.TrimSpace(out.String())
+	raw := line[strings.LastIndex(line, " ")+1:]
+	token, err := a.Authenticate(context.Background(), raw)
+	require.NoError(t, err)
+	assert.Equal(t, "bootstrap", token.Name)
+
+	out.Reset()
+	_, err = newAuthenticator(context.Background(), cfg, false)
+	require.NoError(t, err)
+	assert.Empty(t, out.String(), "в непустом хранилище токен не создаётся")
+}
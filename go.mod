@@ -10,6 +10,7 @@ require (
 	github.com/go-chi/chi/v5 v5.2.3
 	github.com/go-resty/resty/v2 v2.16.5
 	github.com/golang-migrate/migrate/v4 v4.19.0
+	github.com/golang/snappy v1.0.0
 	github.com/gostaticanalysis/nilerr v0.1.2
 	github.com/gostaticanalysis/sqlrows v0.0.0-20231116101209-5091a5920ea6
 	github.com/ilyakaznacheev/cleanenv v1.5.0
@@ -5,6 +5,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,15 +22,43 @@ type Agent struct {
 	config    model.ConfigProvider
 	rateLimit int
 	cryptokey string
+	local     *LocalReceiver
+	stats     *Stats
 }
 
-func NewAgent(collector model.MetricsCollector, sender model.MetricsSender, config model.ConfigProvider) *Agent {
-	return &Agent{
+// необязательные настройки агента.
+type Option func(*Agent)
+
+// включает локальный приём метрик от приложений на этом хосте.
+func WithLocalReceiver(lr *LocalReceiver) Option {
+	return func(a *Agent) {
+		a.local = lr
+	}
+}
+
+// включает отправку собственной статистики агента с префиксом SelfTelemetryPrefix.
+func WithSelfTelemetry() Option {
+	return func(a *Agent) {
+		a.stats = NewStats()
+	}
+}
+
+func NewAgent(collector model.MetricsCollector, sender model.MetricsSender, config model.ConfigProvider, opts ...Option) *Agent {
+	a := &Agent{
 		collector: collector,
 		sender:    sender,
 		config:    config,
 		rateLimit: config.GetRateLimit(),
 	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// возвращает статистику отправки, nil если самотелеметрия выключена.
+func (a *Agent) Stats() *Stats {
+	return a.stats
 }
 
 func (a *Agent) Start(ctx context.Context) error {
@@ -76,6 +105,13 @@ func (a *Agent) Start(ctx context.Context) error {
 		}
 	})
 
+	// Локальный приём метрик от приложений, они попадают в общий батч
+	if a.local != nil {
+		g.Go(func() error {
+			return a.local.Serve(gctx, collectedMetrics.Append)
+		})
+	}
+
 	// 3. Worker pool для отправки с rate limit
 	for i := 0; i < a.rateLimit; i++ {
 		g.Go(func() error {
@@ -92,7 +128,21 @@ func (a *Agent) Start(ctx context.Context) error {
 			case <-gctx.Done():
 				return nil
 			case <-reportTicker.C:
+				// сервер недоступен: метрики копятся в буфере до пробного запроса
+				if a.circuitOpen() {
+					castomLogger.Infof("Circuit breaker open, holding %d metrics", collectedMetrics.Len())
+					continue
+				}
+				spool := collectedMetrics.Len()
 				batch := collectedMetrics.GetAndClear()
+				// статистика агента едет только вместе с собранными метриками:
+				// сама по себе она не должна вызывать отправку каждый цикл
+				if a.stats != nil && batch != nil && len(batch.Item) > 0 {
+					batch.Item = append(batch.Item, a.stats.Snapshot(spool, len(metricsCh))...)
+					if self, ok := a.sender.(interface{ SelfMetrics() []model.Metrics }); ok {
+						batch.Item = append(batch.Item, self.SelfMetrics()...)
+					}
+				}
 				if batch == nil || len(batch.Item) == 0 {
 					collectedMetrics.PutBatch(batch)
 					continue
@@ -106,7 +156,10 @@ func (a *Agent) Start(ctx context.Context) error {
 					return nil
 				default:
 					castomLogger.Infof("Worker pool busy, skipping batch of %d metrics", len(batch.Item))
-					collectedMetrics.Append(batch.Item)
+					if a.stats != nil {
+						a.stats.ObserveDeferred()
+					}
+					collectedMetrics.Append(a.restoreSelfMetrics(batch.Item))
 					collectedMetrics.PutBatch(batch)
 				}
 			}
@@ -123,6 +176,14 @@ func (a *Agent) Start(ctx context.Context) error {
 	return err
 }
 
+// true, если отправитель сообщает о разомкнутой цепи
+func (a *Agent) circuitOpen() bool {
+	if breaker, ok := a.sender.(interface{ CircuitOpen() bool }); ok {
+		return breaker.CircuitOpen()
+	}
+	return false
+}
+
 // Worker для отправки метрик
 func (a *Agent) reportWorker(ctx context.Context, metricsCh <-chan *model.MetricsBatch, collectedMetrics *SafeMetrics) error {
 	for batch := range metricsCh { // <- ключевое
@@ -132,21 +193,12 @@ func (a *Agent) reportWorker(ctx context.Context, metricsCh <-chan *model.Metric
 		}
 
 		sendCtx, cancelSend := context.WithTimeout(ctx, 5*time.Second)
-		err := func() error {
-			defer cancelSend()
-
-			if retrySender, ok := a.sender.(interface {
-				Retry(ctx context.Context, operation func() error) error
-			}); ok {
-				return retrySender.Retry(sendCtx, func() error {
-					return a.sender.SendMetrics(sendCtx, batch.Item)
-				})
-			}
-			return a.sender.SendMetrics(sendCtx, batch.Item)
-		}()
+		err := a.send(sendCtx, batch.Item)
+		cancelSend()
 
 		if err != nil {
 			castomLogger.Infof("Worker failed to send %d metrics: %v", len(batch.Item), err)
+			a.restoreSelfMetrics(batch.Item)
 		} else {
 			castomLogger.Infof("Worker successfully sent %d metrics", len(batch.Item))
 		}
@@ -156,6 +208,28 @@ func (a *Agent) reportWorker(ctx context.Context, metricsCh <-chan *model.Metric
 	return nil
 }
 
+// убирает из недоставленного батча самотелеметрию и возвращает её приращения
+// источникам: следующий отчёт включит их вместе с новыми. возвращает остальные метрики.
+func (a *Agent) restoreSelfMetrics(metrics []model.Metrics) []model.Metrics {
+	if a.stats == nil {
+		return metrics
+	}
+	rest := make([]model.Metrics, 0, len(metrics))
+	var self []model.Metrics
+	for _, m := range metrics {
+		if strings.HasPrefix(m.ID, SelfTelemetryPrefix) {
+			self = append(self, m)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	a.stats.Restore(self)
+	if restorer, ok := a.sender.(interface{ RestoreSelfMetrics([]model.Metrics) }); ok {
+		restorer.RestoreSelfMetrics(self)
+	}
+	return rest
+}
+
 // Финальная отправка при shutdown
 func (a *Agent) finalShutdownSend(metrics *model.MetricsBatch) error {
 	if metrics == nil || len(metrics.Item) == 0 {
@@ -166,21 +240,36 @@ func (a *Agent) finalShutdownSend(metrics *model.MetricsBatch) error {
 	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancelShutdown()
 
+	if err := a.send(shutdownCtx, metrics.Item); err != nil {
+		castomLogger.Infof("Final send failed: %v", err)
+	} else {
+		castomLogger.Infof("Final send completed successfully")
+	}
+	return nil
+}
+
+// отправляет метрики, используя Retry отправителя, если он его поддерживает,
+// и учитывает попытки в статистике агента.
+func (a *Agent) send(ctx context.Context, metrics []model.Metrics) error {
+	attempts := 0
+	start := time.Now()
+	operation := func() error {
+		attempts++
+		return a.sender.SendMetrics(ctx, metrics)
+	}
+
+	var err error
 	if retrySender, ok := a.sender.(interface {
 		Retry(ctx context.Context, operation func() error) error
 	}); ok {
-		err := retrySender.Retry(shutdownCtx, func() error {
-			return a.sender.SendMetrics(shutdownCtx, metrics.Item)
-		})
-		if err != nil {
-			castomLogger.Infof("Final send failed: %v", err)
-		} else {
-			castomLogger.Infof("Final send completed successfully")
-		}
+		err = retrySender.Retry(ctx, operation)
 	} else {
-		if err := a.sender.SendMetrics(shutdownCtx, metrics.Item); err != nil {
-			castomLogger.Infof("Final send failed: %v", err)
-		}
+		err = operation()
 	}
-	return nil
+
+	if a.stats != nil {
+		// Retry может не вызвать операцию, например при отменённом контексте
+		a.stats.ObserveSend(len(metrics), max(attempts, 1), time.Since(start), err)
+	}
+	return err
 }
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ошибка, которую возвращает отправитель, пока цепь разомкнута.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// сервер попросил подождать перед повтором (заголовок Retry-After).
+type RetryAfterError struct {
+	error
+	After time.Duration
+}
+
+func (r RetryAfterError) Unwrap() error {
+	return r.error
+}
+
+// разбирает Retry-After в секундах или в формате HTTP-даты.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// экспоненциальная задержка с джиттером: половина задержки фиксирована,
+// вторая половина случайна, чтобы агенты не били в сервер синхронно.
+func backoffDelay(cfg RetryConfig, attempt int) time.Duration {
+	d := cfg.InitialDelay
+	for i := 0; i < attempt && d < cfg.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > cfg.MaxDelay {
+		d = cfg.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
+
+// выполняет операцию с повторами для повторяемых ошибок.
+// при разомкнутой цепи операция не вызывается вовсе.
+func retryWithBackoff(ctx context.Context, cfg RetryConfig, breaker *CircuitBreaker, operation func() error) error {
+	var lastErr error
+
+	for attempt := 0; attempt < cfg.MaxAttempts; attempt++ {
+		var trial bool
+		if breaker != nil {
+			var ok bool
+			if ok, trial = breaker.allow(); !ok {
+				if lastErr != nil {
+					return fmt.Errorf("%w, последняя ошибка: %w", ErrCircuitOpen, lastErr)
+				}
+				return ErrCircuitOpen
+			}
+		}
+
+		err := operation()
+		if trial {
+			// отмена, ошибка TLS и другие исходы, которые не учитываются в Record,
+			// не должны навсегда занять пробный запрос
+			breaker.release()
+		}
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+
+		//Проверяем, является ли ошибка повторяемой
+		if !IsRetriableError(err) {
+			return fmt.Errorf("неповторяемая ошибка: %w", err)
+		}
+
+		if attempt == cfg.MaxAttempts-1 {
+			break
+		}
+
+		delay := backoffDelay(cfg, attempt)
+		var retryAfter RetryAfterError
+		if errors.As(err, &retryAfter) && retryAfter.After > delay {
+			delay = retryAfter.After
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("операция отменена: %w", ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+
+	return fmt.Errorf("все %d попыток провалены, последняя ошибка: %w", cfg.MaxAttempts, lastErr)
+}
+
+// состояние автомата размыкания цепи.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // запросы идут как обычно
+	BreakerOpen                         // сервер считается недоступным, запросы не отправляются
+	BreakerHalfOpen                     // пропускается один пробный запрос
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// параметры размыкания цепи.
+type BreakerConfig struct {
+	FailureThreshold int           // число подряд неудачных запросов до размыкания
+	OpenTimeout      time.Duration // время в разомкнутом состоянии до пробного запроса
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+// размыкатель цепи для одного эндпоинта, общий для HTTP и gRPC отправителей.
+// неудачей считается только недоступность сервера (сеть, 5xx, 429),
+// ответы 4xx означают, что сервер жив.
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	cfg      BreakerConfig
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	trial    bool // пробный запрос в полуоткрытом состоянии уже выполняется
+}
+
+func NewCircuitBreaker(cfg BreakerConfig) *CircuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = DefaultBreakerConfig().FailureThreshold
+	}
+	if cfg.OpenTimeout <= 0 {
+		cfg.OpenTimeout = DefaultBreakerConfig().OpenTimeout
+	}
+	return &CircuitBreaker{cfg: cfg}
+}
+
+// текущее состояние с учётом истёкшего времени размыкания.
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.currentState()
+}
+
+func (cb *CircuitBreaker) currentState() BreakerState {
+	if cb.state == BreakerOpen && time.Now().Sub(cb.openedAt) >= cb.cfg.OpenTimeout {
+		cb.state = BreakerHalfOpen
+		cb.trial = false
+	}
+	return cb.state
+}
+
+// разрешает запрос. в полуоткрытом состоянии пропускается только один пробный запрос.
+func (cb *CircuitBreaker) Allow() bool {
+	ok, _ := cb.allow()
+	return ok
+}
+
+// как Allow; trial — запросу досталась попытка полуоткрытого состояния,
+// и вызывающий должен учесть её результат через Record или вернуть через release.
+func (cb *CircuitBreaker) allow() (ok, trial bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.currentState() {
+	case BreakerOpen:
+		return false, false
+	case BreakerHalfOpen:
+		if cb.trial {
+			return false, false
+		}
+		cb.trial = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// освобождает пробный запрос, результат которого не учтён в Record:
+// следующий запрос снова сможет проверить сервер.
+func (cb *CircuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == BreakerHalfOpen {
+		cb.trial = false
+	}
+}
+
+// учитывает результат запроса.
+func (cb *CircuitBreaker) Record(serverFailure bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state := cb.currentState()
+	if !serverFailure {
+		cb.state = BreakerClosed
+		cb.failures = 0
+		cb.trial = false
+		return
+	}
+
+	cb.failures++
+	if state == BreakerHalfOpen || cb.failures >= cb.cfg.FailureThreshold {
+		if state != BreakerOpen {
+			castomLogger.Warnf("Circuit breaker opened after %d failures", cb.failures)
+		}
+		cb.state = BreakerOpen
+		cb.openedAt = time.Now()
+		cb.trial = false
+	}
+}
@@ -20,3 +20,11 @@ func BenchmarkRuntimeMetricsCollectorCollectSystem(b *testing.B) {
 		collect.CollectSystemMetrics()
 	}
 }
+
+func BenchmarkGoRuntimeCollectorCollect(b *testing.B) {
+	collector := NewGoRuntimeCollector()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		collector.Collect()
+	}
+}
@@ -0,0 +1,205 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// корень cgroup v2 внутри контейнера.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// собирает ресурсы контейнера из файлов cgroup v2.
+// mem.VirtualMemory внутри контейнера показывает память хоста,
+// а лимиты и потребление контейнера есть только в cgroup.
+// отсутствующие файлы (выключенный контроллер) просто пропускаются.
+type CgroupCollector struct {
+	root   string
+	totals *cumulativeTracker
+}
+
+// создаёт сборщик для каталога cgroup, root должен быть каталогом cgroup v2.
+func NewCgroupCollector(root string) (*CgroupCollector, error) {
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("cgroup collector: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("cgroup collector: %s is not a directory", root)
+	}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("cgroup collector: %s is not a cgroup v2 hierarchy: %w", root, err)
+	}
+	return &CgroupCollector{root: root, totals: newCumulativeTracker()}, nil
+}
+
+func (cc *CgroupCollector) Collect() []model.Metrics {
+	metrics := make([]model.Metrics, 0, 16)
+
+	addGauge := func(id string, value float64) {
+		val := value
+		metrics = append(metrics, model.Metrics{
+			ID:    id,
+			MType: model.Gauge,
+			Value: &val,
+		})
+	}
+	addCounter := func(id string, total float64) {
+		delta, ok := cc.totals.Delta(id, total)
+		if !ok {
+			return
+		}
+		metrics = append(metrics, model.Metrics{
+			ID:    id,
+			MType: model.Counter,
+			Delta: &delta,
+		})
+	}
+
+	// память
+	if v, ok := cc.readValue("memory.current"); ok {
+		addGauge("cgroup_memory_current_bytes", v)
+	}
+	if v, ok := cc.readValue("memory.max"); ok {
+		addGauge("cgroup_memory_max_bytes", v)
+	}
+
+	// CPU: накопительные значения из cpu.stat и лимит из cpu.max
+	if stat, err := cc.readKeyValues("cpu.stat"); err == nil {
+		for _, key := range []string{"usage_usec", "user_usec", "system_usec", "nr_periods", "nr_throttled", "throttled_usec"} {
+			if v, ok := stat[key]; ok {
+				addCounter("cgroup_cpu_"+key, v)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		castomLogger.Warnf("cgroup collector: %v", err)
+	}
+	if quota, period, ok := cc.readCPUMax(); ok {
+		addGauge("cgroup_cpu_limit_cores", quota/period)
+	}
+
+	// IO по устройствам
+	if devices, err := cc.readIOStat(); err == nil {
+		for device, stat := range devices {
+			for key, v := range stat {
+				addCounter(model.SeriesID("cgroup_io_"+key, map[string]string{"device": device}), v)
+			}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		castomLogger.Warnf("cgroup collector: %v", err)
+	}
+
+	// процессы
+	if v, ok := cc.readValue("pids.current"); ok {
+		addGauge("cgroup_pids_current", v)
+	}
+	if v, ok := cc.readValue("pids.max"); ok {
+		addGauge("cgroup_pids_max", v)
+	}
+
+	return metrics
+}
+
+// читает файл с одним числом. значение "max" (нет лимита) считается отсутствующим.
+func (cc *CgroupCollector) readValue(name string) (float64, bool) {
+	data, err := os.ReadFile(filepath.Join(cc.root, name))
+	if err != nil {
+		return 0, false
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		castomLogger.Warnf("cgroup collector: bad value in %s: %q", name, s)
+		return 0, false
+	}
+	return v, true
+}
+
+// читает файл формата "key value" построчно (cpu.stat, memory.stat).
+func (cc *CgroupCollector) readKeyValues(name string) (map[string]float64, error) {
+	f, err := os.Open(filepath.Join(cc.root, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string]float64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		out[fields[0]] = v
+	}
+	return out, sc.Err()
+}
+
+// читает cpu.max формата "<quota> <period>", quota может быть "max".
+func (cc *CgroupCollector) readCPUMax() (float64, float64, bool) {
+	data, err := os.ReadFile(filepath.Join(cc.root, "cpu.max"))
+	if err != nil {
+		return 0, 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0, 0, false
+	}
+	quota, err1 := strconv.ParseFloat(fields[0], 64)
+	period, err2 := strconv.ParseFloat(fields[1], 64)
+	if err1 != nil || err2 != nil || period == 0 {
+		return 0, 0, false
+	}
+	return quota, period, true
+}
+
+// читает io.stat формата "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0".
+func (cc *CgroupCollector) readIOStat() (map[string]map[string]float64, error) {
+	f, err := os.Open(filepath.Join(cc.root, "io.stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string]map[string]float64)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		stat := make(map[string]float64, len(fields)-1)
+		for _, kv := range fields[1:] {
+			key, raw, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(raw, 64)
+			if err != nil {
+				continue
+			}
+			stat[key] = v
+		}
+		out[fields[0]] = stat
+	}
+	return out, sc.Err()
+}
+
+// проверяем на этапе компиляции
+var _ model.MetricsSource = (*CgroupCollector)(nil)
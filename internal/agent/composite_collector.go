@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// объединяет основной сборщик с дополнительными источниками метрик.
+// источники опрашиваются в CollectSystemMetrics: они могут ходить в сеть
+// или файловую систему, и не должны задерживать сбор runtime метрик.
+type CompositeCollector struct {
+	base    model.MetricsCollector
+	sources []model.MetricsSource
+}
+
+// создаёт сборщик поверх base, nil-источники пропускаются.
+func NewCompositeCollector(base model.MetricsCollector, sources ...model.MetricsSource) *CompositeCollector {
+	cc := &CompositeCollector{base: base}
+	for _, s := range sources {
+		if s != nil {
+			cc.sources = append(cc.sources, s)
+		}
+	}
+	return cc
+}
+
+func (cc *CompositeCollector) Collect() []model.Metrics {
+	return cc.base.Collect()
+}
+
+func (cc *CompositeCollector) CollectSystemMetrics() []model.Metrics {
+	metrics := cc.base.CollectSystemMetrics()
+	for _, s := range cc.sources {
+		metrics = append(metrics, s.Collect()...)
+	}
+	return metrics
+}
+
+// проверяем на этапе компиляции
+var _ model.MetricsCollector = (*CompositeCollector)(nil)
@@ -2,9 +2,11 @@
 package agent
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -12,18 +14,36 @@ import (
 	"strings"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/tlsconfig"
 	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 )
 
 type Config struct {
-	ServerURL      string        `json:"address" env:"ADDRESS"`
-	GRPCAddr       string        `json:"grpc_address" env:"GRPC_ADDRESS"`
-	PollInterval   time.Duration `json:"poll_interval" env:"POLL_INTERVAL"`
-	ReportInterval time.Duration `json:"report_interval" env:"REPORT_INTERVAL"`
-	Key            string        `json:"key" env:"KEY"`
-	RateLimit      int           `json:"rate_limit" env:"RATE_LIMIT"`
-	CryptoKey      string        `json:"crypto_key" env:"CRYPTO_KEY"`
-	ConfigFile     string        `json:"-" env:"CONFIG"`
+	ServerURL      string         `json:"address" env:"ADDRESS"`
+	GRPCAddr       string         `json:"grpc_address" env:"GRPC_ADDRESS"`
+	PollInterval   time.Duration  `json:"poll_interval" env:"POLL_INTERVAL"`
+	ReportInterval time.Duration  `json:"report_interval" env:"REPORT_INTERVAL"`
+	Key            string         `json:"key" env:"KEY"`
+	RateLimit      int            `json:"rate_limit" env:"RATE_LIMIT"`
+	CryptoKey      string         `json:"crypto_key" env:"CRYPTO_KEY"`
+	ConfigFile     string         `json:"-" env:"CONFIG"`
+	ScrapeTargets  []ScrapeTarget `json:"scrape_targets" env:"SCRAPE_TARGETS"`
+	ScrapeTimeout  time.Duration  `json:"scrape_timeout" env:"SCRAPE_TIMEOUT"`
+	LocalAddress   string         `json:"local_address" env:"LOCAL_ADDRESS"`
+	LocalSocket    string         `json:"local_socket" env:"LOCAL_SOCKET"`
+	Processes      ProcessFilter  `json:"processes" env:"PROCESS_NAMES"`
+	CgroupPath     string         `json:"cgroup_path" env:"CGROUP_PATH"`
+	Collector      string         `json:"collector" env:"COLLECTOR"`
+	SelfTelemetry  bool           `json:"self_telemetry" env:"SELF_TELEMETRY"`
+	Endpoints      []string       `json:"endpoints" env:"ENDPOINTS"`
+	SendMode       string         `json:"send_mode" env:"SEND_MODE"`
+	TLSCAFile      string         `json:"tls_ca" env:"TLS_CA"`
+	TLSCertFile    string         `json:"tls_cert" env:"TLS_CERT"`
+	TLSKeyFile     string         `json:"tls_key" env:"TLS_KEY"`
+	CryptoLegacy   bool           `json:"crypto_legacy" env:"CRYPTO_LEGACY"`
+	AgentID        string         `json:"agent_id" env:"AGENT_ID"`
+	AuthToken      string         `json:"auth_token" env:"AUTH_TOKEN"`
 }
 
 type jsonDuration struct {
@@ -62,11 +82,27 @@ func (d *jsonDuration) UnmarshalJSON(b []byte) error {
 }
 
 type fileConfig struct {
-	Address        *string       `json:"address"`
-	GRPCAddress    *string       `json:"grpc_address"`
-	PollInterval   *jsonDuration `json:"poll_interval"`
-	ReportInterval *jsonDuration `json:"report_interval"`
-	CryptoKey      *string       `json:"crypto_key"`
+	Address        *string        `json:"address"`
+	GRPCAddress    *string        `json:"grpc_address"`
+	PollInterval   *jsonDuration  `json:"poll_interval"`
+	ReportInterval *jsonDuration  `json:"report_interval"`
+	CryptoKey      *string        `json:"crypto_key"`
+	ScrapeTargets  []ScrapeTarget `json:"scrape_targets"`
+	ScrapeTimeout  *jsonDuration  `json:"scrape_timeout"`
+	LocalAddress   *string        `json:"local_address"`
+	LocalSocket    *string        `json:"local_socket"`
+	Processes      *ProcessFilter `json:"processes"`
+	CgroupPath     *string        `json:"cgroup_path"`
+	Collector      *string        `json:"collector"`
+	SelfTelemetry  *bool          `json:"self_telemetry"`
+	Endpoints      []string       `json:"endpoints"`
+	SendMode       *string        `json:"send_mode"`
+	TLSCAFile      *string        `json:"tls_ca"`
+	TLSCertFile    *string        `json:"tls_cert"`
+	TLSKeyFile     *string        `json:"tls_key"`
+	CryptoLegacy   *bool          `json:"crypto_legacy"`
+	AgentID        *string        `json:"agent_id"`
+	AuthToken      *string        `json:"auth_token"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -79,6 +115,9 @@ func LoadConfig() (*Config, error) {
 		RateLimit:      3,
 		CryptoKey:      "",
 		ConfigFile:     "",
+		ScrapeTimeout:  5 * time.Second,
+		Collector:      CollectorMemStats,
+		SendMode:       SendModeFailover,
 	}
 
 	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
@@ -96,6 +135,21 @@ func LoadConfig() (*Config, error) {
 	crypto := fs.String("crypto-key", cfg.CryptoKey, "path to public key")
 	_ = fs.String("s", cfg.CryptoKey, "alias for -crypto-key (deprecated)")
 	grpcAddr := fs.String("grpc", "", "gRPC server address")
+	scrape := fs.String("scrape", "", "comma-separated Prometheus endpoints to scrape")
+	localAddr := fs.String("local", "", "loopback address for local metrics push (e.g. 127.0.0.1:8125)")
+	localSocket := fs.String("local-socket", "", "unix socket path for local metrics push")
+	processNames := fs.String("process-names", "", "comma-separated process name patterns to monitor")
+	cgroupPath := fs.String("cgroup", "", "cgroup v2 directory to collect container metrics from (e.g. /sys/fs/cgroup)")
+	collectorKind := fs.String("collector", cfg.Collector, "runtime metrics collector: memstats or runtime")
+	selfTelemetry := fs.Bool("self-telemetry", cfg.SelfTelemetry, "report agent send statistics as metrics")
+	endpoints := fs.String("endpoints", "", "comma-separated server addresses, grpc://host:port for gRPC")
+	sendMode := fs.String("send-mode", cfg.SendMode, "multi-server mode: failover or fanout")
+	tlsCA := fs.String("tls-ca", "", "CA certificate to verify the server")
+	tlsCert := fs.String("tls-cert", "", "client certificate for mTLS")
+	tlsKey := fs.String("tls-key", "", "client private key for mTLS")
+	cryptoLegacy := fs.Bool("crypto-legacy", false, "encrypt with legacy rsa/hybrid formats for old servers")
+	agentID := fs.String("agent-id", "", "agent identifier for a per-agent signing key on the server")
+	authToken := fs.String("auth-token", "", "API token for the server")
 
 	if err := fs.Parse(os.Args[1:]); err != nil {
 		return nil, err
@@ -139,10 +193,43 @@ func LoadConfig() (*Config, error) {
 			cfg.ConfigFile = cfgPath
 		case "grpc":
 			cfg.GRPCAddr = *grpcAddr
+		case "scrape":
+			cfg.ScrapeTargets = parseScrapeURLs(*scrape)
+		case "local":
+			cfg.LocalAddress = *localAddr
+		case "local-socket":
+			cfg.LocalSocket = *localSocket
+		case "process-names":
+			cfg.Processes.Names = splitList(*processNames)
+		case "cgroup":
+			cfg.CgroupPath = *cgroupPath
+		case "collector":
+			cfg.Collector = *collectorKind
+		case "self-telemetry":
+			cfg.SelfTelemetry = *selfTelemetry
+		case "endpoints":
+			cfg.Endpoints = splitList(*endpoints)
+		case "send-mode":
+			cfg.SendMode = *sendMode
+		case "tls-ca":
+			cfg.TLSCAFile = *tlsCA
+		case "tls-cert":
+			cfg.TLSCertFile = *tlsCert
+		case "tls-key":
+			cfg.TLSKeyFile = *tlsKey
+		case "crypto-legacy":
+			cfg.CryptoLegacy = *cryptoLegacy
+		case "agent-id":
+			cfg.AgentID = *agentID
+		case "auth-token":
+			cfg.AuthToken = *authToken
 		}
 	})
 
-	// нормализуем адрес для HTTP клиента
+	// нормализуем адрес для HTTP клиента, с TLS по умолчанию https
+	if cfg.UseTLS() {
+		cfg.ServerURL = ensureHTTPSScheme(cfg.ServerURL)
+	}
 	cfg.ServerURL = ensureURLScheme(cfg.ServerURL)
 
 	return cfg, nil
@@ -220,6 +307,54 @@ func loadFromJSON(filename string, cfg *Config) error {
 	if jc.GRPCAddress != nil {
 		cfg.GRPCAddr = *jc.GRPCAddress
 	}
+	if jc.ScrapeTargets != nil {
+		cfg.ScrapeTargets = jc.ScrapeTargets
+	}
+	if jc.ScrapeTimeout != nil {
+		cfg.ScrapeTimeout = jc.ScrapeTimeout.Duration
+	}
+	if jc.LocalAddress != nil {
+		cfg.LocalAddress = *jc.LocalAddress
+	}
+	if jc.LocalSocket != nil {
+		cfg.LocalSocket = *jc.LocalSocket
+	}
+	if jc.Processes != nil {
+		cfg.Processes = *jc.Processes
+	}
+	if jc.CgroupPath != nil {
+		cfg.CgroupPath = *jc.CgroupPath
+	}
+	if jc.Collector != nil {
+		cfg.Collector = *jc.Collector
+	}
+	if jc.SelfTelemetry != nil {
+		cfg.SelfTelemetry = *jc.SelfTelemetry
+	}
+	if jc.Endpoints != nil {
+		cfg.Endpoints = jc.Endpoints
+	}
+	if jc.SendMode != nil {
+		cfg.SendMode = *jc.SendMode
+	}
+	if jc.TLSCAFile != nil {
+		cfg.TLSCAFile = *jc.TLSCAFile
+	}
+	if jc.TLSCertFile != nil {
+		cfg.TLSCertFile = *jc.TLSCertFile
+	}
+	if jc.TLSKeyFile != nil {
+		cfg.TLSKeyFile = *jc.TLSKeyFile
+	}
+	if jc.CryptoLegacy != nil {
+		cfg.CryptoLegacy = *jc.CryptoLegacy
+	}
+	if jc.AgentID != nil {
+		cfg.AgentID = *jc.AgentID
+	}
+	if jc.AuthToken != nil {
+		cfg.AuthToken = *jc.AuthToken
+	}
 
 	return nil
 }
@@ -261,6 +396,87 @@ func applyEnv(cfg *Config) {
 	if v, ok := os.LookupEnv("GRPC_ADDRESS"); ok {
 		cfg.GRPCAddr = v
 	}
+	if v, ok := os.LookupEnv("SCRAPE_TARGETS"); ok && v != "" {
+		cfg.ScrapeTargets = parseScrapeURLs(v)
+	}
+	if v, ok := os.LookupEnv("SCRAPE_TIMEOUT"); ok && v != "" {
+		if d, err := parseDurationOrSeconds(v); err == nil {
+			cfg.ScrapeTimeout = d
+		} else {
+			logger.NewHTTPLogger().Logger.Sugar().Warnf("bad SCRAPE_TIMEOUT=%q: %v", v, err)
+		}
+	}
+	if v, ok := os.LookupEnv("LOCAL_ADDRESS"); ok {
+		cfg.LocalAddress = v
+	}
+	if v, ok := os.LookupEnv("LOCAL_SOCKET"); ok {
+		cfg.LocalSocket = v
+	}
+	if v, ok := os.LookupEnv("PROCESS_NAMES"); ok && v != "" {
+		cfg.Processes.Names = splitList(v)
+	}
+	if v, ok := os.LookupEnv("CGROUP_PATH"); ok {
+		cfg.CgroupPath = v
+	}
+	if v, ok := os.LookupEnv("COLLECTOR"); ok && v != "" {
+		cfg.Collector = v
+	}
+	if v, ok := os.LookupEnv("SELF_TELEMETRY"); ok && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.SelfTelemetry = b
+		} else {
+			logger.NewHTTPLogger().Logger.Sugar().Warnf("bad SELF_TELEMETRY=%q: %v", v, err)
+		}
+	}
+	if v, ok := os.LookupEnv("ENDPOINTS"); ok && v != "" {
+		cfg.Endpoints = splitList(v)
+	}
+	if v, ok := os.LookupEnv("SEND_MODE"); ok && v != "" {
+		cfg.SendMode = v
+	}
+	if v, ok := os.LookupEnv("TLS_CA"); ok {
+		cfg.TLSCAFile = v
+	}
+	if v, ok := os.LookupEnv("TLS_CERT"); ok {
+		cfg.TLSCertFile = v
+	}
+	if v, ok := os.LookupEnv("TLS_KEY"); ok {
+		cfg.TLSKeyFile = v
+	}
+	if v, ok := os.LookupEnv("CRYPTO_LEGACY"); ok && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			cfg.CryptoLegacy = b
+		} else {
+			logger.NewHTTPLogger().Logger.Sugar().Warnf("bad CRYPTO_LEGACY=%q: %v", v, err)
+		}
+	}
+	if v, ok := os.LookupEnv("AGENT_ID"); ok {
+		cfg.AgentID = v
+	}
+	if v, ok := os.LookupEnv("AUTH_TOKEN"); ok {
+		cfg.AuthToken = v
+	}
+}
+
+// из env и флагов можно передать только список URL в формате Prometheus,
+// префиксы и правила переименования задаются в JSON конфиге.
+func parseScrapeURLs(s string) []ScrapeTarget {
+	var targets []ScrapeTarget
+	for _, u := range splitList(s) {
+		targets = append(targets, ScrapeTarget{URL: u, Format: ScrapeFormatPrometheus})
+	}
+	return targets
+}
+
+// разбивает список через запятую, пропуская пустые элементы.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
 }
 
 func parseDurationOrSeconds(s string) (time.Duration, error) {
@@ -300,6 +516,15 @@ func ensureURLScheme(addr string) string {
 	return "http://" + addr
 }
 
+// добавляет https://, если схема не указана явно
+func ensureHTTPSScheme(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if addr == "" || strings.HasPrefix(addr, "http://") || strings.HasPrefix(addr, "https://") {
+		return addr
+	}
+	return "https://" + addr
+}
+
 func wasVisited(fs *flag.FlagSet, name string) bool {
 	visited := false
 	fs.Visit(func(f *flag.Flag) {
@@ -313,3 +538,144 @@ func wasVisited(fs *flag.FlagSet, name string) bool {
 func (c *Config) GetGRPCAddr() string {
 	return c.GRPCAddr
 }
+
+func (c *Config) GetScrapeTargets() []ScrapeTarget {
+	return c.ScrapeTargets
+}
+
+func (c *Config) GetScrapeTimeout() time.Duration {
+	return c.ScrapeTimeout
+}
+
+func (c *Config) GetLocalAddress() string {
+	return c.LocalAddress
+}
+
+func (c *Config) GetLocalSocket() string {
+	return c.LocalSocket
+}
+
+func (c *Config) GetProcessFilter() ProcessFilter {
+	return c.Processes
+}
+
+func (c *Config) GetCgroupPath() string {
+	return c.CgroupPath
+}
+
+func (c *Config) GetSelfTelemetry() bool {
+	return c.SelfTelemetry
+}
+
+func (c *Config) GetCollector() string {
+	return c.Collector
+}
+
+func (c *Config) GetEndpoints() []string {
+	return c.Endpoints
+}
+
+func (c *Config) GetSendMode() string {
+	return c.SendMode
+}
+
+// true, если к серверу нужно подключаться по TLS
+func (c *Config) UseTLS() bool {
+	return c.TLSCAFile != "" || c.TLSCertFile != ""
+}
+
+// параметры gRPC отправителя: подпись, шифрование и TLS те же, что у HTTP.
+func (c *Config) GRPCOptions() ([]GRPCOption, error) {
+	var opts []GRPCOption
+	if c.Key != "" {
+		opts = append(opts, WithGRPCHashKey(c.Key))
+	}
+	if c.AuthToken != "" {
+		opts = append(opts, WithGRPCToken(c.AuthToken))
+	}
+	if c.AgentID != "" {
+		opts = append(opts, WithGRPCAgentID(c.AgentID))
+	}
+	if c.CryptoKey != "" {
+		pub, err := LoadPublicKey(c.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load public key: %w", err)
+		}
+		opts = append(opts, WithGRPCPublicKey(pub))
+	}
+	tlsCfg, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		opts = append(opts, WithGRPCTLS(tlsCfg))
+	}
+	return opts, nil
+}
+
+// HTTP отправитель с подписью, шифрованием и TLS из конфига.
+func (c *Config) NewHTTPSender(serverURL string) (*HTTPSender, error) {
+	tlsCfg, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	sender, err := NewHTTPSender(serverURL, c.Key, c.CryptoKey)
+	if err != nil {
+		return nil, err
+	}
+	if tlsCfg != nil {
+		sender.SetTLSConfig(tlsCfg)
+	}
+	sender.SetLegacyEncryption(c.CryptoLegacy)
+	sender.SetAgentID(c.AgentID)
+	sender.SetAuthToken(c.AuthToken)
+	return sender, nil
+}
+
+// создаёт отправителей по списку Endpoints: grpc://host:port — gRPC, остальные — HTTP.
+// при настроенном TLS адреса без схемы считаются https.
+func (c *Config) NewEndpoints() ([]Endpoint, error) {
+	grpcOpts, err := c.GRPCOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]Endpoint, 0, len(c.Endpoints))
+	for _, addr := range c.Endpoints {
+		var sender model.MetricsSender
+		if target, ok := strings.CutPrefix(addr, "grpc://"); ok {
+			sender, err = NewGRPCSender(target, grpcOpts...)
+		} else {
+			if c.UseTLS() {
+				addr = ensureHTTPSScheme(addr)
+			}
+			addr = ensureURLScheme(addr)
+			sender, err = c.NewHTTPSender(addr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("endpoint %s: %w", addr, err)
+		}
+		endpoints = append(endpoints, Endpoint{Name: addr, Sender: sender})
+	}
+	return endpoints, nil
+}
+
+// TLS конфиг клиента, общий для HTTP и gRPC; nil, если TLS не настроен.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if !c.UseTLS() {
+		return nil, nil
+	}
+	return tlsconfig.Client(c.TLSCAFile, c.TLSCertFile, c.TLSKeyFile)
+}
+
+// создаёт сборщик runtime метрик, выбранный в конфиге.
+func NewCollector(kind string) (model.MetricsCollector, error) {
+	switch kind {
+	case "", CollectorMemStats:
+		return NewRuntimeMetricsCollector(), nil
+	case CollectorRuntime:
+		return NewGoRuntimeCollector(), nil
+	default:
+		return nil, fmt.Errorf("unknown collector %q, expected %s or %s", kind, CollectorMemStats, CollectorRuntime)
+	}
+}
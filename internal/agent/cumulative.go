@@ -0,0 +1,36 @@
+package agent
+
+import (
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/cumulative"
+)
+
+// переводит накопительные значения (counter из Prometheus, usage_usec из cgroup)
+// в приращения, которые ожидает сервер для метрик типа counter.
+// первое наблюдение серии только запоминается, чтобы при перезапуске агента
+// на сервер не уходила вся накопленная история повторно.
+// учёт ведёт тот же cumulative.Tracker, что и на сервере: он помнит уже
+// отправленную часть, поэтому дробные приращения не теряются при округлении.
+type cumulativeTracker struct {
+	totals *cumulative.Tracker
+}
+
+func newCumulativeTracker() *cumulativeTracker {
+	return &cumulativeTracker{totals: cumulative.NewTracker()}
+}
+
+// возвращает приращение серии с прошлого наблюдения.
+// второе значение false, если приращение вычислить нельзя (первое наблюдение).
+// при сбросе счётчика (значение уменьшилось) приращением считается новое значение.
+func (t *cumulativeTracker) Delta(id string, value float64) (int64, bool) {
+	c := t.totals.Begin()
+	known := c.Known(id)
+	c.Set(id, 0, value)
+	delta := c.Increment(id)
+	t.totals.Commit(c)
+	return delta, known
+}
+
+// забывает серии, например завершившегося процесса, чтобы карта не росла
+func (t *cumulativeTracker) Forget(ids ...string) {
+	t.totals.Forget(ids...)
+}
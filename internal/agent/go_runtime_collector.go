@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"math"
+	"math/rand"
+	"runtime/metrics"
+	"sync"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// значения параметра collector в конфиге агента.
+const (
+	CollectorMemStats = "memstats" // RuntimeMetricsCollector на runtime.ReadMemStats
+	CollectorRuntime  = "runtime"  // GoRuntimeCollector на runtime/metrics
+)
+
+// соответствие ключа runtime/metrics имени метрики агента.
+type runtimeMetric struct {
+	key string
+	id  string
+}
+
+// скалярные метрики runtime/metrics.
+var runtimeScalars = []runtimeMetric{
+	{"/sched/goroutines:goroutines", "Goroutines"},
+	{"/sched/gomaxprocs:threads", "GOMAXPROCS"},
+	{"/memory/classes/total:bytes", "Sys"},
+	{"/memory/classes/heap/objects:bytes", "HeapAlloc"},
+	{"/memory/classes/heap/unused:bytes", "HeapUnused"},
+	{"/memory/classes/heap/free:bytes", "HeapFree"},
+	{"/memory/classes/heap/released:bytes", "HeapReleased"},
+	{"/memory/classes/heap/stacks:bytes", "StackInuse"},
+	{"/gc/heap/objects:objects", "HeapObjects"},
+	{"/gc/heap/allocs:bytes", "TotalAlloc"},
+	{"/gc/heap/allocs:objects", "Mallocs"},
+	{"/gc/heap/frees:objects", "Frees"},
+	{"/gc/heap/goal:bytes", "NextGC"},
+	{"/gc/cycles/total:gc-cycles", "NumGC"},
+	{"/gc/cycles/forced:gc-cycles", "NumForcedGC"},
+	{"/cpu/classes/gc/total:cpu-seconds", "GCCPUSeconds"},
+	{"/cpu/classes/total:cpu-seconds", "TotalCPUSeconds"},
+	{"/sync/mutex/wait/total:seconds", "MutexWaitSeconds"},
+}
+
+// распределения runtime/metrics, по ним отдаются квантили и количество событий.
+var runtimeHistograms = []runtimeMetric{
+	{"/gc/pauses:seconds", "GCPause"},
+	{"/sched/latencies:seconds", "SchedLatency"},
+}
+
+var runtimeQuantiles = []struct {
+	suffix string
+	q      float64
+}{
+	{"P50", 0.5},
+	{"P90", 0.9},
+	{"P99", 0.99},
+}
+
+// сборщик runtime метрик на пакете runtime/metrics.
+// в отличие от runtime.ReadMemStats не останавливает мир и отдаёт
+// распределения пауз GC и задержек планировщика, число горутин и ожидание мьютексов.
+type GoRuntimeCollector struct {
+	mu         sync.Mutex
+	pollCount  int64
+	samples    []metrics.Sample
+	scalars    []string // имя метрики агента для каждого скалярного сэмпла
+	histograms []string // имя метрики агента для каждого сэмпла-распределения
+}
+
+// создаёт сборщик, ключи, которых нет в текущей версии Go, пропускаются.
+func NewGoRuntimeCollector() *GoRuntimeCollector {
+	supported := make(map[string]metrics.ValueKind)
+	for _, d := range metrics.All() {
+		supported[d.Name] = d.Kind
+	}
+
+	grc := &GoRuntimeCollector{}
+	for _, m := range runtimeScalars {
+		kind, ok := supported[m.key]
+		if !ok || (kind != metrics.KindUint64 && kind != metrics.KindFloat64) {
+			continue
+		}
+		grc.samples = append(grc.samples, metrics.Sample{Name: m.key})
+		grc.scalars = append(grc.scalars, m.id)
+	}
+	for _, m := range runtimeHistograms {
+		if supported[m.key] != metrics.KindFloat64Histogram {
+			continue
+		}
+		grc.samples = append(grc.samples, metrics.Sample{Name: m.key})
+		grc.histograms = append(grc.histograms, m.id)
+	}
+	return grc
+}
+
+func (grc *GoRuntimeCollector) Collect() []model.Metrics {
+	grc.mu.Lock()
+	defer grc.mu.Unlock()
+
+	grc.pollCount++
+	metrics.Read(grc.samples)
+
+	out := make([]model.Metrics, 0, len(grc.scalars)+len(grc.histograms)*(len(runtimeQuantiles)+1)+2)
+	addGauge := func(id string, value float64) {
+		val := value
+		out = append(out, model.Metrics{
+			ID:    id,
+			MType: model.Gauge,
+			Value: &val,
+		})
+	}
+
+	for i, id := range grc.scalars {
+		v := grc.samples[i].Value
+		switch v.Kind() {
+		case metrics.KindUint64:
+			addGauge(id, float64(v.Uint64()))
+		case metrics.KindFloat64:
+			addGauge(id, v.Float64())
+		}
+	}
+
+	offset := len(grc.scalars)
+	for i, id := range grc.histograms {
+		v := grc.samples[offset+i].Value
+		if v.Kind() != metrics.KindFloat64Histogram {
+			continue
+		}
+		h := v.Float64Histogram()
+		count := histogramCount(h)
+		addGauge(id+"Count", float64(count))
+		for _, q := range runtimeQuantiles {
+			addGauge(id+q.suffix, histogramQuantile(h, count, q.q))
+		}
+	}
+
+	pollCount := grc.pollCount
+	out = append(out, model.Metrics{ID: "PollCount", MType: model.Counter, Delta: &pollCount})
+	addGauge("RandomValue", rand.Float64())
+
+	return out
+}
+
+func (grc *GoRuntimeCollector) CollectSystemMetrics() []model.Metrics {
+	return collectSystemMetrics()
+}
+
+func histogramCount(h *metrics.Float64Histogram) uint64 {
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	return total
+}
+
+// оценивает квантиль по верхней границе бакета, в который он попадает.
+// для последнего бакета с границей +Inf берётся нижняя граница.
+func histogramQuantile(h *metrics.Float64Histogram, total uint64, q float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range h.Counts {
+		seen += c
+		if seen >= rank {
+			upper := h.Buckets[i+1]
+			if math.IsInf(upper, 1) {
+				return h.Buckets[i]
+			}
+			return upper
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1]
+}
+
+// проверяем на этапе компиляции
+var _ model.MetricsCollector = (*GoRuntimeCollector)(nil)
@@ -4,10 +4,8 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"crypto/hmac"
 	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,8 +17,11 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 	model "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
 	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 	"github.com/go-resty/resty/v2"
@@ -100,6 +101,10 @@ func isRetriableNetworkError(err error) bool {
 	if err == nil {
 		return false
 	}
+	// сервер не ответил за отведённое время: это сбой сервера, как и таймаут соединения
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
 
 	errStr := err.Error()
 	return strings.Contains(errStr, "timeout") ||
@@ -120,7 +125,11 @@ type HTTPSender struct {
 	errorClassifier *HTTPErrorClassifier
 	HashKey         string
 	pubKey          *rsa.PublicKey
+	keyID           string
+	legacyCrypto    bool
 	agentIP         net.IP
+	agentID         string
+	breaker         *CircuitBreaker
 }
 
 func getLocalIP() (net.IP, error) {
@@ -150,12 +159,14 @@ func NewHTTPSender(serverURL string, HashKey string, publicKeyPath string) (*HTT
 	}
 
 	var pubKey *rsa.PublicKey
+	var keyID string
 
 	if publicKeyPath != "" {
 		pubKey, err = LoadPublicKey(publicKeyPath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load public key: %w", err)
 		}
+		keyID = envelope.KeyID(pubKey)
 	}
 
 	return &HTTPSender{
@@ -166,7 +177,9 @@ func NewHTTPSender(serverURL string, HashKey string, publicKeyPath string) (*HTT
 		errorClassifier: NewHTTPErrorClassifier(),
 		HashKey:         HashKey,
 		pubKey:          pubKey,
+		keyID:           keyID,
 		agentIP:         ip,
+		breaker:         NewCircuitBreaker(DefaultBreakerConfig()),
 	}, nil
 }
 
@@ -174,38 +187,126 @@ func (s *HTTPSender) calculateHash256(b []byte) string {
 	if s.HashKey == "" {
 		return ""
 	}
-	h := hmac.New(sha256.New, []byte(s.HashKey))
-	h.Write(b)
-	return hex.EncodeToString(h.Sum(nil))
+	return keyring.ComputeHMAC(s.HashKey, b)
 }
 
+// подписывает запрос: HMAC от содержимого до сжатия и шифрования вместе с маршрутом,
+// меткой времени и nonce, чтобы сервер мог отклонить повтор. каждая попытка получает новый nonce.
+func (s *HTTPSender) sign(req *resty.Request, method, fullURL string, content []byte) error {
+	if s.agentID != "" {
+		req.SetHeader(keyring.HeaderAgentID, s.agentID)
+	}
+	if s.HashKey == "" {
+		return nil
+	}
+	timestamp, nonce, err := keyring.NewSignatureParams()
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	req.SetHeader(keyring.HeaderTimestamp, timestamp)
+	req.SetHeader(keyring.HeaderNonce, nonce)
+	u, err := url.Parse(fullURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
+	route := keyring.Route(method, u.Path)
+	req.SetHeader(keyring.HeaderSignature, s.calculateHash256(keyring.SignedPayload(route, timestamp, nonce, content)))
+	return nil
+}
+
+// API токен, передаётся в заголовке Authorization: Bearer.
+func (s *HTTPSender) SetAuthToken(token string) {
+	if token != "" {
+		s.client.SetAuthToken(token)
+	}
+}
+
+// идентификатор агента для выбора его собственного ключа подписи на сервере.
+func (s *HTTPSender) SetAgentID(id string) {
+	s.agentID = id
+}
+
+// повторяет операцию с экспоненциальной задержкой и джиттером,
+// учитывает Retry-After и не обращается к серверу, пока цепь разомкнута.
 func (s *HTTPSender) Retry(ctx context.Context, operation func() error) error {
-	delays := []time.Duration{1 * time.Second, 3 * time.Second, 5 * time.Second}
-	var lastErr error
+	return retryWithBackoff(ctx, s.retryConfig, s.breaker, operation)
+}
 
-	for attempt := 0; attempt < s.retryConfig.MaxAttempts; attempt++ {
-		err := operation()
-		if err == nil {
-			return nil
-		}
-		lastErr = err
+// включает HTTPS с проверкой сервера по CA и клиентским сертификатом для mTLS.
+func (s *HTTPSender) SetTLSConfig(cfg *tls.Config) {
+	s.client.SetTLSClientConfig(cfg)
+}
+
+// переключает шифрование на старые форматы rsa и hybrid для серверов без поддержки конверта.
+func (s *HTTPSender) SetLegacyEncryption(legacy bool) {
+	s.legacyCrypto = legacy
+}
 
-		//Проверяем, является ли ошибка повторяемой
-		if !IsRetriableError(err) {
-			return fmt.Errorf("неповторяемая ошибка: %w", err)
+// шифрует тело и возвращает значение заголовка X-Encrypted.
+func (s *HTTPSender) encrypt(data []byte) ([]byte, string, error) {
+	if !s.legacyCrypto {
+		sealed, err := envelope.Seal(s.pubKey, s.keyID, data)
+		return sealed, envelope.HeaderValue, err
+	}
+	// RSA PKCS#1 v1.5 ключом 2048 бит вмещает не больше 245 байт
+	if len(data) < 180 {
+		encrypted, err := EncryptWithRSA(s.pubKey, data)
+		return encrypted, "rsa", err
+	}
+	encrypted, err := EncryptHybridAESRSA(s.pubKey, data)
+	return encrypted, "hybrid", err
+}
+
+// задаёт параметры повторов отправки.
+func (s *HTTPSender) SetRetryConfig(cfg RetryConfig) {
+	s.retryConfig = cfg
+}
+
+// задаёт размыкатель цепи; один размыкатель можно разделить между отправителями одного эндпоинта.
+func (s *HTTPSender) SetBreaker(cb *CircuitBreaker) {
+	s.breaker = cb
+}
+
+// состояние размыкателя цепи для эндпоинта отправителя.
+func (s *HTTPSender) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+// true, пока сервер считается недоступным и отправка бессмысленна.
+func (s *HTTPSender) CircuitOpen() bool {
+	return s.breaker.State() == BreakerOpen
+}
+
+// классифицирует результат запроса и учитывает его в размыкателе цепи.
+// kind добавляется в текст ошибки: "" для одиночных запросов, "batch " для батча.
+func (s *HTTPSender) checkResponse(resp *resty.Response, err error, kind string) error {
+	if err != nil {
+		// Классифицируем сетевую ошибку
+		if s.errorClassifier.ClassifyHTTPError(err, 0) == Retriable {
+			s.breaker.Record(true)
+			return NewRetriableError(fmt.Errorf("%snetwork error: %w", kind, err))
 		}
+		return fmt.Errorf("%srequest failed: %w", kind, err)
+	}
 
-		if attempt < len(delays) {
-			delay := delays[attempt]
-			select {
-			case <-ctx.Done():
-				return fmt.Errorf("операция отменена: %w", ctx.Err())
-			case <-time.After(delay):
-			}
+	// Классифицируем HTTP ошибку
+	if s.errorClassifier.ClassifyHTTPError(nil, resp.StatusCode()) == Retriable {
+		s.breaker.Record(true)
+		statusErr := fmt.Errorf("%sretriable status %d", kind, resp.StatusCode())
+		if after, ok := parseRetryAfter(resp.Header().Get("Retry-After")); ok {
+			return NewRetriableError(RetryAfterError{error: statusErr, After: after})
 		}
+		return NewRetriableError(statusErr)
+	}
+
+	// сервер ответил, значит он доступен
+	s.breaker.Record(false)
+
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("%snon-retriable status %d", kind, resp.StatusCode())
 	}
 
-	return fmt.Errorf("все %d попыток провалены, последняя ошибка: %w", s.retryConfig.MaxAttempts, lastErr)
+	return nil
 }
 
 func validateMetric(metric model.Metrics) error {
@@ -241,20 +342,24 @@ func (s *HTTPSender) SendMetrics(ctx context.Context, metrics []model.Metrics) e
 		validMetrics = append(validMetrics, metric)
 	}
 
-	// Пробуем отправить батч с повторными попытками
-	batchErr := s.Retry(ctx, func() error {
-		return s.sendBatch(ctx, validMetrics)
-	})
-
+	// одна попытка: повторы и размыкатель цепи — на уровне всей отправки (Agent.send через Retry).
+	// вложенный Retry занял бы второй пробный запрос полуоткрытой цепи и получил отказ
+	batchErr := s.sendBatch(ctx, validMetrics)
 	if batchErr == nil {
 		return nil
 	}
+	// сервер недоступен или просил подождать, поштучная отправка тоже не пройдёт
+	var retryAfter RetryAfterError
+	if s.CircuitOpen() || errors.As(batchErr, &retryAfter) {
+		return batchErr
+	}
 
 	log.Printf("Batch sending failed, falling back to individual sends: %v", batchErr)
 
 	// Ограничим параллелизм семафором
 	semafor := make(chan struct{}, s.maxConc)
 	g, gctx := errgroup.WithContext(ctx)
+	var delivered atomic.Int64
 
 	for _, metric := range validMetrics {
 		m := metric
@@ -263,22 +368,26 @@ func (s *HTTPSender) SendMetrics(ctx context.Context, metrics []model.Metrics) e
 		g.Go(func() error {
 			defer func() { <-semafor }()
 
-			// Отправляем метрику с повторными попытками
-			err := s.Retry(gctx, func() error {
-				reqCtx, cancel := context.WithTimeout(gctx, 5*time.Second)
-				defer cancel()
-				return s.sendOne(reqCtx, m)
-			})
-
-			if err != nil {
-				log.Printf("Failed to send metric %s after retries: %v", m.ID, err)
+			reqCtx, cancel := context.WithTimeout(gctx, 5*time.Second)
+			defer cancel()
+			if err := s.sendOne(reqCtx, m); err != nil {
+				log.Printf("Failed to send metric %s: %v", m.ID, err)
 				// Не возвращаем ошибку, чтобы другие метрики могли отправиться
+				return nil
 			}
+			delivered.Add(1)
 			return nil
 		})
 	}
 
-	return g.Wait()
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	// не доставлено ничего: вызывающий может повторить батч, не задвоив counter
+	if delivered.Load() == 0 && len(validMetrics) > 0 {
+		return batchErr
+	}
+	return nil
 }
 
 func (s *HTTPSender) sendOne(ctx context.Context, metric model.Metrics) error {
@@ -302,7 +411,7 @@ func (s *HTTPSender) sendOne(ctx context.Context, metric model.Metrics) error {
 	}
 
 	// Пробуем сначала новый JSON формат
-	jsonErr := s.sendJSON(ctx, compressionBuf.Bytes())
+	jsonErr := s.sendJSON(ctx, data, compressionBuf.Bytes())
 	if jsonErr == nil {
 		return nil
 	}
@@ -316,15 +425,17 @@ func (s *HTTPSender) sendOne(ctx context.Context, metric model.Metrics) error {
 }
 
 // Новый JSON формат
-func (s *HTTPSender) sendJSON(ctx context.Context, metric []byte) error {
+// plain — JSON до сжатия, от него считается подпись; compressed уходит в теле
+func (s *HTTPSender) sendJSON(ctx context.Context, plain, compressed []byte) error {
 	base := strings.TrimRight(s.url, "/")
 	fullURL := base + "/update/"
 
 	// пытаем зашифровать
-	dataToSend := metric
+	dataToSend := compressed
+	var encType string
 	if s.pubKey != nil {
 		var err error
-		dataToSend, err = EncryptWithRSA(s.pubKey, dataToSend)
+		dataToSend, encType, err = s.encrypt(dataToSend)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt metrics: %w", err)
 		}
@@ -338,34 +449,16 @@ func (s *HTTPSender) sendJSON(ctx context.Context, metric []byte) error {
 		SetHeader("X-Real-IP", s.agentIP.String())
 
 	// добавляем заголовок при шифровании
-	if s.pubKey != nil {
-		req.SetHeader("X-Encrypted", "rsa")
+	if encType != "" {
+		req.SetHeader("X-Encrypted", encType)
 	}
 
-	if hash := s.calculateHash256(metric); hash != "" {
-		req.SetHeader("HashSHA256", hash)
+	if err := s.sign(req, http.MethodPost, fullURL, plain); err != nil {
+		return err
 	}
 
 	resp, err := req.Post(fullURL)
-
-	if err != nil {
-		// Классифицируем сетевую ошибку
-		if s.errorClassifier.ClassifyHTTPError(err, 0) == Retriable {
-			return NewRetriableError(fmt.Errorf("network error: %w", err))
-		}
-		return fmt.Errorf("request failed: %w", err)
-	}
-
-	// Классифицируем HTTP ошибку
-	if s.errorClassifier.ClassifyHTTPError(nil, resp.StatusCode()) == Retriable {
-		return NewRetriableError(fmt.Errorf("retriable status %d", resp.StatusCode()))
-	}
-
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("non-retriable status %d", resp.StatusCode())
-	}
-
-	return nil
+	return s.checkResponse(resp, err, "")
 }
 
 // Старый text формат
@@ -393,28 +486,12 @@ func (s *HTTPSender) sendText(ctx context.Context, metric model.Metrics) error {
 	// Для text формата нужно сериализовать данные для хеша
 	textData := fmt.Sprintf("%s:%s:%s", metric.MType, metric.ID, valueStr)
 
-	if hash := s.calculateHash256([]byte(textData)); hash != "" {
-		req.SetHeader("HashSHA256", hash)
+	if err := s.sign(req, http.MethodPost, fullURL, []byte(textData)); err != nil {
+		return err
 	}
 
 	resp, err := req.Post(fullURL)
-
-	if err != nil {
-		if s.errorClassifier.ClassifyHTTPError(err, 0) == Retriable {
-			return NewRetriableError(fmt.Errorf("network error: %w", err))
-		}
-		return fmt.Errorf("request failed: %w", err)
-	}
-
-	if s.errorClassifier.ClassifyHTTPError(nil, resp.StatusCode()) == Retriable {
-		return NewRetriableError(fmt.Errorf("retriable status %d", resp.StatusCode()))
-	}
-
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("non-retriable status %d", resp.StatusCode())
-	}
-
-	return nil
+	return s.checkResponse(resp, err, "")
 }
 
 // отправка батча
@@ -438,13 +515,10 @@ func (s *HTTPSender) sendBatch(ctx context.Context, metrics []model.Metrics) err
 
 	dataToSend := compressionBuf.Bytes()
 
+	var encType string
 	if s.pubKey != nil {
 		var err error
-		if len(dataToSend) < 180 { // если большой батч то делаем гибридный метод
-			dataToSend, err = EncryptWithRSA(s.pubKey, dataToSend)
-		} else {
-			dataToSend, err = EncryptHybridAESRSA(s.pubKey, dataToSend)
-		}
+		dataToSend, encType, err = s.encrypt(dataToSend)
 		if err != nil {
 			return fmt.Errorf("failed to encrypt metrics: %w", err)
 		}
@@ -457,34 +531,14 @@ func (s *HTTPSender) sendBatch(ctx context.Context, metrics []model.Metrics) err
 		SetBody(dataToSend).
 		SetHeader("X-Real-IP", s.agentIP.String())
 
-	if s.pubKey != nil {
-		if len(dataToSend) < 180 {
-			req.SetHeader("X-Encrypted", "rsa")
-		} else {
-			req.SetHeader("X-Encrypted", "hybrid")
-		}
+	if encType != "" {
+		req.SetHeader("X-Encrypted", encType)
 	}
 
-	if hash := s.calculateHash256(compressionBuf.Bytes()); hash != "" {
-		req.SetHeader("HashSHA256", hash)
+	if err := s.sign(req, http.MethodPost, fullURL, data); err != nil {
+		return err
 	}
 
 	resp, err := req.Post(fullURL)
-
-	if err != nil {
-		if s.errorClassifier.ClassifyHTTPError(err, 0) == Retriable {
-			return NewRetriableError(fmt.Errorf("batch network error: %w", err))
-		}
-		return fmt.Errorf("batch request failed: %w", err)
-	}
-
-	if s.errorClassifier.ClassifyHTTPError(nil, resp.StatusCode()) == Retriable {
-		return NewRetriableError(fmt.Errorf("batch retriable status %d", resp.StatusCode()))
-	}
-
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("batch non-retriable status %d", resp.StatusCode())
-	}
-
-	return nil
+	return s.checkResponse(resp, err, "batch ")
 }
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"golang.org/x/sync/errgroup"
+)
+
+// максимальный размер тела запроса от локального приложения.
+const localMaxBodySize = 4 << 20
+
+// локальный приёмник метрик: приложения на том же хосте отдают метрики агенту
+// в формате серверного /updates/, а агент отправляет их вместе со своими —
+// с батчингом, подписью, шифрованием и повторными попытками.
+type LocalReceiver struct {
+	addr   string // адрес HTTP на loopback интерфейсе
+	socket string // путь к unix сокету
+}
+
+// создаёт приёмник. addr должен указывать на loopback интерфейс,
+// чтобы агент не стал открытым ретранслятором метрик в сети.
+func NewLocalReceiver(addr, socket string) (*LocalReceiver, error) {
+	if addr == "" && socket == "" {
+		return nil, errors.New("local receiver: neither address nor socket is set")
+	}
+	if addr != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("local receiver: invalid address %q: %w", addr, err)
+		}
+		if host != "localhost" {
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsLoopback() {
+				return nil, fmt.Errorf("local receiver: address %q is not a loopback address", addr)
+			}
+		}
+	}
+	return &LocalReceiver{addr: addr, socket: socket}, nil
+}
+
+// возвращает обработчик, который передаёт принятые метрики в sink.
+func (lr *LocalReceiver) Handler(sink func([]model.Metrics)) http.Handler {
+	mux := http.NewServeMux()
+	handle := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		lr.serveUpdates(w, r, sink)
+	}
+	mux.HandleFunc("/updates/", handle)
+	mux.HandleFunc("/updates", handle)
+	return mux
+}
+
+func (lr *LocalReceiver) serveUpdates(w http.ResponseWriter, r *http.Request, sink func([]model.Metrics)) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body io.Reader = http.MaxBytesReader(w, r.Body, localMaxBodySize)
+	if strings.Contains(strings.ToLower(r.Header.Get("Content-Encoding")), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid gzip data"})
+			return
+		}
+		defer gz.Close()
+		body = io.LimitReader(gz, localMaxBodySize)
+	}
+
+	var metrics []model.Metrics
+	if err := json.NewDecoder(body).Decode(&metrics); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid JSON format"})
+		return
+	}
+	if len(metrics) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "empty batch"})
+		return
+	}
+
+	var validationErrors []string
+	for i, m := range metrics {
+		if err := validateMetric(m); err != nil {
+			validationErrors = append(validationErrors, fmt.Sprintf("metric[%d]: %v", i, err))
+			continue
+		}
+		// самотелеметрию пишет только сам агент
+		if strings.HasPrefix(m.ID, SelfTelemetryPrefix) {
+			validationErrors = append(validationErrors, fmt.Sprintf("metric[%d]: prefix %s is reserved", i, SelfTelemetryPrefix))
+		}
+	}
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":   "validation failed",
+			"details": validationErrors,
+		})
+		return
+	}
+
+	sink(metrics)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+}
+
+// слушает заданные адреса до отмены ctx.
+func (lr *LocalReceiver) Serve(ctx context.Context, sink func([]model.Metrics)) error {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	if lr.addr != "" {
+		l, err := net.Listen("tcp", lr.addr)
+		if err != nil {
+			return fmt.Errorf("local receiver: %w", err)
+		}
+		listeners = append(listeners, l)
+	}
+	if lr.socket != "" {
+		// сокет от предыдущего запуска мешает bind
+		if err := os.Remove(lr.socket); err != nil && !os.IsNotExist(err) {
+			closeAll()
+			return fmt.Errorf("local receiver: remove stale socket: %w", err)
+		}
+		l, err := net.Listen("unix", lr.socket)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("local receiver: %w", err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	srv := &http.Server{
+		Handler:           lr.Handler(sink),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	for _, l := range listeners {
+		castomLogger.Infof("Local receiver listening on %s %s", l.Addr().Network(), l.Addr())
+		g.Go(func() error {
+			if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("local receiver: %w", err)
+			}
+			return nil
+		})
+	}
+	g.Go(func() error {
+		<-gctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	})
+
+	return g.Wait()
+}
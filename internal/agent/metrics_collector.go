@@ -95,6 +95,11 @@ func (rmc *RuntimeMetricsCollector) Collect() []model.Metrics {
 
 // Сбор системных метрик через gopsutil
 func (rmc *RuntimeMetricsCollector) CollectSystemMetrics() []model.Metrics {
+	return collectSystemMetrics()
+}
+
+// системные метрики общие для всех сборщиков runtime метрик
+func collectSystemMetrics() []model.Metrics {
 	metrics := make([]model.Metrics, 0, 10)
 
 	addGauge := func(id string, value float64) {
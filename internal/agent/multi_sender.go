@@ -0,0 +1,277 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// режимы отправки на несколько серверов
+const (
+	SendModeFailover = "failover" // по приоритету, следующий сервер только при недоступности предыдущего
+	SendModeFanout   = "fanout"   // на все серверы одновременно, например во время миграции
+)
+
+// сервер назначения и отправитель для него.
+type Endpoint struct {
+	Name   string
+	Sender model.MetricsSender
+}
+
+// результаты отправки на один сервер.
+type EndpointStats struct {
+	Name        string
+	Sent        int64 // успешно отправленные батчи
+	Failed      int64 // батчи, которые не удалось отправить
+	Skipped     int64 // батчи, пропущенные из-за разомкнутой цепи
+	LastError   string
+	LastSuccess time.Time
+	Healthy     bool
+}
+
+type endpointState struct {
+	Endpoint
+	mu    sync.Mutex
+	stats EndpointStats
+	// приращения с прошлого отчёта самотелеметрии
+	sentDelta, failedDelta, skippedDelta int64
+}
+
+func (e *endpointState) record(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		e.stats.Failed++
+		e.failedDelta++
+		e.stats.LastError = err.Error()
+		e.stats.Healthy = false
+		return
+	}
+	e.stats.Sent++
+	e.sentDelta++
+	e.stats.LastSuccess = time.Now()
+	e.stats.Healthy = true
+}
+
+func (e *endpointState) skip() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stats.Skipped++
+	e.skippedDelta++
+	e.stats.Healthy = false
+}
+
+func (e *endpointState) circuitOpen() bool {
+	if breaker, ok := e.Sender.(interface{ CircuitOpen() bool }); ok {
+		return breaker.CircuitOpen()
+	}
+	return false
+}
+
+// отправка с повторами отправителя, если он их поддерживает. SendMetrics делает
+// одну попытку, поэтому пробный запрос полуоткрытой цепи учитывается в ней же
+// и восстановившийся сервер снова начинает принимать батчи.
+func (e *endpointState) send(ctx context.Context, metrics []model.Metrics) error {
+	operation := func() error {
+		return e.Sender.SendMetrics(ctx, metrics)
+	}
+	if retrySender, ok := e.Sender.(interface {
+		Retry(ctx context.Context, operation func() error) error
+	}); ok {
+		return retrySender.Retry(ctx, operation)
+	}
+	return operation()
+}
+
+// отправитель на несколько серверов, HTTP и gRPC можно смешивать.
+// повторы выполняет каждый отправитель сам, поэтому MultiSender не реализует Retry:
+// иначе в режиме fanout успешные серверы получили бы батч повторно.
+type MultiSender struct {
+	mode      string
+	endpoints []*endpointState
+}
+
+func NewMultiSender(mode string, endpoints ...Endpoint) (*MultiSender, error) {
+	if mode == "" {
+		mode = SendModeFailover
+	}
+	if mode != SendModeFailover && mode != SendModeFanout {
+		return nil, fmt.Errorf("unknown send mode %q, expected %s or %s", mode, SendModeFailover, SendModeFanout)
+	}
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoints configured")
+	}
+
+	ms := &MultiSender{mode: mode}
+	for _, ep := range endpoints {
+		if ep.Sender == nil {
+			return nil, fmt.Errorf("endpoint %q has no sender", ep.Name)
+		}
+		ms.endpoints = append(ms.endpoints, &endpointState{
+			Endpoint: ep,
+			stats:    EndpointStats{Name: ep.Name, Healthy: true},
+		})
+	}
+	return ms, nil
+}
+
+func (m *MultiSender) SendMetrics(ctx context.Context, metrics []model.Metrics) error {
+	if m.mode == SendModeFanout {
+		return m.fanout(ctx, metrics)
+	}
+	return m.failover(ctx, metrics)
+}
+
+// перебирает серверы по приоритету, пропуская те, у которых разомкнута цепь.
+// восстановившийся сервер с более высоким приоритетом снова становится основным.
+func (m *MultiSender) failover(ctx context.Context, metrics []model.Metrics) error {
+	var errs []error
+	for i, ep := range m.endpoints {
+		if ep.circuitOpen() {
+			ep.skip()
+			errs = append(errs, fmt.Errorf("%s: %w", ep.Name, ErrCircuitOpen))
+			continue
+		}
+
+		epCtx, cancel := endpointContext(ctx, len(m.endpoints)-i)
+		err := ep.send(epCtx, metrics)
+		cancel()
+		ep.record(err)
+		if err == nil {
+			return nil
+		}
+		castomLogger.Warnf("Endpoint %s failed, trying next: %v", ep.Name, err)
+		errs = append(errs, fmt.Errorf("%s: %w", ep.Name, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("all endpoints failed: %w", errors.Join(errs...))
+}
+
+// доля оставшегося времени ctx на один сервер из remaining: зависший основной
+// сервер не должен со своими повторами съесть весь срок и оставить резервные без попытки.
+// время, не потраченное сервером, достаётся следующим.
+func endpointContext(ctx context.Context, remaining int) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok || remaining <= 1 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+}
+
+// отправляет на все серверы параллельно. ошибка возвращается, только если не удалось никуда:
+// повтор батча продублировал бы счётчики на серверах, которые его уже приняли.
+func (m *MultiSender) fanout(ctx context.Context, metrics []model.Metrics) error {
+	errs := make([]error, len(m.endpoints))
+
+	var wg sync.WaitGroup
+	for i, ep := range m.endpoints {
+		if ep.circuitOpen() {
+			ep.skip()
+			errs[i] = fmt.Errorf("%s: %w", ep.Name, ErrCircuitOpen)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ep.send(ctx, metrics)
+			ep.record(err)
+			if err != nil {
+				castomLogger.Warnf("Endpoint %s failed: %v", ep.Name, err)
+				errs[i] = fmt.Errorf("%s: %w", ep.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("all endpoints failed: %w", errors.Join(errs...))
+}
+
+// true, если отправлять некуда: цепь разомкнута у всех серверов.
+func (m *MultiSender) CircuitOpen() bool {
+	for _, ep := range m.endpoints {
+		if !ep.circuitOpen() {
+			return false
+		}
+	}
+	return true
+}
+
+// текущие результаты отправки по серверам в порядке приоритета.
+func (m *MultiSender) EndpointStats() []EndpointStats {
+	out := make([]EndpointStats, 0, len(m.endpoints))
+	for _, ep := range m.endpoints {
+		ep.mu.Lock()
+		out = append(out, ep.stats)
+		ep.mu.Unlock()
+	}
+	return out
+}
+
+// метрики самотелеметрии по серверам с меткой endpoint, счётчики — приращения с прошлого вызова.
+func (m *MultiSender) SelfMetrics() []model.Metrics {
+	metrics := make([]model.Metrics, 0, len(m.endpoints)*4)
+	for _, ep := range m.endpoints {
+		ep.mu.Lock()
+		sent, failed, skipped := ep.sentDelta, ep.failedDelta, ep.skippedDelta
+		ep.sentDelta, ep.failedDelta, ep.skippedDelta = 0, 0, 0
+		healthy := 0.0
+		if ep.stats.Healthy {
+			healthy = 1
+		}
+		ep.mu.Unlock()
+
+		labels := map[string]string{"endpoint": ep.Name}
+		for _, c := range []struct {
+			name  string
+			delta int64
+		}{
+			{"endpoint_batches_sent", sent},
+			{"endpoint_batches_failed", failed},
+			{"endpoint_batches_skipped", skipped},
+		} {
+			delta := c.delta
+			metrics = append(metrics, model.Metrics{
+				ID:    model.SeriesID(SelfTelemetryPrefix+c.name, labels),
+				MType: model.Counter,
+				Delta: &delta,
+			})
+		}
+		metrics = append(metrics, model.Metrics{
+			ID:    model.SeriesID(SelfTelemetryPrefix+"endpoint_healthy", labels),
+			MType: model.Gauge,
+			Value: &healthy,
+		})
+	}
+	return metrics
+}
+
+// возвращает приращения из недоставленного отчёта SelfMetrics.
+func (m *MultiSender) RestoreSelfMetrics(metrics []model.Metrics) {
+	for _, ep := range m.endpoints {
+		labels := map[string]string{"endpoint": ep.Name}
+		ep.mu.Lock()
+		byID := map[string]*int64{
+			model.SeriesID(SelfTelemetryPrefix+"endpoint_batches_sent", labels):    &ep.sentDelta,
+			model.SeriesID(SelfTelemetryPrefix+"endpoint_batches_failed", labels):  &ep.failedDelta,
+			model.SeriesID(SelfTelemetryPrefix+"endpoint_batches_skipped", labels): &ep.skippedDelta,
+		}
+		for _, m := range metrics {
+			if v, ok := byID[m.ID]; ok && m.Delta != nil {
+				*v += *m.Delta
+			}
+		}
+		ep.mu.Unlock()
+	}
+}
@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// префикс метрик процессов по умолчанию.
+const defaultProcessPrefix = "process_"
+
+// правила выбора процессов для ProcessCollector.
+// процесс попадает в выборку, если подходит хотя бы под одно правило.
+type ProcessFilter struct {
+	Names    []string `json:"names"`    // шаблоны имени процесса (path.Match), например "nginx" или "postgres*"
+	Cmdlines []string `json:"cmdlines"` // регулярные выражения по командной строке
+	Pidfiles []string `json:"pidfiles"` // файлы с PID процесса
+	Prefix   string   `json:"prefix"`   // префикс метрик, по умолчанию process_
+}
+
+// пустой фильтр не выбирает ни одного процесса.
+func (f ProcessFilter) IsEmpty() bool {
+	return len(f.Names) == 0 && len(f.Cmdlines) == 0 && len(f.Pidfiles) == 0
+}
+
+// собирает CPU%, RSS, открытые дескрипторы, потоки и IO выбранных процессов.
+// метрики помечаются метками name и pid: process_cpu_percent{name="nginx",pid="42"}.
+type ProcessCollector struct {
+	filter   ProcessFilter
+	cmdlines []*regexp.Regexp
+	prefix   string
+
+	mu     sync.Mutex
+	procs  map[int32]*trackedProcess
+	totals *cumulativeTracker
+}
+
+// процесс кешируется между опросами, чтобы CPU% считался за интервал опроса,
+// а не за всё время жизни процесса.
+type trackedProcess struct {
+	proc      *process.Process
+	createdAt int64
+	cpuPrimed bool                // первый замер CPU уже сделан
+	counters  map[string]struct{} // серии счётчиков процесса в pc.totals
+}
+
+// серии счётчиков, которые нужно забыть вместе с процессом
+func (tp *trackedProcess) counterIDs() []string {
+	ids := make([]string, 0, len(tp.counters))
+	for id := range tp.counters {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func NewProcessCollector(filter ProcessFilter) (*ProcessCollector, error) {
+	if filter.IsEmpty() {
+		return nil, fmt.Errorf("process collector: no include patterns")
+	}
+	for _, pattern := range filter.Names {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("process collector: bad name pattern %q: %w", pattern, err)
+		}
+	}
+
+	pc := &ProcessCollector{
+		filter: filter,
+		prefix: filter.Prefix,
+		procs:  make(map[int32]*trackedProcess),
+		totals: newCumulativeTracker(),
+	}
+	if pc.prefix == "" {
+		pc.prefix = defaultProcessPrefix
+	}
+	for _, expr := range filter.Cmdlines {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("process collector: bad cmdline regex %q: %w", expr, err)
+		}
+		pc.cmdlines = append(pc.cmdlines, re)
+	}
+	return pc, nil
+}
+
+func (pc *ProcessCollector) Collect() []model.Metrics {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+
+	selected := pc.selectProcesses()
+
+	metrics := make([]model.Metrics, 0, len(selected)*7)
+	seen := make(map[int32]struct{}, len(selected))
+	for _, p := range selected {
+		tp := pc.track(p)
+		if tp == nil {
+			continue
+		}
+		seen[p.Pid] = struct{}{}
+		metrics = append(metrics, pc.collectOne(tp)...)
+	}
+
+	// забываем завершившиеся процессы вместе с накопленными значениями счётчиков
+	for pid, tp := range pc.procs {
+		if _, ok := seen[pid]; !ok {
+			pc.totals.Forget(tp.counterIDs()...)
+			delete(pc.procs, pid)
+		}
+	}
+	return metrics
+}
+
+func (pc *ProcessCollector) selectProcesses() []*process.Process {
+	byPID := make(map[int32]*process.Process)
+
+	for _, pidfile := range pc.filter.Pidfiles {
+		pid, err := readPidfile(pidfile)
+		if err != nil {
+			castomLogger.Warnf("process collector: %v", err)
+			continue
+		}
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		byPID[pid] = p
+	}
+
+	if len(pc.filter.Names) > 0 || len(pc.cmdlines) > 0 {
+		all, err := process.Processes()
+		if err != nil {
+			castomLogger.Warnf("process collector: list processes: %v", err)
+		}
+		for _, p := range all {
+			if _, ok := byPID[p.Pid]; ok {
+				continue
+			}
+			if pc.matches(p) {
+				byPID[p.Pid] = p
+			}
+		}
+	}
+
+	out := make([]*process.Process, 0, len(byPID))
+	for _, p := range byPID {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (pc *ProcessCollector) matches(p *process.Process) bool {
+	if len(pc.filter.Names) > 0 {
+		if name, err := p.Name(); err == nil {
+			for _, pattern := range pc.filter.Names {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+		}
+	}
+	if len(pc.cmdlines) > 0 {
+		if cmdline, err := p.Cmdline(); err == nil && cmdline != "" {
+			for _, re := range pc.cmdlines {
+				if re.MatchString(cmdline) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// возвращает закешированный процесс, заменяя его, если PID был переиспользован.
+func (pc *ProcessCollector) track(p *process.Process) *trackedProcess {
+	created, err := p.CreateTime()
+	if err != nil {
+		return nil
+	}
+	old, ok := pc.procs[p.Pid]
+	if ok && old.createdAt == created {
+		return old
+	}
+	if ok {
+		// новый процесс с тем же PID начинает счётчики заново
+		pc.totals.Forget(old.counterIDs()...)
+	}
+	tp := &trackedProcess{proc: p, createdAt: created, counters: make(map[string]struct{})}
+	pc.procs[p.Pid] = tp
+	return tp
+}
+
+func (pc *ProcessCollector) collectOne(tp *trackedProcess) []model.Metrics {
+	p := tp.proc
+	name, err := p.Name()
+	if err != nil {
+		name = "unknown"
+	}
+	labels := map[string]string{
+		"name": name,
+		"pid":  strconv.FormatInt(int64(p.Pid), 10),
+	}
+
+	metrics := make([]model.Metrics, 0, 7)
+	addGauge := func(id string, value float64) {
+		val := value
+		metrics = append(metrics, model.Metrics{
+			ID:    model.SeriesID(pc.prefix+id, labels),
+			MType: model.Gauge,
+			Value: &val,
+		})
+	}
+	addCounter := func(id string, total uint64) {
+		seriesID := model.SeriesID(pc.prefix+id, labels)
+		tp.counters[seriesID] = struct{}{}
+		delta, ok := pc.totals.Delta(seriesID, float64(total))
+		if !ok {
+			return
+		}
+		metrics = append(metrics, model.Metrics{
+			ID:    seriesID,
+			MType: model.Counter,
+			Delta: &delta,
+		})
+	}
+
+	// первый вызов Percent(0) только запоминает время CPU и возвращает 0:
+	// такой замер не отправляем, иначе новый процесс на один опрос выглядит простаивающим
+	if cpu, err := p.Percent(0); err == nil {
+		if tp.cpuPrimed {
+			addGauge("cpu_percent", cpu)
+		}
+		tp.cpuPrimed = true
+	}
+	if memInfo, err := p.MemoryInfo(); err == nil {
+		addGauge("rss_bytes", float64(memInfo.RSS))
+	}
+	if fds, err := p.NumFDs(); err == nil {
+		addGauge("open_fds", float64(fds))
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		addGauge("threads", float64(threads))
+	}
+	// IO доступен не на всех платформах и не для чужих процессов без прав
+	if io, err := p.IOCounters(); err == nil {
+		addCounter("io_read_bytes", io.ReadBytes)
+		addCounter("io_write_bytes", io.WriteBytes)
+	}
+
+	return metrics
+}
+
+func readPidfile(name string) (int32, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, fmt.Errorf("read pidfile: %w", err)
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("pidfile %s: invalid pid %q", name, strings.TrimSpace(string(data)))
+	}
+	return int32(pid), nil
+}
+
+// проверяем на этапе компиляции
+var _ model.MetricsSource = (*ProcessCollector)(nil)
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// одна строка-сэмпл текстового формата Prometheus.
+type promSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+	Type   string // тип семейства из # TYPE: counter, gauge, histogram, summary, untyped
+}
+
+// разбирает текстовый формат экспозиции Prometheus (version 0.0.4).
+// комментарии HELP пропускаются, из TYPE берётся тип семейства.
+// сэмплы с нечисловыми значениями (NaN, ±Inf) отбрасываются.
+func parsePrometheusText(r io.Reader) ([]promSample, error) {
+	types := make(map[string]string)
+	var samples []promSample
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			fields := strings.Fields(line)
+			if len(fields) >= 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+
+		s, err := parsePromSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		s.Type = promFamilyType(types, s.Name)
+		samples = append(samples, s)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// ищет тип семейства для сэмпла, учитывая суффиксы гистограмм и summary.
+func promFamilyType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count", "_total"} {
+		if base, ok := strings.CutSuffix(name, suffix); ok {
+			if t, ok := types[base]; ok {
+				return t
+			}
+		}
+	}
+	return "untyped"
+}
+
+func parsePromSample(line string) (promSample, error) {
+	var s promSample
+
+	nameEnd := strings.IndexAny(line, "{ \t")
+	if nameEnd <= 0 {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	s.Name = line[:nameEnd]
+	rest := line[nameEnd:]
+
+	if strings.HasPrefix(rest, "{") {
+		labels, n, err := parsePromLabels(rest)
+		if err != nil {
+			return s, err
+		}
+		s.Labels = labels
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value for %s", s.Name)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("bad value for %s: %w", s.Name, err)
+	}
+	s.Value = v
+	return s, nil
+}
+
+// разбирает блок меток {a="b",c="d"} и возвращает количество прочитанных байт.
+func parsePromLabels(s string) (map[string]string, int, error) {
+	labels := make(map[string]string)
+	i := 1 // пропускаем '{'
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == ',') {
+			i++
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("unterminated label set")
+		}
+		if s[i] == '}' {
+			return labels, i + 1, nil
+		}
+
+		eq := strings.IndexByte(s[i:], '=')
+		if eq < 0 {
+			return nil, 0, fmt.Errorf("invalid label set")
+		}
+		key := strings.TrimSpace(s[i : i+eq])
+		i += eq + 1
+		if i >= len(s) || s[i] != '"' {
+			return nil, 0, fmt.Errorf("label %s: value must be quoted", key)
+		}
+		i++
+
+		var val strings.Builder
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				switch s[i] {
+				case 'n':
+					val.WriteByte('\n')
+				default:
+					val.WriteByte(s[i])
+				}
+				continue
+			}
+			val.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, 0, fmt.Errorf("label %s: unterminated value", key)
+		}
+		i++ // закрывающая кавычка
+		labels[key] = val.String()
+	}
+}
@@ -0,0 +1,265 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// форматы, которые умеет разбирать ScrapeCollector.
+const (
+	ScrapeFormatPrometheus = "prometheus"
+	ScrapeFormatJSON       = "json"
+)
+
+// действия правил переименования.
+const (
+	RelabelReplace = "replace"
+	RelabelKeep    = "keep"
+	RelabelDrop    = "drop"
+)
+
+// правило переименования серии, применяется к ID вида name{label="value"}.
+// регулярное выражение якорится целиком, как в relabel_configs Prometheus.
+type RelabelRule struct {
+	Action      string `json:"action"`      // replace (по умолчанию), keep или drop
+	Regex       string `json:"regex"`       // выражение для ID серии
+	Replacement string `json:"replacement"` // новое ID для replace, поддерживает $1, ${name}
+}
+
+// описание локального HTTP-эндпоинта, с которого агент забирает метрики.
+type ScrapeTarget struct {
+	URL      string        `json:"url"`
+	Format   string        `json:"format"`   // prometheus (по умолчанию) или json
+	Prefix   string        `json:"prefix"`   // префикс, добавляемый к именам метрик
+	Counters []string      `json:"counters"` // ключи плоского JSON, которые являются счётчиками
+	Relabel  []RelabelRule `json:"relabel"`
+}
+
+type compiledRelabel struct {
+	action      string
+	re          *regexp.Regexp
+	replacement string
+}
+
+type scrapeTarget struct {
+	ScrapeTarget
+	relabel []compiledRelabel
+}
+
+// забирает метрики из Prometheus/JSON эндпоинтов локальных приложений
+// и превращает их в model.Metrics, чтобы агент переслал их на сервер.
+type ScrapeCollector struct {
+	client  *http.Client
+	targets []scrapeTarget
+	totals  *cumulativeTracker
+}
+
+// создаёт сборщик, проверяя формат и правила переименования каждой цели.
+func NewScrapeCollector(targets []ScrapeTarget, timeout time.Duration) (*ScrapeCollector, error) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	compiled := make([]scrapeTarget, 0, len(targets))
+	for _, t := range targets {
+		if t.URL == "" {
+			return nil, fmt.Errorf("scrape target: empty url")
+		}
+		if t.Format == "" {
+			t.Format = ScrapeFormatPrometheus
+		}
+		if t.Format != ScrapeFormatPrometheus && t.Format != ScrapeFormatJSON {
+			return nil, fmt.Errorf("scrape target %s: unknown format %q", t.URL, t.Format)
+		}
+
+		st := scrapeTarget{ScrapeTarget: t}
+		for _, rule := range t.Relabel {
+			action := rule.Action
+			if action == "" {
+				action = RelabelReplace
+			}
+			if action != RelabelReplace && action != RelabelKeep && action != RelabelDrop {
+				return nil, fmt.Errorf("scrape target %s: unknown relabel action %q", t.URL, rule.Action)
+			}
+			re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("scrape target %s: bad relabel regex: %w", t.URL, err)
+			}
+			st.relabel = append(st.relabel, compiledRelabel{action: action, re: re, replacement: rule.Replacement})
+		}
+		compiled = append(compiled, st)
+	}
+
+	return &ScrapeCollector{
+		client:  &http.Client{Timeout: timeout},
+		targets: compiled,
+		totals:  newCumulativeTracker(),
+	}, nil
+}
+
+// опрашивает все цели, недоступная цель не мешает остальным.
+func (sc *ScrapeCollector) Collect() []model.Metrics {
+	var metrics []model.Metrics
+	for i := range sc.targets {
+		t := &sc.targets[i]
+		got, err := sc.scrape(context.Background(), t)
+		if err != nil {
+			castomLogger.Warnf("scrape %s failed: %v", t.URL, err)
+			continue
+		}
+		metrics = append(metrics, got...)
+	}
+	return metrics
+}
+
+func (sc *ScrapeCollector) scrape(ctx context.Context, t *scrapeTarget) ([]model.Metrics, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if t.Format == ScrapeFormatPrometheus {
+		req.Header.Set("Accept", "text/plain;version=0.0.4")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Format == ScrapeFormatJSON {
+		return sc.fromJSON(t, body)
+	}
+	return sc.fromPrometheus(t, body)
+}
+
+func (sc *ScrapeCollector) fromPrometheus(t *scrapeTarget, body []byte) ([]model.Metrics, error) {
+	samples, err := parsePrometheusText(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]model.Metrics, 0, len(samples))
+	for _, s := range samples {
+		id, ok := t.apply(model.SeriesID(s.Name, s.Labels))
+		if !ok {
+			continue
+		}
+
+		isCounter := s.Type == "counter" ||
+			((s.Type == "histogram" || s.Type == "summary") &&
+				(strings.HasSuffix(s.Name, "_count") || strings.HasSuffix(s.Name, "_bucket")))
+
+		if m, ok := sc.toMetric(id, s.Value, isCounter); ok {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
+
+func (sc *ScrapeCollector) fromJSON(t *scrapeTarget, body []byte) ([]model.Metrics, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	metrics := make([]model.Metrics, 0, len(doc))
+	for key, raw := range doc {
+		var value float64
+		switch v := raw.(type) {
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				continue
+			}
+			value = f
+		case bool:
+			if v {
+				value = 1
+			}
+		default:
+			// вложенные объекты и строки в плоском формате не поддерживаются
+			continue
+		}
+
+		id, ok := t.apply(key)
+		if !ok {
+			continue
+		}
+		if m, ok := sc.toMetric(id, value, slices.Contains(t.Counters, key)); ok {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics, nil
+}
+
+func (sc *ScrapeCollector) toMetric(id string, value float64, isCounter bool) (model.Metrics, bool) {
+	if !isCounter {
+		v := value
+		return model.Metrics{ID: id, MType: model.Gauge, Value: &v}, true
+	}
+
+	delta, ok := sc.totals.Delta(id, value)
+	if !ok {
+		return model.Metrics{}, false
+	}
+	return model.Metrics{ID: id, MType: model.Counter, Delta: &delta}, true
+}
+
+// применяет правила переименования и префикс цели.
+// второе значение false, если серия отброшена правилом keep/drop
+// или попала в зарезервированное пространство самотелеметрии агента.
+func (t *scrapeTarget) apply(id string) (string, bool) {
+	for _, rule := range t.relabel {
+		matched := rule.re.MatchString(id)
+		switch rule.action {
+		case RelabelKeep:
+			if !matched {
+				return "", false
+			}
+		case RelabelDrop:
+			if matched {
+				return "", false
+			}
+		case RelabelReplace:
+			if matched {
+				id = rule.re.ReplaceAllString(id, rule.replacement)
+			}
+		}
+	}
+	if id == "" {
+		return "", false
+	}
+	id = t.Prefix + id
+	if strings.HasPrefix(id, SelfTelemetryPrefix) {
+		return "", false
+	}
+	return id, true
+}
+
+// проверяем на этапе компиляции
+var _ model.MetricsSource = (*ScrapeCollector)(nil)
@@ -2,34 +2,107 @@ package agent
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"fmt"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	grpcserver "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/grpc"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
 	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
 	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type GRPCSender struct {
-	conn   *grpc.ClientConn
-	client pb.MetricsClient
+	conn        *grpc.ClientConn
+	client      pb.MetricsClient
+	retryConfig RetryConfig
+	breaker     *CircuitBreaker
+	hashKey     string
+	pubKey      *rsa.PublicKey
+	tls         *tls.Config
+	authToken   string
+	agentID     string
 }
 
-func NewGRPCSender(addr string) (*GRPCSender, error) {
+// дополнительная настройка gRPC отправителя
+type GRPCOption func(*GRPCSender)
+
+// подписывает запросы HMAC-SHA256, как HTTPSender.
+func WithGRPCHashKey(key string) GRPCOption {
+	return func(s *GRPCSender) {
+		s.hashKey = key
+	}
+}
+
+// шифрует запросы публичным ключом сервера гибридной схемой AES-GCM+RSA.
+func WithGRPCPublicKey(pub *rsa.PublicKey) GRPCOption {
+	return func(s *GRPCSender) {
+		s.pubKey = pub
+	}
+}
+
+// подключается по TLS вместо открытого соединения.
+func WithGRPCTLS(cfg *tls.Config) GRPCOption {
+	return func(s *GRPCSender) {
+		s.tls = cfg
+	}
+}
+
+// передаёт API токен в метаданных authorization.
+func WithGRPCToken(token string) GRPCOption {
+	return func(s *GRPCSender) {
+		s.authToken = token
+	}
+}
+
+// идентификатор агента для выбора его собственного ключа подписи на сервере.
+func WithGRPCAgentID(id string) GRPCOption {
+	return func(s *GRPCSender) {
+		s.agentID = id
+	}
+}
+
+func NewGRPCSender(addr string, opts ...GRPCOption) (*GRPCSender, error) {
+	s := newGRPCSender(opts)
+
+	creds := insecure.NewCredentials()
+	if s.tls != nil {
+		creds = credentials.NewTLS(s.tls)
+	}
+
 	conn, err := grpc.NewClient(
 		addr,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithTransportCredentials(creds),
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	return &GRPCSender{
-		conn:   conn,
-		client: pb.NewMetricsClient(conn),
-	}, nil
+	s.conn = conn
+	s.client = pb.NewMetricsClient(conn)
+	return s, nil
+}
+
+func newGRPCSender(opts []GRPCOption) *GRPCSender {
+	s := &GRPCSender{
+		retryConfig: DefaultRetryConfig(),
+		breaker:     NewCircuitBreaker(DefaultBreakerConfig()),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *GRPCSender) SendMetrics(ctx context.Context, metrics []model.Metrics) error {
@@ -52,15 +125,43 @@ func (s *GRPCSender) SendMetrics(ctx context.Context, metrics []model.Metrics) e
 		protoMetrics = append(protoMetrics, pm)
 	}
 
+	req := &pb.UpdateMetricsRequest{
+		Metrics: protoMetrics,
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	md := metadata.MD{}
 	ip, err := getLocalIP()
 	if err == nil {
-		md := metadata.New(map[string]string{
-			"x-real-ip": ip.String(),
-		})
-		ctx = metadata.NewOutgoingContext(ctx, md)
+		md.Set("x-real-ip", ip.String())
+	}
+	if s.agentID != "" {
+		md.Set(keyring.HeaderAgentID, s.agentID)
+	}
+	// метод, метка времени и nonce подписываются вместе с сообщением, каждая попытка получает новый nonce
+	if s.hashKey != "" {
+		timestamp, nonce, err := keyring.NewSignatureParams()
+		if err != nil {
+			return fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		hash, err := grpcserver.ComputeSignedHash(s.hashKey, pb.Metrics_UpdateMetrics_FullMethodName, timestamp, nonce, req)
+		if err != nil {
+			return fmt.Errorf("failed to compute hash: %w", err)
+		}
+		md.Set(keyring.HeaderTimestamp, timestamp)
+		md.Set(keyring.HeaderNonce, nonce)
+		md.Set(grpcserver.HashMetadataKey, hash)
+	}
+	if s.authToken != "" {
+		md.Set(grpcserver.AuthMetadataKey, "Bearer "+s.authToken)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	callOpts := []grpc.CallOption{grpc.UseCompressor(gzip.Name)}
+	if s.pubKey != nil {
+		callOpts = append(callOpts, grpc.ForceCodec(encryptingCodec{pub: s.pubKey}))
 	}
 
 	logger.NewHTTPLogger().Logger.Sugar().Infof(
@@ -68,11 +169,63 @@ func (s *GRPCSender) SendMetrics(ctx context.Context, metrics []model.Metrics) e
 		ip,
 	)
 
-	_, err = s.client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
-		Metrics: protoMetrics,
-	})
+	var trailer metadata.MD
+	callOpts = append(callOpts, grpc.Trailer(&trailer))
+	_, err = s.client.UpdateMetrics(ctx, req, callOpts...)
+
+	return s.classifyError(err, trailer)
+}
+
+// повторяет операцию с экспоненциальной задержкой и джиттером,
+// не обращается к серверу, пока цепь разомкнута.
+func (s *GRPCSender) Retry(ctx context.Context, operation func() error) error {
+	return retryWithBackoff(ctx, s.retryConfig, s.breaker, operation)
+}
+
+// задаёт параметры повторов отправки.
+func (s *GRPCSender) SetRetryConfig(cfg RetryConfig) {
+	s.retryConfig = cfg
+}
+
+// задаёт размыкатель цепи; один размыкатель можно разделить между отправителями одного эндпоинта.
+func (s *GRPCSender) SetBreaker(cb *CircuitBreaker) {
+	s.breaker = cb
+}
 
-	return err
+// состояние размыкателя цепи для эндпоинта отправителя.
+func (s *GRPCSender) BreakerState() BreakerState {
+	return s.breaker.State()
+}
+
+// true, пока сервер считается недоступным и отправка бессмысленна.
+func (s *GRPCSender) CircuitOpen() bool {
+	return s.breaker.State() == BreakerOpen
+}
+
+// помечает ошибки недоступности сервера как повторяемые и учитывает их в размыкателе цепи.
+// сервер может передать задержку перед повтором в trailer retry-after (секунды).
+func (s *GRPCSender) classifyError(err error, trailer metadata.MD) error {
+	if err == nil {
+		s.breaker.Record(false)
+		return nil
+	}
+
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		s.breaker.Record(true)
+		if v := trailer.Get("retry-after"); len(v) > 0 {
+			if after, ok := parseRetryAfter(v[0]); ok {
+				return NewRetriableError(RetryAfterError{error: err, After: after})
+			}
+		}
+		return NewRetriableError(err)
+	case codes.Canceled:
+		return err
+	default:
+		// сервер ответил, значит он доступен
+		s.breaker.Record(false)
+		return err
+	}
 }
 
 func (s *GRPCSender) Close() error {
@@ -93,10 +246,43 @@ func mapModelType(t string) pb.Metric_MType {
 // проверяем на этапе компиляции
 var _ model.MetricsSender = (*GRPCSender)(nil)
 
-// для тестов
-func NewGRPCSenderWithConn(conn *grpc.ClientConn) *GRPCSender {
-	return &GRPCSender{
-		conn:   conn,
-		client: pb.NewMetricsClient(conn),
+// для тестов; WithGRPCTLS здесь не действует, транспорт задаёт conn
+func NewGRPCSenderWithConn(conn *grpc.ClientConn, opts ...GRPCOption) *GRPCSender {
+	s := newGRPCSender(opts)
+	s.conn = conn
+	s.client = pb.NewMetricsClient(conn)
+	return s
+}
+
+// кодек агента: шифрует сериализованный запрос конвертом, сервер распознаёт его по префиксу.
+type encryptingCodec struct {
+	pub *rsa.PublicKey
+}
+
+func (c encryptingCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := envelope.Seal(c.pub, envelope.KeyID(c.pub), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt message: %w", err)
+	}
+	return append(append([]byte{}, grpcserver.EncryptedMagic...), encrypted...), nil
+}
+
+func (c encryptingCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
 	}
+	return proto.Unmarshal(data, msg)
+}
+
+func (c encryptingCodec) Name() string {
+	return "proto"
 }
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// префикс, зарезервированный за собственными метриками агента.
+const SelfTelemetryPrefix = "agent_self_"
+
+// статистика отправки агента. счётчики накапливаются между отчётами
+// и уходят на сервер как приращения, поэтому сервер видит суммарные значения.
+type Stats struct {
+	batchesSent     atomic.Int64
+	metricsSent     atomic.Int64
+	attemptsFailed  atomic.Int64
+	retries         atomic.Int64
+	batchesDropped  atomic.Int64
+	metricsDropped  atomic.Int64
+	batchesDeferred atomic.Int64
+
+	lastSuccess atomic.Int64 // unix nano последней успешной отправки
+	lastLatency atomic.Int64 // длительность последней успешной отправки в наносекундах
+}
+
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// учитывает результат отправки батча: attempts — число вызовов отправки с учётом повторов.
+func (s *Stats) ObserveSend(size, attempts int, latency time.Duration, err error) {
+	if attempts > 1 {
+		s.retries.Add(int64(attempts - 1))
+	}
+	if err != nil {
+		// неуспешны все попытки, метрики батча потеряны
+		s.attemptsFailed.Add(int64(attempts))
+		s.batchesDropped.Add(1)
+		s.metricsDropped.Add(int64(size))
+		return
+	}
+	if attempts > 1 {
+		s.attemptsFailed.Add(int64(attempts - 1))
+	}
+	s.batchesSent.Add(1)
+	s.metricsSent.Add(int64(size))
+	s.lastSuccess.Store(time.Now().UnixNano())
+	s.lastLatency.Store(int64(latency))
+}
+
+// учитывает батч, который не поместился в очередь и вернулся в буфер.
+func (s *Stats) ObserveDeferred() {
+	s.batchesDeferred.Add(1)
+}
+
+type statsCounter struct {
+	name string
+	v    *atomic.Int64
+}
+
+// счётчики в порядке отчёта
+func (s *Stats) counters() []statsCounter {
+	return []statsCounter{
+		{"batches_sent", &s.batchesSent},
+		{"metrics_sent", &s.metricsSent},
+		{"send_attempts_failed", &s.attemptsFailed},
+		{"send_retries", &s.retries},
+		{"batches_dropped", &s.batchesDropped},
+		{"metrics_dropped", &s.metricsDropped},
+		{"batches_deferred", &s.batchesDeferred},
+	}
+}
+
+// отдаёт метрики агента; счётчики обнуляются, чтобы следующий отчёт содержал только новые события.
+// если отчёт не доставлен, приращения возвращаются через Restore.
+// spool — число метрик, ожидающих отправки, queued — число батчей в очереди воркеров.
+func (s *Stats) Snapshot(spool, queued int) []model.Metrics {
+	metrics := make([]model.Metrics, 0, 11)
+
+	for _, c := range s.counters() {
+		delta := c.v.Swap(0)
+		metrics = append(metrics, model.Metrics{
+			ID:    SelfTelemetryPrefix + c.name,
+			MType: model.Counter,
+			Delta: &delta,
+		})
+	}
+	addGauge := func(id string, value float64) {
+		val := value
+		metrics = append(metrics, model.Metrics{
+			ID:    SelfTelemetryPrefix + id,
+			MType: model.Gauge,
+			Value: &val,
+		})
+	}
+
+	addGauge("spool_size", float64(spool))
+	addGauge("queue_batches", float64(queued))
+	if ts := s.lastSuccess.Load(); ts > 0 {
+		addGauge("last_success_timestamp_seconds", float64(ts)/float64(time.Second))
+		addGauge("send_latency_seconds", time.Duration(s.lastLatency.Load()).Seconds())
+	}
+
+	return metrics
+}
+
+// возвращает приращения счётчиков из недоставленного отчёта, чтобы они ушли со следующим:
+// иначе сбой отправки стёр бы как раз число сбоев.
+func (s *Stats) Restore(metrics []model.Metrics) {
+	byID := make(map[string]*atomic.Int64)
+	for _, c := range s.counters() {
+		byID[SelfTelemetryPrefix+c.name] = c.v
+	}
+	for _, m := range metrics {
+		if v, ok := byID[m.ID]; ok && m.Delta != nil {
+			v.Add(*m.Delta)
+		}
+	}
+}
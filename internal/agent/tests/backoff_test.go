@@ -0,0 +1,279 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/mocks"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func fastRetryConfig(attempts int) agent.RetryConfig {
+	return agent.RetryConfig{
+		MaxAttempts:  attempts,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     5 * time.Millisecond,
+	}
+}
+
+func TestCircuitBreaker_Transitions(t *testing.T) {
+	cb := agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 2, OpenTimeout: 30 * time.Millisecond})
+	assert.Equal(t, agent.BreakerClosed, cb.State())
+
+	cb.Record(true)
+	assert.Equal(t, agent.BreakerClosed, cb.State())
+	cb.Record(true)
+	assert.Equal(t, agent.BreakerOpen, cb.State())
+	assert.False(t, cb.Allow())
+
+	time.Sleep(40 * time.Millisecond)
+	assert.Equal(t, agent.BreakerHalfOpen, cb.State())
+	assert.True(t, cb.Allow(), "пробный запрос должен пройти")
+	assert.False(t, cb.Allow(), "второй запрос в полуоткрытом состоянии не пропускается")
+
+	// неудачный пробный запрос снова размыкает цепь
+	cb.Record(true)
+	assert.Equal(t, agent.BreakerOpen, cb.State())
+
+	time.Sleep(40 * time.Millisecond)
+	require.True(t, cb.Allow())
+	cb.Record(false)
+	assert.Equal(t, agent.BreakerClosed, cb.State())
+	assert.True(t, cb.Allow())
+}
+
+func TestHTTPSender_RetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	var first, second time.Time
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		second = time.Now()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	sender, err := agent.NewHTTPSender(server.URL, "", "")
+	require.NoError(t, err)
+	sender.SetRetryConfig(fastRetryConfig(3))
+
+	v := 1.0
+	metrics := []model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}}
+	// повторяет Retry, как в Agent.send: SendMetrics делает одну попытку
+	err = sender.Retry(context.Background(), func() error {
+		return sender.SendMetrics(context.Background(), metrics)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), calls.Load(), "при Retry-After поштучная отправка не выполняется")
+	assert.GreaterOrEqual(t, second.Sub(first), 900*time.Millisecond, "задержка должна учитывать Retry-After")
+}
+
+func TestHTTPSender_CircuitOpensOnServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	sender, err := agent.NewHTTPSender(server.URL, "", "")
+	require.NoError(t, err)
+	sender.SetRetryConfig(fastRetryConfig(3))
+	sender.SetBreaker(agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 3, OpenTimeout: time.Minute}))
+
+	v := 1.0
+	metrics := []model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}}
+
+	send := func() error {
+		return sender.Retry(context.Background(), func() error {
+			return sender.SendMetrics(context.Background(), metrics)
+		})
+	}
+
+	// батч и поштучная отправка в первой попытке, батч во второй
+	_ = send()
+	assert.True(t, sender.CircuitOpen())
+	assert.Equal(t, agent.BreakerOpen, sender.BreakerState())
+
+	sent := calls.Load()
+	err = send()
+	assert.ErrorIs(t, err, agent.ErrCircuitOpen)
+	assert.Equal(t, sent, calls.Load(), "при разомкнутой цепи запросы не отправляются")
+}
+
+func TestHTTPSender_ClientErrorKeepsCircuitClosed(t *testing.T) {
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+
+	sender, err := agent.NewHTTPSender(server.URL, "", "")
+	require.NoError(t, err)
+	sender.SetRetryConfig(fastRetryConfig(3))
+	sender.SetBreaker(agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+
+	v := 1.0
+	_ = sender.SendMetrics(context.Background(), []model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}})
+	assert.Equal(t, agent.BreakerClosed, sender.BreakerState())
+}
+
+type unavailableGRPCServer struct {
+	pb.UnimplementedMetricsServer
+	calls atomic.Int32
+}
+
+func (s *unavailableGRPCServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if s.calls.Add(1) == 1 {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", "0"))
+		return nil, status.Error(codes.Unavailable, "overloaded")
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+func TestGRPCSender_RetriesUnavailable(t *testing.T) {
+	srv := &unavailableGRPCServer{}
+	conn, cleanup := setupBufConnServer(t, srv)
+	defer cleanup()
+
+	sender := agent.NewGRPCSenderWithConn(conn)
+	sender.SetRetryConfig(fastRetryConfig(3))
+
+	v := 2.5
+	metrics := []model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}}
+
+	err := sender.SendMetrics(context.Background(), metrics)
+	require.Error(t, err)
+	assert.True(t, agent.IsRetriableError(err))
+
+	err = sender.Retry(context.Background(), func() error {
+		return sender.SendMetrics(context.Background(), metrics)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), srv.calls.Load())
+	assert.Equal(t, agent.BreakerClosed, sender.BreakerState())
+}
+
+func TestGRPCSender_InvalidArgumentNotRetriable(t *testing.T) {
+	conn, cleanup := setupBufConnServer(t, &pb.UnimplementedMetricsServer{})
+	defer cleanup()
+
+	sender := agent.NewGRPCSenderWithConn(conn)
+	sender.SetBreaker(agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+
+	v := 1.0
+	err := sender.SendMetrics(context.Background(), []model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}})
+	require.Error(t, err)
+	assert.False(t, agent.IsRetriableError(err))
+	assert.Equal(t, agent.BreakerClosed, sender.BreakerState())
+}
+
+// отправитель с разомкнутой цепью
+type openCircuitSender struct {
+	MockRetrySender
+}
+
+func (s *openCircuitSender) CircuitOpen() bool {
+	return true
+}
+
+func TestAgent_PausesWhileCircuitOpen(t *testing.T) {
+	collector := mocks.NewMetricsCollector(t)
+	config := mocks.NewConfigProvider(t)
+	sender := &openCircuitSender{}
+
+	v := 1.0
+	collector.On("Collect").Return([]model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}}).Maybe()
+	collector.On("CollectSystemMetrics").Return([]model.Metrics{}).Maybe()
+	config.On("GetPollInterval").Return(10 * time.Millisecond)
+	config.On("GetReportInterval").Return(20 * time.Millisecond)
+	config.On("GetRateLimit").Return(1)
+	// финальная отправка при остановке допустима, периодические — нет
+	sender.On("Retry", mock.Anything, mock.Anything).Return(errors.New("circuit open")).Maybe()
+
+	a := agent.NewAgent(collector, sender, config)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	require.NoError(t, a.Start(ctx))
+	assert.LessOrEqual(t, len(sender.Calls), 1, "пока цепь разомкнута, диспетчер не ставит батчи в очередь")
+}
+
+// сервер недоступен, затем восстанавливается: цепь проходит open → half-open → closed
+// через Agent.send, и агент снова отправляет метрики
+func TestAgent_CircuitRecoversAfterServerOutage(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var delivered atomic.Int32
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	sender, err := agent.NewHTTPSender(server.URL, "", "")
+	require.NoError(t, err)
+	sender.SetRetryConfig(fastRetryConfig(2))
+	sender.SetBreaker(agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond}))
+
+	collector := mocks.NewMetricsCollector(t)
+	config := mocks.NewConfigProvider(t)
+	v := 1.0
+	collector.On("Collect").Return([]model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}}).Maybe()
+	collector.On("CollectSystemMetrics").Return([]model.Metrics{}).Maybe()
+	config.On("GetPollInterval").Return(10 * time.Millisecond)
+	config.On("GetReportInterval").Return(20 * time.Millisecond)
+	config.On("GetRateLimit").Return(1)
+
+	a := agent.NewAgent(collector, sender, config)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Start(ctx) }()
+
+	require.Eventually(t, func() bool { return sender.BreakerState() == agent.BreakerOpen }, time.Second, 5*time.Millisecond)
+	down.Store(false)
+	require.Eventually(t, func() bool {
+		return sender.BreakerState() == agent.BreakerClosed && delivered.Load() > 0
+	}, time.Second, 5*time.Millisecond, "после восстановления сервера цепь должна замкнуться")
+
+	// отправка продолжается в замкнутом состоянии
+	sent := delivered.Load()
+	require.Eventually(t, func() bool { return delivered.Load() > sent }, time.Second, 5*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// пробный запрос, исход которого не учтён в Record (например, отмена), не занимает цепь навсегда
+func TestRetry_ReleasesUnrecordedTrial(t *testing.T) {
+	cb := agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond})
+	cb.Record(true)
+	time.Sleep(15 * time.Millisecond)
+	require.Equal(t, agent.BreakerHalfOpen, cb.State())
+
+	sender, err := agent.NewHTTPSender("http://localhost:8080", "", "")
+	require.NoError(t, err)
+	sender.SetRetryConfig(fastRetryConfig(1))
+	sender.SetBreaker(cb)
+
+	err = sender.Retry(context.Background(), func() error { return context.Canceled })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, agent.BreakerHalfOpen, cb.State())
+	assert.True(t, cb.Allow(), "пробный запрос снова доступен")
+}
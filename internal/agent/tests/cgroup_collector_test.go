@@ -0,0 +1,92 @@
+// Package tests
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// копирует фикстуру cgroup во временный каталог, чтобы тест мог менять файлы.
+func copyCgroupFixture(t *testing.T) string {
+	t.Helper()
+	dst := t.TempDir()
+	entries, err := os.ReadDir("testdata/cgroup")
+	require.NoError(t, err)
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join("testdata/cgroup", e.Name()))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dst, e.Name()), data, 0o644))
+	}
+	return dst
+}
+
+func TestNewCgroupCollector(t *testing.T) {
+	_, err := agent.NewCgroupCollector("testdata/cgroup")
+	assert.NoError(t, err)
+
+	_, err = agent.NewCgroupCollector(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+
+	// каталог без cgroup.controllers — не cgroup v2
+	_, err = agent.NewCgroupCollector(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestCgroupCollector_Fixture(t *testing.T) {
+	collector, err := agent.NewCgroupCollector("testdata/cgroup")
+	require.NoError(t, err)
+
+	got := metricsByID(collector.Collect())
+
+	assert.Equal(t, 268435456.0, *got["cgroup_memory_current_bytes"].Value)
+	assert.Equal(t, 536870912.0, *got["cgroup_memory_max_bytes"].Value)
+	assert.Equal(t, 1.5, *got["cgroup_cpu_limit_cores"].Value)
+	assert.Equal(t, 12.0, *got["cgroup_pids_current"].Value)
+	assert.NotContains(t, got, "cgroup_pids_max", "unlimited pids.max must be skipped")
+
+	// накопительные значения при первом чтении только запоминаются
+	assert.NotContains(t, got, "cgroup_cpu_usage_usec")
+	assert.NotContains(t, got, `cgroup_io_rbytes{device="8:0"}`)
+
+	for _, m := range got {
+		assert.Equal(t, model.Gauge, m.MType)
+	}
+}
+
+func TestCgroupCollector_CountersAndUnlimited(t *testing.T) {
+	root := copyCgroupFixture(t)
+	collector, err := agent.NewCgroupCollector(root)
+	require.NoError(t, err)
+	collector.Collect()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu.stat"),
+		[]byte("usage_usec 1250000\nuser_usec 800000\nsystem_usec 450000\nnr_periods 60\nnr_throttled 2\nthrottled_usec 15000\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "io.stat"),
+		[]byte("8:0 rbytes=5096 wbytes=8192 rios=2 wios=2 dbytes=0 dios=0\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory.max"), []byte("max\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "cpu.max"), []byte("max 100000\n"), 0o644))
+	require.NoError(t, os.Remove(filepath.Join(root, "pids.current")))
+
+	got := metricsByID(collector.Collect())
+
+	require.Contains(t, got, "cgroup_cpu_usage_usec")
+	assert.Equal(t, model.Counter, got["cgroup_cpu_usage_usec"].MType)
+	assert.Equal(t, int64(250000), *got["cgroup_cpu_usage_usec"].Delta)
+	assert.Equal(t, int64(150000), *got["cgroup_cpu_system_usec"].Delta)
+	assert.Equal(t, int64(0), *got["cgroup_cpu_nr_throttled"].Delta)
+
+	require.Contains(t, got, `cgroup_io_rbytes{device="8:0"}`)
+	assert.Equal(t, int64(1000), *got[`cgroup_io_rbytes{device="8:0"}`].Delta)
+	assert.NotContains(t, got, `cgroup_io_rbytes{device="253:1"}`)
+
+	assert.NotContains(t, got, "cgroup_memory_max_bytes")
+	assert.NotContains(t, got, "cgroup_cpu_limit_cores")
+	assert.NotContains(t, got, "cgroup_pids_current")
+	assert.Contains(t, got, "cgroup_memory_current_bytes")
+}
@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// пишет пару RSA ключей в PEM файлы, как их генерирует cmd/reset
+func writeRSAKeyPair(t *testing.T) (privPath, pubPath string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	privPath = filepath.Join(dir, "private.pem")
+	pubPath = filepath.Join(dir, "public.pem")
+
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(privPath, privPEM, 0o600))
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0o600))
+	return privPath, pubPath
+}
+
+func TestHTTPSender_EnvelopeEncryption(t *testing.T) {
+	privPath, pubPath := writeRSAKeyPair(t)
+
+	tests := []struct {
+		name       string
+		legacy     bool
+		allowOld   bool
+		wantHeader string
+		wantOK     bool
+	}{
+		{name: "envelope by default", wantHeader: envelope.HeaderValue, wantOK: true},
+		{name: "legacy accepted with compat flag", legacy: true, allowOld: true, wantHeader: "rsa", wantOK: true},
+		{name: "legacy rejected without compat flag", legacy: true, wantHeader: "rsa", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header string
+			var received []model.Metrics
+			inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(body, &received)
+				w.WriteHeader(http.StatusOK)
+			})
+			// порядок как в роутере сервера: сначала расшифровка, потом распаковка
+			handler := middleware.DecryptMiddleware(privPath, tt.allowOld)(middleware.GzipDecompression(inner))
+			server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+				// первый запрос — батч, после отказа агент откатывается на поштучную отправку
+				if header == "" {
+					header = r.Header.Get("X-Encrypted")
+				}
+				handler.ServeHTTP(w, r)
+			})
+
+			sender, err := agent.NewHTTPSender(server.URL, "", pubPath)
+			require.NoError(t, err)
+			sender.SetLegacyEncryption(tt.legacy)
+			sender.SetRetryConfig(fastRetryConfig(1))
+
+			_ = sender.SendMetrics(context.Background(), testBatch())
+
+			assert.Equal(t, tt.wantHeader, header)
+			if tt.wantOK {
+				require.Len(t, received, 1)
+				assert.Equal(t, "g", received[0].ID)
+			} else {
+				assert.Empty(t, received)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Package tests
+package tests
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoRuntimeCollector(t *testing.T) {
+	collector := agent.NewGoRuntimeCollector()
+
+	t.Run("scalar metrics", func(t *testing.T) {
+		got := metricsByID(collector.Collect())
+
+		for _, id := range []string{"Goroutines", "HeapAlloc", "Sys", "NumGC", "MutexWaitSeconds", "RandomValue"} {
+			require.Contains(t, got, id)
+			assert.Equal(t, model.Gauge, got[id].MType, id)
+			assert.NotNil(t, got[id].Value, id)
+		}
+		assert.GreaterOrEqual(t, *got["Goroutines"].Value, 1.0)
+		assert.Greater(t, *got["Sys"].Value, 0.0)
+	})
+
+	t.Run("distributions", func(t *testing.T) {
+		runtime.GC()
+
+		// нагружаем планировщик, чтобы в распределении задержек были события
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				time.Sleep(time.Millisecond)
+			}()
+		}
+		wg.Wait()
+
+		got := metricsByID(collector.Collect())
+		for _, prefix := range []string{"GCPause", "SchedLatency"} {
+			require.Contains(t, got, prefix+"Count")
+			assert.Greater(t, *got[prefix+"Count"].Value, 0.0, prefix)
+			p50, p99 := *got[prefix+"P50"].Value, *got[prefix+"P99"].Value
+			assert.GreaterOrEqual(t, p99, p50, prefix)
+			assert.GreaterOrEqual(t, *got[prefix+"P90"].Value, p50, prefix)
+		}
+	})
+
+	t.Run("poll count increments", func(t *testing.T) {
+		c := agent.NewGoRuntimeCollector()
+		first := metricsByID(c.Collect())
+		second := metricsByID(c.Collect())
+		assert.Equal(t, model.Counter, first["PollCount"].MType)
+		assert.Equal(t, int64(1), *first["PollCount"].Delta)
+		assert.Equal(t, int64(2), *second["PollCount"].Delta)
+	})
+}
+
+func TestNewCollector(t *testing.T) {
+	c, err := agent.NewCollector("")
+	require.NoError(t, err)
+	assert.IsType(t, &agent.RuntimeMetricsCollector{}, c)
+
+	c, err = agent.NewCollector(agent.CollectorMemStats)
+	require.NoError(t, err)
+	assert.IsType(t, &agent.RuntimeMetricsCollector{}, c)
+
+	c, err = agent.NewCollector(agent.CollectorRuntime)
+	require.NoError(t, err)
+	assert.IsType(t, &agent.GoRuntimeCollector{}, c)
+
+	_, err = agent.NewCollector("procfs")
+	assert.Error(t, err)
+}
@@ -2,8 +2,10 @@
 package tests
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	"time"
 
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -466,14 +469,21 @@ func TestHTTPSender_HashSHA256Header(t *testing.T) {
 		assert.NotEmpty(t, receivedHash, "HashSHA256 header should be set for batch format")
 	})
 
-	t.Run("Hash is consistent for same data", func(t *testing.T) {
-		var hashes []string
-		requestCount := 0
+	t.Run("Hash covers plain body, timestamp and nonce", func(t *testing.T) {
+		type signed struct{ hash, expected, nonce string }
+		var requests []signed
 		server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
-			if requestCount < 2 {
-				hashes = append(hashes, r.Header.Get("HashSHA256"))
-			}
-			requestCount++
+			gz, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body, err := io.ReadAll(gz)
+			require.NoError(t, err)
+
+			ts, nonce := r.Header.Get(keyring.HeaderTimestamp), r.Header.Get(keyring.HeaderNonce)
+			requests = append(requests, signed{
+				hash:     r.Header.Get("HashSHA256"),
+				expected: keyring.ComputeHMAC("consistent-key", keyring.SignedPayload(keyring.Route(r.Method, r.URL.Path), ts, nonce, body)),
+				nonce:    nonce,
+			})
 			w.WriteHeader(http.StatusOK)
 		})
 
@@ -482,16 +492,18 @@ func TestHTTPSender_HashSHA256Header(t *testing.T) {
 			{ID: "test", MType: "gauge", Value: float64Ptr(1.23)},
 		}
 
-		// Отправить одни и те же данные дважды
+		// одни и те же данные дважды: подпись от несжатого JSON, nonce каждый раз новый
 		err := sender.SendMetrics(context.Background(), metrics)
 		assert.NoError(t, err)
 		err = sender.SendMetrics(context.Background(), metrics)
 		assert.NoError(t, err)
 
-		assert.Len(t, hashes, 2, "Should have collected 2 hashes")
-		if len(hashes) == 2 {
-			assert.Equal(t, hashes[0], hashes[1], "Hashes should be identical for same data and key")
+		require.Len(t, requests, 2)
+		for _, req := range requests {
+			assert.NotEmpty(t, req.nonce)
+			assert.Equal(t, req.expected, req.hash)
 		}
+		assert.NotEqual(t, requests[0].nonce, requests[1].nonce, "nonce must not repeat")
 	})
 
 	t.Run("Hash changes with different keys", func(t *testing.T) {
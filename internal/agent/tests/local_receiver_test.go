@@ -0,0 +1,171 @@
+// Package tests
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	agentProd "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/mocks"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLocalReceiver(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		socket  string
+		wantErr bool
+	}{
+		{name: "loopback ipv4", addr: "127.0.0.1:8125"},
+		{name: "loopback ipv6", addr: "[::1]:8125"},
+		{name: "localhost", addr: "localhost:8125"},
+		{name: "socket only", socket: "/tmp/agent.sock"},
+		{name: "nothing configured", wantErr: true},
+		{name: "public address", addr: "0.0.0.0:8125", wantErr: true},
+		{name: "no port", addr: "127.0.0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := agentProd.NewLocalReceiver(tt.addr, tt.socket)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestLocalReceiver_Handler(t *testing.T) {
+	receiver, err := agentProd.NewLocalReceiver("127.0.0.1:0", "")
+	require.NoError(t, err)
+
+	var got []model.Metrics
+	handler := receiver.Handler(func(m []model.Metrics) { got = append(got, m...) })
+
+	t.Run("plain json batch", func(t *testing.T) {
+		got = nil
+		req := httptest.NewRequest(http.MethodPost, "/updates/",
+			strings.NewReader(`[{"id":"jobs","type":"counter","delta":3},{"id":"load","type":"gauge","value":0.5}]`))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, got, 2)
+		assert.Equal(t, "jobs", got[0].ID)
+		assert.Equal(t, int64(3), *got[0].Delta)
+	})
+
+	t.Run("gzip body", func(t *testing.T) {
+		got = nil
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, _ = gz.Write([]byte(`[{"id":"load","type":"gauge","value":1.5}]`))
+		require.NoError(t, gz.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/updates", &buf)
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		require.Len(t, got, 1)
+	})
+
+	t.Run("rejects invalid batches", func(t *testing.T) {
+		got = nil
+		for _, body := range []string{
+			`not json`,
+			`[]`,
+			`[{"id":"","type":"gauge","value":1}]`,
+			`[{"id":"x","type":"counter"}]`,
+			`[{"id":"x","type":"histogram","value":1}]`,
+			`[{"id":"agent_self_batches_sent","type":"counter","delta":1000}]`,
+		} {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		}
+		assert.Empty(t, got)
+	})
+
+	t.Run("only POST", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/updates/", nil))
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestLocalReceiver_UnixSocketForwarding(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	receiver, err := agentProd.NewLocalReceiver("", socket)
+	require.NoError(t, err)
+
+	collector := mocks.NewMetricsCollector(t)
+	sender := mocks.NewMetricsSender(t)
+	config := mocks.NewConfigProvider(t)
+
+	collector.On("Collect").Return([]model.Metrics{}).Maybe()
+	collector.On("CollectSystemMetrics").Return([]model.Metrics{}).Maybe()
+	config.On("GetPollInterval").Return(20 * time.Millisecond)
+	config.On("GetReportInterval").Return(40 * time.Millisecond)
+	config.On("GetRateLimit").Return(1)
+
+	var mu sync.Mutex
+	var sent []model.Metrics
+	sender.On("SendMetrics", mock.Anything, mock.AnythingOfType("[]model.Metrics")).
+		Run(func(args mock.Arguments) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, args.Get(1).([]model.Metrics)...)
+		}).Return(nil).Maybe()
+
+	a := agentProd.NewAgent(collector, sender, config, agentProd.WithLocalReceiver(receiver))
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- a.Start(ctx) }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+
+	require.Eventually(t, func() bool {
+		resp, err := client.Post("http://agent/updates/", "application/json",
+			strings.NewReader(`[{"id":"app_requests","type":"counter","delta":7}]`))
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 200*time.Millisecond, 10*time.Millisecond)
+
+	require.NoError(t, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	ids := make([]string, 0, len(sent))
+	for _, m := range sent {
+		ids = append(ids, m.ID)
+	}
+	assert.Contains(t, ids, "app_requests")
+}
@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// отправитель с управляемым результатом и разомкнутой цепью
+type stubSender struct {
+	calls atomic.Int32
+	err   error
+	open  bool
+}
+
+func (s *stubSender) SendMetrics(ctx context.Context, metrics []model.Metrics) error {
+	s.calls.Add(1)
+	return s.err
+}
+
+func (s *stubSender) CircuitOpen() bool {
+	return s.open
+}
+
+func testBatch() []model.Metrics {
+	v := 1.0
+	return []model.Metrics{{ID: "g", MType: model.Gauge, Value: &v}}
+}
+
+func TestMultiSender_Failover(t *testing.T) {
+	t.Run("primary healthy", func(t *testing.T) {
+		primary, backup := &stubSender{}, &stubSender{}
+		ms, err := agent.NewMultiSender(agent.SendModeFailover,
+			agent.Endpoint{Name: "primary", Sender: primary},
+			agent.Endpoint{Name: "backup", Sender: backup},
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+		assert.Equal(t, int32(1), primary.calls.Load())
+		assert.Equal(t, int32(0), backup.calls.Load())
+	})
+
+	t.Run("primary fails", func(t *testing.T) {
+		primary, backup := &stubSender{err: errors.New("down")}, &stubSender{}
+		ms, err := agent.NewMultiSender(agent.SendModeFailover,
+			agent.Endpoint{Name: "primary", Sender: primary},
+			agent.Endpoint{Name: "backup", Sender: backup},
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+		assert.Equal(t, int32(1), backup.calls.Load())
+
+		stats := ms.EndpointStats()
+		require.Len(t, stats, 2)
+		assert.Equal(t, int64(1), stats[0].Failed)
+		assert.False(t, stats[0].Healthy)
+		assert.Equal(t, "down", stats[0].LastError)
+		assert.Equal(t, int64(1), stats[1].Sent)
+		assert.True(t, stats[1].Healthy)
+	})
+
+	t.Run("open circuit is skipped", func(t *testing.T) {
+		primary, backup := &stubSender{open: true}, &stubSender{}
+		ms, err := agent.NewMultiSender(agent.SendModeFailover,
+			agent.Endpoint{Name: "primary", Sender: primary},
+			agent.Endpoint{Name: "backup", Sender: backup},
+		)
+		require.NoError(t, err)
+
+		require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+		assert.Equal(t, int32(0), primary.calls.Load())
+		assert.Equal(t, int64(1), ms.EndpointStats()[0].Skipped)
+		assert.False(t, ms.CircuitOpen())
+
+		backup.open = true
+		assert.True(t, ms.CircuitOpen())
+		assert.ErrorIs(t, ms.SendMetrics(context.Background(), testBatch()), agent.ErrCircuitOpen)
+	})
+}
+
+// основной сервер недоступен, батчи уходят на резервный; после восстановления
+// пробный запрос замыкает цепь основного, и он снова становится основным
+func TestMultiSender_FailoverPrimaryRecovers(t *testing.T) {
+	var primaryDown atomic.Bool
+	primaryDown.Store(true)
+	var primaryCalls, backupCalls atomic.Int32
+	primaryServer := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls.Add(1)
+		if primaryDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	backupServer := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		backupCalls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	newSender := func(url string) *agent.HTTPSender {
+		s, err := agent.NewHTTPSender(url, "", "")
+		require.NoError(t, err)
+		s.SetRetryConfig(fastRetryConfig(2))
+		s.SetBreaker(agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 1, OpenTimeout: 50 * time.Millisecond}))
+		return s
+	}
+	primary := newSender(primaryServer.URL)
+	ms, err := agent.NewMultiSender(agent.SendModeFailover,
+		agent.Endpoint{Name: "primary", Sender: primary},
+		agent.Endpoint{Name: "backup", Sender: newSender(backupServer.URL)},
+	)
+	require.NoError(t, err)
+
+	require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+	assert.Equal(t, agent.BreakerOpen, primary.BreakerState())
+	assert.Equal(t, int32(1), backupCalls.Load())
+
+	// цепь разомкнута: основной пропускается
+	calls := primaryCalls.Load()
+	require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+	assert.Equal(t, calls, primaryCalls.Load())
+	assert.Equal(t, int32(2), backupCalls.Load())
+
+	primaryDown.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+	assert.Equal(t, agent.BreakerClosed, primary.BreakerState())
+	assert.Equal(t, int32(2), backupCalls.Load(), "батч принял восстановившийся основной сервер")
+
+	require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+	assert.Equal(t, int32(2), backupCalls.Load())
+	assert.True(t, ms.EndpointStats()[0].Healthy)
+}
+
+func TestMultiSender_FailoverHangingPrimary(t *testing.T) {
+	var backupCalls atomic.Int32
+	release := make(chan struct{})
+	primaryServer := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		// не отвечает до конца теста
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	t.Cleanup(func() { close(release) })
+	backupServer := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		backupCalls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	newSender := func(url string) *agent.HTTPSender {
+		s, err := agent.NewHTTPSender(url, "", "")
+		require.NoError(t, err)
+		s.SetRetryConfig(fastRetryConfig(3))
+		s.SetBreaker(agent.NewCircuitBreaker(agent.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Minute}))
+		return s
+	}
+	primary := newSender(primaryServer.URL)
+	ms, err := agent.NewMultiSender(agent.SendModeFailover,
+		agent.Endpoint{Name: "primary", Sender: primary},
+		agent.Endpoint{Name: "backup", Sender: newSender(backupServer.URL)},
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
+	defer cancel()
+	require.NoError(t, ms.SendMetrics(ctx, testBatch()), "резервный сервер должен получить свою долю времени")
+	assert.Equal(t, int32(1), backupCalls.Load())
+	assert.Equal(t, agent.BreakerOpen, primary.BreakerState(), "таймаут основного сервера учитывается как сбой")
+	assert.Equal(t, int64(1), ms.EndpointStats()[0].Failed)
+}
+
+func TestMultiSender_Fanout(t *testing.T) {
+	a, b := &stubSender{}, &stubSender{err: errors.New("down")}
+	ms, err := agent.NewMultiSender(agent.SendModeFanout,
+		agent.Endpoint{Name: "old", Sender: a},
+		agent.Endpoint{Name: "new", Sender: b},
+	)
+	require.NoError(t, err)
+
+	// хотя бы один сервер принял батч — повторять нельзя
+	require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+	assert.Equal(t, int32(1), a.calls.Load())
+	assert.Equal(t, int32(1), b.calls.Load())
+
+	a.err = errors.New("down too")
+	assert.Error(t, ms.SendMetrics(context.Background(), testBatch()))
+
+	self := metricsByID(ms.SelfMetrics())
+	require.Contains(t, self, `agent_self_endpoint_batches_sent{endpoint="old"}`)
+	assert.Equal(t, int64(1), *self[`agent_self_endpoint_batches_sent{endpoint="old"}`].Delta)
+	assert.Equal(t, int64(2), *self[`agent_self_endpoint_batches_failed{endpoint="new"}`].Delta)
+	assert.Equal(t, 0.0, *self[`agent_self_endpoint_healthy{endpoint="old"}`].Value)
+
+	// счётчики отдаются приращениями
+	self = metricsByID(ms.SelfMetrics())
+	assert.Equal(t, int64(0), *self[`agent_self_endpoint_batches_failed{endpoint="new"}`].Delta)
+}
+
+func TestMultiSender_RetriesPerEndpoint(t *testing.T) {
+	conn, cleanup := setupBufConnServer(t, &unavailableGRPCServer{})
+	defer cleanup()
+
+	grpcSender := agent.NewGRPCSenderWithConn(conn)
+	grpcSender.SetRetryConfig(fastRetryConfig(3))
+
+	ms, err := agent.NewMultiSender("", agent.Endpoint{Name: "grpc", Sender: grpcSender})
+	require.NoError(t, err)
+
+	require.NoError(t, ms.SendMetrics(context.Background(), testBatch()))
+	assert.Equal(t, int64(1), ms.EndpointStats()[0].Sent)
+}
+
+func TestNewMultiSender_Validation(t *testing.T) {
+	_, err := agent.NewMultiSender("broadcast", agent.Endpoint{Name: "a", Sender: &stubSender{}})
+	assert.Error(t, err)
+
+	_, err = agent.NewMultiSender(agent.SendModeFanout)
+	assert.Error(t, err)
+}
+
+func TestNewEndpoints(t *testing.T) {
+	cfg := &agent.Config{Endpoints: []string{"localhost:8080", "grpc://localhost:3200"}}
+	endpoints, err := cfg.NewEndpoints()
+	require.NoError(t, err)
+	require.Len(t, endpoints, 2)
+
+	assert.Equal(t, "http://localhost:8080", endpoints[0].Name)
+	assert.IsType(t, &agent.HTTPSender{}, endpoints[0].Sender)
+	assert.IsType(t, &agent.GRPCSender{}, endpoints[1].Sender)
+}
+
+func TestLoadConfig_Endpoints(t *testing.T) {
+	origArgs := os.Args
+	t.Cleanup(func() { os.Args = origArgs })
+	os.Args = []string{"agent-test", "-send-mode", agent.SendModeFanout}
+	os.Unsetenv("CONFIG")
+	t.Setenv("ENDPOINTS", "a:8080, grpc://b:3200")
+
+	cfg, err := agent.LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a:8080", "grpc://b:3200"}, cfg.GetEndpoints())
+	assert.Equal(t, agent.SendModeFanout, cfg.GetSendMode())
+}
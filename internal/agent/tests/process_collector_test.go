@@ -0,0 +1,111 @@
+// Package tests
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/shirou/gopsutil/v3/process"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProcessCollector(t *testing.T) {
+	_, err := agent.NewProcessCollector(agent.ProcessFilter{})
+	assert.Error(t, err, "empty filter must be rejected")
+
+	_, err = agent.NewProcessCollector(agent.ProcessFilter{Cmdlines: []string{"("}})
+	assert.Error(t, err)
+
+	_, err = agent.NewProcessCollector(agent.ProcessFilter{Names: []string{"["}})
+	assert.Error(t, err)
+}
+
+func TestProcessCollector_Pidfile(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "self.pid")
+	require.NoError(t, os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644))
+
+	collector, err := agent.NewProcessCollector(agent.ProcessFilter{
+		Pidfiles: []string{pidfile, filepath.Join(t.TempDir(), "missing.pid")},
+		Prefix:   "svc_",
+	})
+	require.NoError(t, err)
+
+	metrics := collector.Collect()
+	require.NotEmpty(t, metrics)
+
+	pidLabel := `pid="` + strconv.Itoa(os.Getpid()) + `"`
+	got := metricsByID(metrics)
+	var hasRSS, hasThreads, hasCPU bool
+	for id, m := range got {
+		assert.True(t, strings.HasPrefix(id, "svc_"), id)
+		assert.Contains(t, id, pidLabel)
+		switch {
+		case strings.HasPrefix(id, "svc_rss_bytes{"):
+			hasRSS = true
+			assert.Equal(t, model.Gauge, m.MType)
+			assert.Greater(t, *m.Value, 0.0)
+		case strings.HasPrefix(id, "svc_threads{"):
+			hasThreads = true
+			assert.GreaterOrEqual(t, *m.Value, 1.0)
+		case strings.HasPrefix(id, "svc_cpu_percent{"):
+			hasCPU = true
+		}
+	}
+	assert.True(t, hasRSS, "rss metric must be present")
+	assert.True(t, hasThreads, "threads metric must be present")
+	assert.False(t, hasCPU, "first cpu sample has no interval and must be skipped")
+
+	hasCPU = false
+	for id := range metricsByID(collector.Collect()) {
+		if strings.HasPrefix(id, "svc_cpu_percent{") {
+			hasCPU = true
+		}
+	}
+	assert.True(t, hasCPU, "cpu metric must be present from the second poll")
+}
+
+func TestProcessCollector_NameAndCmdline(t *testing.T) {
+	self, err := process.NewProcess(int32(os.Getpid()))
+	require.NoError(t, err)
+	name, err := self.Name()
+	require.NoError(t, err)
+
+	t.Run("by name pattern", func(t *testing.T) {
+		collector, err := agent.NewProcessCollector(agent.ProcessFilter{Names: []string{name[:1] + "*"}})
+		require.NoError(t, err)
+		assert.NotEmpty(t, filterByPID(collector.Collect(), os.Getpid()))
+	})
+
+	t.Run("by cmdline regex", func(t *testing.T) {
+		cmdline, err := self.Cmdline()
+		if err != nil || cmdline == "" {
+			t.Skip("cmdline is not available on this platform")
+		}
+		collector, err := agent.NewProcessCollector(agent.ProcessFilter{Cmdlines: []string{`\.test`}})
+		require.NoError(t, err)
+		assert.NotEmpty(t, filterByPID(collector.Collect(), os.Getpid()))
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		collector, err := agent.NewProcessCollector(agent.ProcessFilter{Names: []string{"definitely-not-running-process"}})
+		require.NoError(t, err)
+		assert.Empty(t, collector.Collect())
+	})
+}
+
+func filterByPID(metrics []model.Metrics, pid int) []model.Metrics {
+	label := `pid="` + strconv.Itoa(pid) + `"`
+	var out []model.Metrics
+	for _, m := range metrics {
+		if strings.Contains(m.ID, label) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
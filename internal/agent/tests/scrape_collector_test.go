@@ -0,0 +1,209 @@
+// Package tests
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const promExposition = `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{code="200",method="get"} %d
+http_requests_total{code="500",method="get"} 3
+# TYPE queue_depth gauge
+queue_depth 17.5
+# TYPE go_gc_duration_seconds summary
+go_gc_duration_seconds{quantile="0.5"} 0.001
+go_gc_duration_seconds_sum 0.5
+go_gc_duration_seconds_count %d
+temperature NaN
+`
+
+func metricsByID(metrics []model.Metrics) map[string]model.Metrics {
+	out := make(map[string]model.Metrics, len(metrics))
+	for _, m := range metrics {
+		out[m.ID] = m
+	}
+	return out
+}
+
+func TestScrapeCollector_Prometheus(t *testing.T) {
+	requests, gcCount := 10, 4
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(
+			fmt.Sprintf(promExposition, requests, gcCount),
+		))
+	})
+
+	collector, err := agent.NewScrapeCollector([]agent.ScrapeTarget{
+		{URL: server.URL, Prefix: "app_"},
+	}, time.Second)
+	require.NoError(t, err)
+
+	t.Run("first scrape sets counter baseline", func(t *testing.T) {
+		got := metricsByID(collector.Collect())
+
+		require.Contains(t, got, "app_queue_depth")
+		assert.Equal(t, model.Gauge, got["app_queue_depth"].MType)
+		assert.Equal(t, 17.5, *got["app_queue_depth"].Value)
+
+		require.Contains(t, got, `app_go_gc_duration_seconds{quantile="0.5"}`)
+		require.Contains(t, got, "app_go_gc_duration_seconds_sum")
+		assert.NotContains(t, got, `app_http_requests_total{code="200",method="get"}`)
+		assert.NotContains(t, got, "app_go_gc_duration_seconds_count")
+		assert.NotContains(t, got, "app_temperature")
+	})
+
+	t.Run("next scrape reports counter deltas", func(t *testing.T) {
+		requests, gcCount = 25, 6
+		got := metricsByID(collector.Collect())
+
+		m, ok := got[`app_http_requests_total{code="200",method="get"}`]
+		require.True(t, ok)
+		assert.Equal(t, model.Counter, m.MType)
+		assert.Equal(t, int64(15), *m.Delta)
+
+		m, ok = got[`app_http_requests_total{code="500",method="get"}`]
+		require.True(t, ok)
+		assert.Equal(t, int64(0), *m.Delta)
+
+		m, ok = got["app_go_gc_duration_seconds_count"]
+		require.True(t, ok)
+		assert.Equal(t, int64(2), *m.Delta)
+	})
+
+	t.Run("counter reset reports new value", func(t *testing.T) {
+		requests = 5
+		got := metricsByID(collector.Collect())
+		assert.Equal(t, int64(5), *got[`app_http_requests_total{code="200",method="get"}`].Delta)
+	})
+}
+
+func TestScrapeCollector_FractionalCounter(t *testing.T) {
+	seconds := 1.0
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "# TYPE cpu_seconds_total counter\ncpu_seconds_total %g\n", seconds)
+	})
+
+	collector, err := agent.NewScrapeCollector([]agent.ScrapeTarget{{URL: server.URL}}, time.Second)
+	require.NoError(t, err)
+	collector.Collect()
+
+	// каждое приращение меньше половины единицы, но в сумме они не теряются
+	var total int64
+	for i := 0; i < 5; i++ {
+		seconds += 0.4
+		if m, ok := metricsByID(collector.Collect())["cpu_seconds_total"]; ok {
+			total += *m.Delta
+		}
+	}
+	assert.Equal(t, int64(2), total)
+}
+
+func TestScrapeCollector_JSON(t *testing.T) {
+	total := 100
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"connections": 12, "uptime": 3.5, "ready": true, "total": %d, "version": "1.2", "nested": {"a": 1}}`, total)))
+	})
+
+	collector, err := agent.NewScrapeCollector([]agent.ScrapeTarget{
+		{URL: server.URL, Format: agent.ScrapeFormatJSON, Prefix: "svc.", Counters: []string{"total"}},
+	}, time.Second)
+	require.NoError(t, err)
+
+	got := metricsByID(collector.Collect())
+	assert.Equal(t, 12.0, *got["svc.connections"].Value)
+	assert.Equal(t, 3.5, *got["svc.uptime"].Value)
+	assert.Equal(t, 1.0, *got["svc.ready"].Value)
+	assert.NotContains(t, got, "svc.version")
+	assert.NotContains(t, got, "svc.nested")
+	assert.NotContains(t, got, "svc.total")
+
+	total = 130
+	got = metricsByID(collector.Collect())
+	require.Contains(t, got, "svc.total")
+	assert.Equal(t, model.Counter, got["svc.total"].MType)
+	assert.Equal(t, int64(30), *got["svc.total"].Delta)
+}
+
+func TestScrapeCollector_Relabel(t *testing.T) {
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("# TYPE a gauge\nkeep_me 1\ndebug_info 2\nold_name{x=\"1\"} 3\n"))
+	})
+
+	collector, err := agent.NewScrapeCollector([]agent.ScrapeTarget{{
+		URL: server.URL,
+		Relabel: []agent.RelabelRule{
+			{Action: agent.RelabelDrop, Regex: "debug_.*"},
+			{Regex: `old_name(\{.*\})?`, Replacement: "new_name$1"},
+		},
+	}}, time.Second)
+	require.NoError(t, err)
+
+	got := metricsByID(collector.Collect())
+	assert.Contains(t, got, "keep_me")
+	assert.NotContains(t, got, "debug_info")
+	assert.Contains(t, got, `new_name{x="1"}`)
+}
+
+func TestScrapeCollector_ReservedPrefix(t *testing.T) {
+	server := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("agent_self_batches_sent 1000\nspoof_send_retries 5\nqueue_depth 1\n"))
+	})
+
+	collector, err := agent.NewScrapeCollector([]agent.ScrapeTarget{{
+		URL:     server.URL,
+		Relabel: []agent.RelabelRule{{Regex: "spoof_(.*)", Replacement: "agent_self_$1"}},
+	}}, time.Second)
+	require.NoError(t, err)
+
+	got := metricsByID(collector.Collect())
+	assert.Contains(t, got, "queue_depth")
+	for id := range got {
+		assert.NotContains(t, id, agent.SelfTelemetryPrefix)
+	}
+}
+
+func TestScrapeCollector_Errors(t *testing.T) {
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := agent.NewScrapeCollector([]agent.ScrapeTarget{{URL: ""}}, time.Second)
+		assert.Error(t, err)
+
+		_, err = agent.NewScrapeCollector([]agent.ScrapeTarget{{URL: "http://x", Format: "xml"}}, time.Second)
+		assert.Error(t, err)
+
+		_, err = agent.NewScrapeCollector([]agent.ScrapeTarget{{URL: "http://x", Relabel: []agent.RelabelRule{{Regex: "("}}}}, time.Second)
+		assert.Error(t, err)
+	})
+
+	t.Run("failed target does not block others", func(t *testing.T) {
+		bad := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		})
+		good := setupTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("up 1\n"))
+		})
+
+		collector, err := agent.NewScrapeCollector([]agent.ScrapeTarget{{URL: bad.URL}, {URL: good.URL}}, time.Second)
+		require.NoError(t, err)
+
+		got := metricsByID(collector.Collect())
+		assert.Len(t, got, 1)
+		assert.Contains(t, got, "up")
+	})
+}
+
+func TestSeriesID(t *testing.T) {
+	assert.Equal(t, "cpu", model.SeriesID("cpu", nil))
+	assert.Equal(t, `cpu{core="1",host="a"}`, model.SeriesID("cpu", map[string]string{"host": "a", "core": "1"}))
+	assert.Equal(t, `m{v="a\"b"}`, model.SeriesID("m", map[string]string{"v": `a"b`}))
+}
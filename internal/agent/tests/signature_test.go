@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// сервер с той же цепочкой, что в роутере: расшифровка, распаковка, строгая проверка подписи
+func startSignedServer(t *testing.T, keys *keyring.Keyring) (*httptest.Server, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var bodies []string
+	hash := middleware.NewHashMiddlewareWithKeyring(keys, middleware.WithStrictHash(), middleware.WithReplayWindow(time.Minute))
+	handler := middleware.GzipDecompression(hash.CheckHash(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	})))
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), bodies...)
+	}
+}
+
+func TestHTTPSender_StrictSignatureAccepted(t *testing.T) {
+	srv, received := startSignedServer(t, keyring.Static("secret"))
+
+	sender, err := agent.NewHTTPSender(srv.URL, "secret", "")
+	require.NoError(t, err)
+
+	// батч и повторная отправка тех же данных: новый nonce не считается повтором
+	for i := 0; i < 2; i++ {
+		require.NoError(t, sender.SendMetrics(context.Background(), []model.Metrics{
+			{ID: "cpu", MType: model.Gauge, Value: float64Ptr(0.5)},
+		}))
+	}
+	assert.Len(t, received(), 2)
+}
+
+func TestHTTPSender_StrictSignatureWrongKey(t *testing.T) {
+	srv, received := startSignedServer(t, keyring.Static("secret"))
+
+	sender, err := agent.NewHTTPSender(srv.URL, "wrong", "")
+	require.NoError(t, err)
+	sender.SetRetryConfig(fastRetryConfig(1))
+
+	_ = sender.SendMetrics(context.Background(), []model.Metrics{
+		{ID: "cpu", MType: model.Gauge, Value: float64Ptr(0.5)},
+	})
+	assert.Empty(t, received(), "запросы с чужим ключом не должны дойти до обработчика")
+}
+
+func TestHTTPSender_AgentKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.keys")
+	require.NoError(t, os.WriteFile(path, []byte("agent-7 personal\n"), 0o600))
+	keys, err := keyring.New(keyring.Config{HashKey: "shared", AgentKeysFile: path})
+	require.NoError(t, err)
+	srv, received := startSignedServer(t, keys)
+
+	sender, err := agent.NewHTTPSender(srv.URL, "personal", "")
+	require.NoError(t, err)
+	sender.SetAgentID("agent-7")
+
+	require.NoError(t, sender.SendMetrics(context.Background(), []model.Metrics{
+		{ID: "requests", MType: model.Counter, Delta: int64Ptr(1)},
+	}))
+	assert.Len(t, received(), 1)
+}
@@ -0,0 +1,179 @@
+// Package tests
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	agentProd "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/mocks"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStats_Snapshot(t *testing.T) {
+	stats := agentProd.NewStats()
+
+	t.Run("no sends yet", func(t *testing.T) {
+		got := metricsByID(stats.Snapshot(5, 1))
+		assert.Equal(t, 5.0, *got["agent_self_spool_size"].Value)
+		assert.Equal(t, 1.0, *got["agent_self_queue_batches"].Value)
+		assert.Equal(t, int64(0), *got["agent_self_batches_sent"].Delta)
+		assert.NotContains(t, got, "agent_self_last_success_timestamp_seconds")
+	})
+
+	t.Run("counts sends, retries and drops", func(t *testing.T) {
+		stats.ObserveSend(10, 1, 20*time.Millisecond, nil)
+		stats.ObserveSend(4, 3, 50*time.Millisecond, nil)
+		stats.ObserveSend(7, 3, time.Second, errors.New("server down"))
+		stats.ObserveDeferred()
+
+		got := metricsByID(stats.Snapshot(0, 0))
+		assert.Equal(t, int64(2), *got["agent_self_batches_sent"].Delta)
+		assert.Equal(t, int64(14), *got["agent_self_metrics_sent"].Delta)
+		assert.Equal(t, int64(4), *got["agent_self_send_retries"].Delta)
+		assert.Equal(t, int64(5), *got["agent_self_send_attempts_failed"].Delta)
+		assert.Equal(t, int64(1), *got["agent_self_batches_dropped"].Delta)
+		assert.Equal(t, int64(7), *got["agent_self_metrics_dropped"].Delta)
+		assert.Equal(t, int64(1), *got["agent_self_batches_deferred"].Delta)
+		assert.InDelta(t, 0.05, *got["agent_self_send_latency_seconds"].Value, 1e-9)
+		assert.InDelta(t, float64(time.Now().Unix()), *got["agent_self_last_success_timestamp_seconds"].Value, 5)
+	})
+
+	t.Run("counters reset after snapshot", func(t *testing.T) {
+		got := metricsByID(stats.Snapshot(0, 0))
+		assert.Equal(t, int64(0), *got["agent_self_batches_sent"].Delta)
+		assert.Equal(t, int64(0), *got["agent_self_batches_dropped"].Delta)
+		// время последней успешной отправки сохраняется
+		assert.Contains(t, got, "agent_self_last_success_timestamp_seconds")
+	})
+
+	for _, m := range stats.Snapshot(0, 0) {
+		assert.True(t, strings.HasPrefix(m.ID, agentProd.SelfTelemetryPrefix), m.ID)
+	}
+}
+
+func TestStats_Restore(t *testing.T) {
+	stats := agentProd.NewStats()
+	stats.ObserveSend(3, 2, time.Millisecond, nil)
+
+	report := stats.Snapshot(0, 0)
+	// отчёт не доставлен: приращения возвращаются и уходят со следующим
+	stats.Restore(report)
+	stats.ObserveSend(1, 1, time.Millisecond, nil)
+
+	got := metricsByID(stats.Snapshot(0, 0))
+	assert.Equal(t, int64(2), *got["agent_self_batches_sent"].Delta)
+	assert.Equal(t, int64(4), *got["agent_self_metrics_sent"].Delta)
+	assert.Equal(t, int64(1), *got["agent_self_send_retries"].Delta)
+}
+
+func TestAgent_SelfTelemetrySurvivesFailedSend(t *testing.T) {
+	collector := mocks.NewMetricsCollector(t)
+	config := mocks.NewConfigProvider(t)
+	sender := &MockRetrySender{}
+
+	collector.On("Collect").Return([]model.Metrics{{ID: "Alloc", MType: "gauge", Value: float64Ptr(1)}}).Maybe()
+	collector.On("CollectSystemMetrics").Return([]model.Metrics{}).Maybe()
+	config.On("GetPollInterval").Return(20 * time.Millisecond)
+	config.On("GetReportInterval").Return(40 * time.Millisecond)
+	config.On("GetRateLimit").Return(1)
+
+	var mu sync.Mutex
+	var failed int64
+	sender.On("Retry", mock.Anything, mock.Anything).Return(nil).Maybe()
+	// первые отправки падают, затем сервер оживает
+	sender.On("SendMetrics", mock.Anything, mock.Anything).Return(errors.New("server down")).Times(2)
+	sender.On("SendMetrics", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range args.Get(1).([]model.Metrics) {
+			if m.ID == "agent_self_send_attempts_failed" {
+				failed += *m.Delta
+			}
+		}
+	})
+
+	a := agentProd.NewAgent(collector, sender, config, agentProd.WithSelfTelemetry())
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	require.NoError(t, a.Start(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	// сбой первой отправки учтён во втором отчёте, сбой второй — в третьем
+	assert.Equal(t, int64(2), failed, "failures must reach the server once it is back")
+}
+
+func TestAgent_SelfTelemetry(t *testing.T) {
+	collector := mocks.NewMetricsCollector(t)
+	config := mocks.NewConfigProvider(t)
+	sender := &MockRetrySender{}
+
+	collector.On("Collect").Return([]model.Metrics{{ID: "Alloc", MType: "gauge", Value: float64Ptr(1)}}).Maybe()
+	collector.On("CollectSystemMetrics").Return([]model.Metrics{}).Maybe()
+	config.On("GetPollInterval").Return(20 * time.Millisecond)
+	config.On("GetReportInterval").Return(40 * time.Millisecond)
+	config.On("GetRateLimit").Return(1)
+
+	var mu sync.Mutex
+	var sent [][]model.Metrics
+	sender.On("Retry", mock.Anything, mock.Anything).Return(nil).Maybe()
+	sender.On("SendMetrics", mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, args.Get(1).([]model.Metrics))
+	})
+
+	a := agentProd.NewAgent(collector, sender, config, agentProd.WithSelfTelemetry())
+	require.NotNil(t, a.Stats())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	require.NoError(t, a.Start(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.NotEmpty(t, sent)
+	var sawSelf bool
+	for _, batch := range sent {
+		for _, m := range batch {
+			if strings.HasPrefix(m.ID, agentProd.SelfTelemetryPrefix) {
+				sawSelf = true
+			}
+		}
+	}
+	assert.True(t, sawSelf, "agent must report its own statistics")
+}
+
+func TestAgent_SelfTelemetryAloneIsNotSent(t *testing.T) {
+	collector := mocks.NewMetricsCollector(t)
+	config := mocks.NewConfigProvider(t)
+	sender := mocks.NewMetricsSender(t)
+
+	collector.On("Collect").Return([]model.Metrics{}).Maybe()
+	collector.On("CollectSystemMetrics").Return([]model.Metrics{}).Maybe()
+	config.On("GetPollInterval").Return(20 * time.Millisecond)
+	config.On("GetReportInterval").Return(40 * time.Millisecond)
+	config.On("GetRateLimit").Return(1)
+
+	// без собранных метрик отправок нет: мок упадёт на неожиданном вызове
+	a := agentProd.NewAgent(collector, sender, config, agentProd.WithSelfTelemetry())
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	require.NoError(t, a.Start(ctx))
+}
+
+func TestAgent_SelfTelemetryDisabledByDefault(t *testing.T) {
+	config := mocks.NewConfigProvider(t)
+	config.On("GetRateLimit").Return(1)
+
+	a := agentProd.NewAgent(mocks.NewMetricsCollector(t), mocks.NewMetricsSender(t), config)
+	assert.Nil(t, a.Stats())
+}
@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/tlsconfig"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// выпускает сертификат и пишет его вместе с ключом в dir/name.crt и dir/name.key.
+// без parent сертификат самоподписанный.
+func issueTestCert(t *testing.T, dir, name string, tmpl, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0o600))
+	return cert, key
+}
+
+// CA, сертификат сервера на 127.0.0.1 и клиентский сертификат агента с CN agentID
+func generateTestPKI(t *testing.T, agentID string) string {
+	t.Helper()
+	dir := t.TempDir()
+	notBefore, notAfter := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+
+	ca, caKey := issueTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+
+	issueTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	issueTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: agentID},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	return dir
+}
+
+func startMTLSServer(t *testing.T, dir string, handler http.Handler) *httptest.Server {
+	t.Helper()
+	serverTLS, err := tlsconfig.Server(
+		filepath.Join(dir, "server.crt"),
+		filepath.Join(dir, "server.key"),
+		filepath.Join(dir, "ca.crt"),
+	)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(middleware.ClientCertMiddleware(handler))
+	server.TLS = serverTLS
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPSender_MutualTLS(t *testing.T) {
+	dir := generateTestPKI(t, "agent-42")
+
+	var agentID string
+	server := startMTLSServer(t, dir, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentID = middleware.GetAgentIDFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	clientTLS, err := tlsconfig.Client(
+		filepath.Join(dir, "ca.crt"),
+		filepath.Join(dir, "client.crt"),
+		filepath.Join(dir, "client.key"),
+	)
+	require.NoError(t, err)
+
+	sender, err := agent.NewHTTPSender(server.URL, "", "")
+	require.NoError(t, err)
+	sender.SetTLSConfig(clientTLS)
+
+	err = sender.SendMetrics(context.Background(), testBatch())
+	require.NoError(t, err)
+	assert.Equal(t, "agent-42", agentID)
+}
+
+func TestHTTPSender_MutualTLSWithoutClientCert(t *testing.T) {
+	dir := generateTestPKI(t, "agent-42")
+
+	var called bool
+	server := startMTLSServer(t, dir, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	clientTLS, err := tlsconfig.Client(filepath.Join(dir, "ca.crt"), "", "")
+	require.NoError(t, err)
+
+	sender, err := agent.NewHTTPSender(server.URL, "", "")
+	require.NoError(t, err)
+	sender.SetTLSConfig(clientTLS)
+	sender.SetRetryConfig(fastRetryConfig(1))
+
+	_ = sender.SendMetrics(context.Background(), testBatch())
+	assert.False(t, called, "сервер не должен принимать запросы без клиентского сертификата")
+}
+
+func TestLoadConfig_TLSDefaultsToHTTPS(t *testing.T) {
+	origArgs := os.Args
+	t.Cleanup(func() { os.Args = origArgs })
+	os.Args = []string{"agent-test", "-a", "metrics.internal:8443", "-tls-ca", "/etc/metrics/ca.crt"}
+	os.Unsetenv("CONFIG")
+	os.Unsetenv("ADDRESS")
+
+	cfg, err := agent.LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://metrics.internal:8443", cfg.GetServerURL())
+	assert.True(t, cfg.UseTLS())
+}
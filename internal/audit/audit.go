@@ -0,0 +1,107 @@
+// Package audit описывает события аудита и их доставку получателям.
+// события публикуются в ограниченную очередь и доставляются асинхронно,
+// поэтому медленный получатель не задерживает обработку запросов.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// действие, попавшее в аудит
+type Action string
+
+const (
+	ActionUpdate      Action = "update"       // одна метрика: JSON или /update/{type}/{name}/{value}
+	ActionBatchUpdate Action = "batch_update" // /updates
+	ActionRead        Action = "read"         // /value
+	ActionOTLP        Action = "otlp"         // /v1/metrics, метрики передаёт обработчик
+	ActionRemoteWrite Action = "remote_write" // /api/v1/write, метрики передаёт обработчик
+	ActionInflux      Action = "influx"       // /write, метрики передаёт обработчик
+)
+
+type Event struct {
+	Timestamp int64           `json:"ts"`
+	Action    Action          `json:"action"`
+	Metrics   []model.Metrics `json:"metrics"` // для чтения только id и тип
+	IPAddress string          `json:"ip_address"`
+	AgentID   string          `json:"agent_id,omitempty"` // из клиентского сертификата или X-Agent-ID
+	TokenID   string          `json:"token_id,omitempty"`
+	Status    int             `json:"status"` // код ответа обработчика
+
+	// цепочка хэшей, заполняется при записи в файл
+	Seq      uint64 `json:"seq,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// имена метрик события
+func (e *Event) MetricIDs() []string {
+	ids := make([]string, 0, len(e.Metrics))
+	for _, m := range e.Metrics {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+type Receiver interface {
+	Notify(event *Event) error
+}
+
+// отправляет события POST запросом. неудачная отправка повторяется Retries раз
+// с удваивающейся паузой; ответы 4xx, кроме 429, не повторяются.
+type URLReceiver struct {
+	URL     string
+	Client  *http.Client  // по умолчанию клиент с таймаутом 5 секунд
+	Retries int           // повторов после первой попытки
+	Backoff time.Duration // пауза перед первым повтором, по умолчанию 500 мс
+}
+
+var defaultClient = &http.Client{Timeout: 5 * time.Second}
+
+func (u *URLReceiver) Notify(event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	backoff := u.Backoff
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := u.send(data)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= u.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// отправка одной попытки; retry — имеет ли смысл повторять
+func (u *URLReceiver) send(data []byte) (bool, error) {
+	client := u.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Post(u.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("failed to send audit log, status: %s", resp.Status)
+}
@@ -0,0 +1,247 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// записи журнала образуют цепочку: каждая содержит хэш предыдущей, поэтому
+// изменение, удаление или вставка записи ломают все следующие ссылки.
+// периодически в цепочку добавляется контрольная точка, подписанная HMAC ключом:
+// без ключа нельзя пересчитать цепочку так, чтобы подписи сошлись.
+
+// вид записи контрольной точки; у событий поля kind нет
+const KindCheckpoint = "checkpoint"
+
+// контрольная точка: подпись головы цепочки на момент записи
+type Checkpoint struct {
+	Kind      string `json:"kind"`
+	Timestamp int64  `json:"ts"`
+	Seq       uint64 `json:"seq"`
+	PrevHash  string `json:"prev_hash"`
+	Signature string `json:"signature,omitempty"` // HMAC-SHA256 от "seq:prev_hash" в hex
+	Hash      string `json:"hash"`
+}
+
+// хэш записи: SHA-256 канонического JSON без поля hash.
+// канонический вид — объект с отсортированными ключами, так проверка
+// не зависит от порядка полей и учитывает поля, неизвестные этой версии.
+func RecordHash(record []byte) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(record, &fields); err != nil {
+		return "", err
+	}
+	delete(fields, "hash")
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// подпись контрольной точки
+func SignCheckpoint(key string, seq uint64, prevHash string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strconv.FormatUint(seq, 10) + ":" + prevHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// запись, участвующая в цепочке
+type chained interface {
+	link(seq uint64, prevHash, hash string)
+}
+
+func (e *Event) link(seq uint64, prevHash, hash string) {
+	e.Seq, e.PrevHash, e.Hash = seq, prevHash, hash
+}
+
+func (c *Checkpoint) link(seq uint64, prevHash, hash string) {
+	c.Seq, c.PrevHash, c.Hash = seq, prevHash, hash
+}
+
+// состояние цепочки одного журнала
+type chain struct {
+	seq             uint64
+	head            string // хэш последней записи
+	sinceCheckpoint int
+}
+
+// связывает запись с головой цепочки и возвращает её JSON
+func (c *chain) seal(record chained) ([]byte, error) {
+	record.link(c.seq+1, c.head, "")
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := RecordHash(data)
+	if err != nil {
+		return nil, err
+	}
+	record.link(c.seq+1, c.head, hash)
+	if data, err = json.Marshal(record); err != nil {
+		return nil, err
+	}
+	c.seq++
+	c.head = hash
+	return data, nil
+}
+
+// восстанавливает состояние по записям существующего журнала
+func (c *chain) recover(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var rec chainRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return fmt.Errorf("audit: parse record after seq %d: %w", c.seq, err)
+		}
+		c.seq = rec.Seq
+		c.head = rec.Hash
+		if rec.Kind == KindCheckpoint {
+			c.sinceCheckpoint = 0
+		} else {
+			c.sinceCheckpoint++
+		}
+	}
+	return scanner.Err()
+}
+
+// поля цепочки, общие для событий и контрольных точек
+type chainRecord struct {
+	Kind      string `json:"kind"`
+	Seq       uint64 `json:"seq"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+// результат проверки журнала
+type VerifyReport struct {
+	OK          bool `json:"ok"`
+	Records     int  `json:"records"`     // проверенных событий
+	Checkpoints int  `json:"checkpoints"` // проверенных контрольных точек
+	// подписи не проверялись: ключ не задан
+	SignaturesUnchecked bool `json:"signatures_unchecked,omitempty"`
+	// последняя контрольная точка: записи после неё подписью не защищены
+	SignedSeq uint64 `json:"signed_seq,omitempty"`
+	// первая сломанная ссылка: файл журнала и номер строки в нём, начиная с 1
+	BrokenFile string `json:"broken_file,omitempty"`
+	BrokenLine int    `json:"broken_line,omitempty"`
+	BrokenSeq  uint64 `json:"broken_seq,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	// номер первой проверенной записи: больше 1, если проверка начата
+	// с контрольной точки, потому что начало журнала удалено ротацией
+	StartSeq uint64 `json:"start_seq,omitempty"`
+	// номер и хэш последней проверенной записи
+	HeadSeq uint64 `json:"head_seq,omitempty"`
+	Head    string `json:"head,omitempty"`
+}
+
+// запись, которую продолжает проверяемый журнал: голова предыдущего сегмента
+type ChainHead struct {
+	Seq  uint64
+	Hash string
+}
+
+// наибольшая длина строки журнала
+const maxRecordSize = 16 * 1024 * 1024
+
+// проверяет цепочку журнала от её начала. key — ключ подписи контрольных точек,
+// пустой — подписи не проверяются. ошибка возвращается только при сбое чтения,
+// найденные нарушения описываются в отчёте.
+func Verify(r io.Reader, key string) (*VerifyReport, error) {
+	return VerifyFrom(r, key, nil)
+}
+
+// проверяет журнал, продолжающий цепочку с головой from. при from == nil первая
+// запись должна начинать цепочку (seq 1 без prev_hash) либо быть контрольной точкой
+// с верной подписью: ротация удаляет старые сегменты, и каждый новый файл
+// начинается с такой точки. иначе начало журнала считается удалённым.
+func VerifyFrom(r io.Reader, key string, from *ChainHead) (*VerifyReport, error) {
+	report := &VerifyReport{OK: true, SignaturesUnchecked: key == ""}
+	var (
+		prev    chainRecord
+		started bool
+		lineNo  int
+	)
+	if from != nil {
+		prev = chainRecord{Seq: from.Seq, Hash: from.Hash}
+		started = true
+	}
+	broken := func(seq uint64, reason string, args ...any) (*VerifyReport, error) {
+		report.OK = false
+		report.BrokenLine = lineNo
+		report.BrokenSeq = seq
+		report.Reason = fmt.Sprintf(reason, args...)
+		return report, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec chainRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return broken(0, "invalid record: %v", err)
+		}
+		if rec.Hash == "" {
+			return broken(rec.Seq, "record is not chained")
+		}
+		hash, err := RecordHash(line)
+		if err != nil {
+			return broken(rec.Seq, "invalid record: %v", err)
+		}
+		if hash != rec.Hash {
+			return broken(rec.Seq, "record hash mismatch: record was modified")
+		}
+		if !started {
+			signed := rec.Kind == KindCheckpoint && key != ""
+			if (rec.Seq != 1 || rec.PrevHash != "") && !signed {
+				return broken(rec.Seq, "chain starts at seq %d without a signed checkpoint: leading records were removed", rec.Seq)
+			}
+			report.StartSeq = rec.Seq
+		} else {
+			if rec.Seq != prev.Seq+1 {
+				return broken(rec.Seq, "sequence gap: expected %d, got %d", prev.Seq+1, rec.Seq)
+			}
+			if rec.PrevHash != prev.Hash {
+				return broken(rec.Seq, "previous hash mismatch: record %d was removed or modified", prev.Seq)
+			}
+		}
+
+		if rec.Kind == KindCheckpoint {
+			if key != "" && !hmac.Equal([]byte(rec.Signature), []byte(SignCheckpoint(key, rec.Seq, rec.PrevHash))) {
+				return broken(rec.Seq, "invalid checkpoint signature")
+			}
+			report.Checkpoints++
+			report.SignedSeq = rec.Seq
+		} else {
+			report.Records++
+		}
+
+		prev = rec
+		started = true
+		report.HeadSeq = rec.Seq
+		report.Head = rec.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// метрики, которые передал обработчик: тела OTLP, remote_write и Influx
+// middleware не разбирает, их разбирает только обработчик
+type reported struct {
+	metrics []model.Metrics
+}
+
+type reportedKey struct{}
+
+// WithReport - готовит context запроса к передаче метрик из обработчика.
+// возвращённая функция отдаёт метрики, переданные через Report.
+func WithReport(ctx context.Context) (context.Context, func() []model.Metrics) {
+	rep := &reported{}
+	return context.WithValue(ctx, reportedKey{}, rep), func() []model.Metrics { return rep.metrics }
+}
+
+// Report - передаёт в аудит метрики, принятые обработчиком.
+// без WithReport (аудит выключен) ничего не делает.
+func Report(ctx context.Context, metrics []model.Metrics) {
+	if rep, ok := ctx.Value(reportedKey{}).(*reported); ok {
+		rep.metrics = metrics
+	}
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
+)
+
+var customLogger = logger.NewHTTPLogger().Logger.Sugar()
+
+// размер очереди каждого получателя по умолчанию
+const DefaultQueueSize = 1024
+
+// доставляет события получателям. у каждого получателя своя очередь и горутина,
+// поэтому недоступный URL не задерживает запись в файл. при переполнении очереди
+// событие для этого получателя отбрасывается и учитывается в Dropped.
+type Dispatcher struct {
+	queues  []chan *Event
+	wg      sync.WaitGroup
+	dropped atomic.Int64
+
+	closeOnce sync.Once
+}
+
+func NewDispatcher(receivers []Receiver, queueSize int) *Dispatcher {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	d := &Dispatcher{}
+	for _, r := range receivers {
+		q := make(chan *Event, queueSize)
+		d.queues = append(d.queues, q)
+		d.wg.Add(1)
+		go d.run(r, q)
+	}
+	return d
+}
+
+func (d *Dispatcher) run(r Receiver, q <-chan *Event) {
+	defer d.wg.Done()
+	for event := range q {
+		if err := r.Notify(event); err != nil {
+			customLogger.Warnf("Error while sending audit: %v", err)
+		}
+	}
+}
+
+// true, если есть кому доставлять события
+func (d *Dispatcher) Enabled() bool {
+	return d != nil && len(d.queues) > 0
+}
+
+// ставит событие в очереди получателей, не блокируясь
+func (d *Dispatcher) Publish(event *Event) {
+	if !d.Enabled() {
+		return
+	}
+	for _, q := range d.queues {
+		select {
+		case q <- event:
+		default:
+			d.dropped.Add(1)
+		}
+	}
+}
+
+// число событий, отброшенных из-за переполнения очередей
+func (d *Dispatcher) Dropped() int64 {
+	if d == nil {
+		return 0
+	}
+	return d.dropped.Load()
+}
+
+// перестаёт принимать события и ждёт доставки очередей или отмены ctx.
+// Publish после Close не допускается.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.closeOnce.Do(func() {
+		for _, q := range d.queues {
+			close(q)
+		}
+	})
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,425 @@
+package audit
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// метка времени в имени ротированного сегмента: audit.log.20260102T150405.000000000[.gz]
+const segmentLayout = "20060102T150405.000000000"
+
+// дописывает события в файл, по одному JSON на строку. записи связаны цепочкой
+// хэшей; при заданном SigningKey каждые CheckpointEvery событий добавляется
+// подписанная контрольная точка, ею же начинается каждый новый файл после ротации.
+// цепочка продолжается после перезапуска и ротации.
+//
+// файл держится открытым между событиями. при превышении MaxSize или по истечении
+// RotateInterval он переименовывается в сегмент с меткой времени, сегменты при
+// Compress сжимаются gzip и удаляются сверх MaxBackups и старше MaxBackupAge.
+// нулевые значения отключают соответствующее правило.
+type FileReceiver struct {
+	FilePath        string
+	SigningKey      string
+	CheckpointEvery int
+
+	MaxSize        int64         // байт в текущем файле
+	RotateInterval time.Duration // время жизни текущего файла
+	MaxBackups     int
+	MaxBackupAge   time.Duration
+	Compress       bool
+
+	mu        sync.Mutex
+	chain     chain
+	recovered bool
+	file      *os.File
+	size      int64
+	openedAt  time.Time
+}
+
+func (f *FileReceiver) Notify(event *Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.recovered {
+		if err := f.recover(); err != nil {
+			return err
+		}
+	}
+
+	// событие общее для всех получателей, поля цепочки заполняем в копии
+	record := *event
+	prev := f.chain
+	data, err := f.records(&record, false)
+	if err != nil {
+		f.chain = prev
+		return err
+	}
+
+	if err := f.prepare(int64(len(data))); err != nil {
+		f.chain = prev
+		return err
+	}
+	if f.size == 0 && prev.seq > 0 && f.SigningKey != "" {
+		// новый файл начинается с подписанной контрольной точки: по ней проверяется
+		// цепочка, когда предыдущие сегменты уже удалены
+		f.chain = prev
+		if data, err = f.records(&record, true); err != nil {
+			f.chain = prev
+			return err
+		}
+	}
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	if err != nil {
+		// часть строки могла записаться: состояние перечитаем из файла
+		f.closeFile()
+		f.recovered = false
+		return err
+	}
+	return nil
+}
+
+// связывает событие с цепочкой и возвращает строки для записи: событие и, если
+// подошёл срок, контрольную точку после него. leading добавляет точку перед событием.
+func (f *FileReceiver) records(event *Event, leading bool) ([]byte, error) {
+	var data []byte
+	checkpoint := func() error {
+		cp := &Checkpoint{Kind: KindCheckpoint, Timestamp: event.Timestamp}
+		cp.Signature = SignCheckpoint(f.SigningKey, f.chain.seq+1, f.chain.head)
+		line, err := f.chain.seal(cp)
+		if err != nil {
+			return err
+		}
+		data = append(data, append(line, '\n')...)
+		f.chain.sinceCheckpoint = 0
+		return nil
+	}
+
+	if leading {
+		if err := checkpoint(); err != nil {
+			return nil, err
+		}
+	}
+	line, err := f.chain.seal(event)
+	if err != nil {
+		return nil, err
+	}
+	data = append(data, append(line, '\n')...)
+	f.chain.sinceCheckpoint++
+
+	if f.SigningKey != "" && f.CheckpointEvery > 0 && f.chain.sinceCheckpoint >= f.CheckpointEvery {
+		if err := checkpoint(); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// закрывает файл; следующее событие откроет его заново по FilePath.
+// вызывается по SIGHUP после внешней ротации, например logrotate.
+func (f *FileReceiver) Reopen() error {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closeFile()
+}
+
+func (f *FileReceiver) Close() error {
+	return f.Reopen()
+}
+
+func (f *FileReceiver) closeFile() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// открывает файл и ротирует его, если запись не помещается или он устарел
+func (f *FileReceiver) prepare(size int64) error {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size == 0 {
+		return nil
+	}
+	full := f.MaxSize > 0 && f.size+size > f.MaxSize
+	expired := f.RotateInterval > 0 && time.Since(f.openedAt) >= f.RotateInterval
+	if !full && !expired {
+		return nil
+	}
+	return f.rotate()
+}
+
+func (f *FileReceiver) open() error {
+	file, err := os.OpenFile(f.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// переименовывает текущий файл в сегмент и открывает новый.
+// ошибки сжатия и удаления старых сегментов не мешают записи и только логируются.
+func (f *FileReceiver) rotate() error {
+	if err := f.closeFile(); err != nil {
+		return err
+	}
+	segment := f.FilePath + "." + time.Now().UTC().Format(segmentLayout)
+	if err := os.Rename(f.FilePath, segment); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.Compress {
+		if err := compressSegment(segment); err != nil {
+			customLogger.Errorf("audit: compress %s: %v", segment, err)
+		}
+	}
+	if err := f.cleanup(); err != nil {
+		customLogger.Errorf("audit: remove old segments: %v", err)
+	}
+	return nil
+}
+
+// сжимает сегмент в segment.gz и удаляет исходный файл
+func compressSegment(segment string) error {
+	src, err := os.Open(segment)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := segment + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, segment+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(segment)
+}
+
+// проверяет журнал path целиком: ротированные сегменты от старых к новым, затем
+// текущий файл. каждый файл должен продолжать цепочку предыдущего. отчёт суммирует
+// все файлы, при нарушении BrokenFile указывает файл со сломанной записью.
+// если нет ни сегментов, ни файла, возвращается ошибка os.ErrNotExist.
+func VerifyJournal(path, key string) (*VerifyReport, error) {
+	segments, err := Segments(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]Segment, 0, len(segments)+1)
+	files = append(files, segments...)
+	files = append(files, Segment{Path: path})
+
+	total := &VerifyReport{OK: true, SignaturesUnchecked: key == ""}
+	var head *ChainHead
+	var found bool
+	for _, s := range files {
+		r, err := s.Open()
+		if errors.Is(err, os.ErrNotExist) && head == nil {
+			// сегмент удалён ротацией после чтения каталога
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		report, err := VerifyFrom(r, key, head)
+		r.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.Path, err)
+		}
+
+		total.Records += report.Records
+		total.Checkpoints += report.Checkpoints
+		if report.SignedSeq != 0 {
+			total.SignedSeq = report.SignedSeq
+		}
+		if total.StartSeq == 0 {
+			total.StartSeq = report.StartSeq
+		}
+		if report.HeadSeq != 0 {
+			total.HeadSeq, total.Head = report.HeadSeq, report.Head
+			head = &ChainHead{Seq: report.HeadSeq, Hash: report.Head}
+		}
+		if !report.OK {
+			total.OK = false
+			total.BrokenFile = s.Path
+			total.BrokenLine, total.BrokenSeq, total.Reason = report.BrokenLine, report.BrokenSeq, report.Reason
+			return total, nil
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("audit: %s: %w", path, os.ErrNotExist)
+	}
+	return total, nil
+}
+
+// ротированный сегмент журнала
+type Segment struct {
+	Path       string
+	RotatedAt  time.Time
+	Compressed bool
+}
+
+// сегменты журнала path от старых к новым, без текущего файла
+func Segments(path string) ([]Segment, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	var segments []Segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp, compressed := strings.CutSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		rotatedAt, err := time.Parse(segmentLayout, stamp)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, Segment{
+			Path:       filepath.Join(filepath.Dir(path), name),
+			RotatedAt:  rotatedAt,
+			Compressed: compressed,
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].RotatedAt.Before(segments[j].RotatedAt)
+	})
+	return segments, nil
+}
+
+// открывает сегмент на чтение, сжатый — с распаковкой
+func (s Segment) Open() (io.ReadCloser, error) {
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !s.Compressed {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: gz, file: file}, nil
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	err := g.Reader.Close()
+	if closeErr := g.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// удаляет сегменты сверх MaxBackups и старше MaxBackupAge
+func (f *FileReceiver) cleanup() error {
+	if f.MaxBackups <= 0 && f.MaxBackupAge <= 0 {
+		return nil
+	}
+	segments, err := Segments(f.FilePath)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, s := range segments {
+		extra := f.MaxBackups > 0 && len(segments)-i > f.MaxBackups
+		old := f.MaxBackupAge > 0 && time.Since(s.RotatedAt) > f.MaxBackupAge
+		if !extra && !old {
+			continue
+		}
+		if err := os.Remove(s.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// продолжает цепочку существующего файла, а если он пуст — последнего сегмента
+func (f *FileReceiver) recover() error {
+	f.chain = chain{}
+	if err := f.recoverFrom(f.FilePath, false); err != nil {
+		return err
+	}
+	if f.chain.seq == 0 {
+		segments, err := Segments(f.FilePath)
+		if err != nil {
+			return err
+		}
+		if len(segments) > 0 {
+			last := segments[len(segments)-1]
+			if err := f.recoverFrom(last.Path, last.Compressed); err != nil {
+				return err
+			}
+		}
+	}
+	f.recovered = true
+	return nil
+}
+
+func (f *FileReceiver) recoverFrom(path string, compressed bool) error {
+	r, err := Segment{Path: path, Compressed: compressed}.Open()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := f.chain.recover(r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// события в таблице audit_events (миграция 000003).
+// служит и получателем событий, и хранилищем для поиска.
+type PostgresStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, timeout: 5 * time.Second}
+}
+
+func (s *PostgresStore) Notify(event *Event) error {
+	metrics, err := json.Marshal(event.Metrics)
+	if err != nil {
+		return err
+	}
+	sqlStr, args, err := sq.Insert("audit_events").
+		Columns("ts", "action", "metrics", "ip_address", "agent_id", "token_id", "status").
+		Values(time.Unix(event.Timestamp, 0).UTC(), string(event.Action), string(metrics),
+			event.IPAddress, event.AgentID, event.TokenID, event.Status).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка формирования запроса записи аудита: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	_, err = s.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (s *PostgresStore) Query(ctx context.Context, q Query) (*Page, error) {
+	limit := q.limit()
+	query := sq.Select("ts", "action", "metrics", "ip_address", "agent_id", "token_id", "status").
+		From("audit_events").
+		OrderBy("ts", "id").
+		Limit(uint64(limit) + 1).
+		Offset(uint64(max(q.Offset, 0))).
+		PlaceholderFormat(sq.Dollar)
+	if q.Metric != "" {
+		// jsonb содержит объект с таким id, использует GIN индекс
+		filter, err := json.Marshal([]map[string]string{{"id": q.Metric}})
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("metrics @> ?::jsonb", string(filter))
+	}
+	if q.IP != "" {
+		query = query.Where(sq.Eq{"ip_address": q.IP})
+	}
+	if q.Action != "" {
+		query = query.Where(sq.Eq{"action": string(q.Action)})
+	}
+	if !q.From.IsZero() {
+		query = query.Where(sq.GtOrEq{"ts": q.From.UTC()})
+	}
+	if !q.To.IsZero() {
+		query = query.Where(sq.Lt{"ts": q.To.UTC()})
+	}
+	sqlStr, args, err := query.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка формирования запроса аудита: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &Page{Events: []Event{}}
+	for rows.Next() {
+		var (
+			e       Event
+			ts      time.Time
+			action  string
+			metrics []byte
+		)
+		if err := rows.Scan(&ts, &action, &metrics, &e.IPAddress, &e.AgentID, &e.TokenID, &e.Status); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(metrics, &e.Metrics); err != nil {
+			return nil, fmt.Errorf("audit event metrics: %w", err)
+		}
+		e.Timestamp = ts.Unix()
+		e.Action = Action(action)
+		page.Events = append(page.Events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// лишняя строка означает, что есть следующая страница
+	if len(page.Events) > limit {
+		page.Events = page.Events[:limit]
+		page.Next = q.Offset + limit
+	}
+	return page, nil
+}
+
+var (
+	_ Store    = (*PostgresStore)(nil)
+	_ Receiver = (*PostgresStore)(nil)
+)
@@ -0,0 +1,153 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// размер страницы по умолчанию и наибольший
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// отбор событий журнала; пустые поля не ограничивают выборку
+type Query struct {
+	Metric string
+	IP     string
+	Action Action
+	From   time.Time // включительно
+	To     time.Time // не включительно
+	Limit  int
+	Offset int
+}
+
+// страница событий от старых к новым. Next — смещение следующей страницы, 0 — страниц больше нет
+type Page struct {
+	Events []Event `json:"events"`
+	Next   int     `json:"next,omitempty"`
+}
+
+// хранилище событий аудита с поиском
+type Store interface {
+	Query(ctx context.Context, q Query) (*Page, error)
+}
+
+// приводит размер страницы к допустимому
+func (q Query) limit() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultQueryLimit
+	case q.Limit > MaxQueryLimit:
+		return MaxQueryLimit
+	}
+	return q.Limit
+}
+
+func (q Query) match(e *Event) bool {
+	if q.Action != "" && e.Action != q.Action {
+		return false
+	}
+	if q.IP != "" && e.IPAddress != q.IP {
+		return false
+	}
+	if !q.From.IsZero() && e.Timestamp < q.From.Unix() {
+		return false
+	}
+	if !q.To.IsZero() && e.Timestamp >= q.To.Unix() {
+		return false
+	}
+	if q.Metric == "" {
+		return true
+	}
+	for _, m := range e.Metrics {
+		if m.ID == q.Metric {
+			return true
+		}
+	}
+	return false
+}
+
+// поиск по журналу FileReceiver: ротированные сегменты и текущий файл
+// читаются целиком, поэтому подходит для журналов умеренного размера.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Query(ctx context.Context, q Query) (*Page, error) {
+	segments, err := Segments(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	segments = append(segments, Segment{Path: s.Path})
+
+	limit := q.limit()
+	page := &Page{Events: []Event{}}
+	skipped := 0
+	for _, seg := range segments {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		more, err := s.scan(seg, func(e *Event) bool {
+			if !q.match(e) {
+				return true
+			}
+			if skipped < q.Offset {
+				skipped++
+				return true
+			}
+			if len(page.Events) == limit {
+				page.Next = q.Offset + limit
+				return false
+			}
+			page.Events = append(page.Events, *e)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+	return page, nil
+}
+
+// передаёт события сегмента в fn, пока она возвращает true.
+// контрольные точки и нечитаемые строки пропускаются.
+func (s *FileStore) scan(seg Segment, fn func(*Event) bool) (bool, error) {
+	r, err := seg.Open()
+	if errors.Is(err, os.ErrNotExist) {
+		// сегмент удалён ротацией во время чтения
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for scanner.Scan() {
+		var rec struct {
+			Kind string `json:"kind"`
+			Event
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.Kind != "" {
+			continue
+		}
+		if !fn(&rec.Event) {
+			return false, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+var _ Store = (*FileStore)(nil)
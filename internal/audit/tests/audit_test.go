@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvent(ids ...string) *audit.Event {
+	e := &audit.Event{Timestamp: time.Now().Unix(), Action: audit.ActionUpdate, IPAddress: "127.0.0.1", Status: http.StatusOK}
+	for _, id := range ids {
+		e.Metrics = append(e.Metrics, model.Metrics{ID: id, MType: model.Gauge})
+	}
+	return e
+}
+
+func TestFileReceiver(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: filePath}
+
+	require.NoError(t, receiver.Notify(testEvent("metric1", "metric2")))
+	require.NoError(t, receiver.Notify(testEvent("metric3")))
+
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+
+	var logged audit.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &logged))
+	assert.Equal(t, []string{"metric1", "metric2"}, logged.MetricIDs())
+	assert.Equal(t, audit.ActionUpdate, logged.Action)
+	assert.Equal(t, http.StatusOK, logged.Status)
+}
+
+func TestURLReceiver(t *testing.T) {
+	var received []audit.Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event audit.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, event)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	receiver := &audit.URLReceiver{URL: server.URL}
+	require.NoError(t, receiver.Notify(testEvent("test")))
+	require.Len(t, received, 1)
+	assert.Equal(t, []string{"test"}, received[0].MetricIDs())
+}
+
+func TestURLReceiver_Retries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	receiver := &audit.URLReceiver{URL: server.URL, Retries: 2, Backoff: time.Millisecond}
+	require.NoError(t, receiver.Notify(testEvent("test")))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestURLReceiver_ServerError(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	receiver := &audit.URLReceiver{URL: server.URL, Retries: 1, Backoff: time.Millisecond}
+	err := receiver.Notify(testEvent("test"))
+	require.Error(t, err)
+	assert.Equal(t, "failed to send audit log, status: 500 Internal Server Error", err.Error())
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestURLReceiver_ClientErrorNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	receiver := &audit.URLReceiver{URL: server.URL, Retries: 3, Backoff: time.Millisecond}
+	assert.Error(t, receiver.Notify(testEvent("test")))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+// получатель, который ждёт сигнала перед доставкой
+type blockingReceiver struct {
+	release chan struct{}
+	mu      sync.Mutex
+	got     int
+}
+
+func (b *blockingReceiver) Notify(*audit.Event) error {
+	<-b.release
+	b.mu.Lock()
+	b.got++
+	b.mu.Unlock()
+	return nil
+}
+
+func TestDispatcher_QueueBounded(t *testing.T) {
+	slow := &blockingReceiver{release: make(chan struct{})}
+	fast := &blockingReceiver{release: make(chan struct{})}
+	close(fast.release)
+
+	d := audit.NewDispatcher([]audit.Receiver{slow, fast}, 2)
+	for i := 1; i <= 10; i++ {
+		d.Publish(testEvent("m"))
+		// медленный получатель не задерживает быстрый
+		require.Eventually(t, func() bool {
+			fast.mu.Lock()
+			defer fast.mu.Unlock()
+			return fast.got == i
+		}, time.Second, time.Millisecond)
+	}
+	// у медленного не больше одного события в доставке и двух в очереди, остальные отброшены
+	assert.GreaterOrEqual(t, d.Dropped(), int64(7))
+
+	close(slow.release)
+	require.NoError(t, d.Close(context.Background()))
+	assert.Equal(t, int64(10), int64(slow.got)+d.Dropped())
+}
+
+func TestDispatcher_CloseTimeout(t *testing.T) {
+	slow := &blockingReceiver{release: make(chan struct{})}
+	d := audit.NewDispatcher([]audit.Receiver{slow}, 1)
+	d.Publish(testEvent("m"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, d.Close(ctx), context.DeadlineExceeded)
+	close(slow.release)
+}
+
+func TestDispatcher_NoReceivers(t *testing.T) {
+	d := audit.NewDispatcher(nil, 0)
+	assert.False(t, d.Enabled())
+	d.Publish(testEvent("m"))
+	assert.NoError(t, d.Close(context.Background()))
+}
@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const signingKey = "audit-secret"
+
+// пишет n событий в новый журнал и возвращает его строки
+func writeChain(t *testing.T, n, every int) (string, []string) {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: filePath, SigningKey: signingKey, CheckpointEvery: every}
+	for i := 0; i < n; i++ {
+		require.NoError(t, receiver.Notify(testEvent("metric")))
+	}
+	content, err := os.ReadFile(filePath)
+	require.NoError(t, err)
+	return filePath, strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func verifyLines(t *testing.T, lines []string, key string) *audit.VerifyReport {
+	t.Helper()
+	report, err := audit.Verify(strings.NewReader(strings.Join(lines, "\n")+"\n"), key)
+	require.NoError(t, err)
+	return report
+}
+
+func TestFileReceiver_Chain(t *testing.T) {
+	_, lines := writeChain(t, 5, 2)
+	// 5 событий и контрольные точки после 2-го и 4-го
+	require.Len(t, lines, 7)
+
+	var first, second audit.Event
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, uint64(1), first.Seq)
+	assert.Empty(t, first.PrevHash)
+	assert.Equal(t, first.Hash, second.PrevHash)
+
+	var cp audit.Checkpoint
+	require.NoError(t, json.Unmarshal([]byte(lines[2]), &cp))
+	assert.Equal(t, audit.KindCheckpoint, cp.Kind)
+	assert.Equal(t, uint64(3), cp.Seq)
+	assert.Equal(t, second.Hash, cp.PrevHash)
+	assert.Equal(t, audit.SignCheckpoint(signingKey, 3, second.Hash), cp.Signature)
+
+	report := verifyLines(t, lines, signingKey)
+	assert.True(t, report.OK, report.Reason)
+	assert.Equal(t, 5, report.Records)
+	assert.Equal(t, 2, report.Checkpoints)
+	assert.Equal(t, uint64(6), report.SignedSeq)
+	assert.False(t, report.SignaturesUnchecked)
+}
+
+func TestFileReceiver_ChainContinuesAfterRestart(t *testing.T) {
+	filePath, _ := writeChain(t, 3, 2)
+
+	receiver := &audit.FileReceiver{FilePath: filePath, SigningKey: signingKey, CheckpointEvery: 2}
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+
+	file, err := os.Open(filePath)
+	require.NoError(t, err)
+	defer file.Close()
+	report, err := audit.Verify(file, signingKey)
+	require.NoError(t, err)
+	assert.True(t, report.OK, report.Reason)
+	assert.Equal(t, 5, report.Records)
+	assert.Equal(t, 2, report.Checkpoints)
+}
+
+func TestVerify_Tampering(t *testing.T) {
+	_, lines := writeChain(t, 5, 2)
+
+	t.Run("modified record", func(t *testing.T) {
+		tampered := append([]string(nil), lines...)
+		tampered[3] = strings.Replace(tampered[3], `"status":200`, `"status":500`, 1)
+		report := verifyLines(t, tampered, signingKey)
+		assert.False(t, report.OK)
+		assert.Equal(t, 4, report.BrokenLine)
+		assert.Equal(t, uint64(4), report.BrokenSeq)
+		assert.Contains(t, report.Reason, "hash mismatch")
+	})
+
+	t.Run("removed record", func(t *testing.T) {
+		tampered := append(append([]string(nil), lines[:1]...), lines[2:]...)
+		report := verifyLines(t, tampered, signingKey)
+		assert.False(t, report.OK)
+		assert.Equal(t, 2, report.BrokenLine)
+		assert.Contains(t, report.Reason, "sequence gap")
+	})
+
+	t.Run("rehashed record", func(t *testing.T) {
+		// запись изменена и перехэширована: ломается ссылка следующей записи
+		var event audit.Event
+		require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+		event.Status = 500
+		event.Hash = ""
+		data, err := json.Marshal(event)
+		require.NoError(t, err)
+		event.Hash, err = audit.RecordHash(data)
+		require.NoError(t, err)
+		data, err = json.Marshal(event)
+		require.NoError(t, err)
+
+		tampered := append([]string{string(data)}, lines[1:]...)
+		report := verifyLines(t, tampered, signingKey)
+		assert.False(t, report.OK)
+		assert.Equal(t, 2, report.BrokenLine)
+		assert.Contains(t, report.Reason, "previous hash mismatch")
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		report := verifyLines(t, lines, "other")
+		assert.False(t, report.OK)
+		assert.Equal(t, 3, report.BrokenLine)
+		assert.Contains(t, report.Reason, "signature")
+	})
+
+	t.Run("without key", func(t *testing.T) {
+		report := verifyLines(t, lines, "")
+		assert.True(t, report.OK)
+		assert.True(t, report.SignaturesUnchecked)
+	})
+
+	t.Run("unchained record", func(t *testing.T) {
+		report := verifyLines(t, append([]string{`{"ts":1,"action":"update"}`}, lines...), signingKey)
+		assert.False(t, report.OK)
+		assert.Equal(t, 1, report.BrokenLine)
+	})
+}
+
+func TestVerify_Empty(t *testing.T) {
+	report, err := audit.Verify(bytes.NewReader(nil), signingKey)
+	require.NoError(t, err)
+	assert.True(t, report.OK)
+	assert.Zero(t, report.Records)
+}
+
+func TestVerify_HeadTruncation(t *testing.T) {
+	// 5 событий и контрольные точки seq 3 и 6
+	_, lines := writeChain(t, 5, 2)
+
+	t.Run("first record removed", func(t *testing.T) {
+		report := verifyLines(t, lines[1:], signingKey)
+		assert.False(t, report.OK)
+		assert.Equal(t, 1, report.BrokenLine)
+		assert.Equal(t, uint64(2), report.BrokenSeq)
+		assert.Contains(t, report.Reason, "leading records were removed")
+	})
+
+	t.Run("starts after checkpoint", func(t *testing.T) {
+		report := verifyLines(t, lines[3:], signingKey)
+		assert.False(t, report.OK)
+		assert.Equal(t, uint64(4), report.BrokenSeq)
+	})
+
+	t.Run("starts at signed checkpoint", func(t *testing.T) {
+		report := verifyLines(t, lines[2:], signingKey)
+		assert.True(t, report.OK, report.Reason)
+		assert.Equal(t, uint64(3), report.StartSeq)
+		assert.Equal(t, uint64(7), report.HeadSeq)
+	})
+
+	t.Run("checkpoint without key is not trusted", func(t *testing.T) {
+		report := verifyLines(t, lines[2:], "")
+		assert.False(t, report.OK)
+		assert.Equal(t, 1, report.BrokenLine)
+	})
+}
+
+func TestVerifyFrom(t *testing.T) {
+	_, lines := writeChain(t, 4, 0)
+	head := verifyLines(t, lines[:2], signingKey)
+	require.True(t, head.OK)
+
+	rest := strings.Join(lines[2:], "\n") + "\n"
+	report, err := audit.VerifyFrom(strings.NewReader(rest), signingKey, &audit.ChainHead{Seq: head.HeadSeq, Hash: head.Head})
+	require.NoError(t, err)
+	assert.True(t, report.OK, report.Reason)
+	assert.Equal(t, 2, report.Records)
+
+	// продолжение не той головы
+	report, err = audit.VerifyFrom(strings.NewReader(rest), signingKey, &audit.ChainHead{Seq: 1, Hash: head.Head})
+	require.NoError(t, err)
+	assert.False(t, report.OK)
+	assert.Contains(t, report.Reason, "sequence gap")
+}
@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// проверяет цепочку сегментов и текущего файла как одного журнала
+func verifyAll(t *testing.T, path string) *audit.VerifyReport {
+	t.Helper()
+	report, err := audit.VerifyJournal(path, signingKey)
+	require.NoError(t, err)
+	return report
+}
+
+func TestFileReceiver_RotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: signingKey, CheckpointEvery: 3, MaxSize: 600, Compress: true}
+	defer receiver.Close()
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, receiver.Notify(testEvent("metric")))
+	}
+
+	segments, err := audit.Segments(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+	for _, s := range segments {
+		assert.True(t, s.Compressed, s.Path)
+		assert.True(t, strings.HasSuffix(s.Path, ".gz"))
+	}
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, info.Size(), int64(600))
+
+	// цепочка не прерывается на границах сегментов
+	report := verifyAll(t, path)
+	assert.True(t, report.OK, report.Reason)
+	assert.Equal(t, 20, report.Records)
+}
+
+func TestFileReceiver_RotatedFileStartsWithCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: signingKey, MaxSize: 1, MaxBackups: 1}
+	defer receiver.Close()
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, receiver.Notify(testEvent("metric")))
+	}
+
+	// старые сегменты удалены, но текущий файл проверяется по своей контрольной точке
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"kind":"checkpoint"`)
+
+	report, err := audit.Verify(strings.NewReader(string(content)), signingKey)
+	require.NoError(t, err)
+	assert.True(t, report.OK, report.Reason)
+	assert.Equal(t, 1, report.Records)
+	assert.Equal(t, uint64(6), report.StartSeq)
+}
+
+func TestVerifyJournal(t *testing.T) {
+	// журнал из 5 событий: каждое, кроме первого, ротирует файл
+	writeJournal := func(t *testing.T) (string, []audit.Segment) {
+		path := filepath.Join(t.TempDir(), "audit.log")
+		receiver := &audit.FileReceiver{FilePath: path, SigningKey: signingKey, MaxSize: 1}
+		for i := 0; i < 5; i++ {
+			require.NoError(t, receiver.Notify(testEvent("metric")))
+		}
+		require.NoError(t, receiver.Close())
+		segments, err := audit.Segments(path)
+		require.NoError(t, err)
+		require.Len(t, segments, 4)
+		return path, segments
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		path, _ := writeJournal(t)
+		report := verifyAll(t, path)
+		assert.True(t, report.OK, report.Reason)
+		assert.Equal(t, 5, report.Records)
+		assert.Equal(t, uint64(1), report.StartSeq)
+		assert.Equal(t, uint64(9), report.HeadSeq)
+	})
+
+	t.Run("modified segment", func(t *testing.T) {
+		path, segments := writeJournal(t)
+		content, err := os.ReadFile(segments[0].Path)
+		require.NoError(t, err)
+		tampered := strings.Replace(string(content), `"status":200`, `"status":500`, 1)
+		require.NoError(t, os.WriteFile(segments[0].Path, []byte(tampered), 0644))
+
+		report := verifyAll(t, path)
+		assert.False(t, report.OK)
+		assert.Equal(t, segments[0].Path, report.BrokenFile)
+		assert.Equal(t, 1, report.BrokenLine)
+	})
+
+	t.Run("removed segment", func(t *testing.T) {
+		path, segments := writeJournal(t)
+		require.NoError(t, os.Remove(segments[1].Path))
+
+		report := verifyAll(t, path)
+		assert.False(t, report.OK)
+		assert.Equal(t, segments[2].Path, report.BrokenFile)
+		assert.Contains(t, report.Reason, "sequence gap")
+	})
+
+	t.Run("removed oldest segment", func(t *testing.T) {
+		path, segments := writeJournal(t)
+		require.NoError(t, os.Remove(segments[0].Path))
+
+		// следующий сегмент начинается с подписанной контрольной точки
+		report := verifyAll(t, path)
+		assert.True(t, report.OK, report.Reason)
+		assert.Equal(t, 4, report.Records)
+		assert.Equal(t, uint64(2), report.StartSeq)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := audit.VerifyJournal(filepath.Join(t.TempDir(), "audit.log"), signingKey)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestFileReceiver_RotateByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, RotateInterval: 20 * time.Millisecond}
+	defer receiver.Close()
+
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+
+	segments, err := audit.Segments(path)
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	assert.False(t, segments[0].Compressed)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "\n"))
+}
+
+func TestFileReceiver_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, MaxSize: 1, MaxBackups: 2}
+	defer receiver.Close()
+
+	// каждое событие, кроме первого, ротирует файл
+	for i := 0; i < 6; i++ {
+		require.NoError(t, receiver.Notify(testEvent("metric")))
+	}
+	segments, err := audit.Segments(path)
+	require.NoError(t, err)
+	assert.Len(t, segments, 2)
+
+	receiver.MaxBackupAge = time.Nanosecond
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	segments, err = audit.Segments(path)
+	require.NoError(t, err)
+	assert.Empty(t, segments)
+}
+
+func TestFileReceiver_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path}
+	defer receiver.Close()
+
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+
+	// внешняя ротация: файл переименован, без Reopen запись идёт в старый
+	moved := filepath.Join(dir, "audit.log.1")
+	require.NoError(t, os.Rename(path, moved))
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	_, err := os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, receiver.Reopen())
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+
+	old, err := os.ReadFile(moved)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(old), "\n"))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(current), "\n"))
+
+	// новый файл продолжает цепочку старого
+	report, err := audit.Verify(strings.NewReader(string(old)+string(current)), "")
+	require.NoError(t, err)
+	assert.True(t, report.OK, report.Reason)
+}
+
+func TestFileReceiver_RecoverFromSegment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: signingKey, RotateInterval: time.Millisecond, Compress: true}
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, receiver.Notify(testEvent("metric")))
+	require.NoError(t, receiver.Close())
+
+	// текущего файла нет: цепочка продолжается от последнего сегмента
+	require.NoError(t, os.Remove(path))
+	restarted := &audit.FileReceiver{FilePath: path, SigningKey: signingKey}
+	require.NoError(t, restarted.Notify(testEvent("metric")))
+	require.NoError(t, restarted.Close())
+
+	report := verifyAll(t, path)
+	assert.True(t, report.OK, report.Reason)
+	assert.Equal(t, 2, report.Records)
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_Query(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: signingKey, CheckpointEvery: 2, MaxSize: 500, Compress: true}
+	base := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		e := testEvent("cpu")
+		if i%2 == 1 {
+			e = testEvent("mem", "disk")
+			e.IPAddress = "10.0.0.2"
+		}
+		e.Timestamp = base.Add(time.Duration(i) * time.Hour).Unix()
+		require.NoError(t, receiver.Notify(e))
+	}
+	require.NoError(t, receiver.Close())
+	segments, err := audit.Segments(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+
+	store := &audit.FileStore{Path: path}
+	ctx := context.Background()
+
+	t.Run("by metric across segments", func(t *testing.T) {
+		page, err := store.Query(ctx, audit.Query{Metric: "disk"})
+		require.NoError(t, err)
+		require.Len(t, page.Events, 5)
+		assert.Zero(t, page.Next)
+		for _, e := range page.Events {
+			assert.Contains(t, e.MetricIDs(), "disk")
+		}
+	})
+
+	t.Run("by ip and period", func(t *testing.T) {
+		page, err := store.Query(ctx, audit.Query{IP: "127.0.0.1", From: base.Add(2 * time.Hour), To: base.Add(6 * time.Hour)})
+		require.NoError(t, err)
+		require.Len(t, page.Events, 2)
+		assert.Equal(t, base.Add(2*time.Hour).Unix(), page.Events[0].Timestamp)
+		assert.Equal(t, base.Add(4*time.Hour).Unix(), page.Events[1].Timestamp)
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		page, err := store.Query(ctx, audit.Query{Limit: 4})
+		require.NoError(t, err)
+		require.Len(t, page.Events, 4)
+		assert.Equal(t, 4, page.Next)
+
+		page, err = store.Query(ctx, audit.Query{Limit: 4, Offset: 8})
+		require.NoError(t, err)
+		require.Len(t, page.Events, 2)
+		assert.Zero(t, page.Next)
+		assert.Equal(t, base.Add(9*time.Hour).Unix(), page.Events[1].Timestamp)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		page, err := (&audit.FileStore{Path: filepath.Join(t.TempDir(), "none.log")}).Query(ctx, audit.Query{})
+		require.NoError(t, err)
+		assert.Empty(t, page.Events)
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	store := audit.NewPostgresStore(db)
+
+	t.Run("notify", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO audit_events").
+			WithArgs(sqlmock.AnyArg(), "update", `[{"id":"cpu","type":"gauge"}]`, "127.0.0.1", "", "", http.StatusOK).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+
+		require.NoError(t, store.Notify(testEvent("cpu")))
+	})
+
+	t.Run("query", func(t *testing.T) {
+		from := time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT ts, action, metrics, ip_address, agent_id, token_id, status FROM audit_events "+
+			"WHERE metrics @> $1::jsonb AND ip_address = $2 AND ts >= $3 ORDER BY ts, id LIMIT 3 OFFSET 0")).
+			WithArgs(`[{"id":"cpu"}]`, "127.0.0.1", from).
+			WillReturnRows(sqlmock.NewRows([]string{"ts", "action", "metrics", "ip_address", "agent_id", "token_id", "status"}).
+				AddRow(from, "update", []byte(`[{"id":"cpu","type":"gauge"}]`), "127.0.0.1", "agent-1", "", 200).
+				AddRow(from.Add(time.Hour), "batch_update", []byte(`[{"id":"cpu","type":"gauge"}]`), "127.0.0.1", "", "tok", 200).
+				AddRow(from.Add(2*time.Hour), "read", []byte(`[{"id":"cpu","type":"gauge"}]`), "127.0.0.1", "", "", 200))
+
+		page, err := store.Query(context.Background(), audit.Query{Metric: "cpu", IP: "127.0.0.1", From: from, Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, page.Events, 2)
+		assert.Equal(t, 2, page.Next)
+		assert.Equal(t, audit.ActionBatchUpdate, page.Events[1].Action)
+		assert.Equal(t, []model.Metrics{{ID: "cpu", MType: model.Gauge}}, page.Events[0].Metrics)
+		assert.Equal(t, "agent-1", page.Events[0].AgentID)
+		assert.Equal(t, from.Unix(), page.Events[0].Timestamp)
+	})
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,189 @@
+// Package auth реализует доступ по API токенам: токен передаётся как bearer,
+// сервер хранит только SHA-256 от него. у токена есть набор прав (scopes)
+// и необязательный префикс имён метрик, к которым он даёт доступ.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// права токена
+type Scope string
+
+const (
+	ScopeWrite Scope = "write" // запись метрик
+	ScopeRead  Scope = "read"  // чтение метрик
+	ScopeAdmin Scope = "admin" // управление токенами, включает остальные права
+)
+
+// префикс выдаваемых токенов, чтобы их было легко найти в логах и конфигах
+const tokenPrefix = "mt_"
+
+var (
+	ErrNotFound     = errors.New("auth: token not found")
+	ErrInvalidToken = errors.New("auth: invalid token")
+	ErrRevoked      = errors.New("auth: token revoked")
+	ErrForbidden    = errors.New("auth: access denied")
+)
+
+type Token struct {
+	ID           string     `json:"id"`
+	Name         string     `json:"name"`
+	Hash         string     `json:"hash"` // SHA-256 от токена в hex
+	Scopes       []Scope    `json:"scopes"`
+	MetricPrefix string     `json:"metric_prefix,omitempty"` // пусто — любые метрики
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (t *Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// true, если токен даёт доступ к метрике с таким именем
+func (t *Token) AllowsMetric(name string) bool {
+	return strings.HasPrefix(name, t.MetricPrefix)
+}
+
+func (t *Token) Revoked() bool {
+	return t.RevokedAt != nil
+}
+
+// хранилище токенов: файл или Postgres
+type Store interface {
+	// токен по хэшу, ErrNotFound если такого нет
+	Lookup(ctx context.Context, hash string) (*Token, error)
+	List(ctx context.Context) ([]Token, error)
+	Create(ctx context.Context, token *Token) error
+	// помечает токен отозванным, ErrNotFound если такого нет
+	Revoke(ctx context.Context, id string) error
+}
+
+// проверяет и выдаёт токены
+type Authenticator struct {
+	store Store
+}
+
+func NewAuthenticator(store Store) *Authenticator {
+	return &Authenticator{store: store}
+}
+
+// токен по его значению из заголовка Authorization
+func (a *Authenticator) Authenticate(ctx context.Context, raw string) (*Token, error) {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	token, err := a.store.Lookup(ctx, HashToken(raw))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.Revoked() {
+		return nil, ErrRevoked
+	}
+	return token, nil
+}
+
+// создаёт токен и возвращает его значение, которое показывается только один раз
+func (a *Authenticator) Issue(ctx context.Context, name string, scopes []Scope, metricPrefix string) (string, *Token, error) {
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("auth: at least one scope is required")
+	}
+	for _, s := range scopes {
+		if s != ScopeWrite && s != ScopeRead && s != ScopeAdmin {
+			return "", nil, fmt.Errorf("auth: unknown scope %q", s)
+		}
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return "", nil, err
+	}
+	raw := tokenPrefix + id + "_" + secret
+
+	token := &Token{
+		ID:           id,
+		Name:         name,
+		Hash:         HashToken(raw),
+		Scopes:       scopes,
+		MetricPrefix: metricPrefix,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := a.store.Create(ctx, token); err != nil {
+		return "", nil, err
+	}
+	return raw, token, nil
+}
+
+func (a *Authenticator) Revoke(ctx context.Context, id string) error {
+	return a.store.Revoke(ctx, id)
+}
+
+func (a *Authenticator) List(ctx context.Context) ([]Token, error) {
+	return a.store.List(ctx)
+}
+
+// SHA-256 токена в hex. у токена достаточно энтропии, поэтому медленный хэш не нужен
+func HashToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// значение bearer токена из заголовка Authorization
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// разбирает список прав через запятую
+func ParseScopes(s string) []Scope {
+	var scopes []Scope
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			scopes = append(scopes, Scope(part))
+		}
+	}
+	return scopes
+}
+
+type tokenCtxKey struct{}
+
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenCtxKey{}, token)
+}
+
+// токен запроса, nil если аутентификация выключена
+func TokenFromContext(ctx context.Context) *Token {
+	token, _ := ctx.Value(tokenCtxKey{}).(*Token)
+	return token
+}
+
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// токены в JSON файле. файл перезаписывается целиком через временный файл,
+// чтобы сбой во время записи не оставил его повреждённым.
+type FileStore struct {
+	path string
+
+	mu     sync.RWMutex
+	tokens []Token
+}
+
+// загружает токены из файла; отсутствующий файл считается пустым и будет создан при записи
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("auth: read tokens file: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &s.tokens); err != nil {
+			return nil, fmt.Errorf("auth: parse tokens file: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) Lookup(_ context.Context, hash string) (*Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := range s.tokens {
+		if s.tokens[i].Hash == hash {
+			token := s.tokens[i]
+			return &token, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *FileStore) List(_ context.Context) ([]Token, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Token(nil), s.tokens...), nil
+}
+
+func (s *FileStore) Create(_ context.Context, token *Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.ID == token.ID {
+			return fmt.Errorf("auth: token %s already exists", token.ID)
+		}
+	}
+	tokens := append(append([]Token(nil), s.tokens...), *token)
+	if err := s.save(tokens); err != nil {
+		return err
+	}
+	s.tokens = tokens
+	return nil
+}
+
+func (s *FileStore) Revoke(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tokens := append([]Token(nil), s.tokens...)
+	for i := range tokens {
+		if tokens[i].ID != id {
+			continue
+		}
+		if tokens[i].RevokedAt == nil {
+			now := time.Now().UTC()
+			tokens[i].RevokedAt = &now
+		}
+		if err := s.save(tokens); err != nil {
+			return err
+		}
+		s.tokens = tokens
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *FileStore) save(tokens []Token) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("auth: write tokens file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("auth: write tokens file: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
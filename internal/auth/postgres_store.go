@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// токены в таблице api_tokens (миграция 000002)
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+var tokenColumns = []string{"id", "name", "token_hash", "scopes", "metric_prefix", "created_at", "revoked_at"}
+
+func (s *PostgresStore) Lookup(ctx context.Context, hash string) (*Token, error) {
+	sqlStr, args, err := sq.Select(tokenColumns...).
+		From("api_tokens").
+		Where(sq.Eq{"token_hash": hash}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка формирования запроса токена: %w", err)
+	}
+
+	token, err := scanToken(s.db.QueryRowContext(ctx, sqlStr, args...))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return token, err
+}
+
+func (s *PostgresStore) List(ctx context.Context) ([]Token, error) {
+	sqlStr, args, err := sq.Select(tokenColumns...).
+		From("api_tokens").
+		OrderBy("created_at").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка формирования запроса токенов: %w", err)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sqlStr, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []Token
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *PostgresStore) Create(ctx context.Context, token *Token) error {
+	sqlStr, args, err := sq.Insert("api_tokens").
+		Columns("id", "name", "token_hash", "scopes", "metric_prefix", "created_at").
+		Values(token.ID, token.Name, token.Hash, joinScopes(token.Scopes), token.MetricPrefix, token.CreatedAt).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка формирования запроса создания токена: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, sqlStr, args...)
+	return err
+}
+
+func (s *PostgresStore) Revoke(ctx context.Context, id string) error {
+	sqlStr, args, err := sq.Update("api_tokens").
+		Set("revoked_at", sq.Expr("COALESCE(revoked_at, CURRENT_TIMESTAMP)")).
+		Where(sq.Eq{"id": id}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("ошибка формирования запроса отзыва токена: %w", err)
+	}
+	res, err := s.db.ExecContext(ctx, sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row rowScanner) (*Token, error) {
+	var (
+		token     Token
+		scopes    string
+		createdAt sql.NullTime
+		revokedAt sql.NullTime
+	)
+	if err := row.Scan(&token.ID, &token.Name, &token.Hash, &scopes, &token.MetricPrefix, &createdAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	token.Scopes = ParseScopes(scopes)
+	token.CreatedAt = createdAt.Time
+	if revokedAt.Valid {
+		t := revokedAt.Time
+		token.RevokedAt = &t
+	}
+	return &token, nil
+}
+
+func joinScopes(scopes []Scope) string {
+	parts := make([]string, len(scopes))
+	for i, s := range scopes {
+		parts[i] = string(s)
+	}
+	return strings.Join(parts, ",")
+}
+
+var (
+	_ Store = (*PostgresStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
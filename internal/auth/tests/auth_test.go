@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticator_FileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store, err := auth.NewFileStore(path)
+	require.NoError(t, err)
+	a := auth.NewAuthenticator(store)
+
+	raw, token, err := a.Issue(ctx, "agent-1", []auth.Scope{auth.ScopeWrite}, "app_")
+	require.NoError(t, err)
+	assert.NotContains(t, token.Hash, raw, "хранится только хэш")
+
+	got, err := a.Authenticate(ctx, raw)
+	require.NoError(t, err)
+	assert.Equal(t, token.ID, got.ID)
+	assert.True(t, got.HasScope(auth.ScopeWrite))
+	assert.False(t, got.HasScope(auth.ScopeRead))
+	assert.True(t, got.AllowsMetric("app_requests"))
+	assert.False(t, got.AllowsMetric("sys_cpu"))
+
+	_, err = a.Authenticate(ctx, raw+"x")
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	_, err = a.Authenticate(ctx, "garbage")
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+
+	t.Run("токены сохраняются в файле", func(t *testing.T) {
+		reopened, err := auth.NewFileStore(path)
+		require.NoError(t, err)
+		_, err = auth.NewAuthenticator(reopened).Authenticate(ctx, raw)
+		assert.NoError(t, err)
+	})
+
+	t.Run("отозванный токен не принимается", func(t *testing.T) {
+		require.NoError(t, a.Revoke(ctx, token.ID))
+		_, err := a.Authenticate(ctx, raw)
+		assert.ErrorIs(t, err, auth.ErrRevoked)
+
+		assert.ErrorIs(t, a.Revoke(ctx, "missing"), auth.ErrNotFound)
+	})
+}
+
+func TestAuthenticator_IssueValidation(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	a := auth.NewAuthenticator(store)
+
+	_, _, err = a.Issue(context.Background(), "no scopes", nil, "")
+	assert.Error(t, err)
+	_, _, err = a.Issue(context.Background(), "bad scope", []auth.Scope{"root"}, "")
+	assert.Error(t, err)
+}
+
+func TestToken_AdminHasAllScopes(t *testing.T) {
+	token := auth.Token{Scopes: []auth.Scope{auth.ScopeAdmin}}
+	for _, scope := range []auth.Scope{auth.ScopeRead, auth.ScopeWrite, auth.ScopeAdmin} {
+		assert.True(t, token.HasScope(scope), scope)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{header: "Bearer mt_abc", token: "mt_abc", ok: true},
+		{header: "bearer mt_abc", token: "mt_abc", ok: true},
+		{header: "Basic dXNlcjpwYXNz"},
+		{header: "Bearer "},
+		{header: "mt_abc"},
+	}
+	for _, tt := range tests {
+		token, ok := auth.BearerToken(tt.header)
+		assert.Equal(t, tt.ok, ok, tt.header)
+		assert.Equal(t, tt.token, token, tt.header)
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	store := auth.NewPostgresStore(db)
+	ctx := context.Background()
+
+	t.Run("lookup", func(t *testing.T) {
+		created := time.Now()
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, token_hash, scopes, metric_prefix, created_at, revoked_at FROM api_tokens WHERE token_hash = $1")).
+			WithArgs("hash").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "token_hash", "scopes", "metric_prefix", "created_at", "revoked_at"}).
+				AddRow("id1", "agent", "hash", "write,read", "app_", created, nil))
+
+		token, err := store.Lookup(ctx, "hash")
+		require.NoError(t, err)
+		assert.Equal(t, []auth.Scope{auth.ScopeWrite, auth.ScopeRead}, token.Scopes)
+		assert.Equal(t, "app_", token.MetricPrefix)
+		assert.False(t, token.Revoked())
+	})
+
+	t.Run("lookup not found", func(t *testing.T) {
+		mock.ExpectQuery("SELECT .* FROM api_tokens").
+			WithArgs("missing").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, err := store.Lookup(ctx, "missing")
+		assert.ErrorIs(t, err, auth.ErrNotFound)
+	})
+
+	t.Run("create", func(t *testing.T) {
+		mock.ExpectExec("INSERT INTO api_tokens").
+			WithArgs("id1", "agent", "hash", "write", "", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		err := store.Create(ctx, &auth.Token{ID: "id1", Name: "agent", Hash: "hash", Scopes: []auth.Scope{auth.ScopeWrite}, CreatedAt: time.Now()})
+		assert.NoError(t, err)
+	})
+
+	t.Run("revoke not found", func(t *testing.T) {
+		mock.ExpectExec("UPDATE api_tokens SET revoked_at").
+			WithArgs("missing").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.ErrorIs(t, store.Revoke(ctx, "missing"), auth.ErrNotFound)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,92 @@
+// Package clientip определяет адрес клиента с учётом доверенных прокси.
+// заголовки X-Forwarded-For и X-Real-IP выставляет сам клиент, поэтому им можно
+// верить, только если соединение пришло от доверенного прокси. иначе адрес клиента —
+// адрес сокета.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
+)
+
+type Resolver struct {
+	trusted []*net.IPNet
+}
+
+// resolver со списком CIDR доверенных прокси; отдельный адрес считается сетью /32 или /128.
+func NewResolver(trustedProxies []string) (*Resolver, error) {
+	trusted, err := netpolicy.ParseNetworks(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// true, если адрес принадлежит доверенному прокси
+func (r *Resolver) Trusted(ip net.IP) bool {
+	if r == nil || ip == nil {
+		return false
+	}
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// адрес клиента. peer — адрес сокета (host:port или только host),
+// forwardedFor — значения X-Forwarded-For по порядку, realIP — X-Real-IP.
+//
+// заголовки учитываются, только если peer — доверенный прокси. в X-Forwarded-For
+// берётся самый правый адрес, не принадлежащий доверенным прокси: левее него значения
+// мог подставить клиент. если все адреса цепочки доверенные, берётся самый левый.
+func (r *Resolver) Resolve(peer string, forwardedFor []string, realIP string) string {
+	peerIP := hostIP(peer)
+	if !r.Trusted(net.ParseIP(peerIP)) {
+		return peerIP
+	}
+
+	var hops []string
+	for _, value := range forwardedFor {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	if len(hops) > 0 {
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(hostIP(hops[i]))
+			if ip == nil {
+				// дальше цепочка испорчена, левее доверять нечему
+				break
+			}
+			client = ip.String()
+			if !r.Trusted(ip) {
+				return client
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+
+	if ip := net.ParseIP(strings.TrimSpace(realIP)); ip != nil {
+		return ip.String()
+	}
+	return peerIP
+}
+
+// адрес без порта; для значений без порта возвращает их как есть
+func hostIP(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.Trim(addr, "[]")
+}
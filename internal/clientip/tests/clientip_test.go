@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"net"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewResolver_InvalidCIDR(t *testing.T) {
+	_, err := clientip.NewResolver([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+
+	_, err = clientip.NewResolver([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestResolver_Trusted(t *testing.T) {
+	r, err := clientip.NewResolver([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::1"})
+	require.NoError(t, err)
+
+	assert.True(t, r.Trusted(net.ParseIP("10.1.2.3")))
+	assert.True(t, r.Trusted(net.ParseIP("192.168.1.1")))
+	assert.True(t, r.Trusted(net.ParseIP("::1")))
+	assert.False(t, r.Trusted(net.ParseIP("192.168.1.2")))
+
+	var nilResolver *clientip.Resolver
+	assert.False(t, nilResolver.Trusted(net.ParseIP("10.1.2.3")))
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	r, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		peer   string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{name: "untrusted peer ignores headers", peer: "203.0.113.5:4000", xff: []string{"1.1.1.1"}, realIP: "2.2.2.2", want: "203.0.113.5"},
+		{name: "trusted peer without headers", peer: "10.0.0.1:4000", want: "10.0.0.1"},
+		{name: "trusted peer with x-real-ip", peer: "10.0.0.1:4000", realIP: "198.51.100.7", want: "198.51.100.7"},
+		{name: "invalid x-real-ip", peer: "10.0.0.1:4000", realIP: "garbage", want: "10.0.0.1"},
+		{name: "rightmost untrusted hop", peer: "10.0.0.1:4000", xff: []string{"6.6.6.6, 198.51.100.7, 10.0.0.2"}, want: "198.51.100.7"},
+		{name: "several header values", peer: "10.0.0.1:4000", xff: []string{"6.6.6.6", "198.51.100.7", "10.0.0.2"}, want: "198.51.100.7"},
+		{name: "x-forwarded-for wins over x-real-ip", peer: "10.0.0.1:4000", xff: []string{"198.51.100.7"}, realIP: "6.6.6.6", want: "198.51.100.7"},
+		{name: "all hops trusted", peer: "10.0.0.1:4000", xff: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "broken chain stops", peer: "10.0.0.1:4000", xff: []string{"198.51.100.7, junk, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "peer without port", peer: "203.0.113.5", want: "203.0.113.5"},
+		{name: "ipv6 peer", peer: "[2001:db8::1]:4000", want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, r.Resolve(tt.peer, tt.xff, tt.realIP))
+		})
+	}
+}
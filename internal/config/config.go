@@ -6,8 +6,15 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
 	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 	"github.com/ilyakaznacheev/cleanenv"
 )
@@ -20,13 +27,61 @@ type Config struct {
 	Restore         bool   `env:"RESTORE"`
 	DatabaseDSN     string `env:"DATABASE_DSN"`
 	HashKey         string `env:"KEY"`
-	AuditFile       string `env:"AUDIT_FILE"`
-	AuditURL        string `env:"AUDIT_URL"`
-	ReadTimeout     int    `env:"READ_TIMEOUT"`
-	WriteTimeout    int    `env:"WRITE_TIMEOUT"`
-	IdleTimeout     int    `env:"IDLE_TIMEOUT"`
-	CryptoKey       string `env:"CRYPTO_KEY"`
-	TrustedSubnet   string `env:"TRUSTED_SUBNET"`
+	HashKeyFile     string `env:"KEY_FILE"`    // дополнительные HMAC ключи, по одному на строку
+	HashStrict      bool   `env:"HASH_STRICT"` // отклонять запросы без подписи и с неверной подписью
+	// окно защиты от повтора в секундах, 0 — выключено
+	HashReplayWindow int    `env:"HASH_REPLAY_WINDOW"`
+	AgentKeysFile    string `env:"AGENT_KEYS_FILE"` // ключи подписи отдельных агентов
+	// хранилище API токенов: file или postgres, пусто — доступ без токенов
+	AuthStore      string `env:"AUTH_STORE"`
+	AuthTokensFile string `env:"AUTH_TOKENS_FILE"`
+	AuditFile      string `env:"AUDIT_FILE"`
+	AuditURL       string `env:"AUDIT_URL"`
+	// очередь событий аудита на каждого получателя и число повторов отправки на URL
+	AuditQueueSize  int `env:"AUDIT_QUEUE_SIZE"`
+	AuditURLRetries int `env:"AUDIT_URL_RETRIES"`
+	// ключ подписи контрольных точек журнала и их период в событиях
+	AuditSigningKey      string `env:"AUDIT_SIGNING_KEY"`
+	AuditCheckpointEvery int    `env:"AUDIT_CHECKPOINT_EVERY"`
+	// ротация журнала по размеру в байтах и по времени в секундах, 0 — выключена
+	AuditMaxSize        int64 `env:"AUDIT_MAX_SIZE"`
+	AuditRotateInterval int   `env:"AUDIT_ROTATE_INTERVAL"`
+	// хранение сегментов: число и возраст в секундах, 0 — без ограничения
+	AuditMaxBackups int  `env:"AUDIT_MAX_BACKUPS"`
+	AuditMaxAge     int  `env:"AUDIT_MAX_AGE"`
+	AuditCompress   bool `env:"AUDIT_COMPRESS"` // сжимать сегменты gzip
+	// хранилище для поиска событий: file или postgres
+	AuditStore   string `env:"AUDIT_STORE"`
+	ReadTimeout  int    `env:"READ_TIMEOUT"`
+	WriteTimeout int    `env:"WRITE_TIMEOUT"`
+	IdleTimeout  int    `env:"IDLE_TIMEOUT"`
+	CryptoKey    string `env:"CRYPTO_KEY"`
+	CryptoLegacy bool   `env:"CRYPTO_LEGACY"` // принимать старые форматы шифрования rsa и hybrid
+	// предыдущие приватные ключи, принимаются во время ротации
+	CryptoKeysPrevious []string `env:"CRYPTO_KEYS_PREVIOUS" env-separator:","`
+	TrustedSubnet      string   `env:"TRUSTED_SUBNET"` // разрешённые сети через запятую
+	DeniedSubnets      []string `env:"DENIED_SUBNETS" env-separator:","`
+	// JSON файл с правилами подсетей по классам маршрутов, перечитывается по SIGHUP
+	SubnetPolicyFile string `env:"SUBNET_POLICY_FILE"`
+	// CIDR прокси, от которых принимаются X-Forwarded-For и X-Real-IP
+	TrustedProxies  []string `env:"TRUSTED_PROXIES" env-separator:","`
+	TLSCertFile     string   `env:"TLS_CERT"`
+	TLSKeyFile      string   `env:"TLS_KEY"`
+	TLSClientCAFile string   `env:"TLS_CLIENT_CA"`
+	// запросов в секунду на клиента (токен или IP), 0 — без ограничения.
+	// не RATE_LIMIT: так агент называет число воркеров отправки
+	RequestRateLimit float64 `env:"REQUEST_RATE_LIMIT"`
+	RequestRateBurst int     `env:"REQUEST_RATE_BURST"`
+	// размер тела запроса после распаковки в байтах
+	MaxBodyBytes        int64 `env:"MAX_BODY_BYTES"`
+	MaxBatchSize        int   `env:"MAX_BATCH_SIZE"`
+	MaxMetricNameLength int   `env:"MAX_METRIC_NAME_LENGTH"`
+	// регулярное выражение для имён метрик, пусто — любые имена
+	SeriesNamePattern string `env:"SERIES_NAME_PATTERN"`
+	// префиксы имён, которые клиенты не могут использовать, в дополнение к server_self_
+	SeriesReservedPrefixes []string `env:"SERIES_RESERVED_PREFIXES" env-separator:","`
+	MaxSeries              int      `env:"MAX_SERIES"`            // 0 — без ограничения
+	MaxSeriesPerClient     int      `env:"MAX_SERIES_PER_CLIENT"` // новых рядов от одного токена или IP
 }
 
 type jsonSeconds int
@@ -57,13 +112,20 @@ func Load() *Config {
 	defaultFileStoragePath := filepath.Join(os.TempDir(), "metrics.json")
 
 	cfg := &Config{
-		Address:         "localhost:8080",
-		StoreInterval:   300,
-		FileStoragePath: defaultFileStoragePath,
-		Restore:         true,
-		ReadTimeout:     10,
-		WriteTimeout:    10,
-		IdleTimeout:     10,
+		Address:              "localhost:8080",
+		StoreInterval:        300,
+		FileStoragePath:      defaultFileStoragePath,
+		Restore:              true,
+		ReadTimeout:          10,
+		WriteTimeout:         10,
+		IdleTimeout:          10,
+		AuditQueueSize:       1024,
+		AuditURLRetries:      3,
+		AuditCheckpointEvery: 100,
+
+		MaxBodyBytes:        10 << 20,
+		MaxBatchSize:        10000,
+		MaxMetricNameLength: 255,
 	}
 
 	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
@@ -79,11 +141,44 @@ func Load() *Config {
 	restore := fs.Bool("r", cfg.Restore, "загружать метрики при запуске")
 	dsn := fs.String("d", cfg.DatabaseDSN, "Database connection string")
 	key := fs.String("k", cfg.HashKey, "ключ подписики по алгоритму sha256")
+	keyFile := fs.String("key-file", cfg.HashKeyFile, "файл с дополнительными ключами подписи")
+	hashStrict := fs.Bool("hash-strict", cfg.HashStrict, "отклонять запросы без подписи и с неверной подписью")
+	hashReplayWindow := fs.Int("hash-replay-window", cfg.HashReplayWindow, "окно защиты от повтора в секундах")
+	authStore := fs.String("auth-store", cfg.AuthStore, "хранилище API токенов: file или postgres")
+	authTokensFile := fs.String("auth-tokens-file", cfg.AuthTokensFile, "файл API токенов")
+	agentKeysFile := fs.String("agent-keys-file", cfg.AgentKeysFile, "файл с ключами подписи агентов")
 	auditFile := fs.String("audit-file", cfg.AuditFile, "audit path logs file")
 	auditURL := fs.String("audit-url", cfg.AuditURL, "audit url push logs")
+	auditQueueSize := fs.Int("audit-queue-size", cfg.AuditQueueSize, "размер очереди событий аудита")
+	auditURLRetries := fs.Int("audit-url-retries", cfg.AuditURLRetries, "повторы отправки аудита на URL")
+	auditSigningKey := fs.String("audit-signing-key", cfg.AuditSigningKey, "ключ подписи контрольных точек аудита")
+	auditMaxSize := fs.Int64("audit-max-size", cfg.AuditMaxSize, "размер журнала аудита для ротации в байтах")
+	auditRotateInterval := fs.Int("audit-rotate-interval", cfg.AuditRotateInterval, "период ротации журнала аудита в секундах")
+	auditMaxBackups := fs.Int("audit-max-backups", cfg.AuditMaxBackups, "число хранимых сегментов журнала аудита")
+	auditMaxAge := fs.Int("audit-max-age", cfg.AuditMaxAge, "срок хранения сегментов журнала аудита в секундах")
+	auditCompress := fs.Bool("audit-compress", cfg.AuditCompress, "сжимать сегменты журнала аудита gzip")
+	auditStore := fs.String("audit-store", cfg.AuditStore, "хранилище поиска аудита: file или postgres")
+	auditCheckpointEvery := fs.Int("audit-checkpoint-every", cfg.AuditCheckpointEvery, "контрольная точка аудита каждые N событий")
 	cryptoKey := fs.String("crypto-key", cfg.CryptoKey, "the path to private key")
-	trustedSubnet := fs.String("t", cfg.TrustedSubnet, "trusted subnet CIDR")
+	cryptoKeysPrevious := fs.String("crypto-keys-previous", "", "предыдущие приватные ключи через запятую")
+	cryptoLegacy := fs.Bool("crypto-legacy", cfg.CryptoLegacy, "принимать устаревшие форматы шифрования rsa и hybrid")
+	trustedSubnet := fs.String("t", cfg.TrustedSubnet, "trusted subnet CIDR, несколько через запятую")
+	deniedSubnets := fs.String("denied-subnets", "", "запрещённые сети через запятую")
+	subnetPolicyFile := fs.String("subnet-policy-file", cfg.SubnetPolicyFile, "файл правил подсетей по классам маршрутов")
+	trustedProxies := fs.String("trusted-proxies", "", "CIDR доверенных прокси через запятую")
+	rateLimit := fs.Float64("request-rate-limit", cfg.RequestRateLimit, "запросов в секунду на клиента, 0 — без ограничения")
+	rateBurst := fs.Int("request-rate-burst", cfg.RequestRateBurst, "допустимая серия запросов сверх request-rate-limit")
+	maxBodyBytes := fs.Int64("max-body-bytes", cfg.MaxBodyBytes, "максимальный размер тела после распаковки")
+	maxBatchSize := fs.Int("max-batch-size", cfg.MaxBatchSize, "максимум метрик в одном запросе")
+	maxNameLength := fs.Int("max-metric-name-length", cfg.MaxMetricNameLength, "максимальная длина имени метрики")
+	seriesPattern := fs.String("series-name-pattern", cfg.SeriesNamePattern, "регулярное выражение для имён метрик")
+	seriesReserved := fs.String("series-reserved-prefixes", "", "зарезервированные префиксы имён через запятую")
+	maxSeries := fs.Int("max-series", cfg.MaxSeries, "максимальное число рядов")
+	maxSeriesPerClient := fs.Int("max-series-per-client", cfg.MaxSeriesPerClient, "максимальное число новых рядов от одного клиента")
 	grpcAddr := fs.String("grpc", "", "gRPC server address")
+	tlsCert := fs.String("tls-cert", cfg.TLSCertFile, "сертификат сервера для TLS")
+	tlsKey := fs.String("tls-key", cfg.TLSKeyFile, "приватный ключ сертификата сервера")
+	tlsClientCA := fs.String("tls-client-ca", cfg.TLSClientCAFile, "CA клиентских сертификатов, включает mTLS")
 
 	_ = fs.Parse(os.Args[1:])
 
@@ -116,16 +211,82 @@ func Load() *Config {
 			cfg.DatabaseDSN = *dsn
 		case "k":
 			cfg.HashKey = *key
+		case "key-file":
+			cfg.HashKeyFile = *keyFile
+		case "hash-strict":
+			cfg.HashStrict = *hashStrict
+		case "hash-replay-window":
+			cfg.HashReplayWindow = *hashReplayWindow
+		case "agent-keys-file":
+			cfg.AgentKeysFile = *agentKeysFile
+		case "auth-store":
+			cfg.AuthStore = *authStore
+		case "auth-tokens-file":
+			cfg.AuthTokensFile = *authTokensFile
 		case "audit-file":
 			cfg.AuditFile = *auditFile
 		case "audit-url":
 			cfg.AuditURL = *auditURL
+		case "audit-queue-size":
+			cfg.AuditQueueSize = *auditQueueSize
+		case "audit-url-retries":
+			cfg.AuditURLRetries = *auditURLRetries
+		case "audit-signing-key":
+			cfg.AuditSigningKey = *auditSigningKey
+		case "audit-checkpoint-every":
+			cfg.AuditCheckpointEvery = *auditCheckpointEvery
+		case "audit-max-size":
+			cfg.AuditMaxSize = *auditMaxSize
+		case "audit-rotate-interval":
+			cfg.AuditRotateInterval = *auditRotateInterval
+		case "audit-max-backups":
+			cfg.AuditMaxBackups = *auditMaxBackups
+		case "audit-max-age":
+			cfg.AuditMaxAge = *auditMaxAge
+		case "audit-compress":
+			cfg.AuditCompress = *auditCompress
+		case "audit-store":
+			cfg.AuditStore = *auditStore
 		case "crypto-key":
 			cfg.CryptoKey = *cryptoKey
+		case "crypto-keys-previous":
+			cfg.CryptoKeysPrevious = splitList(*cryptoKeysPrevious)
+		case "crypto-legacy":
+			cfg.CryptoLegacy = *cryptoLegacy
 		case "t":
 			cfg.TrustedSubnet = *trustedSubnet
+		case "denied-subnets":
+			cfg.DeniedSubnets = splitList(*deniedSubnets)
+		case "subnet-policy-file":
+			cfg.SubnetPolicyFile = *subnetPolicyFile
+		case "request-rate-limit":
+			cfg.RequestRateLimit = *rateLimit
+		case "request-rate-burst":
+			cfg.RequestRateBurst = *rateBurst
+		case "max-body-bytes":
+			cfg.MaxBodyBytes = *maxBodyBytes
+		case "max-batch-size":
+			cfg.MaxBatchSize = *maxBatchSize
+		case "max-metric-name-length":
+			cfg.MaxMetricNameLength = *maxNameLength
+		case "series-name-pattern":
+			cfg.SeriesNamePattern = *seriesPattern
+		case "series-reserved-prefixes":
+			cfg.SeriesReservedPrefixes = splitList(*seriesReserved)
+		case "max-series":
+			cfg.MaxSeries = *maxSeries
+		case "max-series-per-client":
+			cfg.MaxSeriesPerClient = *maxSeriesPerClient
+		case "trusted-proxies":
+			cfg.TrustedProxies = splitList(*trustedProxies)
 		case "grpc":
 			cfg.GRPCAddress = *grpcAddr
+		case "tls-cert":
+			cfg.TLSCertFile = *tlsCert
+		case "tls-key":
+			cfg.TLSKeyFile = *tlsKey
+		case "tls-client-ca":
+			cfg.TLSClientCAFile = *tlsClientCA
 		}
 	})
 
@@ -153,9 +314,32 @@ func loadFromJSON(filename string, cfg *Config) {
 		StoreFile     *string      `json:"store_file"`
 		Restore       *bool        `json:"restore"`
 		DatabaseDSN   *string      `json:"database_dsn"`
+		KeyFile       *string      `json:"key_file"`
+		HashStrict    *bool        `json:"hash_strict"`
+		ReplayWindow  *jsonSeconds `json:"hash_replay_window"`
+		AgentKeysFile *string      `json:"agent_keys_file"`
+		AuthStore     *string      `json:"auth_store"`
+		AuthTokens    *string      `json:"auth_tokens_file"`
 		CryptoKey     *string      `json:"crypto_key"`
+		CryptoKeys    []string     `json:"crypto_keys_previous"`
+		CryptoLegacy  *bool        `json:"crypto_legacy"`
 		TrustedSubnet *string      `json:"trusted_subnet"`
+		Proxies       []string     `json:"trusted_proxies"`
+		RateLimit     *float64     `json:"request_rate_limit"`
+		RateBurst     *int         `json:"request_rate_burst"`
+		MaxBodyBytes  *int64       `json:"max_body_bytes"`
+		MaxBatchSize  *int         `json:"max_batch_size"`
+		MaxNameLength *int         `json:"max_metric_name_length"`
+		SeriesPattern *string      `json:"series_name_pattern"`
+		SeriesPrefix  []string     `json:"series_reserved_prefixes"`
+		MaxSeries     *int         `json:"max_series"`
+		MaxPerClient  *int         `json:"max_series_per_client"`
+		DeniedSubnets []string     `json:"denied_subnets"`
+		SubnetPolicy  *string      `json:"subnet_policy_file"`
 		GRPCAddress   *string      `json:"grpc_address"`
+		TLSCertFile   *string      `json:"tls_cert"`
+		TLSKeyFile    *string      `json:"tls_key"`
+		TLSClientCA   *string      `json:"tls_client_ca"`
 	}
 
 	if err := json.Unmarshal(data, &jc); err != nil {
@@ -181,13 +365,187 @@ func loadFromJSON(filename string, cfg *Config) {
 	if jc.CryptoKey != nil {
 		cfg.CryptoKey = *jc.CryptoKey
 	}
+	if jc.KeyFile != nil {
+		cfg.HashKeyFile = *jc.KeyFile
+	}
+	if jc.HashStrict != nil {
+		cfg.HashStrict = *jc.HashStrict
+	}
+	if jc.ReplayWindow != nil {
+		cfg.HashReplayWindow = int(*jc.ReplayWindow)
+	}
+	if jc.AgentKeysFile != nil {
+		cfg.AgentKeysFile = *jc.AgentKeysFile
+	}
+	if jc.AuthStore != nil {
+		cfg.AuthStore = *jc.AuthStore
+	}
+	if jc.AuthTokens != nil {
+		cfg.AuthTokensFile = *jc.AuthTokens
+	}
+	if jc.CryptoKeys != nil {
+		cfg.CryptoKeysPrevious = jc.CryptoKeys
+	}
+	if jc.CryptoLegacy != nil {
+		cfg.CryptoLegacy = *jc.CryptoLegacy
+	}
 	if jc.TrustedSubnet != nil {
 		cfg.TrustedSubnet = *jc.TrustedSubnet
 	}
+	if jc.Proxies != nil {
+		cfg.TrustedProxies = jc.Proxies
+	}
+	if jc.RateLimit != nil {
+		cfg.RequestRateLimit = *jc.RateLimit
+	}
+	if jc.RateBurst != nil {
+		cfg.RequestRateBurst = *jc.RateBurst
+	}
+	if jc.MaxBodyBytes != nil {
+		cfg.MaxBodyBytes = *jc.MaxBodyBytes
+	}
+	if jc.MaxBatchSize != nil {
+		cfg.MaxBatchSize = *jc.MaxBatchSize
+	}
+	if jc.MaxNameLength != nil {
+		cfg.MaxMetricNameLength = *jc.MaxNameLength
+	}
+	if jc.SeriesPattern != nil {
+		cfg.SeriesNamePattern = *jc.SeriesPattern
+	}
+	if jc.SeriesPrefix != nil {
+		cfg.SeriesReservedPrefixes = jc.SeriesPrefix
+	}
+	if jc.MaxSeries != nil {
+		cfg.MaxSeries = *jc.MaxSeries
+	}
+	if jc.MaxPerClient != nil {
+		cfg.MaxSeriesPerClient = *jc.MaxPerClient
+	}
+	if jc.DeniedSubnets != nil {
+		cfg.DeniedSubnets = jc.DeniedSubnets
+	}
+	if jc.SubnetPolicy != nil {
+		cfg.SubnetPolicyFile = *jc.SubnetPolicy
+	}
 	if jc.GRPCAddress != nil {
 		cfg.GRPCAddress = *jc.GRPCAddress
 	}
+	if jc.TLSCertFile != nil {
+		cfg.TLSCertFile = *jc.TLSCertFile
+	}
+	if jc.TLSKeyFile != nil {
+		cfg.TLSKeyFile = *jc.TLSKeyFile
+	}
+	if jc.TLSClientCA != nil {
+		cfg.TLSClientCAFile = *jc.TLSClientCA
+	}
+
+}
+
+// источники ключей для keyring: текущий приватный ключ первым, за ним предыдущие
+func (cfg *Config) KeyringConfig() keyring.Config {
+	var files []string
+	if cfg.CryptoKey != "" {
+		files = append(files, cfg.CryptoKey)
+	}
+	files = append(files, cfg.CryptoKeysPrevious...)
+	return keyring.Config{
+		PrivateKeyFiles: files,
+		HashKey:         cfg.HashKey,
+		HashKeyFile:     cfg.HashKeyFile,
+		AgentKeysFile:   cfg.AgentKeysFile,
+	}
+}
+
+// источники политики подсетей для HTTP и gRPC
+func (cfg *Config) SubnetPolicyConfig() netpolicy.Config {
+	return netpolicy.Config{
+		Allow: splitList(cfg.TrustedSubnet),
+		Deny:  cfg.DeniedSubnets,
+		File:  cfg.SubnetPolicyFile,
+	}
+}
+
+// файловый журнал аудита; nil, если файл не задан
+func (cfg *Config) AuditFileReceiver() *audit.FileReceiver {
+	if cfg.AuditFile == "" {
+		return nil
+	}
+	return &audit.FileReceiver{
+		FilePath:        cfg.AuditFile,
+		SigningKey:      cfg.AuditSigningKey,
+		CheckpointEvery: cfg.AuditCheckpointEvery,
+		MaxSize:         cfg.AuditMaxSize,
+		RotateInterval:  time.Duration(cfg.AuditRotateInterval) * time.Second,
+		MaxBackups:      cfg.AuditMaxBackups,
+		MaxBackupAge:    time.Duration(cfg.AuditMaxAge) * time.Second,
+		Compress:        cfg.AuditCompress,
+	}
+}
+
+// ограничения приёма метрик для HTTP и gRPC
+func (cfg *Config) LimitsConfig() limits.Config {
+	return limits.Config{
+		Rate:          cfg.RequestRateLimit,
+		Burst:         cfg.RequestRateBurst,
+		MaxBodyBytes:  cfg.MaxBodyBytes,
+		MaxBatchSize:  cfg.MaxBatchSize,
+		MaxNameLength: cfg.MaxMetricNameLength,
+	}
+}
+
+// политика имён и числа рядов. префикс собственных метрик сервера зарезервирован всегда
+func (cfg *Config) SeriesConfig() series.Config {
+	return series.Config{
+		NamePattern:      cfg.SeriesNamePattern,
+		ReservedPrefixes: append([]string{limits.SelfTelemetryPrefix}, cfg.SeriesReservedPrefixes...),
+		MaxSeries:        cfg.MaxSeries,
+		MaxPerClient:     cfg.MaxSeriesPerClient,
+	}
+}
+
+// параметры проверки подписи HTTP запросов
+func (cfg *Config) HashOptions() []middleware.HashOption {
+	var opts []middleware.HashOption
+	if cfg.HashStrict {
+		opts = append(opts, middleware.WithStrictHash())
+	}
+	if cfg.HashReplayWindow > 0 {
+		opts = append(opts, middleware.WithReplayWindow(time.Duration(cfg.HashReplayWindow)*time.Second))
+	}
+	return opts
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// хранилище токенов: file, если задан только файл токенов
+func (cfg *Config) AuthStoreKind() string {
+	if cfg.AuthStore == "" && cfg.AuthTokensFile != "" {
+		return "file"
+	}
+	return cfg.AuthStore
+}
+
+// хранилище поиска событий аудита; без явного AUDIT_STORE — file, если задан файл журнала
+func (cfg *Config) AuditStoreKind() string {
+	if cfg.AuditStore == "" && cfg.AuditFile != "" {
+		return "file"
+	}
+	return cfg.AuditStore
+}
 
+// true, если сервер должен принимать соединения по TLS
+func (cfg *Config) UseTLS() bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
 }
 
 func (cfg *Config) GetStoreIntervalDuration() time.Duration {
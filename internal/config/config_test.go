@@ -454,6 +454,21 @@ func TestApplyEnv(t *testing.T) {
 	}
 }
 
+// RATE_LIMIT — число воркеров агента, при общем окружении сервер его не читает
+func TestApplyEnv_RequestRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "7")
+	var cfg Config
+	require.NoError(t, cleanenv.ReadEnv(&cfg))
+	assert.Zero(t, cfg.RequestRateLimit)
+
+	t.Setenv("REQUEST_RATE_LIMIT", "2.5")
+	t.Setenv("REQUEST_RATE_BURST", "10")
+	cfg = Config{}
+	require.NoError(t, cleanenv.ReadEnv(&cfg))
+	assert.Equal(t, 2.5, cfg.RequestRateLimit)
+	assert.Equal(t, 10, cfg.RequestRateBurst)
+}
+
 func TestGetStoreIntervalDuration(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,195 @@
+// Package envelope реализует версионированный конверт для шифрования тела запросов:
+// случайный ключ AES-256-GCM шифрует данные, сам ключ оборачивается RSA-OAEP (SHA-256)
+// публичным ключом сервера. в заголовке конверта передаётся идентификатор ключа,
+// чтобы сервер мог держать несколько ключей во время ротации.
+//
+// формат версии 1:
+//
+//	magic "MENV" | version (1 байт) | len(keyID) (1 байт) | keyID |
+//	len(wrappedKey) (2 байта, big endian) | wrappedKey | nonce (12 байт) | ciphertext+tag
+//
+// заголовок целиком (всё до nonce) передаётся в AES-GCM как associated data,
+// поэтому подмена версии, идентификатора или обёрнутого ключа обнаруживается при расшифровке.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// значение заголовка X-Encrypted для конверта
+const HeaderValue = "envelope-v1"
+
+const (
+	Version1 = 1
+
+	aesKeySize = 32
+	nonceSize  = 12
+)
+
+var (
+	magic = []byte("MENV")
+	// метка OAEP привязывает обёрнутый ключ к формату конверта
+	oaepLabel = []byte("metrics-envelope-v1")
+)
+
+var (
+	ErrMalformed          = errors.New("envelope: malformed payload")
+	ErrUnsupportedVersion = errors.New("envelope: unsupported version")
+	ErrUnknownKey         = errors.New("envelope: unknown key id")
+	ErrDecrypt            = errors.New("envelope: decryption failed")
+)
+
+// идентификатор ключа: первые 8 байт SHA-256 от DER публичного ключа в hex.
+// агент и сервер вычисляют его независимо, настраивать ничего не нужно.
+func KeyID(pub *rsa.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:8])
+}
+
+// true, если данные начинаются с сигнатуры конверта
+func IsEnvelope(data []byte) bool {
+	return len(data) >= len(magic) && string(data[:len(magic)]) == string(magic)
+}
+
+// шифрует plaintext для владельца приватного ключа, соответствующего pub.
+func Seal(pub *rsa.PublicKey, keyID string, plaintext []byte) ([]byte, error) {
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("envelope: key id too long")
+	}
+
+	key := make([]byte, aesKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, oaepLabel)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: wrap key: %w", err)
+	}
+
+	header := make([]byte, 0, len(magic)+4+len(keyID)+len(wrapped))
+	header = append(header, magic...)
+	header = append(header, Version1, byte(len(keyID)))
+	header = append(header, keyID...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+nonceSize+len(plaintext)+gcm.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, header), nil
+}
+
+// разобранный заголовок конверта
+type Header struct {
+	Version    byte
+	KeyID      string
+	wrappedKey []byte
+	raw        []byte // байты заголовка, associated data для AES-GCM
+}
+
+// разбирает заголовок и возвращает его вместе с nonce и шифротекстом.
+func ParseHeader(data []byte) (Header, []byte, error) {
+	var h Header
+	if !IsEnvelope(data) {
+		return h, nil, ErrMalformed
+	}
+	rest := data[len(magic):]
+	if len(rest) < 2 {
+		return h, nil, ErrMalformed
+	}
+
+	h.Version = rest[0]
+	if h.Version != Version1 {
+		return h, nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, h.Version)
+	}
+
+	idLen := int(rest[1])
+	rest = rest[2:]
+	if len(rest) < idLen+2 {
+		return h, nil, ErrMalformed
+	}
+	h.KeyID = string(rest[:idLen])
+	rest = rest[idLen:]
+
+	wrappedLen := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < wrappedLen+nonceSize {
+		return h, nil, ErrMalformed
+	}
+	h.wrappedKey = rest[:wrappedLen]
+	rest = rest[wrappedLen:]
+
+	h.raw = data[:len(data)-len(rest)]
+	return h, rest, nil
+}
+
+// ищет приватный ключ по идентификатору из заголовка
+type KeyLookup func(keyID string) (*rsa.PrivateKey, bool)
+
+// расшифровывает конверт ключом, найденным по идентификатору.
+func Open(data []byte, lookup KeyLookup) ([]byte, error) {
+	h, body, err := ParseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, ok := lookup(h.KeyID)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, h.KeyID)
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, h.wrappedKey, oaepLabel)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, ciphertext := body[:nonceSize], body[nonceSize:]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, h.raw)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// поиск для единственного ключа сервера
+func SingleKey(priv *rsa.PrivateKey) KeyLookup {
+	id := KeyID(&priv.PublicKey)
+	return func(keyID string) (*rsa.PrivateKey, bool) {
+		return priv, keyID == id
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	key := generateKey(t)
+	keyID := envelope.KeyID(&key.PublicKey)
+
+	for _, size := range []int{0, 1, 180, 245, 64 * 1024} {
+		plaintext := make([]byte, size)
+		_, err := rand.Read(plaintext)
+		require.NoError(t, err)
+
+		sealed, err := envelope.Seal(&key.PublicKey, keyID, plaintext)
+		require.NoError(t, err)
+		assert.True(t, envelope.IsEnvelope(sealed))
+
+		h, _, err := envelope.ParseHeader(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, byte(envelope.Version1), h.Version)
+		assert.Equal(t, keyID, h.KeyID)
+
+		opened, err := envelope.Open(sealed, envelope.SingleKey(key))
+		require.NoError(t, err)
+		assert.True(t, bytes.Equal(plaintext, opened), "size %d", size)
+	}
+}
+
+func TestEnvelope_KeyRotation(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+	keys := map[string]*rsa.PrivateKey{
+		envelope.KeyID(&oldKey.PublicKey): oldKey,
+		envelope.KeyID(&newKey.PublicKey): newKey,
+	}
+	lookup := func(id string) (*rsa.PrivateKey, bool) {
+		k, ok := keys[id]
+		return k, ok
+	}
+
+	for _, k := range []*rsa.PrivateKey{oldKey, newKey} {
+		sealed, err := envelope.Seal(&k.PublicKey, envelope.KeyID(&k.PublicKey), []byte("payload"))
+		require.NoError(t, err)
+		opened, err := envelope.Open(sealed, lookup)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(opened))
+	}
+
+	unknown := generateKey(t)
+	sealed, err := envelope.Seal(&unknown.PublicKey, envelope.KeyID(&unknown.PublicKey), []byte("payload"))
+	require.NoError(t, err)
+	_, err = envelope.Open(sealed, lookup)
+	assert.ErrorIs(t, err, envelope.ErrUnknownKey)
+}
+
+func TestEnvelope_Tamper(t *testing.T) {
+	key := generateKey(t)
+	keyID := envelope.KeyID(&key.PublicKey)
+	sealed, err := envelope.Seal(&key.PublicKey, keyID, []byte(`[{"id":"cpu","type":"gauge","value":1}]`))
+	require.NoError(t, err)
+
+	h, body, err := envelope.ParseHeader(sealed)
+	require.NoError(t, err)
+	headerLen := len(sealed) - len(body)
+
+	tests := []struct {
+		name    string
+		mutate  func(b []byte) []byte
+		wantErr error
+	}{
+		{name: "ciphertext bit flip", mutate: func(b []byte) []byte { b[len(b)-20] ^= 0x01; return b }, wantErr: envelope.ErrDecrypt},
+		{name: "tag bit flip", mutate: func(b []byte) []byte { b[len(b)-1] ^= 0x80; return b }, wantErr: envelope.ErrDecrypt},
+		{name: "nonce bit flip", mutate: func(b []byte) []byte { b[headerLen] ^= 0x01; return b }, wantErr: envelope.ErrDecrypt},
+		{name: "wrapped key bit flip", mutate: func(b []byte) []byte { b[headerLen-1] ^= 0x01; return b }, wantErr: envelope.ErrDecrypt},
+		{name: "unsupported version", mutate: func(b []byte) []byte { b[4] = 2; return b }, wantErr: envelope.ErrUnsupportedVersion},
+		{name: "truncated", mutate: func(b []byte) []byte { return b[:headerLen+5] }, wantErr: envelope.ErrMalformed},
+		{name: "not an envelope", mutate: func(b []byte) []byte { return []byte("a|b|c") }, wantErr: envelope.ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(append([]byte{}, sealed...))
+			_, err := envelope.Open(data, envelope.SingleKey(key))
+			assert.True(t, errors.Is(err, tt.wantErr), "got %v", err)
+		})
+	}
+
+	// подмена идентификатора ключа в заголовке ломает associated data
+	swapped := append([]byte{}, sealed...)
+	swapped[6] ^= 0x01
+	lookupAny := func(string) (*rsa.PrivateKey, bool) { return key, true }
+	_, err = envelope.Open(swapped, lookupAny)
+	assert.ErrorIs(t, err, envelope.ErrDecrypt)
+	assert.NotEmpty(t, h.KeyID)
+}
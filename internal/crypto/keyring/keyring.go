@@ -0,0 +1,248 @@
+// Package keyring хранит ключи сервера: приватные RSA ключи для расшифровки
+// и HMAC ключи для проверки подписи. текущий ключ идёт первым, за ним предыдущие,
+// которые ещё принимаются, пока агенты переходят на новый ключ.
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+)
+
+// источники ключей. файлы перечитываются при Reload, статический HMAC ключ — нет.
+type Config struct {
+	PrivateKeyFiles []string // первый — текущий ключ
+	HashKey         string   // ключ из флага -k или KEY, считается текущим
+	HashKeyFile     string   // файл с HMAC ключами, по одному на строку
+	AgentKeysFile   string   // файл с ключами агентов: "agent-id ключ" на строку
+}
+
+type Keyring struct {
+	cfg Config
+
+	mu         sync.RWMutex
+	privByID   map[string]*rsa.PrivateKey
+	privOrder  []*rsa.PrivateKey
+	hashKeys   []string
+	privateIDs []string
+	agentKeys  map[string][]string
+}
+
+func New(cfg Config) (*Keyring, error) {
+	k := &Keyring{cfg: cfg}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// keyring из одного HMAC ключа, для конфигураций без ротации
+func Static(hashKey string) *Keyring {
+	k := &Keyring{cfg: Config{HashKey: hashKey}}
+	// без файлов Reload не возвращает ошибок
+	_ = k.Reload()
+	return k
+}
+
+// перечитывает файлы ключей. при ошибке остаются прежние ключи,
+// чтобы опечатка в файле не остановила приём метрик.
+func (k *Keyring) Reload() error {
+	privByID := make(map[string]*rsa.PrivateKey, len(k.cfg.PrivateKeyFiles))
+	privOrder := make([]*rsa.PrivateKey, 0, len(k.cfg.PrivateKeyFiles))
+	privateIDs := make([]string, 0, len(k.cfg.PrivateKeyFiles))
+	for _, path := range k.cfg.PrivateKeyFiles {
+		priv, err := LoadPrivateKey(path)
+		if err != nil {
+			return fmt.Errorf("keyring: %s: %w", path, err)
+		}
+		id := envelope.KeyID(&priv.PublicKey)
+		if _, dup := privByID[id]; dup {
+			continue
+		}
+		privByID[id] = priv
+		privOrder = append(privOrder, priv)
+		privateIDs = append(privateIDs, id)
+	}
+
+	var hashKeys []string
+	if k.cfg.HashKey != "" {
+		hashKeys = append(hashKeys, k.cfg.HashKey)
+	}
+	if k.cfg.HashKeyFile != "" {
+		fileKeys, err := readHashKeys(k.cfg.HashKeyFile)
+		if err != nil {
+			return fmt.Errorf("keyring: %s: %w", k.cfg.HashKeyFile, err)
+		}
+		hashKeys = append(hashKeys, fileKeys...)
+	}
+
+	var agentKeys map[string][]string
+	if k.cfg.AgentKeysFile != "" {
+		var err error
+		agentKeys, err = readAgentKeys(k.cfg.AgentKeysFile)
+		if err != nil {
+			return fmt.Errorf("keyring: %s: %w", k.cfg.AgentKeysFile, err)
+		}
+	}
+
+	k.mu.Lock()
+	k.privByID = privByID
+	k.privOrder = privOrder
+	k.privateIDs = privateIDs
+	k.hashKeys = hashKeys
+	k.agentKeys = agentKeys
+	k.mu.Unlock()
+	return nil
+}
+
+// поиск приватного ключа по идентификатору из конверта
+func (k *Keyring) Lookup(keyID string) (*rsa.PrivateKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	priv, ok := k.privByID[keyID]
+	return priv, ok
+}
+
+// приватные ключи по порядку, для старых форматов без идентификатора ключа
+func (k *Keyring) PrivateKeys() []*rsa.PrivateKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]*rsa.PrivateKey(nil), k.privOrder...)
+}
+
+// идентификаторы загруженных приватных ключей, текущий первым
+func (k *Keyring) KeyIDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]string(nil), k.privateIDs...)
+}
+
+func (k *Keyring) HasPrivateKeys() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.privOrder) > 0
+}
+
+// true, если задан общий ключ или ключ хотя бы одного агента
+func (k *Keyring) HasHashKeys() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.hashKeys) > 0 || len(k.agentKeys) > 0
+}
+
+// подпись текущим HMAC ключом в hex; пустая строка, если ключей нет
+func (k *Keyring) Sign(data []byte) string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if len(k.hashKeys) == 0 {
+		return ""
+	}
+	return ComputeHMAC(k.hashKeys[0], data)
+}
+
+// true, если подпись совпала с любым из HMAC ключей
+func (k *Keyring) Verify(data []byte, signature string) bool {
+	k.mu.RLock()
+	keys := k.hashKeys
+	k.mu.RUnlock()
+
+	for _, key := range keys {
+		if hmac.Equal([]byte(signature), []byte(ComputeHMAC(key, data))) {
+			return true
+		}
+	}
+	return false
+}
+
+// проверка подписи агента. если у агента есть собственные ключи, принимаются
+// только они: утечка общего ключа не позволяет подписывать от его имени.
+// для агентов без собственных ключей проверяются общие.
+func (k *Keyring) VerifyAgent(agentID string, data []byte, signature string) bool {
+	k.mu.RLock()
+	keys, ok := k.agentKeys[agentID]
+	k.mu.RUnlock()
+	if agentID == "" || !ok {
+		return k.Verify(data, signature)
+	}
+
+	for _, key := range keys {
+		if hmac.Equal([]byte(signature), []byte(ComputeHMAC(key, data))) {
+			return true
+		}
+	}
+	return false
+}
+
+// HMAC-SHA256 в hex
+func ComputeHMAC(key string, data []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// загружает приватный ключ RSA в формате PKCS#1 PEM
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "RSA PRIVATE KEY" {
+		return nil, fmt.Errorf("invalid private key format")
+	}
+
+	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	return privKey, nil
+}
+
+// ключи по одному на строку, пустые строки и комментарии # пропускаются
+func readHashKeys(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, sc.Err()
+}
+
+// ключи агентов, "agent-id ключ" на строку. у агента может быть несколько строк,
+// например текущий и предыдущий ключ во время ротации.
+func readAgentKeys(path string) (map[string][]string, error) {
+	lines, err := readHashKeys(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string][]string, len(lines))
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"agent-id key\"", i+1)
+		}
+		keys[fields[0]] = append(keys[fields[0]], fields[1])
+	}
+	return keys, nil
+}
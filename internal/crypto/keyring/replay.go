@@ -0,0 +1,75 @@
+package keyring
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// защита от повтора подписанных запросов, общая для HTTP и gRPC:
+// метка времени должна быть в пределах окна, nonce принимается один раз
+type ReplayGuard struct {
+	window time.Duration
+	nonces *nonceCache
+}
+
+func NewReplayGuard(window time.Duration) *ReplayGuard {
+	return &ReplayGuard{window: window, nonces: newNonceCache(2 * window)}
+}
+
+// метка времени в пределах окна и непустой nonce
+func (g *ReplayGuard) CheckFreshness(timestamp, nonce string) error {
+	if timestamp == "" || nonce == "" {
+		return fmt.Errorf("missing signature timestamp or nonce")
+	}
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp")
+	}
+	skew := time.Since(time.Unix(sec, 0))
+	if skew < -g.window || skew > g.window {
+		return fmt.Errorf("signature timestamp outside of allowed window")
+	}
+	return nil
+}
+
+// запоминает nonce; false, если он уже встречался.
+// вызывать после проверки подписи, чтобы чужие запросы не занимали nonce
+func (g *ReplayGuard) Remember(nonce string) bool {
+	return g.nonces.add(nonce, time.Now())
+}
+
+// использованные nonce. хранятся дольше окна с обеих сторон,
+// после этого запрос с тем же nonce всё равно отклоняется по метке времени.
+type nonceCache struct {
+	mu        sync.Mutex
+	ttl       time.Duration
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+func newNonceCache(ttl time.Duration) *nonceCache {
+	return &nonceCache{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+// false, если nonce уже встречался
+func (c *nonceCache) add(nonce string, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPurge) > c.ttl/2 {
+		for n, at := range c.seen {
+			if now.Sub(at) > c.ttl {
+				delete(c.seen, n)
+			}
+		}
+		c.lastPurge = now
+	}
+
+	if at, ok := c.seen[nonce]; ok && now.Sub(at) <= c.ttl {
+		return false
+	}
+	c.seen[nonce] = now
+	return true
+}
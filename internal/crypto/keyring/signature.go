@@ -0,0 +1,45 @@
+package keyring
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// заголовки подписи HTTP запроса. метка времени и nonce входят в подписанные данные,
+// поэтому перехваченный запрос нельзя повторить с новыми значениями.
+const (
+	HeaderSignature = "HashSHA256"
+	HeaderTimestamp = "X-Signature-Timestamp" // unix время в секундах
+	HeaderNonce     = "X-Signature-Nonce"
+	HeaderAgentID   = "X-Agent-ID"
+)
+
+// данные, которые подписываются вместе с маршрутом, меткой времени и nonce.
+// маршрут не даёт повторить подписанное тело на другом пути или методе
+func SignedPayload(route, timestamp, nonce string, body []byte) []byte {
+	out := make([]byte, 0, len(route)+len(timestamp)+len(nonce)+len(body)+3)
+	out = append(out, route...)
+	out = append(out, '\n')
+	out = append(out, timestamp...)
+	out = append(out, '\n')
+	out = append(out, nonce...)
+	out = append(out, '\n')
+	return append(out, body...)
+}
+
+// маршрут HTTP запроса для подписи: метод и путь без query, "POST /updates/".
+// у gRPC маршрутом служит полное имя метода
+func Route(method, path string) string {
+	return method + " " + path
+}
+
+// метка времени и случайный nonce для нового запроса
+func NewSignatureParams() (timestamp, nonce string, err error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	return strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(buf), nil
+}
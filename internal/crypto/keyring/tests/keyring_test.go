@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, path string) *rsa.PrivateKey {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return priv
+}
+
+func TestKeyring_RotationByKeyID(t *testing.T) {
+	dir := t.TempDir()
+	current := writeKey(t, filepath.Join(dir, "current.pem"))
+	previous := writeKey(t, filepath.Join(dir, "previous.pem"))
+
+	keys, err := keyring.New(keyring.Config{PrivateKeyFiles: []string{
+		filepath.Join(dir, "current.pem"),
+		filepath.Join(dir, "previous.pem"),
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{envelope.KeyID(&current.PublicKey), envelope.KeyID(&previous.PublicKey)}, keys.KeyIDs())
+
+	// агенты на старом и новом ключе принимаются одновременно
+	for _, priv := range []*rsa.PrivateKey{current, previous} {
+		sealed, err := envelope.Seal(&priv.PublicKey, envelope.KeyID(&priv.PublicKey), []byte("payload"))
+		require.NoError(t, err)
+		plain, err := envelope.Open(sealed, keys.Lookup)
+		require.NoError(t, err)
+		assert.Equal(t, "payload", string(plain))
+	}
+}
+
+func TestKeyring_HashKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hmac.keys")
+	require.NoError(t, os.WriteFile(path, []byte("# предыдущие ключи\nold-key\n\nolder-key\n"), 0o600))
+
+	keys, err := keyring.New(keyring.Config{HashKey: "new-key", HashKeyFile: path})
+	require.NoError(t, err)
+
+	data := []byte(`{"id":"cpu"}`)
+	assert.Equal(t, keyring.ComputeHMAC("new-key", data), keys.Sign(data), "подпись текущим ключом")
+	for _, key := range []string{"new-key", "old-key", "older-key"} {
+		assert.True(t, keys.Verify(data, keyring.ComputeHMAC(key, data)), key)
+	}
+	assert.False(t, keys.Verify(data, keyring.ComputeHMAC("unknown", data)))
+	assert.False(t, keys.Verify(data, ""))
+}
+
+func TestKeyring_Reload(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	hashPath := filepath.Join(dir, "hmac.keys")
+	first := writeKey(t, keyPath)
+	require.NoError(t, os.WriteFile(hashPath, []byte("first\n"), 0o600))
+
+	keys, err := keyring.New(keyring.Config{PrivateKeyFiles: []string{keyPath}, HashKeyFile: hashPath})
+	require.NoError(t, err)
+
+	t.Run("подхватывает новые ключи", func(t *testing.T) {
+		second := writeKey(t, keyPath)
+		require.NoError(t, os.WriteFile(hashPath, []byte("second\n"), 0o600))
+		require.NoError(t, keys.Reload())
+
+		_, ok := keys.Lookup(envelope.KeyID(&first.PublicKey))
+		assert.False(t, ok)
+		_, ok = keys.Lookup(envelope.KeyID(&second.PublicKey))
+		assert.True(t, ok)
+		assert.True(t, keys.Verify([]byte("x"), keyring.ComputeHMAC("second", []byte("x"))))
+	})
+
+	t.Run("при ошибке сохраняет прежние ключи", func(t *testing.T) {
+		before := keys.KeyIDs()
+		require.NoError(t, os.WriteFile(keyPath, []byte("not a key"), 0o600))
+
+		assert.Error(t, keys.Reload())
+		assert.Equal(t, before, keys.KeyIDs())
+		assert.True(t, keys.Verify([]byte("x"), keyring.ComputeHMAC("second", []byte("x"))))
+	})
+}
+
+func TestKeyring_Static(t *testing.T) {
+	assert.False(t, keyring.Static("").HasHashKeys())
+
+	keys := keyring.Static("secret")
+	assert.True(t, keys.HasHashKeys())
+	assert.False(t, keys.HasPrivateKeys())
+	assert.NoError(t, keys.Reload())
+	assert.True(t, keys.HasHashKeys(), "статический ключ сохраняется после Reload")
+}
+
+func TestKeyring_MissingFile(t *testing.T) {
+	_, err := keyring.New(keyring.Config{PrivateKeyFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+// Package cumulative переводит накопительные ряды в приращения counter.
+//
+// источники вроде Prometheus и OTLP присылают счётчики накопительным итогом,
+// а counter сервиса прибавляет delta. Tracker помнит итог каждого ряда и уже
+// отправленную часть, чтобы отправлять только прирост и переживать сбросы.
+package cumulative
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ряды, не обновлявшиеся дольше, забываются
+const staleAfter = time.Hour
+
+type Tracker struct {
+	mu      sync.Mutex
+	started time.Time
+	series  map[string]*state
+	purged  time.Time
+}
+
+// состояние ряда
+type state struct {
+	start   uint64  // время начала ряда в наносекундах, 0 — неизвестно
+	value   float64 // накопленный итог
+	sent    int64   // сколько уже отправлено в counter
+	updated time.Time
+}
+
+func NewTracker() *Tracker {
+	now := time.Now()
+	return &Tracker{started: now, series: make(map[string]*state), purged: now}
+}
+
+// изменения одного запроса. они применяются к Tracker только в Commit,
+// после того как метрики записаны: иначе неудачная запись потеряла бы прирост.
+type Changes struct {
+	t       *Tracker
+	pending map[string]state
+}
+
+func (t *Tracker) Begin() *Changes {
+	return &Changes{t: t, pending: make(map[string]state)}
+}
+
+// запоминает итоги записанного запроса
+func (t *Tracker) Commit(c *Changes) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range c.pending {
+		s.updated = now
+		t.series[id] = &s
+	}
+	if now.Sub(t.purged) > staleAfter {
+		for id, s := range t.series {
+			if now.Sub(s.updated) > staleAfter {
+				delete(t.series, id)
+			}
+		}
+		t.purged = now
+	}
+}
+
+// забывает ряды, которые больше не появятся, не дожидаясь устаревания
+func (t *Tracker) Forget(ids ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, id := range ids {
+		delete(t.series, id)
+	}
+}
+
+// состояние ряда с учётом ещё не применённых изменений
+func (c *Changes) current(id string) (state, bool) {
+	if s, ok := c.pending[id]; ok {
+		return s, true
+	}
+	c.t.mu.Lock()
+	defer c.t.mu.Unlock()
+	if s, ok := c.t.series[id]; ok {
+		return *s, true
+	}
+	return state{}, false
+}
+
+// сообщает, наблюдался ли ряд раньше
+func (c *Changes) Known(id string) bool {
+	_, ok := c.current(id)
+	return ok
+}
+
+// прибавляет приращение v к итогу ряда и возвращает итог
+func (c *Changes) Add(id string, v float64) float64 {
+	s, _ := c.current(id)
+	s.value += v
+	c.pending[id] = s
+	return s.value
+}
+
+// устанавливает накопительный итог v и возвращает его. start — начало ряда
+// в наносекундах unix, 0 — неизвестно. сброс ряда определяется по смене start
+// или уменьшению итога, после сброса весь итог считается новым.
+func (c *Changes) Set(id string, start uint64, v float64) float64 {
+	s, known := c.current(id)
+	switch {
+	case !known:
+		// первая точка известна не с начала ряда: если ряд начался до запуска
+		// сервера, его прошлое не восстановить, точка становится отправной
+		s.sent = 0
+		if start == 0 || time.Unix(0, int64(start)).Before(c.t.started) {
+			s.sent = int64(math.Round(v))
+		}
+	case start != s.start || v < s.value:
+		s.sent = 0
+	}
+	s.start = start
+	s.value = v
+	c.pending[id] = s
+	return v
+}
+
+// прирост counter с прошлой отправки; отмечает его отправленным
+func (c *Changes) Increment(id string) int64 {
+	s := c.pending[id]
+	delta := int64(math.Round(s.value)) - s.sent
+	s.sent += delta
+	c.pending[id] = s
+	return delta
+}
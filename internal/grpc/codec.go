@@ -0,0 +1,60 @@
+package grpcserver
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"google.golang.org/protobuf/proto"
+)
+
+// префикс зашифрованного сообщения. валидное protobuf сообщение не начинается
+// с нулевого байта (поле с номером 0 запрещено), поэтому открытые и зашифрованные
+// запросы различаются без отдельного content-subtype. должен совпадать с агентом.
+var EncryptedMagic = []byte{0x00, 'E', 'N', 'C', '1'}
+
+// кодек сервера: расшифровывает запросы агента, упакованные в конверт envelope,
+// открытые запросы принимает как обычный protobuf.
+type DecryptingCodec struct {
+	lookup envelope.KeyLookup
+}
+
+func NewDecryptingCodec(priv *rsa.PrivateKey) *DecryptingCodec {
+	return &DecryptingCodec{lookup: envelope.SingleKey(priv)}
+}
+
+// кодек с выбором ключа по идентификатору из конверта, например из keyring
+func NewDecryptingCodecWithLookup(lookup envelope.KeyLookup) *DecryptingCodec {
+	return &DecryptingCodec{lookup: lookup}
+}
+
+func (c *DecryptingCodec) Marshal(v any) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (c *DecryptingCodec) Unmarshal(data []byte, v any) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+
+	if payload, found := bytes.CutPrefix(data, EncryptedMagic); found {
+		plain, err := envelope.Open(payload, c.lookup)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt message: %w", err)
+		}
+		data = plain
+	}
+
+	return proto.Unmarshal(data, msg)
+}
+
+// имя совпадает со стандартным кодеком, чтобы агенту не нужен был особый content-subtype
+func (c *DecryptingCodec) Name() string {
+	return "proto"
+}
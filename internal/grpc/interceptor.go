@@ -2,32 +2,47 @@ package grpcserver
 
 import (
 	"context"
+	"errors"
 	"net"
+	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
+	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
+// пропускает только клиентов из trustedSubnet. адрес клиента — адрес соединения,
+// метаданные x-real-ip и x-forwarded-for не учитываются.
 func SubnetInterceptor(trustedSubnet *net.IPNet) grpc.UnaryServerInterceptor {
+	return SubnetInterceptorWithResolver(trustedSubnet, nil)
+}
+
+// то же, но метаданные x-forwarded-for и x-real-ip учитываются, если соединение
+// пришло от доверенного прокси
+func SubnetInterceptorWithResolver(trustedSubnet *net.IPNet, resolver *clientip.Resolver) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
 
 		if trustedSubnet == nil {
 			return handler(ctx, req)
 		}
 
-		md, ok := metadata.FromIncomingContext(ctx)
+		ipStr, ok := clientIP(ctx, resolver)
 		if !ok {
-			return nil, status.Error(codes.PermissionDenied, "no metadata")
-		}
-
-		ips := md.Get("x-real-ip")
-		if len(ips) == 0 {
 			return nil, status.Error(codes.PermissionDenied, "no ip")
 		}
 
-		ip := net.ParseIP(ips[0])
+		ip := net.ParseIP(ipStr)
 		if ip == nil || !trustedSubnet.Contains(ip) {
 			return nil, status.Error(codes.PermissionDenied, "ip not allowed")
 		}
@@ -35,3 +50,222 @@ func SubnetInterceptor(trustedSubnet *net.IPNet) grpc.UnaryServerInterceptor {
 		return handler(ctx, req)
 	}
 }
+
+// классы методов для политики подсетей, как у HTTP маршрутов
+var methodClasses = map[string]netpolicy.Class{
+	pb.Metrics_UpdateMetrics_FullMethodName: netpolicy.ClassWrite,
+}
+
+// проверяет адрес клиента по политике подсетей для класса метода
+func PolicyInterceptor(policy *netpolicy.Policy, resolver *clientip.Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		class := methodClasses[info.FullMethod]
+		if !policy.Restricted(class) {
+			return handler(ctx, req)
+		}
+
+		ip, ok := clientIP(ctx, resolver)
+		if !ok {
+			return nil, status.Error(codes.PermissionDenied, "no ip")
+		}
+		if err := policy.Check(class, ip); err != nil {
+			return nil, status.Error(codes.PermissionDenied, "ip not allowed")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// адрес клиента: адрес соединения или, за доверенным прокси, из метаданных
+func clientIP(ctx context.Context, resolver *clientip.Resolver) (string, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "", false
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var realIP string
+	if ips := md.Get("x-real-ip"); len(ips) > 0 {
+		realIP = ips[0]
+	}
+	return resolver.Resolve(p.Addr.String(), md.Get("x-forwarded-for"), realIP), true
+}
+
+type clientIPCtxKey struct{}
+
+// кладёт адрес клиента в context для следующих interceptor'ов и обработчиков
+func ClientIPInterceptor(resolver *clientip.Resolver) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if ip, ok := clientIP(ctx, resolver); ok {
+			ctx = context.WithValue(ctx, clientIPCtxKey{}, ip)
+		}
+		return handler(ctx, req)
+	}
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPCtxKey{}).(string)
+	return ip
+}
+
+// клиент для лимитов: токен, если он есть, иначе IP
+func ClientKey(ctx context.Context) string {
+	if token := auth.TokenFromContext(ctx); token != nil {
+		return "token:" + token.ID
+	}
+	return "ip:" + ClientIPFromContext(ctx)
+}
+
+// ограничивает частоту запросов клиента
+func RateLimitInterceptor(l *limits.Limits) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !l.Allow(ClientKey(ctx)) {
+			return nil, status.Error(codes.ResourceExhausted, "too many requests")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// ключ метаданных с HMAC-SHA256 запроса, аналог заголовка HashSHA256 в HTTP
+const HashMetadataKey = "hashsha256"
+
+// проверяет подпись запроса. подписывается детерминированная protobuf сериализация
+// сообщения, поэтому подпись не зависит от сжатия и шифрования на транспорте.
+// в отличие от HTTP, запрос без подписи или с неверной подписью отклоняется.
+func HashInterceptor(hashKey string) grpc.UnaryServerInterceptor {
+	return HashInterceptorWithKeyring(keyring.Static(hashKey), 0)
+}
+
+// то же, но подпись принимается от любого из действующих ключей, а у агента
+// с собственными ключами — только от них, как в HTTP. метка времени и nonce
+// передаются в метаданных с именами заголовков HTTP; при replayWindow > 0 они
+// обязательны, и каждый nonce принимается один раз.
+func HashInterceptorWithKeyring(keys *keyring.Keyring, replayWindow time.Duration) grpc.UnaryServerInterceptor {
+	var replay *keyring.ReplayGuard
+	if replayWindow > 0 {
+		replay = keyring.NewReplayGuard(replayWindow)
+	}
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !keys.HasHashKeys() {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		hashes := md.Get(HashMetadataKey)
+		if len(hashes) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "missing hash")
+		}
+
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "unexpected request type")
+		}
+		data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+		if err != nil {
+			return nil, status.Error(codes.Internal, "cannot compute hash")
+		}
+
+		timestamp := firstValue(md, keyring.HeaderTimestamp)
+		nonce := firstValue(md, keyring.HeaderNonce)
+		if replay != nil {
+			if err := replay.CheckFreshness(timestamp, nonce); err != nil {
+				return nil, status.Error(codes.Unauthenticated, err.Error())
+			}
+		}
+		if timestamp != "" || nonce != "" {
+			data = keyring.SignedPayload(info.FullMethod, timestamp, nonce, data)
+		}
+
+		if !keys.VerifyAgent(agentID(ctx, md), data, hashes[0]) {
+			return nil, status.Error(codes.Unauthenticated, "invalid hash")
+		}
+		// nonce запоминается только после проверки подписи
+		if replay != nil && !replay.Remember(nonce) {
+			return nil, status.Error(codes.Unauthenticated, "replayed request")
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// агент из клиентского сертификата, при его отсутствии — из метаданных x-agent-id.
+// метаданным можно доверять: подпись ключом агента подтверждает, что он его.
+func agentID(ctx context.Context, md metadata.MD) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			if id := middleware.AgentIDFromCertificate(info.State.PeerCertificates[0]); id != "" {
+				return id
+			}
+		}
+	}
+	return firstValue(md, keyring.HeaderAgentID)
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// HMAC-SHA256 детерминированной сериализации сообщения в hex.
+func ComputeHash(hashKey string, msg proto.Message) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return keyring.ComputeHMAC(hashKey, data), nil
+}
+
+// то же вместе с полным именем метода, меткой времени и nonce из метаданных
+func ComputeSignedHash(hashKey, fullMethod, timestamp, nonce string, msg proto.Message) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	return keyring.ComputeHMAC(hashKey, keyring.SignedPayload(fullMethod, timestamp, nonce, data)), nil
+}
+
+// ключ метаданных с bearer токеном, как заголовок Authorization в HTTP
+const AuthMetadataKey = "authorization"
+
+// проверяет API токен: запись метрик требует права write, а при заданном
+// у токена префиксе все метрики запроса должны с него начинаться.
+// с nil аутентификатором запросы пропускаются.
+func AuthInterceptor(a *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if a == nil {
+			return handler(ctx, req)
+		}
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(AuthMetadataKey)
+		if len(values) == 0 {
+			return nil, status.Error(codes.Unauthenticated, "missing token")
+		}
+		raw, ok := auth.BearerToken(values[0])
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "invalid authorization metadata")
+		}
+		token, err := a.Authenticate(ctx, raw)
+		if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrRevoked) {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if err != nil {
+			return nil, status.Error(codes.Internal, "token lookup failed")
+		}
+
+		if !token.HasScope(auth.ScopeWrite) {
+			return nil, status.Error(codes.PermissionDenied, "insufficient scope")
+		}
+		if update, ok := req.(*pb.UpdateMetricsRequest); ok && token.MetricPrefix != "" {
+			for _, m := range update.GetMetrics() {
+				if !token.AllowsMetric(m.GetId()) {
+					return nil, status.Errorf(codes.PermissionDenied, "metric not allowed for token: %s", m.GetId())
+				}
+			}
+		}
+
+		return handler(auth.WithToken(ctx, token), req)
+	}
+}
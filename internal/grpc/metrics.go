@@ -2,10 +2,15 @@ package grpcserver
 
 import (
 	"context"
+	"errors"
 	"log"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
 	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type MetricsUpdater interface {
@@ -14,20 +19,39 @@ type MetricsUpdater interface {
 
 type MetricsGRPCHandler struct {
 	pb.UnimplementedMetricsServer
-	svc MetricsUpdater
+	svc    MetricsUpdater
+	limits *limits.Limits
+	series *series.Policy
 }
 
 func NewMetricsHandler(svc MetricsUpdater) *MetricsGRPCHandler {
 	return &MetricsGRPCHandler{svc: svc}
 }
 
+// ограничения на длину батча и имени метрики, как у HTTP
+func (h *MetricsGRPCHandler) SetLimits(l *limits.Limits) {
+	h.limits = l
+}
+
+// проверка имён и лимиты числа рядов, как у HTTP
+func (h *MetricsGRPCHandler) SetSeriesPolicy(p *series.Policy) {
+	h.series = p
+}
+
 func (h *MetricsGRPCHandler) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
 
 	log.Printf("gRPC UpdateMetrics called, metrics=%d", len(req.Metrics))
 
 	metrics := make([]model.Metrics, 0, len(req.Metrics))
+	// запрос не пройдёт и при повторе: InvalidArgument агент не повторяет
+	if err := h.limits.CheckBatch(len(req.Metrics)); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 
 	for _, m := range req.Metrics {
+		if err := h.limits.CheckName(m.Id); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
 		metric := model.Metrics{
 			ID:    m.Id,
 			MType: mapProtoType(m.Type),
@@ -43,8 +67,18 @@ func (h *MetricsGRPCHandler) UpdateMetrics(ctx context.Context, req *pb.UpdateMe
 		metrics = append(metrics, metric)
 	}
 
-	err := h.svc.UpdateMetricsBatch(ctx, metrics)
+	release, err := h.series.Reserve(ClientKey(ctx), metrics)
 	if err != nil {
+		// отказ постоянный, как 422 в HTTP: ResourceExhausted агент повторяет
+		// и считает недоступностью сервера, он остаётся только для ограничения частоты
+		if errors.Is(err, series.ErrLimitExceeded) {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if err := h.svc.UpdateMetricsBatch(ctx, metrics); err != nil {
+		release()
 		return nil, err
 	}
 
@@ -1,11 +1,21 @@
 package grpcserver
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"log"
 	"net"
+	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
 	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // регистрирует gzip, агент сжимает запросы
 )
 
 type Server struct {
@@ -13,13 +23,125 @@ type Server struct {
 	addr       string
 }
 
-func New(addr string, metricsService pb.MetricsServer, trustedSubnet *net.IPNet) *Server {
+type serverOptions struct {
+	tls        *tls.Config
+	hashKey    string
+	privateKey *rsa.PrivateKey
+	keys       *keyring.Keyring
+	auth       *auth.Authenticator
+	proxies    *clientip.Resolver
+	policy     *netpolicy.Policy
+	limits     *limits.Limits
+	replay     time.Duration
+}
+
+// дополнительная настройка gRPC сервера
+type Option func(*serverOptions)
+
+// включает TLS; для mTLS в конфиге должны быть заданы ClientCAs.
+func WithTLS(cfg *tls.Config) Option {
+	return func(o *serverOptions) {
+		o.tls = cfg
+	}
+}
+
+// включает проверку HMAC подписи запросов.
+func WithHashKey(key string) Option {
+	return func(o *serverOptions) {
+		o.hashKey = key
+	}
+}
+
+// включает расшифровку запросов, зашифрованных публичным ключом агента.
+func WithPrivateKey(key *rsa.PrivateKey) Option {
+	return func(o *serverOptions) {
+		o.privateKey = key
+	}
+}
 
-	s := grpc.NewServer(
-		grpc.UnaryInterceptor(
-			SubnetInterceptor(trustedSubnet),
+// ключи сервера с ротацией: заменяет WithHashKey и WithPrivateKey,
+// перечитанные ключи применяются без перезапуска.
+func WithKeyring(keys *keyring.Keyring) Option {
+	return func(o *serverOptions) {
+		o.keys = keys
+	}
+}
+
+// включает доступ по API токенам
+func WithAuthenticator(a *auth.Authenticator) Option {
+	return func(o *serverOptions) {
+		o.auth = a
+	}
+}
+
+// доверенные прокси, от которых принимаются метаданные x-forwarded-for и x-real-ip
+func WithTrustedProxies(resolver *clientip.Resolver) Option {
+	return func(o *serverOptions) {
+		o.proxies = resolver
+	}
+}
+
+// политика подсетей, общая с HTTP; заменяет проверку trustedSubnet
+func WithSubnetPolicy(policy *netpolicy.Policy) Option {
+	return func(o *serverOptions) {
+		o.policy = policy
+	}
+}
+
+// ограничения частоты запросов и размера сообщения
+func WithLimits(l *limits.Limits) Option {
+	return func(o *serverOptions) {
+		o.limits = l
+	}
+}
+
+// окно защиты от повтора подписанных запросов, как HASH_REPLAY_WINDOW в HTTP
+func WithReplayWindow(window time.Duration) Option {
+	return func(o *serverOptions) {
+		o.replay = window
+	}
+}
+
+func New(addr string, metricsService pb.MetricsServer, trustedSubnet *net.IPNet, opts ...Option) *Server {
+	var o serverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	keys := o.keys
+	if keys == nil {
+		keys = keyring.Static(o.hashKey)
+	}
+
+	subnetInterceptor := SubnetInterceptorWithResolver(trustedSubnet, o.proxies)
+	if o.policy != nil {
+		subnetInterceptor = PolicyInterceptor(o.policy, o.proxies)
+	}
+
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			ClientIPInterceptor(o.proxies),
+			subnetInterceptor,
+			AuthInterceptor(o.auth),
+			RateLimitInterceptor(o.limits),
+			HashInterceptorWithKeyring(keys, o.replay),
 		),
-	)
+	}
+	if maxBytes := o.limits.MaxBodyBytes(); maxBytes > 0 {
+		// gRPC проверяет размер сообщения после распаковки
+		serverOpts = append(serverOpts, grpc.MaxRecvMsgSize(int(maxBytes)))
+	}
+	if o.tls != nil {
+		serverOpts = append(serverOpts, grpc.Creds(credentials.NewTLS(o.tls)))
+	}
+	switch {
+	case o.keys != nil:
+		serverOpts = append(serverOpts, grpc.ForceServerCodec(NewDecryptingCodecWithLookup(o.keys.Lookup)))
+	case o.privateKey != nil:
+		serverOpts = append(serverOpts, grpc.ForceServerCodec(NewDecryptingCodec(o.privateKey)))
+	}
+
+	s := grpc.NewServer(serverOpts...)
 
 	pb.RegisterMetricsServer(s, metricsService)
 
@@ -39,6 +161,11 @@ func (s *Server) Start() error {
 	return s.grpcServer.Serve(lis)
 }
 
+// обслуживает уже открытый listener, удобно для тестов
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpcServer.Serve(lis)
+}
+
 func (s *Server) Stop() {
 	s.grpcServer.GracefulStop()
 }
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	g "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthInterceptor(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := auth.NewAuthenticator(store)
+	ctx := context.Background()
+
+	writer, _, err := a.Issue(ctx, "writer", []auth.Scope{auth.ScopeWrite}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, _, err := a.Issue(ctx, "reader", []auth.Scope{auth.ScopeRead}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sampleMetrics содержит cpu и requests
+	limited, _, err := a.Issue(ctx, "limited", []auth.Scope{auth.ScopeWrite}, "cpu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &mockService{}
+	addr := startServer(t, svc, g.WithAuthenticator(a))
+
+	tests := []struct {
+		name  string
+		token string
+		code  codes.Code
+	}{
+		{name: "write token", token: writer, code: codes.OK},
+		{name: "missing token", code: codes.Unauthenticated},
+		{name: "unknown token", token: "mt_unknown", code: codes.Unauthenticated},
+		{name: "read only token", token: reader, code: codes.PermissionDenied},
+		{name: "metric outside prefix", token: limited, code: codes.PermissionDenied},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts []agent.GRPCOption
+			if tt.token != "" {
+				opts = append(opts, agent.WithGRPCToken(tt.token))
+			}
+			sender, err := agent.NewGRPCSender(addr, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sender.Close()
+
+			err = sender.SendMetrics(ctx, sampleMetrics())
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected %v, got %v", tt.code, err)
+			}
+		})
+	}
+}
@@ -2,11 +2,16 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	g "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/grpc"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
 	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type mockService struct {
@@ -61,3 +66,83 @@ func TestUpdateMetrics(t *testing.T) {
 		t.Errorf("wrong type for counter")
 	}
 }
+
+func TestUpdateMetrics_Limits(t *testing.T) {
+	mockSvc := &mockService{}
+	handler := g.NewMetricsHandler(mockSvc)
+	handler.SetLimits(limits.New(limits.Config{MaxBatchSize: 1, MaxNameLength: 4}))
+
+	_, err := handler.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "a", Type: pb.Metric_GAUGE}, {Id: "b", Type: pb.Metric_GAUGE}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for batch, got %v", err)
+	}
+
+	_, err = handler.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "toolong", Type: pb.Metric_GAUGE}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument for name, got %v", err)
+	}
+
+	if mockSvc.called {
+		t.Fatal("service must not be called")
+	}
+}
+
+func TestUpdateMetrics_SeriesPolicy(t *testing.T) {
+	mockSvc := &mockService{}
+	handler := g.NewMetricsHandler(mockSvc)
+	policy, err := series.New(series.Config{ReservedPrefixes: []string{"server_self_"}, MaxSeries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetSeriesPolicy(policy)
+
+	update := func(id string) error {
+		_, err := handler.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+			Metrics: []*pb.Metric{{Id: id, Type: pb.Metric_GAUGE, Value: 1}},
+		})
+		return err
+	}
+
+	if err := update("cpu"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := update("mem"); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected FailedPrecondition, got %v", err)
+	}
+	if err := update("server_self_x"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+	if err := update("cpu"); err != nil {
+		t.Fatalf("existing series must update: %v", err)
+	}
+}
+
+func TestUpdateMetrics_SeriesReleasedOnStoreError(t *testing.T) {
+	mockSvc := &mockService{err: errors.New("store down")}
+	handler := g.NewMetricsHandler(mockSvc)
+	policy, err := series.New(series.Config{MaxSeries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetSeriesPolicy(policy)
+
+	update := func(id string) error {
+		_, err := handler.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+			Metrics: []*pb.Metric{{Id: id, Type: pb.Metric_GAUGE, Value: 1}},
+		})
+		return err
+	}
+
+	if err := update("cpu"); err == nil {
+		t.Fatal("expected store error")
+	}
+	// неудачная запись не заняла единственный ряд
+	mockSvc.err = nil
+	if err := update("mem"); err != nil {
+		t.Fatalf("series must be released after failed write: %v", err)
+	}
+}
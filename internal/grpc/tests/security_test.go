@@ -0,0 +1,351 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/agent"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	g "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/grpc"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/tlsconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// файлы тестового PKI: CA, сертификат сервера на 127.0.0.1 и клиентский сертификат
+type testPKI struct {
+	caFile, serverCert, serverKey, clientCert, clientKey string
+}
+
+func writePEM(t *testing.T, path, typ string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func issueCert(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(dir, name+".key"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return cert, key
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	notAfter := time.Now().Add(time.Hour)
+
+	ca, caKey := issueCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+
+	issueCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	issueCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "agent-1"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, ca, caKey)
+
+	return testPKI{
+		caFile:     filepath.Join(dir, "ca.crt"),
+		serverCert: filepath.Join(dir, "server.crt"),
+		serverKey:  filepath.Join(dir, "server.key"),
+		clientCert: filepath.Join(dir, "client.crt"),
+		clientKey:  filepath.Join(dir, "client.key"),
+	}
+}
+
+func startServer(t *testing.T, svc *mockService, opts ...g.Option) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := g.New(lis.Addr().String(), g.NewMetricsHandler(svc), nil, opts...)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func sampleMetrics() []model.Metrics {
+	v := 0.5
+	d := int64(3)
+	return []model.Metrics{
+		{ID: "cpu", MType: model.Gauge, Value: &v},
+		{ID: "requests", MType: model.Counter, Delta: &d},
+	}
+}
+
+func TestGRPCSecurity_TLSHashEncryption(t *testing.T) {
+	pki := newTestPKI(t)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverTLS, err := tlsconfig.Server(pki.serverCert, pki.serverKey, pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &mockService{}
+	addr := startServer(t, svc,
+		g.WithTLS(serverTLS),
+		g.WithHashKey("secret"),
+		g.WithPrivateKey(rsaKey),
+	)
+
+	clientTLS, err := tlsconfig.Client(pki.caFile, pki.clientCert, pki.clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := agent.NewGRPCSender(addr,
+		agent.WithGRPCTLS(clientTLS),
+		agent.WithGRPCHashKey("secret"),
+		agent.WithGRPCPublicKey(&rsaKey.PublicKey),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	if err := sender.SendMetrics(context.Background(), sampleMetrics()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(svc.metrics) != 2 || svc.metrics[0].ID != "cpu" || *svc.metrics[1].Delta != 3 {
+		t.Fatalf("unexpected metrics received: %+v", svc.metrics)
+	}
+}
+
+func TestGRPCSecurity_HashRejected(t *testing.T) {
+	addr := startServer(t, &mockService{}, g.WithHashKey("secret"))
+
+	tests := []struct {
+		name string
+		opts []agent.GRPCOption
+	}{
+		{name: "wrong key", opts: []agent.GRPCOption{agent.WithGRPCHashKey("other")}},
+		{name: "missing hash"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sender, err := agent.NewGRPCSender(addr, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sender.Close()
+
+			err = sender.SendMetrics(context.Background(), sampleMetrics())
+			if status.Code(err) != codes.Unauthenticated {
+				t.Fatalf("expected Unauthenticated, got %v", err)
+			}
+			if agent.IsRetriableError(err) {
+				t.Errorf("auth failure must not be retried")
+			}
+		})
+	}
+}
+
+func TestGRPCSecurity_AgentKeys(t *testing.T) {
+	// только ключи агентов, общего ключа нет
+	agentKeys := filepath.Join(t.TempDir(), "agents.txt")
+	if err := os.WriteFile(agentKeys, []byte("agent-1 agent-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := keyring.New(keyring.Config{AgentKeysFile: agentKeys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &mockService{}
+	addr := startServer(t, svc, g.WithKeyring(keys))
+
+	send := func(opts ...agent.GRPCOption) error {
+		sender, err := agent.NewGRPCSender(addr, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sender.Close()
+		return sender.SendMetrics(context.Background(), sampleMetrics())
+	}
+
+	if err := send(agent.WithGRPCHashKey("agent-secret"), agent.WithGRPCAgentID("agent-1")); err != nil {
+		t.Fatalf("agent key must be accepted: %v", err)
+	}
+	if len(svc.metrics) != 2 {
+		t.Fatalf("unexpected metrics received: %+v", svc.metrics)
+	}
+	if err := send(agent.WithGRPCHashKey("agent-secret")); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated without agent id, got %v", err)
+	}
+	if err := send(agent.WithGRPCHashKey("agent-secret"), agent.WithGRPCAgentID("agent-2")); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated for other agent, got %v", err)
+	}
+}
+
+func TestGRPCSecurity_Replay(t *testing.T) {
+	addr := startServer(t, &mockService{}, g.WithHashKey("secret"), g.WithReplayWindow(time.Minute))
+
+	sender, err := agent.NewGRPCSender(addr, agent.WithGRPCHashKey("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	for i := 0; i < 2; i++ {
+		if err := sender.SendMetrics(context.Background(), sampleMetrics()); err != nil {
+			t.Fatalf("send %d: unexpected error: %v", i, err)
+		}
+	}
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewMetricsClient(conn)
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "cpu", Type: pb.Metric_GAUGE, Value: 1}}}
+	call := func(timestamp, nonce string) error {
+		hash, err := g.ComputeSignedHash("secret", pb.Metrics_UpdateMetrics_FullMethodName, timestamp, nonce, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		md := metadata.Pairs(g.HashMetadataKey, hash)
+		if timestamp != "" {
+			md.Set(keyring.HeaderTimestamp, timestamp)
+			md.Set(keyring.HeaderNonce, nonce)
+		}
+		_, err = client.UpdateMetrics(metadata.NewOutgoingContext(context.Background(), md), req)
+		return err
+	}
+
+	timestamp, nonce, err := keyring.NewSignatureParams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := call(timestamp, nonce); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := call(timestamp, nonce); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected replay to be rejected, got %v", err)
+	}
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	if err := call(stale, "fresh-nonce"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected stale timestamp to be rejected, got %v", err)
+	}
+	if err := call("", ""); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected unsigned timestamp to be rejected, got %v", err)
+	}
+}
+
+func TestGRPCSecurity_MTLSRequiresClientCert(t *testing.T) {
+	pki := newTestPKI(t)
+	serverTLS, err := tlsconfig.Server(pki.serverCert, pki.serverKey, pki.caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, &mockService{}, g.WithTLS(serverTLS))
+
+	clientTLS, err := tlsconfig.Client(pki.caFile, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender, err := agent.NewGRPCSender(addr, agent.WithGRPCTLS(clientTLS))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	if err := sender.SendMetrics(context.Background(), sampleMetrics()); err == nil {
+		t.Fatal("expected handshake failure without client certificate")
+	}
+}
+
+func TestDecryptingCodec_AcceptsPlainMessages(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := g.NewDecryptingCodec(rsaKey)
+
+	data, err := proto.Marshal(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "cpu"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req pb.UpdateMetricsRequest
+	if err := codec.Unmarshal(data, &req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(req.Metrics) != 1 || req.Metrics[0].Id != "cpu" {
+		t.Fatalf("unexpected request: %v", req.Metrics)
+	}
+
+	if err := codec.Unmarshal(append(append([]byte{}, g.EncryptedMagic...), "garbage"...), &req); err == nil {
+		t.Fatal("expected error for corrupted encrypted payload")
+	}
+}
+
+func TestTLSConfig_Validation(t *testing.T) {
+	if _, err := tlsconfig.Server("", "", ""); err == nil {
+		t.Error("expected error without server certificate")
+	}
+	if _, err := tlsconfig.Client("", "cert.pem", ""); err == nil {
+		t.Error("expected error when client key is missing")
+	}
+	if _, err := tlsconfig.Client(filepath.Join(t.TempDir(), "missing.pem"), "", ""); err == nil {
+		t.Error("expected error for missing CA file")
+	}
+}
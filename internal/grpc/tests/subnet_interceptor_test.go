@@ -5,13 +5,22 @@ import (
 	"net"
 	"testing"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
 	g "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/grpc"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
+	pb "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/proto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
+func peerContext(addr string) context.Context {
+	tcpAddr, _ := net.ResolveTCPAddr("tcp", addr)
+	return peer.NewContext(context.Background(), &peer.Peer{Addr: tcpAddr})
+}
+
 func TestSubnetInterceptor(t *testing.T) {
 	_, subnet, _ := net.ParseCIDR("127.0.0.0/8")
 
@@ -26,10 +35,7 @@ func TestSubnetInterceptor(t *testing.T) {
 	t.Run("allowed ip", func(t *testing.T) {
 		handlerCalled = false
 
-		md := metadata.New(map[string]string{
-			"x-real-ip": "127.0.0.1",
-		})
-		ctx := metadata.NewIncomingContext(context.Background(), md)
+		ctx := peerContext("127.0.0.1:5000")
 
 		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
 		if err != nil {
@@ -41,10 +47,19 @@ func TestSubnetInterceptor(t *testing.T) {
 	})
 
 	t.Run("ip not allowed", func(t *testing.T) {
+		ctx := peerContext("10.0.0.1:5000")
+
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		if status.Code(err) != codes.PermissionDenied {
+			t.Fatalf("expected PermissionDenied, got %v", err)
+		}
+	})
+
+	t.Run("metadata from untrusted peer ignored", func(t *testing.T) {
 		md := metadata.New(map[string]string{
-			"x-real-ip": "10.0.0.1",
+			"x-real-ip": "127.0.0.1",
 		})
-		ctx := metadata.NewIncomingContext(context.Background(), md)
+		ctx := metadata.NewIncomingContext(peerContext("10.0.0.1:5000"), md)
 
 		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
 		if status.Code(err) != codes.PermissionDenied {
@@ -52,18 +67,71 @@ func TestSubnetInterceptor(t *testing.T) {
 		}
 	})
 
-	t.Run("no metadata", func(t *testing.T) {
-		_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	t.Run("no peer", func(t *testing.T) {
+		md := metadata.New(map[string]string{
+			"x-real-ip": "127.0.0.1",
+		})
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
 		if status.Code(err) != codes.PermissionDenied {
 			t.Fatalf("expected PermissionDenied, got %v", err)
 		}
 	})
+}
+
+func TestSubnetInterceptor_TrustedProxy(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("127.0.0.0/8")
+	proxies, err := clientip.NewResolver([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := g.SubnetInterceptorWithResolver(subnet, proxies)
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	t.Run("x-real-ip from proxy", func(t *testing.T) {
+		md := metadata.New(map[string]string{"x-real-ip": "127.0.0.1"})
+		ctx := metadata.NewIncomingContext(peerContext("10.0.0.1:5000"), md)
+
+		if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("x-forwarded-for rightmost untrusted hop", func(t *testing.T) {
+		md := metadata.New(map[string]string{"x-forwarded-for": "127.0.0.1, 192.168.0.5, 10.0.0.2"})
+		ctx := metadata.NewIncomingContext(peerContext("10.0.0.1:5000"), md)
 
-	t.Run("no ip", func(t *testing.T) {
-		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(nil))
 		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
 		if status.Code(err) != codes.PermissionDenied {
 			t.Fatalf("expected PermissionDenied, got %v", err)
 		}
 	})
 }
+
+func TestPolicyInterceptor(t *testing.T) {
+	policy, err := netpolicy.New(netpolicy.Config{Allow: []string{"127.0.0.0/8", "::1"}, Deny: []string{"127.0.0.66"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := g.PolicyInterceptor(policy, nil)
+	info := &grpc.UnaryServerInfo{FullMethod: pb.Metrics_UpdateMetrics_FullMethodName}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return "ok", nil
+	}
+
+	for addr, want := range map[string]codes.Code{
+		"127.0.0.1:5000":  codes.OK,
+		"[::1]:5000":      codes.OK,
+		"127.0.0.66:5000": codes.PermissionDenied,
+		"10.0.0.1:5000":   codes.PermissionDenied,
+	} {
+		_, err := interceptor(peerContext(addr), nil, info, handler)
+		if status.Code(err) != want {
+			t.Fatalf("%s: expected %v, got %v", addr, want, err)
+		}
+	}
+}
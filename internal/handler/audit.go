@@ -0,0 +1,139 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+)
+
+// доступ к журналу аудита: поиск событий и проверка целостности файла
+type AuditHandler struct {
+	store      audit.Store
+	file       string
+	signingKey string
+}
+
+// store — хранилище для поиска, file и signingKey — журнал для проверки;
+// пустые значения отключают соответствующий маршрут.
+func NewAuditHandler(store audit.Store, file, signingKey string) *AuditHandler {
+	return &AuditHandler{store: store, file: file, signingKey: signingKey}
+}
+
+// QueryAudit godoc
+// @Tags Admin
+// @Summary Поиск событий аудита
+// @Description События от старых к новым. Время в RFC3339 или unix секундах, to не включается
+// @Produce json
+// @Param metric query string false "Имя метрики"
+// @Param ip query string false "IP адрес клиента"
+// @Param action query string false "Действие: update, batch_update, read, otlp, remote_write, influx"
+// @Param from query string false "Начало периода"
+// @Param to query string false "Конец периода"
+// @Param limit query int false "Размер страницы, до 1000"
+// @Param offset query int false "Смещение, значение next из предыдущего ответа"
+// @Success 200 {object} audit.Page
+// @Failure 400 {string} string "Неверные параметры"
+// @Failure 500 {string} string "Ошибка хранилища"
+// @Router /audit [get]
+func (h *AuditHandler) QueryAudit(w http.ResponseWriter, r *http.Request) {
+	q, err := parseAuditQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.store.Query(r.Context(), q)
+	if err != nil {
+		http.Error(w, "failed to query audit log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseAuditQuery(values url.Values) (audit.Query, error) {
+	q := audit.Query{
+		Metric: values.Get("metric"),
+		IP:     values.Get("ip"),
+		Action: audit.Action(values.Get("action")),
+	}
+	switch q.Action {
+	case "", audit.ActionUpdate, audit.ActionBatchUpdate, audit.ActionRead, audit.ActionOTLP, audit.ActionRemoteWrite, audit.ActionInflux:
+	default:
+		return q, fmt.Errorf("invalid action: %s", q.Action)
+	}
+
+	var err error
+	if q.From, err = parseAuditTime(values.Get("from")); err != nil {
+		return q, fmt.Errorf("invalid from: %w", err)
+	}
+	if q.To, err = parseAuditTime(values.Get("to")); err != nil {
+		return q, fmt.Errorf("invalid to: %w", err)
+	}
+	if q.Limit, err = parseAuditInt(values.Get("limit")); err != nil || q.Limit > audit.MaxQueryLimit {
+		return q, fmt.Errorf("invalid limit, expected 1..%d", audit.MaxQueryLimit)
+	}
+	if q.Offset, err = parseAuditInt(values.Get("offset")); err != nil {
+		return q, errors.New("invalid offset")
+	}
+	return q, nil
+}
+
+// время в RFC3339 или unix секундах, пустое — без ограничения
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func parseAuditInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, errors.New("expected non-negative integer")
+	}
+	return n, nil
+}
+
+// VerifyAudit godoc
+// @Tags Admin
+// @Summary Проверка целостности журнала аудита
+// @Description Проверяет цепочку хэшей и подписи контрольных точек во всех сегментах журнала и ссылки между ними, сообщает первую сломанную запись
+// @Produce json
+// @Success 200 {object} audit.VerifyReport "Цепочка цела"
+// @Failure 409 {object} audit.VerifyReport "Цепочка нарушена"
+// @Failure 404 {string} string "Журнал не найден"
+// @Failure 500 {string} string "Ошибка чтения журнала"
+// @Router /audit/verify [get]
+func (h *AuditHandler) VerifyAudit(w http.ResponseWriter, r *http.Request) {
+	report, err := audit.VerifyJournal(h.file, h.signingKey)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "audit log not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusConflict
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
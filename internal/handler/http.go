@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -13,8 +14,14 @@ import (
 	"strings"
 	"text/template"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/config/db"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/otlp"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/remotewrite"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
 	"github.com/go-chi/chi/v5"
 )
@@ -22,10 +29,38 @@ import (
 // Handler обрабатывает HTTP запросы для работы с метриками.
 // Содержит бизнес-логику сервиса через MetricsService.
 type Handler struct {
-	svc *service.MetricsService
+	svc    *service.MetricsService
+	limits *limits.Limits
+	series *series.Policy
+	otlp   *otlp.Converter // состояние накопительных рядов OTLP
+
+	remoteWrite *remotewrite.Converter // то же для Prometheus remote_write
+}
+
+// дополнительная настройка обработчиков
+type HandlerOption func(*Handler)
+
+// ограничения на длину батча и имени метрики
+func WithLimits(l *limits.Limits) HandlerOption {
+	return func(h *Handler) {
+		h.limits = l
+	}
 }
 
-func NewHandler(svc *service.MetricsService) *Handler { return &Handler{svc: svc} }
+// проверка имён и лимиты числа рядов
+func WithSeriesPolicy(p *series.Policy) HandlerOption {
+	return func(h *Handler) {
+		h.series = p
+	}
+}
+
+func NewHandler(svc *service.MetricsService, opts ...HandlerOption) *Handler {
+	h := &Handler{svc: svc, otlp: otlp.NewConverter(), remoteWrite: remotewrite.NewConverter()}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
 
 // UpdateMetric godoc
 // @Tags Info
@@ -47,6 +82,8 @@ func NewHandler(svc *service.MetricsService) *Handler { return &Handler{svc: svc
 // @Failure 400 {string} string "Неверный запрос"
 // @Failure 400 {string} string "Неверный запрос: bad gauge value, bad counter value, unknown metric type, metric ID is required, gauge value is required, counter delta is required"
 // @Failure 404 {string} string "Not Found"
+// @Failure 413 {string} string "Слишком длинное имя метрики"
+// @Failure 422 {string} string "Превышен лимит числа рядов"
 // @Failure 500 {string} string "Внутренняя ошибка сервера: store error"
 // @Router /update [post]
 // @Router /update/ [post]
@@ -56,8 +93,12 @@ func (h *Handler) UpdateMetric(w http.ResponseWriter, r *http.Request) {
 	if r.Body != nil && r.ContentLength > 0 {
 		var metric model.Metrics
 		if err := json.NewDecoder(r.Body).Decode(&metric); err == nil {
+			if err := h.limits.CheckName(metric.ID); err != nil {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
 			if err := h.processMetric(r.Context(), metric); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+				http.Error(w, err.Error(), seriesStatus(err))
 				return
 			}
 			w.Header().Set("Content-Type", "application/json")
@@ -85,6 +126,10 @@ func (h *Handler) processURLParams(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if err := h.limits.CheckName(id); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 
 	switch mType {
 	case service.Gauge:
@@ -93,7 +138,13 @@ func (h *Handler) processURLParams(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bad gauge value", http.StatusBadRequest)
 			return
 		}
+		release, err := h.admit(r.Context(), model.Metrics{ID: id, MType: mType})
+		if err != nil {
+			http.Error(w, err.Error(), seriesStatus(err))
+			return
+		}
 		if err := h.svc.UpdateGauge(r.Context(), id, f); err != nil {
+			release()
 			http.Error(w, "store error", http.StatusInternalServerError)
 			return
 		}
@@ -104,7 +155,13 @@ func (h *Handler) processURLParams(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "bad counter value", http.StatusBadRequest)
 			return
 		}
+		release, err := h.admit(r.Context(), model.Metrics{ID: id, MType: mType})
+		if err != nil {
+			http.Error(w, err.Error(), seriesStatus(err))
+			return
+		}
 		if err := h.svc.UpdateCounter(r.Context(), id, d); err != nil {
+			release()
 			http.Error(w, "store error", http.StatusInternalServerError)
 			return
 		}
@@ -128,17 +185,41 @@ func (h *Handler) processMetric(ctx context.Context, metric model.Metrics) error
 		if metric.Value == nil {
 			return fmt.Errorf("gauge value is required")
 		}
-		return h.svc.UpdateGauge(ctx, metric.ID, *metric.Value)
-
 	case service.Counter:
 		if metric.Delta == nil {
 			return fmt.Errorf("counter delta is required")
 		}
-		return h.svc.UpdateCounter(ctx, metric.ID, *metric.Delta)
-
 	default:
 		return fmt.Errorf("unknown metric type: %s", metric.MType)
 	}
+
+	release, err := h.admit(ctx, metric)
+	if err != nil {
+		return err
+	}
+	if metric.MType == service.Gauge {
+		err = h.svc.UpdateGauge(ctx, metric.ID, *metric.Value)
+	} else {
+		err = h.svc.UpdateCounter(ctx, metric.ID, *metric.Delta)
+	}
+	if err != nil {
+		release()
+	}
+	return err
+}
+
+// проверяет имена и лимит рядов для клиента запроса
+// занимает новые ряды запроса; release вызывается, если запись в хранилище не удалась
+func (h *Handler) admit(ctx context.Context, metrics ...model.Metrics) (release func(), err error) {
+	return h.series.Reserve(middleware.ClientKey(ctx), metrics)
+}
+
+// код ответа на ошибку проверки рядов: лимит — 422, остальное — 400
+func seriesStatus(err error) int {
+	if errors.Is(err, series.ErrLimitExceeded) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadRequest
 }
 
 // GetValue godoc
@@ -185,12 +266,22 @@ func (h *Handler) GetValue(w http.ResponseWriter, r *http.Request) {
 // @Tags Info
 // @Summary Получение всех метрик в HTML формате
 // @Description Возвращает HTML страницу со списком всех метрик (gauge и counter) из базы данных.
+// @Description Токен с префиксом видит только метрики с этим префиксом.
 // @Produce html
 // @Success 200 {string} string "HTML страница со списком метрик"
 // @Failure 500 {string} string "Ошибка сервера"
 // @Router / [get]
 func (h *Handler) GetAll(w http.ResponseWriter, r *http.Request) {
 	all := h.svc.AllText(r.Context())
+	// в пути и теле нет имён, поэтому префикс токена применяется к списку
+	if token := auth.TokenFromContext(r.Context()); token != nil && token.MetricPrefix != "" {
+		for key := range all {
+			// ключ вида "тип.имя"
+			if _, name, _ := strings.Cut(key, "."); !token.AllowsMetric(name) {
+				delete(all, key)
+			}
+		}
+	}
 
 	const tpl = `<!doctype html>
 	<html><head><meta charset="utf-8"><title>metrics</title></head>
@@ -309,6 +400,8 @@ func (h *Handler) PingDB(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} map[string]string "Пример: {\"status\":\"OK\"}"
 // @Failure 400 {object} map[string]string "Неверный JSON формат или пустой массив"
 // @Failure 400 {object} map[string]interface{} "Пример: {\"error\":\"validation failed\",\"details\":[\"metric[0]: ID is required\"]}"
+// @Failure 413 {object} map[string]string "Слишком много метрик или слишком длинное имя"
+// @Failure 422 {object} map[string]string "Превышен лимит числа рядов"
 // @Failure 500 {object} map[string]string "Пример: {\"error\":\"failed to update metric Alloc\"}"
 // @Router /updates [post]
 func (h *Handler) UpdateMetricsBatch(w http.ResponseWriter, r *http.Request) {
@@ -327,6 +420,12 @@ func (h *Handler) UpdateMetricsBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.limits.CheckMetrics(metrics); err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
 	var validationErrors []string
 	for i, metric := range metrics {
 		if metric.ID == "" {
@@ -357,8 +456,17 @@ func (h *Handler) UpdateMetricsBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// новые ряды батча принимаются все вместе или не принимаются
+	release, err := h.admit(r.Context(), metrics...)
+	if err != nil {
+		w.WriteHeader(seriesStatus(err))
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
 	for _, metric := range metrics {
 		if err := h.processMetric(r.Context(), metric); err != nil {
+			release()
 			log.Printf("Error updating metric %s: %v", metric.ID, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("failed to update metric %s, err: %s", metric.ID, err)})
@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/influx"
+)
+
+// сколько ошибок строк попадает в ответ
+const maxLineErrors = 100
+
+// ответ с ошибкой в формате InfluxDB: клиенты Influx читают code и message
+type influxError struct {
+	Code       string              `json:"code"`
+	Message    string              `json:"message"`
+	LineErrors []*influx.LineError `json:"line_errors,omitempty"`
+}
+
+// коды ошибок InfluxDB по статусу ответа
+var influxCodes = map[int]string{
+	http.StatusBadRequest:            "invalid",
+	http.StatusForbidden:             "forbidden",
+	http.StatusRequestEntityTooLarge: "request too large",
+	http.StatusUnprocessableEntity:   "unprocessable entity",
+	http.StatusInternalServerError:   "internal error",
+}
+
+func writeInfluxError(w http.ResponseWriter, status int, e influxError) {
+	e.Code = influxCodes[status]
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// WriteInflux godoc
+// @Tags Info
+// @Summary Приём InfluxDB line protocol
+// @Description Строки measurement[,tag=value...] field=value[,field=value...] [timestamp].
+// @Description Каждое числовое поле становится метрикой measurement_field, теги — её метками.
+// @Description Целые поля (1i, 1u) — counter, дробные — gauge, строковые и логические пропускаются.
+// @Description Корректные строки записываются, даже если в запросе есть ошибочные: они перечисляются в line_errors с ответом 400.
+// @Accept plain
+// @Produce json
+// @Param precision query string false "Единица меток времени: ns (по умолчанию), us, ms, s, m, h"
+// @Success 204 "Все строки приняты"
+// @Failure 400 {object} map[string]any "Ошибки строк или неверный запрос"
+// @Failure 403 {object} map[string]any "Метрика не разрешена токену"
+// @Failure 413 {object} map[string]any "Превышены ограничения размера"
+// @Failure 422 {object} map[string]any "Превышен лимит числа рядов"
+// @Failure 500 {object} map[string]any "Ошибка записи"
+// @Router /write [post]
+func (h *Handler) WriteInflux(w http.ResponseWriter, r *http.Request) {
+	precision, err := influx.ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		writeInfluxError(w, http.StatusBadRequest, influxError{Message: err.Error()})
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeInfluxError(w, http.StatusBadRequest, influxError{Message: "failed to read body"})
+		return
+	}
+
+	points, parseErrs := influx.Parse(body, precision, time.Now())
+	metrics, convErrs := influx.Convert(points)
+	lineErrs := append(parseErrs, convErrs...)
+	sort.Slice(lineErrs, func(i, j int) bool { return lineErrs[i].Line < lineErrs[j].Line })
+	lines, rejected := countLines(points, lineErrs)
+
+	// в аудит попадают и отклонённые запросы, со статусом отказа
+	audit.Report(r.Context(), metrics)
+	release, status, err := h.checkIngested(r.Context(), metrics)
+	if err != nil {
+		writeInfluxError(w, status, influxError{Message: err.Error()})
+		return
+	}
+	if len(metrics) > 0 {
+		if err := h.svc.UpdateMetricsBatch(r.Context(), metrics); err != nil {
+			release()
+			writeInfluxError(w, http.StatusInternalServerError, influxError{Message: "failed to store metrics"})
+			return
+		}
+	}
+
+	if len(lineErrs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	// как InfluxDB: корректные строки записаны, об остальных сообщает 400
+	msg := fmt.Sprintf("partial write: %d of %d lines rejected: %s", rejected, lines, lineErrs[0])
+	if rejected == lines {
+		msg = fmt.Sprintf("no valid lines: %s", lineErrs[0])
+	}
+	if len(lineErrs) > 1 {
+		msg += fmt.Sprintf(" (and %d more)", len(lineErrs)-1)
+	}
+	if len(lineErrs) > maxLineErrors {
+		lineErrs = lineErrs[:maxLineErrors]
+	}
+	writeInfluxError(w, http.StatusBadRequest, influxError{Message: msg, LineErrors: lineErrs})
+}
+
+// число строк запроса и отклонённых строк: на одну строку может прийтись
+// и точка, и несколько ошибок, поэтому считаются разные номера строк
+func countLines(points []influx.Point, lineErrs []*influx.LineError) (lines, rejected int) {
+	seen := make(map[int]struct{}, len(points)+len(lineErrs))
+	for _, e := range lineErrs {
+		seen[e.Line] = struct{}{}
+	}
+	rejected = len(seen)
+	for _, p := range points {
+		seen[p.Line] = struct{}{}
+	}
+	return len(seen), rejected
+}
@@ -0,0 +1,32 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// проверяет метрики, разобранные из внешнего протокола (OTLP, remote_write):
+// префикс токена, ограничения размера и лимит рядов. при ошибке возвращает код ответа,
+// иначе release, освобождающий новые ряды, если запись в хранилище не удалась.
+// префикс токена проверяется здесь: имена известны только после разбора тела
+func (h *Handler) checkIngested(ctx context.Context, metrics []model.Metrics) (release func(), status int, err error) {
+	if token := auth.TokenFromContext(ctx); token != nil {
+		for _, m := range metrics {
+			if !token.AllowsMetric(m.ID) {
+				return nil, http.StatusForbidden, fmt.Errorf("metric not allowed for token: %s", m.ID)
+			}
+		}
+	}
+	if err := h.limits.CheckMetrics(metrics); err != nil {
+		return nil, http.StatusRequestEntityTooLarge, err
+	}
+	release, err = h.admit(ctx, metrics...)
+	if err != nil {
+		return nil, seriesStatus(err), err
+	}
+	return release, 0, nil
+}
@@ -0,0 +1,89 @@
+package httpserver
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/otlp"
+)
+
+// коды google.rpc.Status в ответах OTLP
+const (
+	rpcInvalidArgument   int32 = 3
+	rpcPermissionDenied  int32 = 7
+	rpcResourceExhausted int32 = 8
+	rpcInternal          int32 = 13
+)
+
+// ExportOTLP godoc
+// @Tags Info
+// @Summary Приём метрик OpenTelemetry
+// @Description OTLP/HTTP ExportMetricsServiceRequest в protobuf или JSON. Gauge становится gauge,
+// @Description монотонная sum — counter с учётом temporality, гистограммы раскладываются на _bucket, _count и _sum.
+// @Description Атрибуты ресурса и точек становятся метками ряда. Неподдерживаемые точки возвращаются в partial_success.
+// @Accept application/x-protobuf
+// @Accept json
+// @Produce application/x-protobuf
+// @Produce json
+// @Success 200 {object} map[string]any "ExportMetricsServiceResponse"
+// @Failure 400 {object} map[string]any "Неверный запрос"
+// @Failure 403 {object} map[string]any "Метрика не разрешена токену"
+// @Failure 413 {object} map[string]any "Превышены ограничения размера"
+// @Failure 415 {string} string "Неподдерживаемый Content-Type"
+// @Failure 422 {object} map[string]any "Превышен лимит числа рядов"
+// @Failure 500 {object} map[string]any "Ошибка записи"
+// @Router /v1/metrics [post]
+func (h *Handler) ExportOTLP(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlp.ContentTypeProtobuf && contentType != otlp.ContentTypeJSON {
+		http.Error(w, "unsupported content type, expected application/x-protobuf or application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	fail := func(status int, code int32, msg string) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(status)
+		w.Write(otlp.EncodeStatus(contentType, code, msg))
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fail(http.StatusBadRequest, rpcInvalidArgument, "failed to read body")
+		return
+	}
+	req, err := otlp.Decode(contentType, body)
+	if err != nil {
+		fail(http.StatusBadRequest, rpcInvalidArgument, "invalid OTLP request: "+err.Error())
+		return
+	}
+
+	batch := h.otlp.Convert(req)
+	// в аудит попадают и отклонённые запросы, со статусом отказа
+	audit.Report(r.Context(), batch.Metrics)
+	release, status, err := h.checkIngested(r.Context(), batch.Metrics)
+	if err != nil {
+		code := rpcInvalidArgument
+		switch status {
+		case http.StatusForbidden:
+			code = rpcPermissionDenied
+		case http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+			code = rpcResourceExhausted
+		}
+		fail(status, code, err.Error())
+		return
+	}
+
+	if len(batch.Metrics) > 0 {
+		if err := h.svc.UpdateMetricsBatch(r.Context(), batch.Metrics); err != nil {
+			release()
+			fail(http.StatusInternalServerError, rpcInternal, "failed to store metrics")
+			return
+		}
+	}
+	h.otlp.Commit(batch)
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(otlp.EncodeResponse(contentType, batch.Rejected, batch.Message()))
+}
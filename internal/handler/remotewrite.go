@@ -0,0 +1,81 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/remotewrite"
+)
+
+// RemoteWrite godoc
+// @Tags Info
+// @Summary Приём Prometheus remote_write
+// @Description WriteRequest в protobuf, сжатый snappy (remote_write 1.0). Ряд становится метрикой с ID name{label="value"}:
+// @Description counter и бакеты гистограмм — counter с приростом накопительного итога, остальные — gauge последнего отсчёта.
+// @Description Тип берётся из метаданных запроса, без них counter — ряды _total и _bucket.
+// @Accept application/x-protobuf
+// @Success 204 "Отсчёты приняты"
+// @Failure 400 {string} string "Неверный запрос"
+// @Failure 403 {string} string "Метрика не разрешена токену"
+// @Failure 413 {string} string "Превышены ограничения размера"
+// @Failure 415 {string} string "Неподдерживаемый Content-Type или Content-Encoding"
+// @Failure 422 {string} string "Превышен лимит числа рядов"
+// @Failure 500 {string} string "Ошибка записи"
+// @Router /api/v1/write [post]
+func (h *Handler) RemoteWrite(w http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, _ := mime.ParseMediaType(ct); mediaType != "application/x-protobuf" {
+			http.Error(w, "unsupported content type, expected application/x-protobuf", http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	// тело всегда сжато snappy, заголовок некоторые отправители не ставят
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		http.Error(w, "unsupported content encoding, expected snappy", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	req, err := remotewrite.Decode(body, h.limits.MaxBodyBytes())
+	if errors.Is(err, remotewrite.ErrTooLarge) {
+		h.limits.ObserveBodyTooLarge()
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid remote write request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	batch := h.remoteWrite.Convert(req)
+	// в аудит попадают и отклонённые запросы, со статусом отказа
+	audit.Report(r.Context(), batch.Metrics)
+	release, status, err := h.checkIngested(r.Context(), batch.Metrics)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if len(batch.Metrics) > 0 {
+		if err := h.svc.UpdateMetricsBatch(r.Context(), batch.Metrics); err != nil {
+			release()
+			http.Error(w, "failed to store metrics", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.remoteWrite.Commit(batch)
+
+	// remote_write не сообщает о частичном приёме, а на 4xx Prometheus отбрасывает
+	// весь запрос: принятые ряды записаны, об отклонённых отсчётах остаётся запись в логе
+	if batch.Rejected > 0 {
+		log.Printf("remote write: rejected %d samples: %s", batch.Rejected, batch.Message())
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
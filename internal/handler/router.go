@@ -6,50 +6,168 @@ import (
 
 	_ "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/docs" //
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
 	"github.com/go-chi/chi/v5"
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
+type routerOptions struct {
+	hashOpts []middleware.HashOption
+	auth     *auth.Authenticator
+	proxies  *clientip.Resolver
+	limits   *limits.Limits
+	// журнал аудита: хранилище для поиска, файл и ключ для проверки
+	auditStore audit.Store
+	auditFile  string
+	auditKey   string
+}
+
+// дополнительная настройка роутера
+type RouterOption func(*routerOptions)
+
+// параметры проверки подписи запросов
+func WithHashOptions(opts ...middleware.HashOption) RouterOption {
+	return func(o *routerOptions) {
+		o.hashOpts = append(o.hashOpts, opts...)
+	}
+}
+
+// включает доступ по API токенам и маршруты /admin/tokens
+func WithAuth(a *auth.Authenticator) RouterOption {
+	return func(o *routerOptions) {
+		o.auth = a
+	}
+}
+
+// доверенные прокси, от которых принимаются X-Forwarded-For и X-Real-IP
+func WithTrustedProxies(resolver *clientip.Resolver) RouterOption {
+	return func(o *routerOptions) {
+		o.proxies = resolver
+	}
+}
+
+// ограничения частоты запросов и размера тела
+func WithRequestLimits(l *limits.Limits) RouterOption {
+	return func(o *routerOptions) {
+		o.limits = l
+	}
+}
+
+// файл журнала аудита для /audit/verify; signingKey проверяет подписи контрольных точек.
+// маршрут есть только вместе с WithAuth
+func WithAuditLog(file, signingKey string) RouterOption {
+	return func(o *routerOptions) {
+		o.auditFile = file
+		o.auditKey = signingKey
+	}
+}
+
+// хранилище событий аудита для поиска через GET /audit; маршрут есть только вместе с WithAuth
+func WithAuditStore(store audit.Store) RouterOption {
+	return func(o *routerOptions) {
+		o.auditStore = store
+	}
+}
+
+// keys содержит HMAC ключи и приватные ключи сервера, текущие и предыдущие.
+// policy задаёт разрешённые подсети по классам маршрутов, nil — без ограничений.
 func NewRouter(h *Handler,
-	HashKey string,
-	auditReceivers []middleware.AuditReceiver,
-	privateKeyPath string,
-	trustedSubnet string) http.Handler {
+	keys *keyring.Keyring,
+	auditor *audit.Dispatcher,
+	policy *netpolicy.Policy,
+	allowLegacyCrypto bool,
+	opts ...RouterOption) http.Handler {
+	var o routerOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	r := chi.NewRouter()
 	// получаем IP агента и кладём в context
-	r.Use(middleware.GetRealIPMiddleware)
-	// берём IP из context и проверяем trusted_subnet
-	r.Use(middleware.TrustedSubnetMiddleware(trustedSubnet))
-	// декомпрессия данных
-	r.Use(middleware.GzipDecompression)
-	// расшифровываем боди если был передан адрес на приватный ключ и если есть заголовок
-	if privateKeyPath != "" {
-		r.Use(middleware.DecryptMiddleware(privateKeyPath))
+	r.Use(middleware.RealIPMiddleware(o.proxies))
+	// идентификатор агента из клиентского сертификата при mTLS
+	r.Use(middleware.ClientCertMiddleware)
+	// токен из Authorization, права проверяются на маршрутах
+	authMiddleware := middleware.NewAuth(o.auth)
+	r.Use(authMiddleware.Authenticate)
+	// частота запросов по токену или IP и размер тела до расшифровки и распаковки
+	r.Use(middleware.RateLimitMiddleware(o.limits))
+	r.Use(middleware.BodyLimitMiddleware(o.limits))
+	// расшифровываем боди если загружены приватные ключи и если есть заголовок.
+	// агент сжимает данные до шифрования, поэтому расшифровка идёт раньше декомпрессии
+	if keys.HasPrivateKeys() {
+		r.Use(middleware.DecryptMiddlewareWithKeyring(keys, allowLegacyCrypto))
 	}
+	// декомпрессия данных
+	r.Use(middleware.GzipDecompressionWithLimit(o.limits))
 	// лоигрование
 	r.Use(middleware.LoggerMiddleware())
 	// компресия ответа
 	r.Use(middleware.GzipCompression)
-	//аудит
-	r.Use(middleware.AuditMiddleware(auditReceivers))
 
-	//проверка и добавление хэша
-	hashMiddleware := middleware.NewHashMiddleware(HashKey)
+	//проверка и добавление хэша, до аудита: неподписанный запрос не должен попасть в журнал
+	hashMiddleware := middleware.NewHashMiddlewareWithKeyring(keys, o.hashOpts...)
 	r.Use(hashMiddleware.CheckHash)
 	r.Use(hashMiddleware.AddHash)
 
-	r.Post("/value", h.GetValueJSON)
-	r.Post("/value/", h.GetValueJSON)
-	r.Post("/update", h.UpdateMetric)
-	r.Post("/update/", h.UpdateMetric)
-	r.Post("/update/{type}/{name}/{value}", h.UpdateMetric)
-	r.Post("/updates/", h.UpdateMetricsBatch)
-	r.Get("/value/{type}/{name}", h.GetValue)
-	r.Get("/", h.GetAll)
-	r.Get("/ping", h.PingDB)
-
-	r.Get("/swagger/*", httpSwagger.Handler(
+	//аудит
+	r.Use(middleware.AuditMiddleware(auditor))
+
+	// берём IP из context и проверяем политику подсетей для класса маршрута
+	write := r.With(middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassWrite), authMiddleware.Require(auth.ScopeWrite))
+	read := r.With(middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassRead), authMiddleware.Require(auth.ScopeRead))
+	open := r.With(middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassDefault))
+
+	read.Post("/value", h.GetValueJSON)
+	read.Post("/value/", h.GetValueJSON)
+	write.Post("/update", h.UpdateMetric)
+	write.Post("/update/", h.UpdateMetric)
+	write.Post("/update/{type}/{name}/{value}", h.UpdateMetric)
+	write.Post("/updates/", h.UpdateMetricsBatch)
+	// OTLP/HTTP, Prometheus remote_write и Influx line protocol:
+	// префикс токена проверяет обработчик после разбора тела
+	ingest := r.With(middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassWrite), authMiddleware.RequireScope(auth.ScopeWrite))
+	ingest.Post("/v1/metrics", h.ExportOTLP)
+	ingest.Post("/api/v1/write", h.RemoteWrite)
+	ingest.Post("/write", h.WriteInflux)
+	read.Get("/value/{type}/{name}", h.GetValue)
+	read.Get("/", h.GetAll)
+	open.Get("/ping", h.PingDB)
+
+	if o.auth != nil {
+		tokens := NewTokenHandler(o.auth)
+		r.Route("/admin/tokens", func(r chi.Router) {
+			r.Use(middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassAdmin))
+			r.Use(authMiddleware.Require(auth.ScopeAdmin))
+			r.Get("/", tokens.ListTokens)
+			r.Post("/", tokens.CreateToken)
+			r.Delete("/{id}", tokens.RevokeToken)
+		})
+	}
+
+	// без токенов Require ничего не проверяет, а журнал содержит адреса, агентов
+	// и значения метрик, и его проверка читает файл целиком: только для администратора
+	if o.auth != nil && (o.auditStore != nil || o.auditFile != "") {
+		auditHandler := NewAuditHandler(o.auditStore, o.auditFile, o.auditKey)
+		r.Route("/audit", func(r chi.Router) {
+			r.Use(middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassAdmin))
+			r.Use(authMiddleware.Require(auth.ScopeAdmin))
+			if o.auditStore != nil {
+				r.Get("/", auditHandler.QueryAudit)
+			}
+			if o.auditFile != "" {
+				r.Get("/verify", auditHandler.VerifyAudit)
+			}
+		})
+	}
+
+	open.Get("/swagger/*", httpSwagger.Handler(
 		httpSwagger.URL("/swagger/doc.json"),
 	))
 
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	handlerhttp "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/memory"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// получатель аудита, запоминающий события
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []*audit.Event
+}
+
+func (a *auditRecorder) Notify(event *audit.Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+	return nil
+}
+
+// роутер с аудитом в auditRecorder; close доставляет события и возвращает их
+func newAuditedRouter(t *testing.T, h *handlerhttp.Handler, opts ...handlerhttp.RouterOption) (http.Handler, func() []*audit.Event) {
+	t.Helper()
+	recorder := &auditRecorder{}
+	d := audit.NewDispatcher([]audit.Receiver{recorder}, 10)
+	router := handlerhttp.NewRouter(h, keyring.Static(""), d, nil, false, opts...)
+	return router, func() []*audit.Event {
+		require.NoError(t, d.Close(context.Background()))
+		return recorder.events
+	}
+}
+
+func TestRouter_AuditVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	admin, _, err := authenticator.Issue(context.Background(), "admin", []auth.Scope{auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false,
+		handlerhttp.WithAuth(authenticator), handlerhttp.WithAuditLog(path, "secret"))
+
+	rr := doRequest(t, router, http.MethodGet, "/audit/verify", admin, nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: "secret", CheckpointEvery: 2}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, receiver.Notify(&audit.Event{Timestamp: int64(i), Action: audit.ActionUpdate, Status: http.StatusOK}))
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(t, router, http.MethodGet, "/audit/verify", "", nil).Code)
+	rr = doRequest(t, router, http.MethodGet, "/audit/verify", admin, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var report audit.VerifyReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.True(t, report.OK)
+	assert.Equal(t, 3, report.Records)
+	assert.Equal(t, 1, report.Checkpoints)
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	tampered := strings.Replace(string(content), `"ts":2`, `"ts":5`, 1)
+	require.NoError(t, os.WriteFile(path, []byte(tampered), 0644))
+
+	rr = doRequest(t, router, http.MethodGet, "/audit/verify", admin, nil)
+	require.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+	report = audit.VerifyReport{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.False(t, report.OK)
+	assert.Equal(t, 4, report.BrokenLine)
+
+	// без хранилища токенов проверка не подключается
+	router = handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false, handlerhttp.WithAuditLog(path, "secret"))
+	assert.Equal(t, http.StatusNotFound, doRequest(t, router, http.MethodGet, "/audit/verify", "", nil).Code)
+}
+
+func TestRouter_AuditVerifySegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	admin, _, err := authenticator.Issue(context.Background(), "admin", []auth.Scope{auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false,
+		handlerhttp.WithAuth(authenticator), handlerhttp.WithAuditLog(path, "secret"))
+
+	// каждое событие, кроме первого, ротирует файл
+	receiver := &audit.FileReceiver{FilePath: path, SigningKey: "secret", MaxSize: 1}
+	for i := 0; i < 3; i++ {
+		require.NoError(t, receiver.Notify(&audit.Event{Timestamp: int64(i), Action: audit.ActionUpdate, Status: http.StatusOK}))
+	}
+	require.NoError(t, receiver.Close())
+
+	rr := doRequest(t, router, http.MethodGet, "/audit/verify", admin, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var report audit.VerifyReport
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, 3, report.Records)
+
+	segments, err := audit.Segments(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, segments)
+	content, err := os.ReadFile(segments[0].Path)
+	require.NoError(t, err)
+	tampered := strings.Replace(string(content), `"ts":0`, `"ts":7`, 1)
+	require.NoError(t, os.WriteFile(segments[0].Path, []byte(tampered), 0644))
+
+	rr = doRequest(t, router, http.MethodGet, "/audit/verify", admin, nil)
+	require.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+	report = audit.VerifyReport{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &report))
+	assert.Equal(t, segments[0].Path, report.BrokenFile)
+}
+
+func TestRouter_AuditQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	receiver := &audit.FileReceiver{FilePath: path}
+	for i, id := range []string{"cpu", "mem", "cpu"} {
+		require.NoError(t, receiver.Notify(&audit.Event{
+			Timestamp: int64(1000 + i),
+			Action:    audit.ActionUpdate,
+			Metrics:   []model.Metrics{{ID: id, MType: model.Gauge}},
+			IPAddress: "127.0.0.1",
+			Status:    http.StatusOK,
+		}))
+	}
+	require.NoError(t, receiver.Close())
+
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	admin, _, err := authenticator.Issue(context.Background(), "admin", []auth.Scope{auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+	reader, _, err := authenticator.Issue(context.Background(), "reader", []auth.Scope{auth.ScopeRead}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false,
+		handlerhttp.WithAuth(authenticator),
+		handlerhttp.WithAuditStore(&audit.FileStore{Path: path}))
+
+	rr := doRequest(t, router, http.MethodGet, "/audit?metric=cpu&limit=1", admin, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var page audit.Page
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, int64(1000), page.Events[0].Timestamp)
+	assert.Equal(t, 1, page.Next)
+
+	rr = doRequest(t, router, http.MethodGet, "/audit?metric=cpu&from=1001&to=1970-01-01T00:20:00Z&offset=0", admin, nil)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	page = audit.Page{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &page))
+	require.Len(t, page.Events, 1)
+	assert.Equal(t, int64(1002), page.Events[0].Timestamp)
+
+	for _, query := range []string{"from=yesterday", "limit=-1", "limit=5000", "offset=x", "action=delete"} {
+		rr = doRequest(t, router, http.MethodGet, "/audit?"+query, admin, nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, doRequest(t, router, http.MethodGet, "/audit", "", nil).Code)
+	assert.Equal(t, http.StatusForbidden, doRequest(t, router, http.MethodGet, "/audit", reader, nil).Code)
+	// без файла журнала проверка не подключается
+	assert.Equal(t, http.StatusNotFound, doRequest(t, router, http.MethodGet, "/audit/verify", admin, nil).Code)
+
+	// без хранилища токенов журнал не отдаётся никому
+	router = handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false,
+		handlerhttp.WithAuditStore(&audit.FileStore{Path: path}))
+	assert.Equal(t, http.StatusNotFound, doRequest(t, router, http.MethodGet, "/audit", "", nil).Code)
+}
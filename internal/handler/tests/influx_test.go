@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	handlerhttp "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/memory"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func postInflux(t *testing.T, router http.Handler, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+type influxResponse struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	LineErrors []struct {
+		Line  int    `json:"line"`
+		Text  string `json:"text"`
+		Error string `json:"error"`
+	} `json:"line_errors"`
+}
+
+func TestRouter_Influx(t *testing.T) {
+	svc := service.NewMetricsService(memory.New())
+	router := handlerhttp.NewRouter(handlerhttp.NewHandler(svc), keyring.Static(""), nil, nil, false)
+	ctx := context.Background()
+
+	body := "gateway,site=plant-1 temperature=21.5,packets=10i 1760000000\n" +
+		"gateway,site=plant-1 packets=5i 1760000010\n"
+	rr := postInflux(t, router, "/write?precision=s", "", body)
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	temperature, ok := svc.GetGauge(ctx, `gateway_temperature{site="plant-1"}`)
+	require.True(t, ok)
+	assert.Equal(t, 21.5, temperature)
+	packets, ok := svc.GetCounter(ctx, `gateway_packets{site="plant-1"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(15), packets)
+
+	t.Run("ошибочные строки", func(t *testing.T) {
+		body := "gateway,site=plant-2 temperature=19\n" +
+			"gateway,site=plant-2 temperature=hot\n" +
+			"gateway,site=plant-2\n"
+		rr := postInflux(t, router, "/write", "", body)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+
+		var resp influxResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "invalid", resp.Code)
+		assert.Equal(t, `partial write: 2 of 3 lines rejected: line 2: field "temperature": invalid value "hot" (and 1 more)`, resp.Message)
+		require.Len(t, resp.LineErrors, 2)
+		assert.Equal(t, 2, resp.LineErrors[0].Line)
+		assert.Equal(t, "gateway,site=plant-2 temperature=hot", resp.LineErrors[0].Text)
+		assert.Equal(t, 3, resp.LineErrors[1].Line)
+		assert.Equal(t, "missing fields", resp.LineErrors[1].Error)
+
+		// корректная строка записана
+		temperature, ok := svc.GetGauge(ctx, `gateway_temperature{site="plant-2"}`)
+		require.True(t, ok)
+		assert.Equal(t, 19.0, temperature)
+	})
+
+	t.Run("все строки ошибочны", func(t *testing.T) {
+		rr := postInflux(t, router, "/write", "", "bad\n")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		var resp influxResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, "no valid lines: line 1: missing fields", resp.Message)
+	})
+
+	t.Run("ошибка перевода точки", func(t *testing.T) {
+		body := "gateway,site=plant-3 load=1\n" +
+			"gateway,site=plant-3 total=18446744073709551615u\n" +
+			"gateway,site=plant-3\n"
+		rr := postInflux(t, router, "/write", "", body)
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+		var resp influxResponse
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+		assert.Equal(t, `partial write: 2 of 3 lines rejected: line 2: field "total": unsigned value overflows counter (and 1 more)`, resp.Message)
+	})
+
+	rr = postInflux(t, router, "/write?precision=days", "", body)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, http.StatusNoContent, postInflux(t, router, "/write", "", "").Code)
+}
+
+func TestRouter_InfluxTokenPrefix(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	gateway, _, err := authenticator.Issue(context.Background(), "gateway", []auth.Scope{auth.ScopeWrite}, "gateway_")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false, handlerhttp.WithAuth(authenticator))
+
+	assert.Equal(t, http.StatusNoContent, postInflux(t, router, "/write", gateway, "gateway load=1").Code)
+	rr := postInflux(t, router, "/write", gateway, "plc load=1")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	assert.JSONEq(t, `{"code":"forbidden","message":"metric not allowed for token: plc_load"}`, rr.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, postInflux(t, router, "/write", "", "gateway load=1").Code)
+}
+
+func TestRouter_InfluxAudit(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	gateway, token, err := authenticator.Issue(context.Background(), "gateway", []auth.Scope{auth.ScopeWrite}, "gateway_")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router, events := newAuditedRouter(t, h, handlerhttp.WithAuth(authenticator))
+
+	// отклонённая запись попадает в журнал со статусом отказа
+	require.Equal(t, http.StatusForbidden, postInflux(t, router, "/write", gateway, "plc load=1").Code)
+	// частичная запись попадает в журнал с итоговым кодом ответа
+	require.Equal(t, http.StatusBadRequest, postInflux(t, router, "/write", gateway, "gateway load=1\ngateway load=x").Code)
+
+	got := events()
+	require.Len(t, got, 2)
+	assert.Equal(t, audit.ActionInflux, got[0].Action)
+	assert.Equal(t, []string{"plc_load"}, got[0].MetricIDs())
+	assert.Equal(t, token.ID, got[0].TokenID)
+	assert.Equal(t, http.StatusForbidden, got[0].Status)
+
+	assert.Equal(t, audit.ActionInflux, got[1].Action)
+	assert.Equal(t, []string{"gateway_load"}, got[1].MetricIDs())
+	assert.Equal(t, token.ID, got[1].TokenID)
+	assert.Equal(t, http.StatusBadRequest, got[1].Status)
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	handlerhttp "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/memory"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const otlpJSON = `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+  "scopeMetrics":[{"metrics":[
+    {"name":"app.jobs","sum":{"aggregationTemporality":1,"isMonotonic":true,"dataPoints":[{"asInt":"3"}]}},
+    {"name":"app.load","gauge":{"dataPoints":[{"asDouble":0.5}]}},
+    {"name":"app.exp","exponentialHistogram":{"dataPoints":[{}]}}
+  ]}]}]}`
+
+func postOTLP(t *testing.T, router http.Handler, contentType, token string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRouter_OTLP(t *testing.T) {
+	svc := service.NewMetricsService(memory.New())
+	router := handlerhttp.NewRouter(handlerhttp.NewHandler(svc), keyring.Static(""), nil, nil, false)
+	ctx := context.Background()
+
+	rr := postOTLP(t, router, "application/json", "", []byte(otlpJSON))
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"1","errorMessage":"app.exp: exponential histograms are not supported"}}`, rr.Body.String())
+
+	rr = postOTLP(t, router, "application/json; charset=utf-8", "", []byte(otlpJSON))
+	require.Equal(t, http.StatusOK, rr.Code)
+	jobs, ok := svc.GetCounter(ctx, `app_jobs{service_name="api"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(6), jobs)
+	load, ok := svc.GetGauge(ctx, `app_load{service_name="api"}`)
+	require.True(t, ok)
+	assert.Equal(t, 0.5, load)
+
+	// protobuf: метрика gauge с одной точкой
+	point := protowire.AppendTag(nil, 4, protowire.Fixed64Type)
+	point = protowire.AppendFixed64(point, 0x4000000000000000) // 2.0
+	gauge := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), point)
+	metric := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "proto.gauge")
+	metric = protowire.AppendBytes(protowire.AppendTag(metric, 5, protowire.BytesType), gauge)
+	scope := protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), metric)
+	resource := protowire.AppendBytes(protowire.AppendTag(nil, 2, protowire.BytesType), scope)
+	body := protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), resource)
+
+	rr = postOTLP(t, router, "application/x-protobuf", "", body)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	assert.Equal(t, "application/x-protobuf", rr.Header().Get("Content-Type"))
+	assert.Empty(t, rr.Body.Bytes())
+	value, ok := svc.GetGauge(ctx, "proto_gauge")
+	require.True(t, ok)
+	assert.Equal(t, 2.0, value)
+
+	assert.Equal(t, http.StatusBadRequest, postOTLP(t, router, "application/x-protobuf", "", []byte{0x0a, 0xff}).Code)
+	assert.Equal(t, http.StatusBadRequest, postOTLP(t, router, "application/json", "", []byte("{")).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, postOTLP(t, router, "text/plain", "", []byte(otlpJSON)).Code)
+}
+
+func TestRouter_OTLPTokenPrefix(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	appWriter, _, err := authenticator.Issue(context.Background(), "app", []auth.Scope{auth.ScopeWrite}, "app_")
+	require.NoError(t, err)
+	otherWriter, _, err := authenticator.Issue(context.Background(), "other", []auth.Scope{auth.ScopeWrite}, "other_")
+	require.NoError(t, err)
+	reader, _, err := authenticator.Issue(context.Background(), "reader", []auth.Scope{auth.ScopeRead}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false, handlerhttp.WithAuth(authenticator))
+
+	assert.Equal(t, http.StatusOK, postOTLP(t, router, "application/json", appWriter, []byte(otlpJSON)).Code)
+	assert.Equal(t, http.StatusForbidden, postOTLP(t, router, "application/json", otherWriter, []byte(otlpJSON)).Code)
+	assert.Equal(t, http.StatusForbidden, postOTLP(t, router, "application/json", reader, []byte(otlpJSON)).Code)
+	assert.Equal(t, http.StatusUnauthorized, postOTLP(t, router, "application/json", "", []byte(otlpJSON)).Code)
+}
+
+func TestRouter_OTLPAudit(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	writer, token, err := authenticator.Issue(context.Background(), "app", []auth.Scope{auth.ScopeWrite}, "app_")
+	require.NoError(t, err)
+	other, otherToken, err := authenticator.Issue(context.Background(), "other", []auth.Scope{auth.ScopeWrite}, "other_")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router, events := newAuditedRouter(t, h, handlerhttp.WithAuth(authenticator))
+
+	require.Equal(t, http.StatusOK, postOTLP(t, router, "application/json", writer, []byte(otlpJSON)).Code)
+	// тело не разобрано и метрики не переданы — события нет
+	require.Equal(t, http.StatusBadRequest, postOTLP(t, router, "application/json", writer, []byte("{")).Code)
+	// отклонённая запись попадает в журнал со статусом отказа
+	require.Equal(t, http.StatusForbidden, postOTLP(t, router, "application/json", other, []byte(otlpJSON)).Code)
+
+	got := events()
+	require.Len(t, got, 2)
+	assert.Equal(t, audit.ActionOTLP, got[0].Action)
+	assert.Equal(t, []string{`app_jobs{service_name="api"}`, `app_load{service_name="api"}`}, got[0].MetricIDs())
+	assert.Equal(t, token.ID, got[0].TokenID)
+	assert.Equal(t, http.StatusOK, got[0].Status)
+
+	assert.Equal(t, audit.ActionOTLP, got[1].Action)
+	assert.NotEmpty(t, got[1].MetricIDs())
+	assert.Equal(t, otherToken.ID, got[1].TokenID)
+	assert.Equal(t, http.StatusForbidden, got[1].Status)
+}
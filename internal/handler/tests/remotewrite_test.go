@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	handlerhttp "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/memory"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// тела remote_write из тестов пакета remotewrite
+func remoteWritePayload(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("..", "..", "remotewrite", "tests", "testdata", name))
+	require.NoError(t, err)
+	return body
+}
+
+func postRemoteWrite(t *testing.T, router http.Handler, token, encoding string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", encoding)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRouter_RemoteWrite(t *testing.T) {
+	svc := service.NewMetricsService(memory.New())
+	router := handlerhttp.NewRouter(handlerhttp.NewHandler(svc), keyring.Static(""), nil, nil, false)
+	ctx := context.Background()
+
+	rr := postRemoteWrite(t, router, "", "snappy", remoteWritePayload(t, "write_request_1.snappy"))
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	memFree, ok := svc.GetGauge(ctx, `node_memory_free_bytes{instance="host:9100",job="node"}`)
+	require.True(t, ok)
+	assert.Equal(t, 2048.0, memFree)
+
+	rr = postRemoteWrite(t, router, "", "snappy", remoteWritePayload(t, "write_request_2.snappy"))
+	require.Equal(t, http.StatusNoContent, rr.Code, rr.Body.String())
+	requests, ok := svc.GetCounter(ctx, `http_requests_total{job="api",method="GET"}`)
+	require.True(t, ok)
+	assert.Equal(t, int64(50), requests, "10 внутри первого запроса и 40 во втором")
+
+	assert.Equal(t, http.StatusBadRequest, postRemoteWrite(t, router, "", "snappy", []byte("not snappy")).Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, postRemoteWrite(t, router, "", "zstd", remoteWritePayload(t, "write_request_1.snappy")).Code)
+}
+
+func TestRouter_RemoteWriteTokenPrefix(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	nodeWriter, _, err := authenticator.Issue(context.Background(), "node", []auth.Scope{auth.ScopeWrite}, "node_")
+	require.NoError(t, err)
+	writer, _, err := authenticator.Issue(context.Background(), "prometheus", []auth.Scope{auth.ScopeWrite}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false, handlerhttp.WithAuth(authenticator))
+	body := remoteWritePayload(t, "write_request_1.snappy")
+
+	assert.Equal(t, http.StatusForbidden, postRemoteWrite(t, router, nodeWriter, "snappy", body).Code)
+	assert.Equal(t, http.StatusNoContent, postRemoteWrite(t, router, writer, "snappy", body).Code)
+	assert.Equal(t, http.StatusUnauthorized, postRemoteWrite(t, router, "", "snappy", body).Code)
+}
+
+func TestRouter_RemoteWriteAudit(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	nodeWriter, nodeToken, err := authenticator.Issue(context.Background(), "node", []auth.Scope{auth.ScopeWrite}, "node_")
+	require.NoError(t, err)
+	writer, token, err := authenticator.Issue(context.Background(), "prometheus", []auth.Scope{auth.ScopeWrite}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router, events := newAuditedRouter(t, h, handlerhttp.WithAuth(authenticator))
+	body := remoteWritePayload(t, "write_request_1.snappy")
+
+	// отклонённая запись попадает в журнал со статусом отказа
+	require.Equal(t, http.StatusForbidden, postRemoteWrite(t, router, nodeWriter, "snappy", body).Code)
+	require.Equal(t, http.StatusNoContent, postRemoteWrite(t, router, writer, "snappy", body).Code)
+
+	got := events()
+	require.Len(t, got, 2)
+	assert.Equal(t, audit.ActionRemoteWrite, got[0].Action)
+	assert.NotEmpty(t, got[0].MetricIDs())
+	assert.Equal(t, nodeToken.ID, got[0].TokenID)
+	assert.Equal(t, http.StatusForbidden, got[0].Status)
+
+	assert.Equal(t, audit.ActionRemoteWrite, got[1].Action)
+	assert.Contains(t, got[1].MetricIDs(), `node_memory_free_bytes{instance="host:9100",job="node"}`)
+	assert.Equal(t, token.ID, got[1].TokenID)
+	assert.Equal(t, http.StatusNoContent, got[1].Status)
+}
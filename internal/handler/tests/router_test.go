@@ -9,19 +9,26 @@ import (
 	"testing"
 
 	handlerhttp "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/mocks"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
 	"github.com/go-chi/chi/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestRouter(handler *handlerhttp.Handler, HashKey string, trustedSubnet string) *chi.Mux {
 	r := chi.NewRouter()
 
 	r.Use(middleware.GetRealIPMiddleware)
-	r.Use(middleware.TrustedSubnetMiddleware(trustedSubnet))
+	subnetMiddleware, err := middleware.TrustedSubnetMiddleware(trustedSubnet)
+	if err != nil {
+		panic(err)
+	}
+	r.Use(subnetMiddleware)
 
 	r.Post("/value", handler.GetValueJSON)
 	r.Post("/value/", handler.GetValueJSON)
@@ -193,3 +200,75 @@ func TestHandler_UpdateMetricsBatch(t *testing.T) {
 		mockRepo.AssertExpectations(t)
 	})
 }
+
+func TestHandler_Limits(t *testing.T) {
+	mockRepo := new(mocks.MetricsRepo)
+	svc := service.NewMetricsService(mockRepo)
+	handler := handlerhttp.NewHandler(svc, handlerhttp.WithLimits(limits.New(limits.Config{MaxBatchSize: 1, MaxNameLength: 8})))
+	router := setupTestRouter(handler, "", "")
+
+	t.Run("слишком большой батч", func(t *testing.T) {
+		v := 1.0
+		body, _ := json.Marshal([]model.Metrics{
+			{ID: "a", MType: model.Gauge, Value: &v},
+			{ID: "b", MType: model.Gauge, Value: &v},
+		})
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("слишком длинное имя в URL", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/update/gauge/very_long_name/1", nil)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandler_SeriesPolicy(t *testing.T) {
+	mockRepo := new(mocks.MetricsRepo)
+	svc := service.NewMetricsService(mockRepo)
+	policy, err := series.New(series.Config{NamePattern: `^[A-Za-z_]+$`, MaxSeries: 1})
+	require.NoError(t, err)
+	handler := handlerhttp.NewHandler(svc, handlerhttp.WithSeriesPolicy(policy))
+	router := setupTestRouter(handler, "", "")
+
+	send := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	mockRepo.On("UpsertGauge", "Alloc", 1.0).Return(nil).Twice()
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/update/gauge/Alloc/1", nil).Code)
+
+	t.Run("недопустимое имя", func(t *testing.T) {
+		rr := send(http.MethodPost, "/update/gauge/req-42/1", nil)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("новый ряд сверх лимита", func(t *testing.T) {
+		v := 1.0
+		body, _ := json.Marshal(model.Metrics{ID: "HeapInuse", MType: model.Gauge, Value: &v})
+		rr := send(http.MethodPost, "/update", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+		assert.Contains(t, rr.Body.String(), "series limit exceeded")
+
+		body, _ = json.Marshal([]model.Metrics{{ID: "Alloc", MType: model.Gauge, Value: &v}, {ID: "HeapIdle", MType: model.Gauge, Value: &v}})
+		rr = send(http.MethodPost, "/updates/", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	})
+
+	t.Run("существующий ряд обновляется", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, send(http.MethodPost, "/update/gauge/Alloc/1", nil).Code)
+	})
+
+	mockRepo.AssertExpectations(t)
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	handlerhttp "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/handler"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/repository/memory"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func doRequest(t *testing.T, router http.Handler, method, path, token string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRouter_TokenAuth(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	admin, _, err := authenticator.Issue(context.Background(), "admin", []auth.Scope{auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false, handlerhttp.WithAuth(authenticator))
+
+	// админ выдаёт агенту токен на запись метрик с префиксом app_
+	body, _ := json.Marshal(map[string]any{"name": "agent-1", "scopes": []string{"write"}, "metric_prefix": "app_"})
+	rr := doRequest(t, router, http.MethodPost, "/admin/tokens", admin, body)
+	require.Equal(t, http.StatusCreated, rr.Code, rr.Body.String())
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &created))
+	require.NotEmpty(t, created.Token)
+	writer := created.Token
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "запись разрешённой метрики", method: http.MethodPost, path: "/update/gauge/app_cpu/1", token: writer, status: http.StatusOK},
+		{name: "метрика вне префикса", method: http.MethodPost, path: "/update/gauge/sys_cpu/1", token: writer, status: http.StatusForbidden},
+		{name: "без токена", method: http.MethodPost, path: "/update/gauge/app_cpu/1", status: http.StatusUnauthorized},
+		{name: "неизвестный токен", method: http.MethodPost, path: "/update/gauge/app_cpu/1", token: "mt_unknown", status: http.StatusUnauthorized},
+		{name: "чтение без права read", method: http.MethodGet, path: "/value/gauge/app_cpu", token: writer, status: http.StatusForbidden},
+		{name: "чтение админом", method: http.MethodGet, path: "/value/gauge/app_cpu", token: admin, status: http.StatusOK},
+		{name: "управление токенами без права admin", method: http.MethodGet, path: "/admin/tokens", token: writer, status: http.StatusForbidden},
+		{name: "список токенов", method: http.MethodGet, path: "/admin/tokens", token: admin, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := doRequest(t, router, tt.method, tt.path, tt.token, nil)
+			assert.Equal(t, tt.status, rr.Code, rr.Body.String())
+		})
+	}
+
+	t.Run("отзыв токена", func(t *testing.T) {
+		rr := doRequest(t, router, http.MethodDelete, "/admin/tokens/"+created.ID, admin, nil)
+		require.Equal(t, http.StatusNoContent, rr.Code)
+
+		rr = doRequest(t, router, http.MethodPost, "/update/gauge/app_cpu/2", writer, nil)
+		assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+		rr = doRequest(t, router, http.MethodDelete, "/admin/tokens/missing", admin, nil)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+}
+
+func TestRouter_GetAllTokenPrefix(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	appReader, _, err := authenticator.Issue(context.Background(), "app", []auth.Scope{auth.ScopeRead}, "app_")
+	require.NoError(t, err)
+	reader, _, err := authenticator.Issue(context.Background(), "all", []auth.Scope{auth.ScopeRead}, "")
+	require.NoError(t, err)
+
+	svc := service.NewMetricsService(memory.New())
+	require.NoError(t, svc.UpdateGauge(context.Background(), "app_cpu", 1))
+	require.NoError(t, svc.UpdateGauge(context.Background(), "sys_cpu", 2))
+	router := handlerhttp.NewRouter(handlerhttp.NewHandler(svc), keyring.Static(""), nil, nil, false, handlerhttp.WithAuth(authenticator))
+
+	rr := doRequest(t, router, http.MethodGet, "/", appReader, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "app_cpu")
+	assert.NotContains(t, rr.Body.String(), "sys_cpu", "метрики вне префикса токена не отдаются")
+
+	rr = doRequest(t, router, http.MethodGet, "/", reader, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "app_cpu")
+	assert.Contains(t, rr.Body.String(), "sys_cpu")
+}
+
+func TestRouter_NoAuthConfigured(t *testing.T) {
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static(""), nil, nil, false)
+
+	rr := doRequest(t, router, http.MethodPost, "/update/gauge/cpu/1", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = doRequest(t, router, http.MethodGet, "/admin/tokens", "", nil)
+	assert.Equal(t, http.StatusNotFound, rr.Code, "без хранилища токенов маршрутов администрирования нет")
+}
+
+// строгая подпись касается только маршрутов агента
+func TestRouter_StrictHashAgentRoutesOnly(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	authenticator := auth.NewAuthenticator(store)
+	admin, _, err := authenticator.Issue(context.Background(), "admin", []auth.Scope{auth.ScopeAdmin}, "")
+	require.NoError(t, err)
+
+	h := handlerhttp.NewHandler(service.NewMetricsService(memory.New()))
+	router := handlerhttp.NewRouter(h, keyring.Static("secret"), nil, nil, false,
+		handlerhttp.WithAuth(authenticator),
+		handlerhttp.WithHashOptions(middleware.WithStrictHash()),
+		handlerhttp.WithAuditLog(filepath.Join(t.TempDir(), "audit.log"), ""))
+
+	assert.Equal(t, http.StatusOK, postOTLP(t, router, "application/json", admin, []byte(otlpJSON)).Code)
+	assert.Equal(t, http.StatusNoContent, postRemoteWrite(t, router, admin, "snappy", remoteWritePayload(t, "write_request_1.snappy")).Code)
+	assert.Equal(t, http.StatusNoContent, postInflux(t, router, "/write", admin, "gateway load=1").Code)
+	body, _ := json.Marshal(map[string]any{"name": "agent", "scopes": []string{"write"}})
+	assert.Equal(t, http.StatusCreated, doRequest(t, router, http.MethodPost, "/admin/tokens", admin, body).Code)
+
+	// запись агента без подписи отклоняется
+	assert.Equal(t, http.StatusBadRequest, doRequest(t, router, http.MethodPost, "/update/gauge/cpu/1", admin, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, doRequest(t, router, http.MethodPost, "/updates/", admin, []byte(`[]`)).Code)
+}
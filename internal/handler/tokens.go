@@ -0,0 +1,121 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/go-chi/chi/v5"
+)
+
+// управление API токенами, доступно только с правом admin
+type TokenHandler struct {
+	auth *auth.Authenticator
+}
+
+func NewTokenHandler(a *auth.Authenticator) *TokenHandler {
+	return &TokenHandler{auth: a}
+}
+
+type createTokenRequest struct {
+	Name         string       `json:"name"`
+	Scopes       []auth.Scope `json:"scopes"`
+	MetricPrefix string       `json:"metric_prefix"`
+}
+
+// токен в ответах API, без хэша
+type tokenResponse struct {
+	ID           string       `json:"id"`
+	Name         string       `json:"name"`
+	Scopes       []auth.Scope `json:"scopes"`
+	MetricPrefix string       `json:"metric_prefix,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+	RevokedAt    *time.Time   `json:"revoked_at,omitempty"`
+	Token        string       `json:"token,omitempty"` // только при создании
+}
+
+func newTokenResponse(t *auth.Token) tokenResponse {
+	return tokenResponse{
+		ID:           t.ID,
+		Name:         t.Name,
+		Scopes:       t.Scopes,
+		MetricPrefix: t.MetricPrefix,
+		CreatedAt:    t.CreatedAt,
+		RevokedAt:    t.RevokedAt,
+	}
+}
+
+// CreateToken godoc
+// @Tags Admin
+// @Summary Создание API токена
+// @Description Значение токена возвращается только в этом ответе
+// @Accept json
+// @Produce json
+// @Success 201 {object} tokenResponse
+// @Failure 400 {string} string "Неверный запрос"
+// @Router /admin/tokens [post]
+func (h *TokenHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	var req createTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	raw, token, err := h.auth.Issue(r.Context(), req.Name, req.Scopes, req.MetricPrefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := newTokenResponse(token)
+	resp.Token = raw
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// ListTokens godoc
+// @Tags Admin
+// @Summary Список API токенов
+// @Produce json
+// @Success 200 {array} tokenResponse
+// @Router /admin/tokens [get]
+func (h *TokenHandler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := h.auth.List(r.Context())
+	if err != nil {
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	resp := make([]tokenResponse, 0, len(tokens))
+	for i := range tokens {
+		resp = append(resp, newTokenResponse(&tokens[i]))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// RevokeToken godoc
+// @Tags Admin
+// @Summary Отзыв API токена
+// @Param id path string true "Идентификатор токена"
+// @Success 204
+// @Failure 404 {string} string "Токен не найден"
+// @Router /admin/tokens/{id} [delete]
+func (h *TokenHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	err := h.auth.Revoke(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, auth.ErrNotFound) {
+		http.Error(w, "token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "store error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,57 @@
+package influx
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// переводит точки в метрики: каждое числовое поле — метрика measurement_field,
+// теги — её метки. целые поля (1i, 1u) — counter, значение прибавляется как delta,
+// дробные — gauge. строковые и логические поля пропускаются.
+// имена и ключи меток приводятся к виду Prometheus, как у OTLP.
+//
+// ошибка перевода отклоняет строку целиком, чтобы она не записалась частично
+func Convert(points []Point) ([]model.Metrics, []*LineError) {
+	var (
+		metrics []model.Metrics
+		errs    []*LineError
+	)
+	for _, p := range points {
+		got, err := convertPoint(p)
+		if err != nil {
+			errs = append(errs, &LineError{Line: p.Line, Err: err.Error()})
+			continue
+		}
+		metrics = append(metrics, got...)
+	}
+	return metrics, errs
+}
+
+func convertPoint(p Point) ([]model.Metrics, error) {
+	labels := make(map[string]string, len(p.Tags))
+	for k, v := range p.Tags {
+		labels[model.SanitizeLabel(k)] = v
+	}
+
+	var metrics []model.Metrics
+	for _, f := range p.Fields {
+		id := model.SeriesID(model.SanitizeName(p.Measurement+"_"+f.Key), labels)
+		switch f.Type {
+		case FieldFloat:
+			v := f.Float
+			metrics = append(metrics, model.Metrics{ID: id, MType: model.Gauge, Value: &v})
+		case FieldInteger:
+			d := f.Int
+			metrics = append(metrics, model.Metrics{ID: id, MType: model.Counter, Delta: &d})
+		case FieldUnsigned:
+			if f.Uint > math.MaxInt64 {
+				return nil, fmt.Errorf("field %q: unsigned value overflows counter", f.Key)
+			}
+			d := int64(f.Uint)
+			metrics = append(metrics, model.Metrics{ID: id, MType: model.Counter, Delta: &d})
+		}
+	}
+	return metrics, nil
+}
@@ -0,0 +1,283 @@
+// Package influx разбирает InfluxDB line protocol и переводит точки в метрики сервиса.
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// строки разбираются независимо: ошибка в одной строке не мешает остальным
+// и возвращается с её номером.
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// тип значения поля
+type FieldType int
+
+const (
+	FieldFloat    FieldType = iota // 1.5, 1, -2e3
+	FieldInteger                   // 1i
+	FieldUnsigned                  // 1u
+	FieldString                    // "text"
+	FieldBoolean                   // t, true, F, false...
+)
+
+type Field struct {
+	Key   string
+	Type  FieldType
+	Float float64
+	Int   int64
+	Uint  uint64
+	Str   string
+	Bool  bool
+}
+
+// точка одной строки
+type Point struct {
+	Line        int // номер строки, с 1
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Time        time.Time
+}
+
+// ошибка разбора или перевода строки
+type LineError struct {
+	Line int    `json:"line"`
+	Text string `json:"text,omitempty"` // строка, длинная обрезается
+	Err  string `json:"error"`
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// сколько символов строки попадает в LineError.Text
+const maxErrorText = 128
+
+func lineError(n int, line string, err error) *LineError {
+	if len(line) > maxErrorText {
+		line = line[:maxErrorText] + "..."
+	}
+	return &LineError{Line: n, Text: line, Err: err.Error()}
+}
+
+// единица времени меток: параметр precision в /write InfluxDB 1.x и 2.x;
+// пустое значение — наносекунды
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	}
+	return 0, fmt.Errorf("unknown precision %q, expected ns, us, ms, s, m or h", s)
+}
+
+// разбирает тело запроса. строки без метки времени получают now.
+// пустые строки и комментарии (#) пропускаются
+func Parse(body []byte, precision time.Duration, now time.Time) ([]Point, []*LineError) {
+	var (
+		points []Point
+		errs   []*LineError
+	)
+	for i, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		p, err := parseLine(trimmed, precision, now)
+		if err != nil {
+			errs = append(errs, lineError(i+1, line, err))
+			continue
+		}
+		p.Line = i + 1
+		points = append(points, p)
+	}
+	return points, errs
+}
+
+type scanner struct {
+	s   string
+	pos int
+}
+
+func (sc *scanner) done() bool { return sc.pos >= len(sc.s) }
+
+func (sc *scanner) peek() byte { return sc.s[sc.pos] }
+
+// читает до неэкранированного символа из stop; escapable — символы,
+// которые снимают экранирование, остальные \ остаются как есть
+func (sc *scanner) until(stop, escapable string) string {
+	var b strings.Builder
+	for !sc.done() {
+		c := sc.peek()
+		if c == '\\' && sc.pos+1 < len(sc.s) && strings.IndexByte(escapable, sc.s[sc.pos+1]) >= 0 {
+			b.WriteByte(sc.s[sc.pos+1])
+			sc.pos += 2
+			continue
+		}
+		if strings.IndexByte(stop, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+		sc.pos++
+	}
+	return b.String()
+}
+
+func (sc *scanner) skipSpaces() {
+	for !sc.done() && sc.peek() == ' ' {
+		sc.pos++
+	}
+}
+
+func parseLine(line string, precision time.Duration, now time.Time) (Point, error) {
+	sc := &scanner{s: line}
+	p := Point{Measurement: sc.until(", ", `, \`)}
+	if p.Measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+
+	for !sc.done() && sc.peek() == ',' {
+		sc.pos++
+		key := sc.until("=, ", `,= \`)
+		if sc.done() || sc.peek() != '=' {
+			return p, fmt.Errorf("tag %q: missing value", key)
+		}
+		sc.pos++
+		value := sc.until(", ", `,= \`)
+		if key == "" || value == "" {
+			return p, fmt.Errorf("invalid tag %q=%q: key and value must not be empty", key, value)
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[key] = value
+	}
+
+	sc.skipSpaces()
+	if sc.done() {
+		return p, errors.New("missing fields")
+	}
+	for {
+		f, err := sc.field()
+		if err != nil {
+			return p, err
+		}
+		p.Fields = append(p.Fields, f)
+		if sc.done() || sc.peek() != ',' {
+			break
+		}
+		sc.pos++
+	}
+
+	p.Time = now
+	sc.skipSpaces()
+	if sc.done() {
+		return p, nil
+	}
+	raw := sc.s[sc.pos:]
+	ts, err := strconv.ParseInt(strings.TrimRight(raw, " "), 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid timestamp %q", raw)
+	}
+	if ts > math.MaxInt64/int64(precision) || ts < math.MinInt64/int64(precision) {
+		return p, fmt.Errorf("timestamp %d out of range", ts)
+	}
+	p.Time = time.Unix(0, ts*int64(precision))
+	return p, nil
+}
+
+func (sc *scanner) field() (Field, error) {
+	f := Field{Key: sc.until("=, ", `,= \`)}
+	if f.Key == "" {
+		return f, errors.New("missing field key")
+	}
+	if sc.done() || sc.peek() != '=' {
+		return f, fmt.Errorf("field %q: missing value", f.Key)
+	}
+	sc.pos++
+	if sc.done() {
+		return f, fmt.Errorf("field %q: missing value", f.Key)
+	}
+
+	if sc.peek() == '"' {
+		sc.pos++
+		var b strings.Builder
+		for {
+			if sc.done() {
+				return f, fmt.Errorf("field %q: unterminated string", f.Key)
+			}
+			c := sc.peek()
+			if c == '\\' && sc.pos+1 < len(sc.s) && (sc.s[sc.pos+1] == '"' || sc.s[sc.pos+1] == '\\') {
+				b.WriteByte(sc.s[sc.pos+1])
+				sc.pos += 2
+				continue
+			}
+			sc.pos++
+			if c == '"' {
+				break
+			}
+			b.WriteByte(c)
+		}
+		if !sc.done() && sc.peek() != ',' && sc.peek() != ' ' {
+			return f, fmt.Errorf("field %q: unexpected data after string", f.Key)
+		}
+		f.Type, f.Str = FieldString, b.String()
+		return f, nil
+	}
+
+	raw := sc.until(", ", "")
+	if err := parseValue(&f, raw); err != nil {
+		return f, fmt.Errorf("field %q: %w", f.Key, err)
+	}
+	return f, nil
+}
+
+func parseValue(f *Field, raw string) error {
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		f.Type, f.Bool = FieldBoolean, true
+		return nil
+	case "f", "F", "false", "False", "FALSE":
+		f.Type, f.Bool = FieldBoolean, false
+		return nil
+	}
+
+	var err error
+	switch {
+	case raw == "":
+		return errors.New("missing value")
+	case strings.HasSuffix(raw, "i"):
+		f.Type = FieldInteger
+		f.Int, err = strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+	case strings.HasSuffix(raw, "u"):
+		f.Type = FieldUnsigned
+		f.Uint, err = strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+	default:
+		f.Type = FieldFloat
+		f.Float, err = strconv.ParseFloat(raw, 64)
+		// ParseFloat понимает NaN и Inf, line protocol — нет
+		if err == nil && (math.IsNaN(f.Float) || math.IsInf(f.Float, 0)) {
+			err = errors.New("not a number")
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value %q", raw)
+	}
+	return nil
+}
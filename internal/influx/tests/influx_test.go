@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/influx"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Unix(1760000000, 0)
+
+func TestParse(t *testing.T) {
+	body := "# комментарий\n" +
+		"weather,location=us-midwest,season=summer temperature=82.5,humidity=71i 1465839830100400200\n" +
+		"\n" +
+		"my\\ sensor,room=living\\,room\\=1 status=\"ok \\\"fine\\\"\",on=t,count=3u\r\n" +
+		"cpu load=0.5"
+
+	points, errs := influx.Parse([]byte(body), time.Nanosecond, now)
+	require.Empty(t, errs)
+	require.Len(t, points, 3)
+
+	p := points[0]
+	assert.Equal(t, 2, p.Line)
+	assert.Equal(t, "weather", p.Measurement)
+	assert.Equal(t, map[string]string{"location": "us-midwest", "season": "summer"}, p.Tags)
+	assert.Equal(t, []influx.Field{
+		{Key: "temperature", Type: influx.FieldFloat, Float: 82.5},
+		{Key: "humidity", Type: influx.FieldInteger, Int: 71},
+	}, p.Fields)
+	assert.Equal(t, time.Unix(0, 1465839830100400200), p.Time)
+
+	p = points[1]
+	assert.Equal(t, 4, p.Line)
+	assert.Equal(t, "my sensor", p.Measurement)
+	assert.Equal(t, map[string]string{"room": "living,room=1"}, p.Tags)
+	assert.Equal(t, []influx.Field{
+		{Key: "status", Type: influx.FieldString, Str: `ok "fine"`},
+		{Key: "on", Type: influx.FieldBoolean, Bool: true},
+		{Key: "count", Type: influx.FieldUnsigned, Uint: 3},
+	}, p.Fields)
+
+	assert.Equal(t, now, points[2].Time, "без метки времени — время приёма")
+}
+
+func TestParse_Precision(t *testing.T) {
+	precision, err := influx.ParsePrecision("s")
+	require.NoError(t, err)
+	points, errs := influx.Parse([]byte("cpu load=1 1700000000"), precision, now)
+	require.Empty(t, errs)
+	assert.Equal(t, time.Unix(1700000000, 0), points[0].Time)
+
+	_, err = influx.ParsePrecision("days")
+	assert.Error(t, err)
+
+	_, errs = influx.Parse([]byte("cpu load=1 9223372036854775807"), time.Second, now)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "line 1: timestamp 9223372036854775807 out of range", errs[0].Error())
+}
+
+func TestParse_LineErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		err  string
+	}{
+		{line: ",host=a load=1", err: "missing measurement"},
+		{line: "cpu", err: "missing fields"},
+		{line: "cpu,host load=1", err: `tag "host": missing value`},
+		{line: "cpu,host= load=1", err: `invalid tag "host"="": key and value must not be empty`},
+		{line: "cpu load", err: `field "load": missing value`},
+		{line: "cpu load=", err: `field "load": missing value`},
+		{line: "cpu =1", err: "missing field key"},
+		{line: "cpu load=abc", err: `field "load": invalid value "abc"`},
+		{line: "cpu load=1.5i", err: `field "load": invalid value "1.5i"`},
+		{line: "cpu load=-1u", err: `field "load": invalid value "-1u"`},
+		{line: "cpu load=NaN", err: `field "load": invalid value "NaN"`},
+		{line: `cpu msg="open`, err: `field "msg": unterminated string`},
+		{line: `cpu msg="a"b`, err: `field "msg": unexpected data after string`},
+		{line: "cpu load=1 12:00", err: `invalid timestamp "12:00"`},
+		{line: "cpu load=1 1 2", err: `invalid timestamp "1 2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			points, errs := influx.Parse([]byte("ok value=1\n"+tt.line), time.Nanosecond, now)
+			require.Len(t, points, 1, "корректная строка разбирается несмотря на ошибку в соседней")
+			require.Len(t, errs, 1)
+			assert.Equal(t, 2, errs[0].Line)
+			assert.Equal(t, tt.line, errs[0].Text)
+			assert.Equal(t, tt.err, errs[0].Err)
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	body := "weather,location=us-midwest temperature=82.5,humidity=71i,sky=\"clear\",raining=f\n" +
+		"http.server,status.code=200 requests=18446744073709551615u\n" +
+		"disk free=10u"
+	points, errs := influx.Parse([]byte(body), time.Nanosecond, now)
+	require.Empty(t, errs)
+
+	metrics, errs := influx.Convert(points)
+	require.Len(t, errs, 1)
+	assert.Equal(t, `line 2: field "requests": unsigned value overflows counter`, errs[0].Error())
+
+	require.Len(t, metrics, 3)
+	assert.Equal(t, `weather_temperature{location="us-midwest"}`, metrics[0].ID)
+	assert.Equal(t, model.Gauge, metrics[0].MType)
+	assert.Equal(t, 82.5, *metrics[0].Value)
+	assert.Equal(t, `weather_humidity{location="us-midwest"}`, metrics[1].ID)
+	assert.Equal(t, model.Counter, metrics[1].MType)
+	assert.Equal(t, int64(71), *metrics[1].Delta)
+	assert.Equal(t, "disk_free", metrics[2].ID)
+	assert.Equal(t, int64(10), *metrics[2].Delta)
+}
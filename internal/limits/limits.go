@@ -0,0 +1,181 @@
+// Package limits ограничивает приём метрик: частоту запросов клиента (token bucket),
+// размер тела после распаковки, длину батча и длину имени метрики.
+// нарушения считаются и отдаются как собственные метрики сервера.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// префикс собственных метрик сервера
+const SelfTelemetryPrefix = "server_self_"
+
+var (
+	ErrBodyTooLarge  = errors.New("request body too large")
+	ErrBatchTooLarge = errors.New("batch too large")
+	ErrNameTooLong   = errors.New("metric name too long")
+)
+
+// нулевое значение поля — без ограничения
+type Config struct {
+	Rate          float64 // запросов в секунду на клиента
+	Burst         int     // запросов сверх Rate подряд, по умолчанию max(1, Rate)
+	MaxBodyBytes  int64   // размер тела после распаковки
+	MaxBatchSize  int     // метрик в одном запросе
+	MaxNameLength int     // длина имени метрики в байтах
+}
+
+type Limits struct {
+	cfg     Config
+	buckets *buckets
+
+	rateLimited   atomic.Int64
+	bodyTooLarge  atomic.Int64
+	batchTooLarge atomic.Int64
+	nameTooLong   atomic.Int64
+}
+
+func New(cfg Config) *Limits {
+	l := &Limits{cfg: cfg}
+	if cfg.Rate > 0 {
+		burst := cfg.Burst
+		if burst <= 0 {
+			burst = max(1, int(cfg.Rate))
+		}
+		l.buckets = newBuckets(cfg.Rate, float64(burst))
+	}
+	return l
+}
+
+// false, если клиент key превысил частоту запросов
+func (l *Limits) Allow(key string) bool {
+	if l == nil || l.buckets == nil {
+		return true
+	}
+	if l.buckets.take(key, time.Now()) {
+		return true
+	}
+	l.rateLimited.Add(1)
+	return false
+}
+
+// максимальный размер тела, 0 — без ограничения
+func (l *Limits) MaxBodyBytes() int64 {
+	if l == nil {
+		return 0
+	}
+	return l.cfg.MaxBodyBytes
+}
+
+// учитывает запрос, отклонённый из-за размера тела
+func (l *Limits) ObserveBodyTooLarge() {
+	if l != nil {
+		l.bodyTooLarge.Add(1)
+	}
+}
+
+// ErrBatchTooLarge, если в запросе слишком много метрик
+func (l *Limits) CheckBatch(n int) error {
+	if l == nil || l.cfg.MaxBatchSize <= 0 || n <= l.cfg.MaxBatchSize {
+		return nil
+	}
+	l.batchTooLarge.Add(1)
+	return fmt.Errorf("%w: %d metrics, limit %d", ErrBatchTooLarge, n, l.cfg.MaxBatchSize)
+}
+
+// ErrNameTooLong, если имя метрики длиннее допустимого
+func (l *Limits) CheckName(name string) error {
+	if l == nil || l.cfg.MaxNameLength <= 0 || len(name) <= l.cfg.MaxNameLength {
+		return nil
+	}
+	l.nameTooLong.Add(1)
+	return fmt.Errorf("%w: %d bytes, limit %d", ErrNameTooLong, len(name), l.cfg.MaxNameLength)
+}
+
+// проверяет длину батча и имена метрик
+func (l *Limits) CheckMetrics(metrics []model.Metrics) error {
+	if err := l.CheckBatch(len(metrics)); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if err := l.CheckName(m.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// счётчики отклонённых запросов как приращения с момента прошлого вызова
+func (l *Limits) Snapshot() []model.Metrics {
+	metrics := make([]model.Metrics, 0, 4)
+	addCounter := func(id string, v *atomic.Int64) {
+		delta := v.Swap(0)
+		if delta == 0 {
+			return
+		}
+		metrics = append(metrics, model.Metrics{
+			ID:    SelfTelemetryPrefix + id,
+			MType: model.Counter,
+			Delta: &delta,
+		})
+	}
+	addCounter("requests_rate_limited", &l.rateLimited)
+	addCounter("requests_body_too_large", &l.bodyTooLarge)
+	addCounter("requests_batch_too_large", &l.batchTooLarge)
+	addCounter("requests_name_too_long", &l.nameTooLong)
+	return metrics
+}
+
+// token bucket на каждого клиента. давно не использованные корзины удаляются:
+// за это время они всё равно наполнились бы до burst.
+type buckets struct {
+	rate  float64
+	burst float64
+
+	mu        sync.Mutex
+	items     map[string]*bucket
+	lastPurge time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newBuckets(rate, burst float64) *buckets {
+	return &buckets{rate: rate, burst: burst, items: make(map[string]*bucket)}
+}
+
+func (b *buckets) take(key string, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	full := time.Duration(b.burst / b.rate * float64(time.Second))
+	if now.Sub(b.lastPurge) > full+time.Minute {
+		for k, it := range b.items {
+			if now.Sub(it.last) > full {
+				delete(b.items, k)
+			}
+		}
+		b.lastPurge = now
+	}
+
+	it, ok := b.items[key]
+	if !ok {
+		it = &bucket{tokens: b.burst, last: now}
+		b.items[key] = it
+	}
+	it.tokens = min(b.burst, it.tokens+now.Sub(it.last).Seconds()*b.rate)
+	it.last = now
+	if it.tokens < 1 {
+		return false
+	}
+	it.tokens--
+	return true
+}
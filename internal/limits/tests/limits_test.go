@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimits_Allow(t *testing.T) {
+	l := limits.New(limits.Config{Rate: 1, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.Allow("ip:10.0.0.1"), "request %d", i)
+	}
+	assert.False(t, l.Allow("ip:10.0.0.1"))
+	// у другого клиента своя корзина
+	assert.True(t, l.Allow("ip:10.0.0.2"))
+}
+
+func TestLimits_Unlimited(t *testing.T) {
+	l := limits.New(limits.Config{})
+	for i := 0; i < 1000; i++ {
+		require.True(t, l.Allow("ip:10.0.0.1"))
+	}
+	assert.NoError(t, l.CheckBatch(1_000_000))
+	assert.NoError(t, l.CheckName(strings.Repeat("a", 10000)))
+
+	var nilLimits *limits.Limits
+	assert.True(t, nilLimits.Allow("x"))
+	assert.NoError(t, nilLimits.CheckMetrics(make([]model.Metrics, 10)))
+	assert.Zero(t, nilLimits.MaxBodyBytes())
+}
+
+func TestLimits_CheckMetrics(t *testing.T) {
+	l := limits.New(limits.Config{MaxBatchSize: 2, MaxNameLength: 5})
+
+	assert.NoError(t, l.CheckMetrics([]model.Metrics{{ID: "a"}, {ID: "bbbbb"}}))
+	assert.ErrorIs(t, l.CheckMetrics([]model.Metrics{{ID: "a"}, {ID: "b"}, {ID: "c"}}), limits.ErrBatchTooLarge)
+	assert.ErrorIs(t, l.CheckMetrics([]model.Metrics{{ID: "toolong"}}), limits.ErrNameTooLong)
+}
+
+func TestLimits_Snapshot(t *testing.T) {
+	l := limits.New(limits.Config{Rate: 1, Burst: 1, MaxBatchSize: 1})
+
+	assert.Empty(t, l.Snapshot())
+
+	l.Allow("k")
+	l.Allow("k")
+	l.Allow("k")
+	_ = l.CheckBatch(5)
+	l.ObserveBodyTooLarge()
+
+	got := map[string]int64{}
+	for _, m := range l.Snapshot() {
+		assert.Equal(t, model.Counter, m.MType)
+		got[m.ID] = *m.Delta
+	}
+	assert.Equal(t, map[string]int64{
+		"server_self_requests_rate_limited":    2,
+		"server_self_requests_batch_too_large": 1,
+		"server_self_requests_body_too_large":  1,
+	}, got)
+
+	// счётчики отдаются как приращения
+	assert.Empty(t, l.Snapshot())
+}
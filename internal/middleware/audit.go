@@ -4,126 +4,179 @@ package middleware
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
-	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 )
 
-type AuditEvent struct {
-	Timestamp int64    `json:"ts"`
-	Metrics   []string `json:"metrics"`
-	IPAddress string   `json:"ip_address"`
-}
+// AuditMiddleware - после обработки запроса к метрикам публикует событие аудита.
+// тело только читается: ошибки разбора не влияют на ответ, их возвращает обработчик.
+func AuditMiddleware(d *audit.Dispatcher) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !d.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			action, ok := auditAction(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-type AuditReceiver interface {
-	Notify(event *AuditEvent) error
-}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			if reportedByHandler(action) {
+				ctx, reported := audit.WithReport(r.Context())
+				next.ServeHTTP(rec, r.WithContext(ctx))
+				publishAudit(d, r, action, reported(), rec.status)
+				return
+			}
 
-type FileAuditReceiver struct {
-	FilePath string
-}
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				data, err := io.ReadAll(r.Body)
+				if err != nil {
+					// пусть ошибку чтения вернёт обработчик
+					r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), errReader{err}))
+					next.ServeHTTP(w, r)
+					return
+				}
+				body = data
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
 
-func (f *FileAuditReceiver) Notify(event *AuditEvent) error {
-	file, err := os.OpenFile(f.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+			next.ServeHTTP(rec, r)
+			publishAudit(d, r, action, auditMetrics(r, action, body), rec.status)
+		})
 	}
-	defer file.Close()
+}
 
-	data, err := json.Marshal(event)
-	if err != nil {
-		return err
+// событие публикуется, только если в запросе есть метрики
+func publishAudit(d *audit.Dispatcher, r *http.Request, action audit.Action, metrics []model.Metrics, status int) {
+	if len(metrics) == 0 {
+		return
 	}
-
-	_, err = file.Write(append(data, '\n'))
-	return err
+	event := &audit.Event{
+		Timestamp: time.Now().Unix(),
+		Action:    action,
+		Metrics:   metrics,
+		IPAddress: GetRealIPFromContext(r.Context()),
+		AgentID:   requestAgentID(r),
+		Status:    status,
+	}
+	if token := auth.TokenFromContext(r.Context()); token != nil {
+		event.TokenID = token.ID
+	}
+	d.Publish(event)
 }
 
-type URLAuditReceiver struct {
-	URL string
+// действие по маршруту; false — запрос не аудируется
+func auditAction(r *http.Request) (audit.Action, bool) {
+	path := r.URL.Path
+	switch {
+	case r.Method == http.MethodPost && (path == "/updates" || path == "/updates/"):
+		return audit.ActionBatchUpdate, true
+	case r.Method == http.MethodPost && (path == "/update" || strings.HasPrefix(path, "/update/")):
+		return audit.ActionUpdate, true
+	case r.Method == http.MethodPost && (path == "/value" || path == "/value/"):
+		return audit.ActionRead, true
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/value/"):
+		return audit.ActionRead, true
+	case r.Method == http.MethodPost && path == "/v1/metrics":
+		return audit.ActionOTLP, true
+	case r.Method == http.MethodPost && path == "/api/v1/write":
+		return audit.ActionRemoteWrite, true
+	case r.Method == http.MethodPost && path == "/write":
+		return audit.ActionInflux, true
+	}
+	return "", false
 }
 
-func (u *URLAuditReceiver) Notify(event *AuditEvent) error {
-	data, err := json.Marshal(event)
-	if err != nil {
-		return err
+// тело этих запросов не JSON, метрики передаёт обработчик через audit.Report
+func reportedByHandler(action audit.Action) bool {
+	switch action {
+	case audit.ActionOTLP, audit.ActionRemoteWrite, audit.ActionInflux:
+		return true
 	}
+	return false
+}
 
-	resp, err := http.Post(u.URL, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		return err
+// метрики запроса: из JSON тела (объект или массив) или из пути text формата
+func auditMetrics(r *http.Request, action audit.Action, body []byte) []model.Metrics {
+	var metrics []model.Metrics
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		if trimmed[0] == '[' {
+			_ = json.Unmarshal(trimmed, &metrics)
+		} else {
+			var m model.Metrics
+			if json.Unmarshal(trimmed, &m) == nil {
+				metrics = []model.Metrics{m}
+			}
+		}
+	} else {
+		metrics = pathMetric(r.URL.Path)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send audit log, status: %s", resp.Status)
+	out := metrics[:0]
+	for _, m := range metrics {
+		if m.ID == "" {
+			continue
+		}
+		if action == audit.ActionRead {
+			m = model.Metrics{ID: m.ID, MType: m.MType}
+		}
+		out = append(out, m)
 	}
-	return nil
+	return out
 }
 
-// AuditMiddleware - извлекает метрики и передает их в аудит
-func AuditMiddleware(auditReceivers []AuditReceiver) func(next http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost {
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			if r.URL.Path != "/update" &&
-				r.URL.Path != "/update/" &&
-				r.URL.Path != "/updates" &&
-				r.URL.Path != "/updates/" &&
-				r.URL.Path != "/value" {
-				next.ServeHTTP(w, r)
-				return
+// /update/{type}/{name}/{value} и /value/{type}/{name}
+func pathMetric(path string) []model.Metrics {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 {
+		return nil
+	}
+	m := model.Metrics{MType: parts[1], ID: parts[2]}
+	if parts[0] == "update" && len(parts) == 4 {
+		switch m.MType {
+		case model.Gauge:
+			if v, err := strconv.ParseFloat(parts[3], 64); err == nil {
+				m.Value = &v
 			}
-
-			body, err := io.ReadAll(r.Body)
-			if err != nil {
-				logger.NewHTTPLogger().Logger.Sugar().Warnf("Error reading request body: %v", err)
-				next.ServeHTTP(w, r)
-				return
-			}
-
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
-
-			var metrics []model.Metrics
-			if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(map[string]string{"error": "invalid JSON format"})
-				return
+		case model.Counter:
+			if d, err := strconv.ParseInt(parts[3], 10, 64); err == nil {
+				m.Delta = &d
 			}
+		}
+	}
+	return []model.Metrics{m}
+}
 
-			logger.NewHTTPLogger().Logger.Sugar().Infof("Extracted metrics: %v", metrics)
+// код ответа обработчика
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
 
-			var auditMetrics []string
-			for _, metric := range metrics {
-				auditMetrics = append(auditMetrics, metric.ID)
-			}
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
 
-			if len(auditMetrics) == 0 {
-				next.ServeHTTP(w, r)
-				return
-			}
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
 
-			event := &AuditEvent{
-				Timestamp: time.Now().Unix(),
-				Metrics:   auditMetrics,
-				IPAddress: r.RemoteAddr,
-			}
+type errReader struct{ err error }
 
-			for _, receiver := range auditReceivers {
-				if err := receiver.Notify(event); err != nil {
-					logger.NewHTTPLogger().Logger.Sugar().Warnf("Error while sending audit: %v", err)
-				}
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
-}
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
+	"github.com/go-chi/chi/v5"
+)
+
+// проверка bearer токенов. с nil аутентификатором все проверки пропускаются,
+// так сервер без настроенных токенов работает как раньше.
+type Auth struct {
+	authenticator *auth.Authenticator
+}
+
+func NewAuth(a *auth.Authenticator) *Auth {
+	return &Auth{authenticator: a}
+}
+
+func (m *Auth) Enabled() bool {
+	return m.authenticator != nil
+}
+
+// находит токен из заголовка Authorization и кладёт его в context.
+// запрос без токена пропускается, права проверяет Require на конкретных маршрутах.
+func (m *Auth) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !m.Enabled() || header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		raw, ok := auth.BearerToken(header)
+		if !ok {
+			unauthorized(w, "invalid authorization header")
+			return
+		}
+		token, err := m.authenticator.Authenticate(r.Context(), raw)
+		if err != nil {
+			if errors.Is(err, auth.ErrInvalidToken) || errors.Is(err, auth.ErrRevoked) {
+				unauthorized(w, err.Error())
+				return
+			}
+			logger.NewHTTPLogger().Logger.Sugar().Errorf("token lookup failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), token)))
+	})
+}
+
+// требует токен с правом scope. если у токена задан префикс метрик,
+// имена из пути и тела запроса должны с него начинаться.
+func (m *Auth) Require(scope auth.Scope) func(http.Handler) http.Handler {
+	return m.require(scope, true)
+}
+
+// требует токен с правом scope, не разбирая тело запроса. для форматов,
+// в которых имена метрик известны только обработчику: префикс токена проверяет он.
+func (m *Auth) RequireScope(scope auth.Scope) func(http.Handler) http.Handler {
+	return m.require(scope, false)
+}
+
+func (m *Auth) require(scope auth.Scope, checkPrefix bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !m.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := auth.TokenFromContext(r.Context())
+			if token == nil {
+				unauthorized(w, "authorization required")
+				return
+			}
+			if !token.HasScope(scope) {
+				http.Error(w, "insufficient scope", http.StatusForbidden)
+				return
+			}
+			if checkPrefix && token.MetricPrefix != "" {
+				names, err := requestMetricNames(r)
+				if err != nil {
+					http.Error(w, "invalid request body", http.StatusBadRequest)
+					return
+				}
+				for _, name := range names {
+					if !token.AllowsMetric(name) {
+						http.Error(w, "metric not allowed for token: "+name, http.StatusForbidden)
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// имена метрик запроса: из пути /{type}/{name}/... или из JSON тела,
+// которое может быть одной метрикой или массивом. тело возвращается на место.
+func requestMetricNames(r *http.Request) ([]string, error) {
+	if name := chi.URLParam(r, "name"); name != "" {
+		return []string{name}, nil
+	}
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	type metricID struct {
+		ID string `json:"id"`
+	}
+	var items []metricID
+	if trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &items)
+	} else {
+		var one metricID
+		err = json.Unmarshal(trimmed, &one)
+		items = []metricID{one}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(items))
+	for _, item := range items {
+		names = append(names, item.ID)
+	}
+	return names, nil
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+type AgentIDCtxKey struct{}
+
+// кладёт в context идентификатор агента из клиентского сертификата (mTLS).
+// сертификат к этому моменту уже проверен TLS сервером по CA из конфига.
+func ClientCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		id := AgentIDFromCertificate(r.TLS.PeerCertificates[0])
+		ctx := context.WithValue(r.Context(), AgentIDCtxKey{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// идентификатор агента: CommonName, а если он пуст — первое DNS имя из SAN.
+func AgentIDFromCertificate(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return ""
+}
+
+// пустая строка, если агент не предъявил сертификат
+func GetAgentIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(AgentIDCtxKey{}).(string)
+	return id
+}
@@ -6,35 +6,19 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
-	"encoding/pem"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 	logger "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/pgk/logger"
 )
 
 // загружает приватный ключ RSA
 func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %w", err)
-	}
-
-	block, _ := pem.Decode(data)
-	if block == nil || block.Type != "RSA PRIVATE KEY" {
-		return nil, fmt.Errorf("invalid private key format")
-	}
-
-	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
-	}
-
-	return privKey, nil
+	return keyring.LoadPrivateKey(path)
 }
 
 // расшифровывает данные RSA
@@ -96,13 +80,20 @@ func DecryptHybridAESRSA(priv *rsa.PrivateKey, payload []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
-// расшифровывает тело запроса по заголовку X-Encrypted
-func DecryptMiddleware(privKeyPath string) func(http.Handler) http.Handler {
-	privKey, err := LoadPrivateKey(privKeyPath)
+// расшифровывает тело запроса по заголовку X-Encrypted одним ключом из файла.
+func DecryptMiddleware(privKeyPath string, allowLegacy bool) func(http.Handler) http.Handler {
+	keys, err := keyring.New(keyring.Config{PrivateKeyFiles: []string{privKeyPath}})
 	if err != nil {
 		logger.NewHTTPLogger().Sugar().Fatalf("failed to load private key: %v", err)
 	}
+	return DecryptMiddlewareWithKeyring(keys, allowLegacy)
+}
 
+// расшифровывает тело запроса ключами из keyring. конверт выбирает ключ по идентификатору,
+// форматы rsa и hybrid (PKCS#1 v1.5 без аутентификации заголовка) принимаются
+// только при allowLegacy, для агентов, которые ещё не перешли на конверт,
+// и пробуют ключи по очереди начиная с текущего.
+func DecryptMiddlewareWithKeyring(keys *keyring.Keyring, allowLegacy bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			encType := r.Header.Get("X-Encrypted")
@@ -125,10 +116,18 @@ func DecryptMiddleware(privKeyPath string) func(http.Handler) http.Handler {
 
 			var decrypted []byte
 			switch encType {
-			case "rsa":
-				decrypted, err = DecryptWithRSA(privKey, bodyData)
-			case "hybrid":
-				decrypted, err = DecryptHybridAESRSA(privKey, bodyData)
+			case envelope.HeaderValue:
+				decrypted, err = envelope.Open(bodyData, keys.Lookup)
+			case "rsa", "hybrid":
+				if !allowLegacy {
+					http.Error(w, "legacy encryption is disabled", http.StatusBadRequest)
+					return
+				}
+				decrypt := DecryptWithRSA
+				if encType == "hybrid" {
+					decrypt = DecryptHybridAESRSA
+				}
+				decrypted, err = decryptWithAny(keys.PrivateKeys(), bodyData, decrypt)
 			default:
 				http.Error(w, "unsupported encryption type", http.StatusBadRequest)
 				return
@@ -142,7 +141,25 @@ func DecryptMiddleware(privKeyPath string) func(http.Handler) http.Handler {
 
 			logger.NewHTTPLogger().Sugar().Infof("successfully decrypted request body for %s using %s", r.RequestURI, encType)
 			r.Body = io.NopCloser(bytes.NewReader(decrypted))
+			r.ContentLength = int64(len(decrypted))
+			r.Header.Del("X-Encrypted")
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// пробует ключи по очереди, возвращает первую удачную расшифровку
+func decryptWithAny(keys []*rsa.PrivateKey, data []byte, decrypt func(*rsa.PrivateKey, []byte) ([]byte, error)) ([]byte, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no private keys loaded")
+	}
+	var lastErr error
+	for _, priv := range keys {
+		plaintext, err := decrypt(priv, data)
+		if err == nil {
+			return plaintext, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
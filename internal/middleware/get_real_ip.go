@@ -2,25 +2,28 @@ package middleware
 
 import (
 	"context"
-	"net"
 	"net/http"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
 )
 
 type RealIPCtxKey struct{}
 
+// IP клиента по адресу сокета; заголовки X-Real-IP и X-Forwarded-For не учитываются
 func GetRealIPMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.Header.Get("X-Real-IP")
+	return RealIPMiddleware(nil)(next)
+}
 
-		if ip == "" {
-			host, _, err := net.SplitHostPort(r.RemoteAddr) //если ip пустой получаем ip отправляющего, порт не нужен(пропускаем)
-			if err == nil {
-				ip = host
-			}
-		}
-		ctx := context.WithValue(r.Context(), RealIPCtxKey{}, ip)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// IP клиента с учётом доверенных прокси: заголовки X-Forwarded-For и X-Real-IP
+// учитываются, только если запрос пришёл с адреса доверенного прокси
+func RealIPMiddleware(resolver *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := resolver.Resolve(r.RemoteAddr, r.Header.Values("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
+			ctx := context.WithValue(r.Context(), RealIPCtxKey{}, ip)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
 
 func GetRealIPFromContext(ctx context.Context) string {
@@ -7,44 +7,59 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
 )
 
 // GzipDecompression middleware распаковывает входящие gzip данные
 func GzipDecompression(httpGzip http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		//по тз проверяем content-encoding gzip
-		contentEncoding := r.Header.Get("Content-Encoding")
+	return GzipDecompressionWithLimit(nil)(httpGzip)
+}
 
-		if strings.Contains(strings.ToLower(contentEncoding), "gzip") {
-			//если тело пустое выходим
-			if r.ContentLength == 0 || r.Body == http.NoBody {
-				r.Header.Del("Content-Encoding")
-				httpGzip.ServeHTTP(w, r)
-				return
-			}
+// то же, но распакованное тело больше l.MaxBodyBytes() отклоняется с 413,
+// не дочитывая архив до конца: защита от gzip бомб
+func GzipDecompressionWithLimit(l *limits.Limits) func(http.Handler) http.Handler {
+	return func(httpGzip http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			//по тз проверяем content-encoding gzip
+			contentEncoding := r.Header.Get("Content-Encoding")
 
-			//создания reader для распаковки
-			gz, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Invalid gzip data", http.StatusBadRequest)
-				return
-			}
-			defer gz.Close()
-			//новое тело для распакованных данных
-			decompressionbody, err := io.ReadAll(gz)
-			if err != nil {
-				http.Error(w, "Failed to decompression gz", http.StatusBadRequest)
-				return
+			if strings.Contains(strings.ToLower(contentEncoding), "gzip") {
+				//если тело пустое выходим
+				if r.ContentLength == 0 || r.Body == http.NoBody {
+					r.Header.Del("Content-Encoding")
+					httpGzip.ServeHTTP(w, r)
+					return
+				}
+
+				//создания reader для распаковки
+				gz, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "Invalid gzip data", http.StatusBadRequest)
+					return
+				}
+				defer gz.Close()
+				//новое тело для распакованных данных
+				decompressionbody, err := readLimited(gz, l.MaxBodyBytes())
+				if err == limits.ErrBodyTooLarge {
+					l.ObserveBodyTooLarge()
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
+				if err != nil {
+					http.Error(w, "Failed to decompression gz", http.StatusBadRequest)
+					return
+				}
+				//заменяем тело запроса
+				r.Body = io.NopCloser(bytes.NewReader(decompressionbody))
+				//удаляем заголовок сжатия
+				r.Header.Del("Content-Encoding")
+				//обновляем len body content
+				r.ContentLength = int64(len(decompressionbody))
 			}
-			//заменяем тело запроса
-			r.Body = io.NopCloser(bytes.NewReader(decompressionbody))
-			//удаляем заголовок сжатия
-			r.Header.Del("Content-Encoding")
-			//обновляем len body content
-			r.ContentLength = int64(len(decompressionbody))
-		}
-		httpGzip.ServeHTTP(w, r)
-	})
+			httpGzip.ServeHTTP(w, r)
+		})
+	}
 }
 
 type GzipResponseWriter struct {
@@ -4,55 +4,182 @@ package middleware
 import (
 	"bytes"
 	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"io"
 	"log"
 	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 )
 
 type HashMiddleware struct {
 	HashKey string
+	// ключи для проверки: текущий и предыдущие, ответы подписываются текущим
+	Keys *keyring.Keyring
+	// запрос агента (/update, /updates) без подписи или с неверной подписью отклоняется
+	// с 400, иначе только логируется
+	Strict bool
+	// допустимое расхождение метки времени; если задано, метка времени и nonce обязательны
+	ReplayWindow time.Duration
+
+	replay *keyring.ReplayGuard
+}
+
+// дополнительная настройка проверки подписи
+type HashOption func(*HashMiddleware)
+
+// отклонять запросы агента без подписи и с неверной подписью
+func WithStrictHash() HashOption {
+	return func(h *HashMiddleware) {
+		h.Strict = true
+	}
+}
+
+// защита от повтора: подписанные метка времени и nonce, nonce принимается один раз
+func WithReplayWindow(window time.Duration) HashOption {
+	return func(h *HashMiddleware) {
+		h.ReplayWindow = window
+	}
 }
 
 func NewHashMiddleware(HashKey string) *HashMiddleware {
-	return &HashMiddleware{HashKey: HashKey}
+	return &HashMiddleware{HashKey: HashKey, Keys: keyring.Static(HashKey)}
+}
+
+// middleware с набором ключей, которые можно перечитать без перезапуска
+func NewHashMiddlewareWithKeyring(keys *keyring.Keyring, opts ...HashOption) *HashMiddleware {
+	h := &HashMiddleware{Keys: keys}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.ReplayWindow > 0 {
+		h.replay = keyring.NewReplayGuard(h.ReplayWindow)
+	}
+	return h
+}
+
+func (h *HashMiddleware) enabled() bool {
+	if h.Keys != nil {
+		return h.Keys.HasHashKeys()
+	}
+	return h.HashKey != ""
+}
+
+func (h *HashMiddleware) verify(agentID string, data []byte, signature string) bool {
+	if h.Keys != nil {
+		return h.Keys.VerifyAgent(agentID, data, signature)
+	}
+	return hmac.Equal([]byte(signature), []byte(h.ComputeHash(data)))
 }
 
 // проверяем входящие запросы на хэш
 func (h *HashMiddleware) CheckHash(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if h.HashKey == "" {
+		if !h.enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		//приходящий sha256
+		incomingHash := r.Header.Get(keyring.HeaderSignature)
+		// строго проверяются только маршруты агента: клиенты OTLP, remote_write
+		// и Influx, а также администраторы подписывать запросы не умеют
+		strict := h.Strict && agentRoute(r.URL.Path)
+		if incomingHash == "" {
+			if strict {
+				http.Error(w, "missing hash", http.StatusBadRequest)
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
-		if r.Method == http.MethodPut || r.Method == http.MethodPost {
-			//приходящий sha256
-			incomingHash := r.Header.Get("HashSHA256")
-			if incomingHash != "" {
-				body, err := io.ReadAll(r.Body)
-				if err != nil {
-					http.Error(w, "Cannot read body", http.StatusBadRequest)
-					return
-				}
-				r.Body = io.NopCloser(bytes.NewBuffer(body))
-				//используем тело для получаения sha256
-				computedHash := h.ComputeHash(body)
-				if !hmac.Equal([]byte(incomingHash), []byte(computedHash)) {
-					// http.Error(w, "Invalid hash sum", http.StatusBadRequest)
-					// return
-					log.Printf("Хэши не сходятся: incoming=%s, computed=%s", incomingHash, computedHash)
-				}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Cannot read body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		timestamp := r.Header.Get(keyring.HeaderTimestamp)
+		nonce := r.Header.Get(keyring.HeaderNonce)
+		if h.replay != nil {
+			if err := h.replay.CheckFreshness(timestamp, nonce); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
 		}
+
+		//подписываются тело (или путь text формата) вместе с маршрутом, меткой времени и nonce, если они переданы.
+		//без метки времени и nonce — старый формат, только тело
+		payload := signedContent(r, body)
+		if timestamp != "" || nonce != "" {
+			payload = keyring.SignedPayload(keyring.Route(r.Method, r.URL.Path), timestamp, nonce, payload)
+		}
+
+		agentID := requestAgentID(r)
+		if !h.verify(agentID, payload, incomingHash) {
+			if strict {
+				http.Error(w, "Invalid hash sum", http.StatusBadRequest)
+				return
+			}
+			log.Printf("Хэши не сходятся: agent=%q incoming=%s", agentID, incomingHash)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// nonce запоминается только после проверки подписи, чтобы чужие запросы не занимали его
+		if h.replay != nil && !h.replay.Remember(nonce) {
+			http.Error(w, "replayed request", http.StatusBadRequest)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// агент из клиентского сертификата, при его отсутствии — из заголовка.
+// заголовку можно доверять: подпись ключом агента подтверждает, что он его.
+func requestAgentID(r *http.Request) string {
+	if id := GetAgentIDFromContext(r.Context()); id != "" {
+		return id
+	}
+	return r.Header.Get(keyring.HeaderAgentID)
+}
+
+// маршруты записи агента: /update, /update/..., /updates/
+func agentRoute(path string) bool {
+	return path == "/update" || path == "/updates" ||
+		strings.HasPrefix(path, "/update/") || strings.HasPrefix(path, "/updates/")
+}
+
+// у text формата /update/{type}/{name}/{value} тела нет, подписывается "type:name:value"
+func signedContent(r *http.Request, body []byte) []byte {
+	if len(body) > 0 {
+		return body
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/update/")
+	if !ok {
+		return body
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) != 3 {
+		return body
+	}
+	return []byte(strings.Join(parts, ":"))
+}
+
+// подпись текущим ключом
 func (h *HashMiddleware) ComputeHash(body []byte) string {
-	hmacHash := hmac.New(sha256.New, []byte(h.HashKey))
-	hmacHash.Write(body)
-	return hex.EncodeToString(hmacHash.Sum(nil))
+	if h.Keys != nil {
+		return h.Keys.Sign(body)
+	}
+	return keyring.ComputeHMAC(h.HashKey, body)
 }
 
 type AddResponseWriter struct {
@@ -64,7 +191,7 @@ type AddResponseWriter struct {
 // добавляем хэш на отправку
 func (h *HashMiddleware) AddHash(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if h.HashKey == "" {
+		if !h.enabled() {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -75,7 +202,7 @@ func (h *HashMiddleware) AddHash(next http.Handler) http.Handler {
 		//вычисляем
 		if len(addRes.Body) > 0 {
 			hash := h.ComputeHash(addRes.Body)
-			addRes.ResponseWriter.Header().Set("HashSHA256", hash)
+			addRes.ResponseWriter.Header().Set(keyring.HeaderSignature, hash)
 		}
 	})
 }
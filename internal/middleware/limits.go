@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+)
+
+// ограничивает частоту запросов клиента: по токену, если он есть, иначе по IP
+func RateLimitMiddleware(l *limits.Limits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.Allow(ClientKey(r.Context())) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// клиент для лимитов: токен, если он есть, иначе IP
+func ClientKey(ctx context.Context) string {
+	if token := auth.TokenFromContext(ctx); token != nil {
+		return "token:" + token.ID
+	}
+	return "ip:" + GetRealIPFromContext(ctx)
+}
+
+// отклоняет с 413 тела больше лимита. тело читается целиком сразу,
+// чтобы следующие middleware не получали обрезанные данные.
+func BodyLimitMiddleware(l *limits.Limits) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			maxBytes := l.MaxBodyBytes()
+			if maxBytes <= 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if r.ContentLength > maxBytes {
+				l.ObserveBodyTooLarge()
+				http.Error(w, limits.ErrBodyTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			body, err := readLimited(r.Body, maxBytes)
+			if err == limits.ErrBodyTooLarge {
+				l.ObserveBodyTooLarge()
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "Cannot read body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// читает не больше maxBytes, ErrBodyTooLarge если данных больше; 0 — без ограничения
+func readLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, limits.ErrBodyTooLarge
+	}
+	return data, nil
+}
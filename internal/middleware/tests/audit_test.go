@@ -3,331 +3,295 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/audit"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // MockAuditReceiver для тестирования
 type MockAuditReceiver struct {
-	events []*middleware.AuditEvent
-	errors []error
+	mu     sync.Mutex
+	events []*audit.Event
 }
 
-func (m *MockAuditReceiver) Notify(event *middleware.AuditEvent) error {
+func (m *MockAuditReceiver) Notify(event *audit.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.events = append(m.events, event)
-	if len(m.errors) > 0 {
-		err := m.errors[0]
-		m.errors = m.errors[1:]
-		return err
-	}
 	return nil
 }
 
-func (m *MockAuditReceiver) GetEvents() []*middleware.AuditEvent {
-	return m.events
-}
+// прогоняет запрос через AuditMiddleware и возвращает ответ и доставленные события
+func serveAudited(t *testing.T, next http.Handler, req *http.Request) (*httptest.ResponseRecorder, []*audit.Event) {
+	t.Helper()
+	receiver := &MockAuditReceiver{}
+	d := audit.NewDispatcher([]audit.Receiver{receiver}, 10)
 
-func (m *MockAuditReceiver) SetErrors(errors []error) {
-	m.errors = errors
+	rr := httptest.NewRecorder()
+	middleware.AuditMiddleware(d)(next).ServeHTTP(rr, req)
+	require.NoError(t, d.Close(context.Background()))
+	return rr, receiver.events
 }
 
-// Test функция для проверки логирования
-func TestAuditMiddleware_Success(t *testing.T) {
-	mockReceiver := &MockAuditReceiver{}
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
+func okHandler(status int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.WriteHeader(status)
 		w.Write([]byte("OK"))
 	})
+}
 
-	middleware := middleware.AuditMiddleware([]middleware.AuditReceiver{mockReceiver})
-	testHandler := middleware(handler)
-
+func TestAuditMiddleware_Batch(t *testing.T) {
 	metrics := []model.Metrics{
 		{ID: "test1", MType: "gauge", Value: Ptr(1.5)},
 		{ID: "test2", MType: "counter", Delta: Ptr(int64(10))},
 	}
-
 	body, _ := json.Marshal(metrics)
-	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
-	req.RemoteAddr = "127.0.0.1:8080"
-	rr := httptest.NewRecorder()
-
-	testHandler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusOK {
-		t.Errorf("expected status 200, got %d", rr.Code)
-	}
-
-	if len(mockReceiver.events) != 1 {
-		t.Errorf("expected 1 audit event, got %d", len(mockReceiver.events))
-		return
-	}
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RealIPCtxKey{}, "127.0.0.1"))
+	req = req.WithContext(auth.WithToken(req.Context(), &auth.Token{ID: "tok1"}))
+	req.Header.Set("X-Agent-ID", "agent-7")
+
+	rr, events := serveAudited(t, okHandler(http.StatusOK), req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, events, 1)
+	event := events[0]
+	assert.Equal(t, audit.ActionBatchUpdate, event.Action)
+	assert.Equal(t, metrics, event.Metrics)
+	assert.Equal(t, []string{"test1", "test2"}, event.MetricIDs())
+	assert.Equal(t, "127.0.0.1", event.IPAddress)
+	assert.Equal(t, "tok1", event.TokenID)
+	assert.Equal(t, "agent-7", event.AgentID)
+	assert.Equal(t, http.StatusOK, event.Status)
+}
 
-	event := mockReceiver.events[0]
-	if len(event.Metrics) != 2 {
-		t.Errorf("expected 2 metrics in audit, got %d", len(event.Metrics))
+func TestAuditMiddleware_Success(t *testing.T) {
+	metrics := []model.Metrics{
+		{ID: "test1", MType: "gauge", Value: Ptr(1.5)},
+		{ID: "test2", MType: "counter", Delta: Ptr(int64(10))},
 	}
+	body, _ := json.Marshal(metrics)
+	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RealIPCtxKey{}, "127.0.0.1"))
 
-	if event.Metrics[0] != "test1" || event.Metrics[1] != "test2" {
-		t.Errorf("unexpected metric names: %v", event.Metrics)
-	}
+	rr, events := serveAudited(t, okHandler(http.StatusOK), req)
 
-	if event.IPAddress != "127.0.0.1:8080" {
-		t.Errorf("unexpected IP address: %s", event.IPAddress)
-	}
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.ActionUpdate, events[0].Action)
+	assert.Equal(t, []string{"test1", "test2"}, events[0].MetricIDs())
+	assert.Equal(t, "127.0.0.1", events[0].IPAddress)
 }
 
-func TestAuditMiddleware_InvalidJSON(t *testing.T) {
-	mockReceiver := &MockAuditReceiver{}
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		t.Error("next handler should not be called for invalid JSON")
-	})
-
-	middleware := middleware.AuditMiddleware([]middleware.AuditReceiver{mockReceiver})
-	testHandler := middleware(handler)
-
-	req := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader("invalid json"))
-	rr := httptest.NewRecorder()
-
-	testHandler.ServeHTTP(rr, req)
-
-	if rr.Code != http.StatusBadRequest {
-		t.Errorf("expected status 400 for invalid JSON, got %d", rr.Code)
-	}
-
-	var resp map[string]string
-	json.NewDecoder(rr.Body).Decode(&resp)
-	if resp["error"] != "invalid JSON format" {
-		t.Errorf("expected error message, got %v", resp)
-	}
+// получатель, который всегда возвращает ошибку
+type failingReceiver struct{}
 
-	if len(mockReceiver.events) != 0 {
-		t.Errorf("expected 0 audit events for invalid JSON, got %d", len(mockReceiver.events))
-	}
+func (failingReceiver) Notify(*audit.Event) error {
+	return errors.New("mock error")
 }
 
 func TestAuditMiddleware_ReceiverError(t *testing.T) {
-	mockReceiver := &MockAuditReceiver{}
-	mockReceiver.SetErrors([]error{fmt.Errorf("mock error")})
-
+	d := audit.NewDispatcher([]audit.Receiver{failingReceiver{}}, 10)
 	calledNext := false
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		calledNext = true
 		w.WriteHeader(http.StatusOK)
 	})
 
-	middleware := middleware.AuditMiddleware([]middleware.AuditReceiver{mockReceiver})
-	testHandler := middleware(handler)
-
-	metrics := []model.Metrics{{ID: "test", MType: "gauge", Value: Ptr(1.0)}}
-	body, _ := json.Marshal(metrics)
+	body, _ := json.Marshal([]model.Metrics{{ID: "test", MType: "gauge", Value: Ptr(1.0)}})
 	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
 	rr := httptest.NewRecorder()
+	middleware.AuditMiddleware(d)(next).ServeHTTP(rr, req)
+	require.NoError(t, d.Close(context.Background()))
 
-	testHandler.ServeHTTP(rr, req)
-
-	if !calledNext {
-		t.Error("next handler should be called even if audit receiver fails")
-	}
-
-	if rr.Code != http.StatusOK {
-		t.Errorf("expected status 200, got %d", rr.Code)
-	}
+	assert.True(t, calledNext, "ошибка получателя не мешает обработке запроса")
+	assert.Equal(t, http.StatusOK, rr.Code)
 }
 
-func TestAuditMiddleware_OnlyUpdateEndpoints(t *testing.T) {
-	mockReceiver := &MockAuditReceiver{}
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-	})
-
-	middleware := middleware.AuditMiddleware([]middleware.AuditReceiver{mockReceiver})
-	testHandler := middleware(handler)
-
-	// Test /value endpoint - ДОЛЖЕН триггерить аудит (твоя логика)
-	metrics := []model.Metrics{{ID: "test", MType: "gauge", Value: Ptr(1.0)}}
-	body, _ := json.Marshal(metrics)
-	req := httptest.NewRequest(http.MethodPost, "/value", bytes.NewReader(body))
-	rr := httptest.NewRecorder()
-
-	testHandler.ServeHTTP(rr, req)
-
-	if len(mockReceiver.events) != 1 { // меняем ожидание с 0 на 1
-		t.Errorf("expected 1 audit event for /value endpoint, got %d", len(mockReceiver.events))
-	}
-}
-
-func TestFileAuditReceiver(t *testing.T) {
-	tempDir := t.TempDir()
-	filePath := filepath.Join(tempDir, "audit.log")
-
-	receiver := &middleware.FileAuditReceiver{FilePath: filePath}
-	event := &middleware.AuditEvent{
-		Timestamp: time.Now().Unix(),
-		Metrics:   []string{"metric1", "metric2"},
-		IPAddress: "127.0.0.1:8080",
-	}
-
-	err := receiver.Notify(event)
-	if err != nil {
-		t.Fatalf("failed to write to file: %v", err)
-	}
-
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		t.Fatalf("failed to read file: %v", err)
-	}
+func TestAuditMiddleware_EmptyMetricsArray(t *testing.T) {
+	body, _ := json.Marshal([]model.Metrics{})
+	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
 
-	var loggedEvent middleware.AuditEvent
-	err = json.Unmarshal(content, &loggedEvent)
-	if err != nil {
-		t.Fatalf("failed to unmarshal logged event: %v", err)
-	}
+	rr, events := serveAudited(t, okHandler(http.StatusOK), req)
 
-	if len(loggedEvent.Metrics) != 2 {
-		t.Errorf("expected 2 metrics in log, got %d", len(loggedEvent.Metrics))
-	}
+	assert.Equal(t, http.StatusOK, rr.Code)
+	// пустой массив не содержит метрик, событие не пишется
+	assert.Empty(t, events)
 }
 
-func TestFileAuditReceiver_CreateFile(t *testing.T) {
-	tempDir := t.TempDir()
-	filePath := filepath.Join(tempDir, "new-audit.log")
-
-	// Убедимся что файла нет
-	if _, err := os.Stat(filePath); err == nil {
-		t.Fatal("file should not exist before test")
-	}
-
-	receiver := &middleware.FileAuditReceiver{FilePath: filePath}
-	event := &middleware.AuditEvent{Timestamp: time.Now().Unix(), Metrics: []string{"test"}}
-
-	err := receiver.Notify(event)
-	if err != nil {
-		t.Fatalf("failed to create and write file: %v", err)
-	}
-
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		t.Fatal("file should be created")
-	}
-}
-
-func TestURLAuditReceiver(t *testing.T) {
-	// Создаем тестовый сервер для приема аудит логов
-	receivedEvents := []*middleware.AuditEvent{}
+func TestAuditMiddleware_URLReceiverRetriedThroughQueue(t *testing.T) {
+	var calls atomic.Int32
+	var (
+		mu       sync.Mutex
+		received []audit.Event
+	)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var event middleware.AuditEvent
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event audit.Event
 		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
-			t.Errorf("failed to decode request: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		receivedEvents = append(receivedEvents, &event)
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	receiver := &middleware.URLAuditReceiver{URL: server.URL}
-	event := &middleware.AuditEvent{
-		Timestamp: time.Now().Unix(),
-		Metrics:   []string{"test"},
-		IPAddress: "127.0.0.1:8080",
-	}
+	receiver := &audit.URLReceiver{URL: server.URL, Retries: 2, Backoff: time.Millisecond}
+	d := audit.NewDispatcher([]audit.Receiver{receiver}, 10)
 
-	err := receiver.Notify(event)
-	if err != nil {
-		t.Fatalf("failed to send to URL: %v", err)
-	}
+	req := httptest.NewRequest(http.MethodPost, "/update/gauge/Alloc/1.5", nil)
+	rr := httptest.NewRecorder()
+	middleware.AuditMiddleware(d)(okHandler(http.StatusOK)).ServeHTTP(rr, req)
+	// ответ не ждёт доставки: повтор идёт в фоне из очереди
+	assert.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, d.Close(context.Background()))
+
+	assert.Equal(t, int32(2), calls.Load())
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 1)
+	assert.Equal(t, audit.ActionUpdate, received[0].Action)
+	assert.Equal(t, []string{"Alloc"}, received[0].MetricIDs())
+}
 
-	if len(receivedEvents) != 1 {
-		t.Errorf("expected 1 event on server, got %d", len(receivedEvents))
-	}
+func TestAuditMiddleware_SingleJSON(t *testing.T) {
+	body, _ := json.Marshal(model.Metrics{ID: "Alloc", MType: "gauge", Value: Ptr(2.5)})
+	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
+
+	var handlerBody []byte
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	})
+	rr, events := serveAudited(t, next, req)
+
+	// раньше одиночная метрика отклонялась с 400 до обработчика
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, body, handlerBody)
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.ActionUpdate, events[0].Action)
+	require.Len(t, events[0].Metrics, 1)
+	assert.Equal(t, 2.5, *events[0].Metrics[0].Value)
 }
 
-func TestURLAuditReceiver_ServerError(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
-	defer server.Close()
+func TestAuditMiddleware_TextFormat(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/5", nil)
+	_, events := serveAudited(t, okHandler(http.StatusOK), req)
 
-	receiver := &middleware.URLAuditReceiver{URL: server.URL}
-	event := &middleware.AuditEvent{Timestamp: time.Now().Unix(), Metrics: []string{"test"}}
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.ActionUpdate, events[0].Action)
+	require.Len(t, events[0].Metrics, 1)
+	assert.Equal(t, "PollCount", events[0].Metrics[0].ID)
+	assert.Equal(t, int64(5), *events[0].Metrics[0].Delta)
+}
 
-	err := receiver.Notify(event)
-	if err == nil {
-		t.Error("expected error for non-200 status code")
-	}
+func TestAuditMiddleware_StatusAfterHandler(t *testing.T) {
+	body, _ := json.Marshal([]model.Metrics{{ID: "bad", MType: "unknown"}})
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
 
-	expectedError := "failed to send audit log, status: 500 Internal Server Error"
-	if err.Error() != expectedError {
-		t.Errorf("expected error %q, got %q", expectedError, err.Error())
-	}
+	rr, events := serveAudited(t, okHandler(http.StatusBadRequest), req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	require.Len(t, events, 1)
+	assert.Equal(t, http.StatusBadRequest, events[0].Status)
 }
 
-func TestAuditMiddleware_EmptyMetricsArray(t *testing.T) {
-	mockReceiver := &MockAuditReceiver{}
-	handlerCalled := false
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handlerCalled = true
-		w.WriteHeader(http.StatusOK)
+func TestAuditMiddleware_InvalidJSONReachesHandler(t *testing.T) {
+	calledNext := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledNext = true
+		http.Error(w, "bad json", http.StatusBadRequest)
 	})
+	req := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader("invalid json"))
 
-	middleware := middleware.AuditMiddleware([]middleware.AuditReceiver{mockReceiver})
-	testHandler := middleware(handler)
+	rr, events := serveAudited(t, next, req)
 
-	body, _ := json.Marshal([]model.Metrics{}) // Пустой массив
-	req := httptest.NewRequest(http.MethodPost, "/update", bytes.NewReader(body))
-	rr := httptest.NewRecorder()
+	assert.True(t, calledNext, "ответ на неверный JSON даёт обработчик")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Empty(t, events)
+}
 
-	testHandler.ServeHTTP(rr, req)
+func TestAuditMiddleware_Reads(t *testing.T) {
+	body, _ := json.Marshal(model.Metrics{ID: "Alloc", MType: "gauge"})
+	req := httptest.NewRequest(http.MethodPost, "/value", bytes.NewReader(body))
+	_, events := serveAudited(t, okHandler(http.StatusOK), req)
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.ActionRead, events[0].Action)
+
+	req = httptest.NewRequest(http.MethodGet, "/value/gauge/Alloc", nil)
+	_, events = serveAudited(t, okHandler(http.StatusNotFound), req)
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.ActionRead, events[0].Action)
+	assert.Equal(t, []model.Metrics{{ID: "Alloc", MType: "gauge"}}, events[0].Metrics)
+	assert.Equal(t, http.StatusNotFound, events[0].Status)
+}
 
-	if !handlerCalled {
-		t.Error("handler should be called for empty metrics array")
-	}
+func TestAuditMiddleware_OnlyUpdateEndpoints(t *testing.T) {
+	// /value тоже аудируется, как чтение
+	body, _ := json.Marshal([]model.Metrics{{ID: "test", MType: "gauge", Value: Ptr(1.0)}})
+	req := httptest.NewRequest(http.MethodPost, "/value", bytes.NewReader(body))
+	_, events := serveAudited(t, okHandler(http.StatusOK), req)
 
-	if rr.Code != http.StatusOK {
-		t.Errorf("expected status 200 for empty metrics, got %d", rr.Code)
-	}
+	require.Len(t, events, 1)
+	assert.Equal(t, audit.ActionRead, events[0].Action)
+	assert.Equal(t, []string{"test"}, events[0].MetricIDs())
+}
 
-	// Ожидаем 0 событий аудита, т.к. пустой массив пропускается
-	if len(mockReceiver.events) != 0 {
-		t.Errorf("expected 0 audit events for empty metrics, got %d", len(mockReceiver.events))
+func TestAuditMiddleware_NotAudited(t *testing.T) {
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/", nil),
+		httptest.NewRequest(http.MethodGet, "/ping", nil),
+		httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("[]")),
+	} {
+		_, events := serveAudited(t, okHandler(http.StatusOK), req)
+		assert.Empty(t, events, req.URL.Path)
 	}
 }
+
 func TestAuditMiddleware_RequestBodyReadError(t *testing.T) {
-	mockReceiver := &MockAuditReceiver{}
-	handlerCalled := false
-	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handlerCalled = true
-		w.WriteHeader(http.StatusOK)
+	var readErr error
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusBadRequest)
 	})
 
-	middleware := middleware.AuditMiddleware([]middleware.AuditReceiver{mockReceiver})
-	testHandler := middleware(handler)
-
-	// Создаем запрос с телом, которое вызовет ошибку при чтении
 	req := httptest.NewRequest(http.MethodPost, "/update", errorReader{})
-	rr := httptest.NewRecorder()
+	_, events := serveAudited(t, next, req)
 
-	testHandler.ServeHTTP(rr, req)
+	assert.ErrorIs(t, readErr, io.ErrUnexpectedEOF, "обработчик получает ошибку чтения")
+	assert.Empty(t, events)
+}
 
-	if !handlerCalled {
-		t.Error("next handler should be called even if body read fails")
-	}
+func TestAuditMiddleware_Disabled(t *testing.T) {
+	next := okHandler(http.StatusOK)
+	handler := middleware.AuditMiddleware(nil)(next)
 
-	if len(mockReceiver.events) != 0 {
-		t.Errorf("expected 0 audit events for read error, got %d", len(mockReceiver.events))
-	}
+	req := httptest.NewRequest(http.MethodPost, "/update/gauge/a/1", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
 }
 
 // Вспомогательная функция
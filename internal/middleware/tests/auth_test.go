@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/auth"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuth_MetricPrefixInBody(t *testing.T) {
+	store, err := auth.NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+	require.NoError(t, err)
+	a := auth.NewAuthenticator(store)
+	token, _, err := a.Issue(context.Background(), "agent", []auth.Scope{auth.ScopeWrite}, "app_")
+	require.NoError(t, err)
+
+	m := middleware.NewAuth(a)
+	r := chi.NewRouter()
+	r.Use(m.Authenticate)
+	var received string
+	r.With(m.Require(auth.ScopeWrite)).Post("/updates/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "массив в пределах префикса", body: `[{"id":"app_a"},{"id":"app_b"}]`, status: http.StatusOK},
+		{name: "одна метрика", body: `{"id":"app_a"}`, status: http.StatusOK},
+		{name: "одна метрика вне префикса", body: `[{"id":"app_a"},{"id":"sys_b"}]`, status: http.StatusForbidden},
+		{name: "битый JSON", body: `[{"id":`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received = ""
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.status, rr.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, tt.body, received, "тело должно дойти до обработчика")
+			}
+		})
+	}
+}
+
+func TestAuth_Disabled(t *testing.T) {
+	m := middleware.NewAuth(nil)
+	called := false
+	handler := m.Authenticate(m.Require(auth.ScopeAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.True(t, called)
+}
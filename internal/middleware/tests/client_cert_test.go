@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientCertMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    *tls.ConnectionState
+		expected string
+	}{
+		{name: "plain http", state: nil, expected: ""},
+		{name: "tls without client cert", state: &tls.ConnectionState{}, expected: ""},
+		{
+			name: "common name",
+			state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{Subject: pkix.Name{CommonName: "agent-1"}, DNSNames: []string{"agent-1.local"}},
+			}},
+			expected: "agent-1",
+		},
+		{
+			name: "dns name fallback",
+			state: &tls.ConnectionState{PeerCertificates: []*x509.Certificate{
+				{DNSNames: []string{"agent-2.local"}},
+			}},
+			expected: "agent-2.local",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := middleware.ClientCertMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = middleware.GetAgentIDFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			req.TLS = tt.state
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
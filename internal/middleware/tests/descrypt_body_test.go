@@ -16,6 +16,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/envelope"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
 )
 
@@ -83,8 +85,8 @@ func TestDecryptMiddleware(t *testing.T) {
 		}
 	})
 
-	// middleware
-	mw := middleware.DecryptMiddleware(privPath)
+	// middleware, старые форматы включены флагом совместимости
+	mw := middleware.DecryptMiddleware(privPath, true)
 	testHandler := mw(handler)
 
 	tests := []struct {
@@ -107,6 +109,14 @@ func TestDecryptMiddleware(t *testing.T) {
 				return encryptHybrid(pub, []byte("secret message"))
 			},
 		},
+		{
+			name:   "envelope decryption",
+			header: envelope.HeaderValue,
+			encryptFn: func() []byte {
+				sealed, _ := envelope.Seal(pub, envelope.KeyID(pub), []byte("secret message"))
+				return sealed
+			},
+		},
 		{
 			name:      "no encryption",
 			header:    "",
@@ -138,3 +148,94 @@ func TestDecryptMiddleware(t *testing.T) {
 		})
 	}
 }
+
+func TestDecryptMiddleware_LegacyDisabled(t *testing.T) {
+	_, pub, privPath := generateRSAKeys(t)
+	defer os.Remove(privPath)
+
+	handlerCalled := false
+	handler := middleware.DecryptMiddleware(privPath, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(encryptHybrid(pub, []byte("secret message"))))
+	req.Header.Set("X-Encrypted", "hybrid")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if handlerCalled {
+		t.Error("legacy payload must be rejected without compatibility flag")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestDecryptMiddleware_EnvelopeTampered(t *testing.T) {
+	_, pub, privPath := generateRSAKeys(t)
+	defer os.Remove(privPath)
+
+	handler := middleware.DecryptMiddleware(privPath, false)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not be called for tampered payload")
+	}))
+
+	sealed, err := envelope.Seal(pub, envelope.KeyID(pub), []byte("secret message"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 0x01
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(sealed))
+	req.Header.Set("X-Encrypted", envelope.HeaderValue)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", w.Code)
+	}
+}
+
+func TestDecryptMiddlewareWithKeyring_PreviousKey(t *testing.T) {
+	_, _, currentPath := generateRSAKeys(t)
+	defer os.Remove(currentPath)
+	_, previousPub, previousPath := generateRSAKeys(t)
+	defer os.Remove(previousPath)
+
+	keys, err := keyring.New(keyring.Config{PrivateKeyFiles: []string{currentPath, previousPath}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	handler := middleware.DecryptMiddlewareWithKeyring(keys, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = io.ReadAll(r.Body)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+	}{
+		{name: "envelope", header: envelope.HeaderValue},
+		{name: "hybrid", header: "hybrid", body: encryptHybrid(previousPub, []byte("secret message"))},
+		{name: "rsa", header: "rsa", body: encryptRSA(previousPub, []byte("secret message"))},
+	}
+	tests[0].body, _ = envelope.Seal(previousPub, envelope.KeyID(previousPub), []byte("secret message"))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			req.Header.Set("X-Encrypted", tt.header)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("unexpected status code %d", w.Code)
+			}
+			if string(got) != "secret message" {
+				t.Errorf("body mismatch, got %q", got)
+			}
+		})
+	}
+}
@@ -5,8 +5,10 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/clientip"
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetRealIPMiddleware_FromHeader(t *testing.T) {
@@ -17,7 +19,10 @@ func TestGetRealIPMiddleware_FromHeader(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	handler := middleware.GetRealIPMiddleware(next)
+	// httptest.NewRequest ставит RemoteAddr 192.0.2.1:1234
+	proxies, err := clientip.NewResolver([]string{"192.0.2.0/24"})
+	require.NoError(t, err)
+	handler := middleware.RealIPMiddleware(proxies)(next)
 
 	req := httptest.NewRequest(http.MethodGet, "/", nil)
 	req.Header.Set("X-Real-IP", "10.10.1.92")
@@ -29,6 +34,51 @@ func TestGetRealIPMiddleware_FromHeader(t *testing.T) {
 	assert.Equal(t, "10.10.1.92", gotIP)
 }
 
+func TestGetRealIPMiddleware_UntrustedHeaderIgnored(t *testing.T) {
+	var gotIP string
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIP = middleware.GetRealIPFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := middleware.GetRealIPMiddleware(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "203.0.113.5:4000"
+	req.Header.Set("X-Real-IP", "10.10.1.92")
+	req.Header.Set("X-Forwarded-For", "10.10.1.93")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "203.0.113.5", gotIP)
+}
+
+func TestGetRealIPMiddleware_ForwardedFor(t *testing.T) {
+	var gotIP string
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIP = middleware.GetRealIPFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	proxies, err := clientip.NewResolver([]string{"192.0.2.0/24", "10.0.0.0/8"})
+	require.NoError(t, err)
+	handler := middleware.RealIPMiddleware(proxies)(next)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	// левый адрес подставил клиент, его брать нельзя
+	req.Header.Set("X-Forwarded-For", "10.10.1.92, 198.51.100.7, 10.0.0.2")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "198.51.100.7", gotIP)
+}
+
 func TestGetRealIPMiddleware_FromRemoteAddr(t *testing.T) {
 	var gotIP string
 
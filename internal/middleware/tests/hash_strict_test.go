@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
+	middlwar "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// подписанный запрос в формате агента
+func signedRequest(key, path, body, ts, nonce string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	content := body
+	if content == "" {
+		content = strings.ReplaceAll(strings.TrimPrefix(path, "/update/"), "/", ":")
+	}
+	req.Header.Set(keyring.HeaderTimestamp, ts)
+	req.Header.Set(keyring.HeaderNonce, nonce)
+	req.Header.Set(keyring.HeaderSignature, keyring.ComputeHMAC(key, keyring.SignedPayload(keyring.Route(http.MethodPost, path), ts, nonce, []byte(content))))
+	return req
+}
+
+func serveHash(mw *middlwar.HashMiddleware, req *http.Request) (int, bool) {
+	called := false
+	handler := mw.CheckHash(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Code, called
+}
+
+func now() string {
+	return strconv.FormatInt(time.Now().Unix(), 10)
+}
+
+func TestHashMiddleware_Strict(t *testing.T) {
+	mw := middlwar.NewHashMiddlewareWithKeyring(keyring.Static("secret"), middlwar.WithStrictHash())
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{
+			name:   "валидная подпись",
+			req:    func() *http.Request { return signedRequest("secret", "/updates/", `[{"id":"cpu"}]`, now(), "n1") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "подпись text формата по пути",
+			req:    func() *http.Request { return signedRequest("secret", "/update/gauge/cpu/1.5", "", now(), "n2") },
+			status: http.StatusOK,
+		},
+		{
+			name:   "неверный ключ",
+			req:    func() *http.Request { return signedRequest("other", "/updates/", `[{"id":"cpu"}]`, now(), "n3") },
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "подмена тела",
+			req: func() *http.Request {
+				req := signedRequest("secret", "/updates/", `[{"id":"cpu"}]`, now(), "n4")
+				req.Body = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`[{"id":"mem"}]`)).Body
+				return req
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "без подписи",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[]`))
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "подпись с другого маршрута",
+			req: func() *http.Request {
+				req := signedRequest("secret", "/updates/", `[{"id":"cpu"}]`, now(), "n5")
+				req.URL.Path = "/update"
+				return req
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "OTLP без подписи",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodPost, "/v1/metrics", strings.NewReader(`{}`))
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "GET без подписи",
+			req: func() *http.Request {
+				return httptest.NewRequest(http.MethodGet, "/", nil)
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, called := serveHash(mw, tt.req())
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.status == http.StatusOK, called)
+		})
+	}
+}
+
+func TestHashMiddleware_NotStrictPassesMismatch(t *testing.T) {
+	mw := middlwar.NewHashMiddlewareWithKeyring(keyring.Static("secret"))
+
+	status, called := serveHash(mw, signedRequest("other", "/updates/", `[]`, now(), "n1"))
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, called)
+}
+
+func TestHashMiddleware_ReplayWindow(t *testing.T) {
+	mw := middlwar.NewHashMiddlewareWithKeyring(keyring.Static("secret"),
+		middlwar.WithStrictHash(), middlwar.WithReplayWindow(time.Minute))
+	body := `[{"id":"cpu"}]`
+
+	t.Run("повтор nonce отклоняется", func(t *testing.T) {
+		ts := now()
+		status, _ := serveHash(mw, signedRequest("secret", "/updates/", body, ts, "once"))
+		assert.Equal(t, http.StatusOK, status)
+
+		status, called := serveHash(mw, signedRequest("secret", "/updates/", body, ts, "once"))
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.False(t, called)
+	})
+
+	t.Run("устаревшая метка времени", func(t *testing.T) {
+		old := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+		status, _ := serveHash(mw, signedRequest("secret", "/updates/", body, old, "stale"))
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("без метки времени", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		req.Header.Set(keyring.HeaderSignature, keyring.ComputeHMAC("secret", []byte(body)))
+		status, _ := serveHash(mw, req)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+
+	t.Run("подмена метки времени ломает подпись", func(t *testing.T) {
+		req := signedRequest("secret", "/updates/", body, strconv.FormatInt(time.Now().Add(-30*time.Second).Unix(), 10), "moved")
+		req.Header.Set(keyring.HeaderTimestamp, now())
+		status, _ := serveHash(mw, req)
+		assert.Equal(t, http.StatusBadRequest, status)
+	})
+}
+
+func TestHashMiddleware_AgentKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agents.keys")
+	require.NoError(t, os.WriteFile(path, []byte("agent-1 agent-1-key\n"), 0o600))
+	keys, err := keyring.New(keyring.Config{HashKey: "shared", AgentKeysFile: path})
+	require.NoError(t, err)
+	mw := middlwar.NewHashMiddlewareWithKeyring(keys, middlwar.WithStrictHash())
+
+	tests := []struct {
+		name    string
+		agentID string
+		key     string
+		status  int
+	}{
+		{name: "агент со своим ключом", agentID: "agent-1", key: "agent-1-key", status: http.StatusOK},
+		{name: "агенту со своим ключом общий не подходит", agentID: "agent-1", key: "shared", status: http.StatusBadRequest},
+		{name: "агент без своего ключа", agentID: "agent-2", key: "shared", status: http.StatusOK},
+		{name: "без идентификатора", key: "shared", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(tt.key, "/updates/", `[]`, now(), tt.name)
+			if tt.agentID != "" {
+				req.Header.Set(keyring.HeaderAgentID, tt.agentID)
+			}
+			status, _ := serveHash(mw, req)
+			assert.Equal(t, tt.status, status)
+		})
+	}
+}
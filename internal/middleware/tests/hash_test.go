@@ -9,9 +9,12 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/crypto/keyring"
 	middlwar "github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -382,3 +385,25 @@ func computeExpectedHash(key, data string) string {
 	hmacHash.Write([]byte(data))
 	return hex.EncodeToString(hmacHash.Sum(nil))
 }
+
+func TestHashMiddleware_Keyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hmac.keys")
+	require.NoError(t, os.WriteFile(path, []byte("old-key\n"), 0o600))
+	keys, err := keyring.New(keyring.Config{HashKey: "new-key", HashKeyFile: path})
+	require.NoError(t, err)
+
+	mw := middlwar.NewHashMiddlewareWithKeyring(keys)
+	handler := mw.CheckHash(mw.AddHash(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+
+	body := `{"id":"cpu"}`
+	req := httptest.NewRequest(http.MethodPost, "/update", strings.NewReader(body))
+	req.Header.Set("HashSHA256", computeExpectedHash("old-key", body))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	// ответ подписывается текущим ключом
+	assert.Equal(t, computeExpectedHash("new-key", "ok"), w.Header().Get("HashSHA256"))
+}
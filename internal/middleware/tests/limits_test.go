@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/limits"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoBody() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	l := limits.New(limits.Config{Rate: 1, Burst: 2})
+	handler := middleware.RateLimitMiddleware(l)(echoBody())
+
+	send := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.RealIPCtxKey{}, ip))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, send("10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, send("10.0.0.1").Code)
+	rr := send("10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, send("10.0.0.2").Code)
+}
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	l := limits.New(limits.Config{MaxBodyBytes: 10})
+	handler := middleware.BodyLimitMiddleware(l)(echoBody())
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("0123456789"))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "0123456789", rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader("0123456789A"))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+
+	// без Content-Length размер проверяется при чтении
+	req = httptest.NewRequest(http.MethodPost, "/updates/", io.NopCloser(strings.NewReader("0123456789A")))
+	req.ContentLength = -1
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func TestGzipDecompressionWithLimit_Bomb(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(bytes.Repeat([]byte{'0'}, 1<<20))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	// мегабайт нулей сжимается в несколько килобайт
+	require.Less(t, compressed.Len(), 1<<14)
+
+	l := limits.New(limits.Config{MaxBodyBytes: 1 << 16})
+	handler := middleware.BodyLimitMiddleware(l)(middleware.GzipDecompressionWithLimit(l)(echoBody()))
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(compressed.Bytes()))
+	req.Header.Set("Content-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	metrics := l.Snapshot()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "server_self_requests_body_too_large", metrics[0].ID)
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/middleware"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTrustedSubnetMiddleware_AllowedIP(t *testing.T) {
@@ -18,7 +22,8 @@ func TestTrustedSubnetMiddleware_AllowedIP(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	mw := middleware.TrustedSubnetMiddleware("192.168.1.0/24")
+	mw, err := middleware.TrustedSubnetMiddleware("192.168.1.0/24")
+	require.NoError(t, err)
 	handler := mw(next)
 
 	req := httptest.NewRequest(http.MethodPost, "/update", nil)
@@ -40,7 +45,8 @@ func TestTrustedSubnetMiddleware_ForbiddenIP(t *testing.T) {
 		called = true
 	})
 
-	mw := middleware.TrustedSubnetMiddleware("192.168.1.0/24")
+	mw, err := middleware.TrustedSubnetMiddleware("192.168.1.0/24")
+	require.NoError(t, err)
 	handler := mw(next)
 
 	req := httptest.NewRequest(http.MethodPost, "/update", nil)
@@ -63,7 +69,8 @@ func TestTrustedSubnetMiddleware_EmptySubnet(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	mw := middleware.TrustedSubnetMiddleware("")
+	mw, err := middleware.TrustedSubnetMiddleware("")
+	require.NoError(t, err)
 	handler := mw(next)
 
 	req := httptest.NewRequest(http.MethodPost, "/update", nil)
@@ -74,3 +81,64 @@ func TestTrustedSubnetMiddleware_EmptySubnet(t *testing.T) {
 	assert.True(t, called)
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
+
+func TestTrustedSubnetMiddleware_InvalidCIDR(t *testing.T) {
+	_, err := middleware.TrustedSubnetMiddleware("192.168.1.0/33")
+	assert.Error(t, err)
+}
+
+func TestTrustedSubnetMiddleware_MultipleAndIPv6(t *testing.T) {
+	mw, err := middleware.TrustedSubnetMiddleware("192.168.1.0/24, 2001:db8::/32")
+	require.NoError(t, err)
+	handler := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for ip, want := range map[string]int{
+		"192.168.1.10": http.StatusOK,
+		"2001:db8::1":  http.StatusOK,
+		"2001:db9::1":  http.StatusForbidden,
+		"":             http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/update", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.RealIPCtxKey{}, ip))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, want, rr.Code, ip)
+	}
+}
+
+func TestSubnetPolicyMiddleware_PerRoute(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{
+		"default": {"allow": ["10.0.0.0/8"]},
+		"routes": {"read": {"allow": ["10.0.0.0/8", "192.168.0.0/16"], "deny": ["192.168.66.0/24"]}}
+	}`), 0o600))
+	policy, err := netpolicy.New(netpolicy.Config{File: file})
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	read := middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassRead)(ok)
+	write := middleware.SubnetPolicyMiddleware(policy, netpolicy.ClassWrite)(ok)
+
+	serve := func(h http.Handler, ip string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.RealIPCtxKey{}, ip))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve(read, "192.168.1.5"))
+	assert.Equal(t, http.StatusForbidden, serve(read, "192.168.66.5"))
+	assert.Equal(t, http.StatusForbidden, serve(write, "192.168.1.5"))
+	assert.Equal(t, http.StatusOK, serve(write, "10.1.1.1"))
+
+	// перечитанная политика применяется без пересоздания middleware
+	require.NoError(t, os.WriteFile(file, []byte(`{"routes": {"write": {"deny": ["10.1.0.0/16"]}}}`), 0o600))
+	require.NoError(t, policy.Reload())
+	assert.Equal(t, http.StatusForbidden, serve(write, "10.1.1.1"))
+	assert.Equal(t, http.StatusOK, serve(read, "192.168.66.5"))
+}
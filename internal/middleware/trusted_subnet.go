@@ -1,36 +1,30 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
-)
+	"strings"
 
-func TrustedSubnetMiddleware(cidr string) func(http.Handler) http.Handler {
-	if cidr == "" { // если пусто выходим
-		return func(next http.Handler) http.Handler {
-			return next
-		}
-	}
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
+)
 
-	_, subnet, err := net.ParseCIDR(cidr)
-	if err != nil { // если конфиг битый
-		panic("invalid trusted_subnet CIDR: " + cidr)
+// пропускает запросы только из перечисленных через запятую сетей; пустая строка — без ограничений
+func TrustedSubnetMiddleware(cidr string) (func(http.Handler) http.Handler, error) {
+	policy, err := netpolicy.New(netpolicy.Config{Allow: strings.Split(cidr, ",")})
+	if err != nil {
+		return nil, err
 	}
+	return SubnetPolicyMiddleware(policy, netpolicy.ClassDefault), nil
+}
 
+// проверяет IP из context по правилу политики для класса маршрутов.
+// правила читаются на каждом запросе, поэтому перечитанная политика применяется сразу.
+func SubnetPolicyMiddleware(policy *netpolicy.Policy, class netpolicy.Class) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			ipStr := GetRealIPFromContext(r.Context())
-			if ipStr == "" {
+			if err := policy.Check(class, GetRealIPFromContext(r.Context())); err != nil {
 				http.Error(w, "forbidden", http.StatusForbidden)
 				return
 			}
-
-			ip := net.ParseIP(ipStr)
-			if ip == nil || !subnet.Contains(ip) {
-				http.Error(w, "forbidden", http.StatusForbidden)
-				return
-			}
-
 			next.ServeHTTP(w, r)
 		})
 	}
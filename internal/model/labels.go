@@ -0,0 +1,73 @@
+package model
+
+import (
+	"sort"
+	"strings"
+)
+
+// собирает идентификатор серии из имени метрики и набора меток.
+// метки сортируются по ключу, чтобы одна и та же серия всегда давала один ID,
+// формат совпадает с текстовым форматом Prometheus: name{key="value",...}.
+// если меток нет, возвращается имя без изменений.
+func SeriesID(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeLabelValue(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(v)
+}
+
+// имя метрики в стиле Prometheus: точки и прочие символы заменяются на _
+func SanitizeName(name string) string {
+	return sanitize(name, func(r rune) bool { return r == ':' })
+}
+
+// ключ метки: буквы, цифры и _, service.name становится service_name
+func SanitizeLabel(key string) string {
+	return sanitize(key, func(rune) bool { return false })
+}
+
+func sanitize(s string, extra func(rune) bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || extra(r):
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
@@ -31,6 +31,13 @@ type MetricsCollector interface {
 	CollectSystemMetrics() []Metrics
 }
 
+// интерфейс дополнительного источника метрик (скрейпинг, процессы, cgroup и т.д.).
+// источники опрашиваются агентом вместе с системными метриками.
+type MetricsSource interface {
+	// собирает метрики источника, ошибки источник обрабатывает сам.
+	Collect() []Metrics
+}
+
 // интерфейс для отправки метрик на сервер.
 type MetricsSender interface {
 	// отправляет массив метрик с переданным контекстом для управления таймаутами и отменой операции.
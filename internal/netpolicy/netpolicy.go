@@ -0,0 +1,199 @@
+// Package netpolicy решает, с каких адресов разрешены запросы. правило состоит из
+// списков разрешённых и запрещённых сетей (IPv4 и IPv6), запрет важнее разрешения.
+// у каждого класса маршрутов (чтение, запись, администрирование) может быть своё
+// правило, иначе действует правило по умолчанию. политика общая для HTTP и gRPC.
+package netpolicy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// класс маршрутов
+type Class string
+
+const (
+	ClassDefault Class = ""      // маршруты без своего класса: /ping, swagger
+	ClassRead    Class = "read"  // чтение метрик
+	ClassWrite   Class = "write" // запись метрик
+	ClassAdmin   Class = "admin" // администрирование
+)
+
+// правило в конфиге: сети в виде CIDR или отдельных адресов
+type Rule struct {
+	Allow []string `json:"allow,omitempty"` // пусто — разрешены все, кроме Deny
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// содержимое файла политики
+type File struct {
+	Default *Rule          `json:"default,omitempty"` // заменяет правило из Config.Allow и Config.Deny
+	Routes  map[Class]Rule `json:"routes,omitempty"`
+}
+
+// источники политики. файл перечитывается при Reload, списки из конфига — нет.
+type Config struct {
+	Allow []string // trusted_subnet, правило по умолчанию
+	Deny  []string
+	File  string // JSON файл с правилами по классам маршрутов
+}
+
+var ErrForbidden = errors.New("netpolicy: address not allowed")
+
+type rule struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+type Policy struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	def    rule
+	routes map[Class]rule
+}
+
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{cfg: cfg}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// перечитывает файл политики. при ошибке остаются прежние правила.
+func (p *Policy) Reload() error {
+	def, err := compile(Rule{Allow: p.cfg.Allow, Deny: p.cfg.Deny})
+	if err != nil {
+		return err
+	}
+	routes := make(map[Class]rule)
+
+	if p.cfg.File != "" {
+		data, err := os.ReadFile(p.cfg.File)
+		if err != nil {
+			return fmt.Errorf("netpolicy: %w", err)
+		}
+		var f File
+		if err := json.Unmarshal(data, &f); err != nil {
+			return fmt.Errorf("netpolicy: parse %s: %w", p.cfg.File, err)
+		}
+		if f.Default != nil {
+			if def, err = compile(*f.Default); err != nil {
+				return err
+			}
+		}
+		for class, r := range f.Routes {
+			switch class {
+			case ClassRead, ClassWrite, ClassAdmin:
+			default:
+				return fmt.Errorf("netpolicy: unknown route class %q", class)
+			}
+			if routes[class], err = compile(r); err != nil {
+				return fmt.Errorf("netpolicy: route %s: %w", class, err)
+			}
+		}
+	}
+
+	p.mu.Lock()
+	p.def = def
+	p.routes = routes
+	p.mu.Unlock()
+	return nil
+}
+
+// true, если для класса заданы какие-либо ограничения
+func (p *Policy) Restricted(class Class) bool {
+	if p == nil {
+		return false
+	}
+	r := p.rule(class)
+	return len(r.allow) > 0 || len(r.deny) > 0
+}
+
+// nil, если запрос с адреса ip разрешён для класса маршрутов.
+// пустой или нераспознанный адрес разрешён только без ограничений.
+func (p *Policy) Check(class Class, ip string) error {
+	if p == nil {
+		return nil
+	}
+	r := p.rule(class)
+	if len(r.allow) == 0 && len(r.deny) == 0 {
+		return nil
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ErrForbidden
+	}
+	if contains(r.deny, addr) {
+		return ErrForbidden
+	}
+	if len(r.allow) > 0 && !contains(r.allow, addr) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (p *Policy) rule(class Class) rule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if r, ok := p.routes[class]; ok && class != ClassDefault {
+		return r
+	}
+	return p.def
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func compile(r Rule) (rule, error) {
+	allow, err := ParseNetworks(r.Allow)
+	if err != nil {
+		return rule{}, err
+	}
+	deny, err := ParseNetworks(r.Deny)
+	if err != nil {
+		return rule{}, err
+	}
+	return rule{allow: allow, deny: deny}, nil
+}
+
+// разбирает список CIDR; отдельный адрес считается сетью /32 или /128, пустые значения пропускаются
+func ParseNetworks(values []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range values {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("netpolicy: invalid network %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if v4 := ip.To4(); v4 != nil {
+				ip, bits = v4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("netpolicy: invalid network %q: %w", s, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/netpolicy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_AllowDeny(t *testing.T) {
+	p, err := netpolicy.New(netpolicy.Config{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Deny:  []string{"10.66.0.0/16", "2001:db8:dead::/48"},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Check(netpolicy.ClassWrite, "10.1.2.3"))
+	assert.NoError(t, p.Check(netpolicy.ClassRead, "2001:db8::1"))
+	assert.ErrorIs(t, p.Check(netpolicy.ClassWrite, "10.66.1.1"), netpolicy.ErrForbidden)
+	assert.ErrorIs(t, p.Check(netpolicy.ClassWrite, "2001:db8:dead::1"), netpolicy.ErrForbidden)
+	assert.ErrorIs(t, p.Check(netpolicy.ClassWrite, "192.168.1.1"), netpolicy.ErrForbidden)
+	assert.ErrorIs(t, p.Check(netpolicy.ClassWrite, ""), netpolicy.ErrForbidden)
+}
+
+func TestPolicy_DenyOnly(t *testing.T) {
+	p, err := netpolicy.New(netpolicy.Config{Deny: []string{"203.0.113.7"}})
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Check(netpolicy.ClassRead, "203.0.113.8"))
+	assert.Error(t, p.Check(netpolicy.ClassRead, "203.0.113.7"))
+}
+
+func TestPolicy_Unrestricted(t *testing.T) {
+	p, err := netpolicy.New(netpolicy.Config{})
+	require.NoError(t, err)
+
+	assert.False(t, p.Restricted(netpolicy.ClassWrite))
+	assert.NoError(t, p.Check(netpolicy.ClassWrite, ""))
+
+	var nilPolicy *netpolicy.Policy
+	assert.NoError(t, nilPolicy.Check(netpolicy.ClassWrite, "1.2.3.4"))
+}
+
+func TestPolicy_InvalidConfig(t *testing.T) {
+	_, err := netpolicy.New(netpolicy.Config{Allow: []string{"10.0.0.0/33"}})
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"routes": {"delete": {"allow": ["10.0.0.0/8"]}}}`), 0o600))
+	_, err = netpolicy.New(netpolicy.Config{File: file})
+	assert.Error(t, err)
+
+	_, err = netpolicy.New(netpolicy.Config{File: filepath.Join(t.TempDir(), "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestPolicy_ReloadKeepsPreviousOnError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"routes": {"write": {"allow": ["10.0.0.0/8"]}}}`), 0o600))
+
+	p, err := netpolicy.New(netpolicy.Config{Allow: []string{"192.168.0.0/16"}, File: file})
+	require.NoError(t, err)
+	assert.NoError(t, p.Check(netpolicy.ClassWrite, "10.0.0.1"))
+	assert.Error(t, p.Check(netpolicy.ClassWrite, "192.168.0.1"))
+	// у чтения своего правила нет, действует правило по умолчанию
+	assert.NoError(t, p.Check(netpolicy.ClassRead, "192.168.0.1"))
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"routes": `), 0o600))
+	assert.Error(t, p.Reload())
+	assert.NoError(t, p.Check(netpolicy.ClassWrite, "10.0.0.1"))
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"default": {"allow": ["172.16.0.0/12"]}}`), 0o600))
+	require.NoError(t, p.Reload())
+	assert.Error(t, p.Check(netpolicy.ClassWrite, "10.0.0.1"))
+	assert.NoError(t, p.Check(netpolicy.ClassWrite, "172.16.0.1"))
+}
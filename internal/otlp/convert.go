@@ -0,0 +1,236 @@
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/cumulative"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+// переводит OTLP в метрики сервиса.
+//
+// gauge становится gauge. монотонная sum становится counter: у дельта-рядов
+// значения прибавляются, у накопительных отправляется прирост с прошлой точки,
+// сброс определяется по смене start_time или уменьшению значения.
+// немонотонная накопительная sum — это текущее значение, она становится gauge.
+// гистограммы и summary раскладываются как в формате Prometheus:
+// name_bucket{le} и name_count — counter, name_sum и квантили summary — gauge.
+// атрибуты ресурса и точки становятся метками ряда, атрибуты точки важнее.
+type Converter struct {
+	tracker *cumulative.Tracker
+}
+
+func NewConverter() *Converter {
+	return &Converter{tracker: cumulative.NewTracker()}
+}
+
+// результат перевода одного запроса
+type Batch struct {
+	Metrics  []model.Metrics
+	Rejected int64    // отклонённых точек
+	Errors   []string // причины отклонения, без повторов
+
+	changes *cumulative.Changes
+}
+
+// сообщение для partial_success
+func (b *Batch) Message() string {
+	return strings.Join(b.Errors, "; ")
+}
+
+func (b *Batch) reject(n int, format string, args ...any) {
+	b.Rejected += int64(n)
+	msg := fmt.Sprintf(format, args...)
+	for _, e := range b.Errors {
+		if e == msg {
+			return
+		}
+	}
+	b.Errors = append(b.Errors, msg)
+}
+
+// переводит запрос. состояние накопительных рядов меняется только в Commit,
+// после того как метрики записаны.
+func (c *Converter) Convert(req *ExportRequest) *Batch {
+	b := &Batch{changes: c.tracker.Begin()}
+	for _, rm := range req.ResourceMetrics {
+		resource := attributes(nil, rm.Resource.Attributes)
+		for _, sm := range rm.ScopeMetrics {
+			for _, m := range sm.Metrics {
+				c.convertMetric(b, resource, &m)
+			}
+		}
+	}
+	return b
+}
+
+// запоминает накопленные значения записанного батча
+func (c *Converter) Commit(b *Batch) {
+	c.tracker.Commit(b.changes)
+}
+
+func (c *Converter) convertMetric(b *Batch, resource map[string]string, m *Metric) {
+	name := model.SanitizeName(m.Name)
+	if name == "" {
+		b.reject(countPoints(m), "metric name is required")
+		return
+	}
+
+	switch {
+	case m.Gauge != nil:
+		for _, p := range m.Gauge.DataPoints {
+			v, ok := p.value()
+			if !ok {
+				b.reject(1, "%s: data point without value", m.Name)
+				continue
+			}
+			b.gauge(seriesID(name, "", resource, p.Attributes), v)
+		}
+
+	case m.Sum != nil:
+		temporality := m.Sum.AggregationTemporality
+		if temporality != TemporalityDelta && temporality != TemporalityCumulative {
+			b.reject(len(m.Sum.DataPoints), "%s: unspecified aggregation temporality", m.Name)
+			return
+		}
+		for _, p := range m.Sum.DataPoints {
+			v, ok := p.value()
+			if !ok {
+				b.reject(1, "%s: data point without value", m.Name)
+				continue
+			}
+			id := seriesID(name, "", resource, p.Attributes)
+			if !m.Sum.IsMonotonic && temporality == TemporalityCumulative {
+				b.gauge(id, v)
+				continue
+			}
+			c.counter(b, id, temporality, uint64(p.StartTimeUnixNano), v)
+		}
+
+	case m.Histogram != nil:
+		temporality := m.Histogram.AggregationTemporality
+		if temporality != TemporalityDelta && temporality != TemporalityCumulative {
+			b.reject(len(m.Histogram.DataPoints), "%s: unspecified aggregation temporality", m.Name)
+			return
+		}
+		for _, p := range m.Histogram.DataPoints {
+			if len(p.BucketCounts) != 0 && len(p.BucketCounts) != len(p.ExplicitBounds)+1 {
+				b.reject(1, "%s: bucket counts do not match bounds", m.Name)
+				continue
+			}
+			start := uint64(p.StartTimeUnixNano)
+			// бакеты в OTLP не накопительные, в Prometheus — накопительные по le
+			var cumulative uint64
+			for i, count := range p.BucketCounts {
+				cumulative += uint64(count)
+				le := "+Inf"
+				if i < len(p.ExplicitBounds) {
+					le = formatFloat(float64(p.ExplicitBounds[i]))
+				}
+				c.counter(b, seriesID(name, "_bucket", resource, p.Attributes, "le", le), temporality, start, float64(cumulative))
+			}
+			c.counter(b, seriesID(name, "_count", resource, p.Attributes), temporality, start, float64(p.Count))
+			if p.Sum != nil {
+				c.sum(b, seriesID(name, "_sum", resource, p.Attributes), temporality, start, float64(*p.Sum))
+			}
+		}
+
+	case m.Summary != nil:
+		for _, p := range m.Summary.DataPoints {
+			start := uint64(p.StartTimeUnixNano)
+			for _, q := range p.QuantileValues {
+				b.gauge(seriesID(name, "", resource, p.Attributes, "quantile", formatFloat(float64(q.Quantile))), float64(q.Value))
+			}
+			c.counter(b, seriesID(name, "_count", resource, p.Attributes), TemporalityCumulative, start, float64(p.Count))
+			b.gauge(seriesID(name, "_sum", resource, p.Attributes), float64(p.Sum))
+		}
+
+	case m.ExponentialHistogram != nil:
+		b.reject(len(m.ExponentialHistogram.DataPoints), "%s: exponential histograms are not supported", m.Name)
+	}
+}
+
+func (b *Batch) gauge(id string, v float64) {
+	if !finite(v) {
+		b.reject(1, "%s: value is not finite", id)
+		return
+	}
+	b.Metrics = append(b.Metrics, model.Metrics{ID: id, MType: model.Gauge, Value: &v})
+}
+
+// накопленное значение ряда по точке
+func (b *Batch) accumulate(id string, temporality Temporality, start uint64, v float64) float64 {
+	if temporality == TemporalityDelta {
+		return b.changes.Add(id, v)
+	}
+	return b.changes.Set(id, start, v)
+}
+
+func (c *Converter) counter(b *Batch, id string, temporality Temporality, start uint64, v float64) {
+	if !finite(v) {
+		b.reject(1, "%s: value is not finite", id)
+		return
+	}
+	b.accumulate(id, temporality, start, v)
+	delta := b.changes.Increment(id)
+	b.Metrics = append(b.Metrics, model.Metrics{ID: id, MType: model.Counter, Delta: &delta})
+}
+
+// сумма гистограммы — gauge с накопленным значением
+func (c *Converter) sum(b *Batch, id string, temporality Temporality, start uint64, v float64) {
+	if !finite(v) {
+		b.reject(1, "%s: value is not finite", id)
+		return
+	}
+	b.gauge(id, b.accumulate(id, temporality, start, v))
+}
+
+func countPoints(m *Metric) int {
+	switch {
+	case m.Gauge != nil:
+		return len(m.Gauge.DataPoints)
+	case m.Sum != nil:
+		return len(m.Sum.DataPoints)
+	case m.Histogram != nil:
+		return len(m.Histogram.DataPoints)
+	case m.ExponentialHistogram != nil:
+		return len(m.ExponentialHistogram.DataPoints)
+	case m.Summary != nil:
+		return len(m.Summary.DataPoints)
+	}
+	return 0
+}
+
+// метки из атрибутов поверх base
+func attributes(base map[string]string, attrs []KeyValue) map[string]string {
+	labels := make(map[string]string, len(base)+len(attrs))
+	for k, v := range base {
+		labels[k] = v
+	}
+	for _, kv := range attrs {
+		if key := model.SanitizeLabel(kv.Key); key != "" {
+			labels[key] = kv.Value.String()
+		}
+	}
+	return labels
+}
+
+// ID ряда: имя с суффиксом и метки ресурса, точки и extra (пары ключ-значение)
+func seriesID(name, suffix string, resource map[string]string, attrs []KeyValue, extra ...string) string {
+	labels := attributes(resource, attrs)
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels[extra[i]] = extra[i+1]
+	}
+	return model.SeriesID(name+suffix, labels)
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
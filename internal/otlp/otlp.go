@@ -0,0 +1,303 @@
+// Package otlp принимает метрики OpenTelemetry по OTLP/HTTP в protobuf и JSON
+// и переводит их в метрики сервиса.
+//
+// типы повторяют сообщения opentelemetry/proto/metrics/v1 в объёме, нужном для
+// приёма: gauge, sum, histogram и summary. protobuf разбирается через protowire,
+// чтобы не тянуть сгенерированный код всего OTLP.
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// типы содержимого OTLP/HTTP
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+)
+
+// ExportMetricsServiceRequest
+type ExportRequest struct {
+	ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+}
+
+type ResourceMetrics struct {
+	Resource     Resource       `json:"resource"`
+	ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeMetrics struct {
+	Metrics []Metric `json:"metrics"`
+}
+
+// задано ровно одно из полей данных
+type Metric struct {
+	Name                 string                `json:"name"`
+	Gauge                *Gauge                `json:"gauge,omitempty"`
+	Sum                  *Sum                  `json:"sum,omitempty"`
+	Histogram            *Histogram            `json:"histogram,omitempty"`
+	ExponentialHistogram *ExponentialHistogram `json:"exponentialHistogram,omitempty"`
+	Summary              *Summary              `json:"summary,omitempty"`
+}
+
+type Gauge struct {
+	DataPoints []NumberDataPoint `json:"dataPoints"`
+}
+
+type Sum struct {
+	DataPoints             []NumberDataPoint `json:"dataPoints"`
+	AggregationTemporality Temporality       `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type Histogram struct {
+	DataPoints             []HistogramDataPoint `json:"dataPoints"`
+	AggregationTemporality Temporality          `json:"aggregationTemporality"`
+}
+
+// не поддерживается, точки учитываются как отклонённые
+type ExponentialHistogram struct {
+	DataPoints []json.RawMessage `json:"dataPoints"`
+}
+
+type Summary struct {
+	DataPoints []SummaryDataPoint `json:"dataPoints"`
+}
+
+// задано одно из AsDouble и AsInt
+type NumberDataPoint struct {
+	Attributes        []KeyValue `json:"attributes"`
+	StartTimeUnixNano jsonUint64 `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64 `json:"timeUnixNano"`
+	AsDouble          *jsonFloat `json:"asDouble,omitempty"`
+	AsInt             *jsonInt64 `json:"asInt,omitempty"`
+}
+
+// значение точки и признак, что оно задано
+func (p *NumberDataPoint) value() (float64, bool) {
+	switch {
+	case p.AsDouble != nil:
+		return float64(*p.AsDouble), true
+	case p.AsInt != nil:
+		return float64(*p.AsInt), true
+	}
+	return 0, false
+}
+
+// BucketCounts на одно значение длиннее ExplicitBounds: последний бакет до +Inf
+type HistogramDataPoint struct {
+	Attributes        []KeyValue   `json:"attributes"`
+	StartTimeUnixNano jsonUint64   `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64   `json:"timeUnixNano"`
+	Count             jsonUint64   `json:"count"`
+	Sum               *jsonFloat   `json:"sum,omitempty"`
+	BucketCounts      []jsonUint64 `json:"bucketCounts"`
+	ExplicitBounds    []jsonFloat  `json:"explicitBounds"`
+}
+
+type SummaryDataPoint struct {
+	Attributes        []KeyValue        `json:"attributes"`
+	StartTimeUnixNano jsonUint64        `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64        `json:"timeUnixNano"`
+	Count             jsonUint64        `json:"count"`
+	Sum               jsonFloat         `json:"sum"`
+	QuantileValues    []ValueAtQuantile `json:"quantileValues"`
+}
+
+type ValueAtQuantile struct {
+	Quantile jsonFloat `json:"quantile"`
+	Value    jsonFloat `json:"value"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// задано одно из полей
+type AnyValue struct {
+	StringValue *string       `json:"stringValue,omitempty"`
+	BoolValue   *bool         `json:"boolValue,omitempty"`
+	IntValue    *jsonInt64    `json:"intValue,omitempty"`
+	DoubleValue *jsonFloat    `json:"doubleValue,omitempty"`
+	ArrayValue  *ArrayValue   `json:"arrayValue,omitempty"`
+	KvlistValue *KeyValueList `json:"kvlistValue,omitempty"`
+	BytesValue  []byte        `json:"bytesValue,omitempty"`
+}
+
+type ArrayValue struct {
+	Values []AnyValue `json:"values"`
+}
+
+type KeyValueList struct {
+	Values []KeyValue `json:"values"`
+}
+
+// значение атрибута в виде строки метки; массивы и списки — в JSON
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(float64(*v.DoubleValue), 'g', -1, 64)
+	case v.BytesValue != nil:
+		return fmt.Sprintf("%x", v.BytesValue)
+	case v.ArrayValue != nil:
+		values := make([]string, len(v.ArrayValue.Values))
+		for i, item := range v.ArrayValue.Values {
+			values[i] = item.String()
+		}
+		data, _ := json.Marshal(values)
+		return string(data)
+	case v.KvlistValue != nil:
+		values := make(map[string]string, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = kv.Value.String()
+		}
+		data, _ := json.Marshal(values)
+		return string(data)
+	}
+	return ""
+}
+
+// AggregationTemporality
+type Temporality int32
+
+const (
+	TemporalityUnspecified Temporality = 0
+	TemporalityDelta       Temporality = 1
+	TemporalityCumulative  Temporality = 2
+)
+
+var temporalityNames = map[string]Temporality{
+	"AGGREGATION_TEMPORALITY_UNSPECIFIED": TemporalityUnspecified,
+	"AGGREGATION_TEMPORALITY_DELTA":       TemporalityDelta,
+	"AGGREGATION_TEMPORALITY_CUMULATIVE":  TemporalityCumulative,
+}
+
+// в JSON перечисление приходит числом или именем
+func (t *Temporality) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		v, ok := temporalityNames[name]
+		if !ok {
+			return fmt.Errorf("unknown aggregation temporality %q", name)
+		}
+		*t = v
+		return nil
+	}
+	var n int32
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*t = Temporality(n)
+	return nil
+}
+
+// в OTLP/JSON 64-битные целые передаются строками, принимаем и числа
+type jsonUint64 uint64
+
+func (u *jsonUint64) UnmarshalJSON(b []byte) error {
+	s := unquote(b)
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid uint64 %s", b)
+	}
+	*u = jsonUint64(v)
+	return nil
+}
+
+type jsonInt64 int64
+
+func (i *jsonInt64) UnmarshalJSON(b []byte) error {
+	s := unquote(b)
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 %s", b)
+	}
+	*i = jsonInt64(v)
+	return nil
+}
+
+// число или строки "NaN", "Infinity", "-Infinity"
+type jsonFloat float64
+
+func (f *jsonFloat) UnmarshalJSON(b []byte) error {
+	switch s := unquote(b); s {
+	case "NaN":
+		*f = jsonFloat(math.NaN())
+	case "Infinity":
+		*f = jsonFloat(math.Inf(1))
+	case "-Infinity":
+		*f = jsonFloat(math.Inf(-1))
+	default:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %s", b)
+		}
+		*f = jsonFloat(v)
+	}
+	return nil
+}
+
+func unquote(b []byte) string {
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		return string(b[1 : len(b)-1])
+	}
+	return string(b)
+}
+
+// разбирает тело запроса по типу содержимого
+func Decode(contentType string, body []byte) (*ExportRequest, error) {
+	var req ExportRequest
+	switch contentType {
+	case ContentTypeProtobuf:
+		if err := unmarshalRequest(body, &req); err != nil {
+			return nil, err
+		}
+	case ContentTypeJSON:
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return &req, nil
+}
+
+// ответ на экспорт в формате запроса; rejected и message заполняют partial_success
+func EncodeResponse(contentType string, rejected int64, message string) []byte {
+	if contentType == ContentTypeProtobuf {
+		return marshalResponse(rejected, message)
+	}
+	if rejected == 0 && message == "" {
+		return []byte("{}")
+	}
+	data, _ := json.Marshal(map[string]any{
+		"partialSuccess": map[string]any{
+			"rejectedDataPoints": strconv.FormatInt(rejected, 10),
+			"errorMessage":       message,
+		},
+	})
+	return data
+}
+
+// тело ответа с ошибкой: google.rpc.Status с кодом gRPC
+func EncodeStatus(contentType string, code int32, message string) []byte {
+	if contentType == ContentTypeProtobuf {
+		return marshalStatus(code, message)
+	}
+	data, _ := json.Marshal(map[string]any{"code": code, "message": message})
+	return data
+}
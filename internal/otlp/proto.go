@@ -0,0 +1,414 @@
+package otlp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// номера полей opentelemetry/proto/metrics/v1 и common/v1
+
+// одно поле сообщения; заполнено значение, соответствующее типу
+type field struct {
+	num     protowire.Number
+	typ     protowire.Type
+	varint  uint64
+	fixed64 uint64
+	bytes   []byte
+}
+
+func (f field) double() float64 { return math.Float64frombits(f.fixed64) }
+
+var errWireType = errors.New("unexpected wire type")
+
+// обходит поля сообщения, неизвестные поля пропускаются
+func walk(b []byte, fn func(f field) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		f := field{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.varint, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.fixed64, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(f); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+// проверяет тип поля перед чтением значения
+func expect(f field, typ protowire.Type) error {
+	if f.typ != typ {
+		return errWireType
+	}
+	return nil
+}
+
+func unmarshalRequest(b []byte, req *ExportRequest) error {
+	return walk(b, func(f field) error {
+		if f.num != 1 {
+			return nil
+		}
+		if err := expect(f, protowire.BytesType); err != nil {
+			return err
+		}
+		var rm ResourceMetrics
+		if err := unmarshalResourceMetrics(f.bytes, &rm); err != nil {
+			return err
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		return nil
+	})
+}
+
+func unmarshalResourceMetrics(b []byte, rm *ResourceMetrics) error {
+	return walk(b, func(f field) error {
+		switch f.num {
+		case 1: // resource
+			if err := expect(f, protowire.BytesType); err != nil {
+				return err
+			}
+			return walk(f.bytes, func(f field) error {
+				if f.num != 1 {
+					return nil
+				}
+				return appendKeyValue(f, &rm.Resource.Attributes)
+			})
+		case 2: // scope_metrics
+			if err := expect(f, protowire.BytesType); err != nil {
+				return err
+			}
+			var sm ScopeMetrics
+			err := walk(f.bytes, func(f field) error {
+				if f.num != 2 {
+					return nil
+				}
+				if err := expect(f, protowire.BytesType); err != nil {
+					return err
+				}
+				var m Metric
+				if err := unmarshalMetric(f.bytes, &m); err != nil {
+					return err
+				}
+				sm.Metrics = append(sm.Metrics, m)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		return nil
+	})
+}
+
+func unmarshalMetric(b []byte, m *Metric) error {
+	return walk(b, func(f field) error {
+		if f.num != 1 && f.num != 5 && f.num != 7 && f.num != 9 && f.num != 10 && f.num != 11 {
+			return nil
+		}
+		if err := expect(f, protowire.BytesType); err != nil {
+			return err
+		}
+		switch f.num {
+		case 1:
+			m.Name = string(f.bytes)
+		case 5:
+			m.Gauge = &Gauge{}
+			return unmarshalNumberPoints(f.bytes, &m.Gauge.DataPoints, nil)
+		case 7:
+			m.Sum = &Sum{}
+			return unmarshalNumberPoints(f.bytes, &m.Sum.DataPoints, func(f field) error {
+				switch f.num {
+				case 2:
+					m.Sum.AggregationTemporality = Temporality(f.varint)
+				case 3:
+					m.Sum.IsMonotonic = f.varint != 0
+				}
+				return nil
+			})
+		case 9:
+			m.Histogram = &Histogram{}
+			return walk(f.bytes, func(f field) error {
+				switch f.num {
+				case 1:
+					if err := expect(f, protowire.BytesType); err != nil {
+						return err
+					}
+					var p HistogramDataPoint
+					if err := unmarshalHistogramPoint(f.bytes, &p); err != nil {
+						return err
+					}
+					m.Histogram.DataPoints = append(m.Histogram.DataPoints, p)
+				case 2:
+					m.Histogram.AggregationTemporality = Temporality(f.varint)
+				}
+				return nil
+			})
+		case 10:
+			m.ExponentialHistogram = &ExponentialHistogram{}
+			return walk(f.bytes, func(f field) error {
+				if f.num == 1 {
+					m.ExponentialHistogram.DataPoints = append(m.ExponentialHistogram.DataPoints, nil)
+				}
+				return nil
+			})
+		case 11:
+			m.Summary = &Summary{}
+			return walk(f.bytes, func(f field) error {
+				if f.num != 1 {
+					return nil
+				}
+				if err := expect(f, protowire.BytesType); err != nil {
+					return err
+				}
+				var p SummaryDataPoint
+				if err := unmarshalSummaryPoint(f.bytes, &p); err != nil {
+					return err
+				}
+				m.Summary.DataPoints = append(m.Summary.DataPoints, p)
+				return nil
+			})
+		}
+		return nil
+	})
+}
+
+// точки Gauge и Sum в поле 1; остальные поля сообщения передаются в other
+func unmarshalNumberPoints(b []byte, points *[]NumberDataPoint, other func(f field) error) error {
+	return walk(b, func(f field) error {
+		if f.num != 1 {
+			if other == nil {
+				return nil
+			}
+			return other(f)
+		}
+		if err := expect(f, protowire.BytesType); err != nil {
+			return err
+		}
+		var p NumberDataPoint
+		err := walk(f.bytes, func(f field) error {
+			switch f.num {
+			case 7:
+				return appendKeyValue(f, &p.Attributes)
+			case 2:
+				p.StartTimeUnixNano = jsonUint64(f.fixed64)
+			case 3:
+				p.TimeUnixNano = jsonUint64(f.fixed64)
+			case 4:
+				v := jsonFloat(f.double())
+				p.AsDouble = &v
+			case 6:
+				v := jsonInt64(f.fixed64)
+				p.AsInt = &v
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		*points = append(*points, p)
+		return nil
+	})
+}
+
+func unmarshalHistogramPoint(b []byte, p *HistogramDataPoint) error {
+	return walk(b, func(f field) error {
+		switch f.num {
+		case 9:
+			return appendKeyValue(f, &p.Attributes)
+		case 2:
+			p.StartTimeUnixNano = jsonUint64(f.fixed64)
+		case 3:
+			p.TimeUnixNano = jsonUint64(f.fixed64)
+		case 4:
+			p.Count = jsonUint64(f.fixed64)
+		case 5:
+			v := jsonFloat(f.double())
+			p.Sum = &v
+		case 6:
+			return appendFixed64(f, func(v uint64) { p.BucketCounts = append(p.BucketCounts, jsonUint64(v)) })
+		case 7:
+			return appendFixed64(f, func(v uint64) {
+				p.ExplicitBounds = append(p.ExplicitBounds, jsonFloat(math.Float64frombits(v)))
+			})
+		}
+		return nil
+	})
+}
+
+func unmarshalSummaryPoint(b []byte, p *SummaryDataPoint) error {
+	return walk(b, func(f field) error {
+		switch f.num {
+		case 7:
+			return appendKeyValue(f, &p.Attributes)
+		case 2:
+			p.StartTimeUnixNano = jsonUint64(f.fixed64)
+		case 3:
+			p.TimeUnixNano = jsonUint64(f.fixed64)
+		case 4:
+			p.Count = jsonUint64(f.fixed64)
+		case 5:
+			p.Sum = jsonFloat(f.double())
+		case 6:
+			if err := expect(f, protowire.BytesType); err != nil {
+				return err
+			}
+			var q ValueAtQuantile
+			err := walk(f.bytes, func(f field) error {
+				switch f.num {
+				case 1:
+					q.Quantile = jsonFloat(f.double())
+				case 2:
+					q.Value = jsonFloat(f.double())
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			p.QuantileValues = append(p.QuantileValues, q)
+		}
+		return nil
+	})
+}
+
+// повторяющееся fixed64 или double: упакованное или по одному значению
+func appendFixed64(f field, add func(uint64)) error {
+	switch f.typ {
+	case protowire.Fixed64Type:
+		add(f.fixed64)
+		return nil
+	case protowire.BytesType:
+		b := f.bytes
+		for len(b) > 0 {
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			add(v)
+			b = b[n:]
+		}
+		return nil
+	}
+	return errWireType
+}
+
+func appendKeyValue(f field, attrs *[]KeyValue) error {
+	if err := expect(f, protowire.BytesType); err != nil {
+		return err
+	}
+	var kv KeyValue
+	if err := unmarshalKeyValue(f.bytes, &kv); err != nil {
+		return err
+	}
+	*attrs = append(*attrs, kv)
+	return nil
+}
+
+func unmarshalKeyValue(b []byte, kv *KeyValue) error {
+	return walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			if err := expect(f, protowire.BytesType); err != nil {
+				return err
+			}
+			kv.Key = string(f.bytes)
+		case 2:
+			if err := expect(f, protowire.BytesType); err != nil {
+				return err
+			}
+			return unmarshalAnyValue(f.bytes, &kv.Value)
+		}
+		return nil
+	})
+}
+
+func unmarshalAnyValue(b []byte, v *AnyValue) error {
+	return walk(b, func(f field) error {
+		switch f.num {
+		case 1:
+			s := string(f.bytes)
+			v.StringValue = &s
+		case 2:
+			b := f.varint != 0
+			v.BoolValue = &b
+		case 3:
+			i := jsonInt64(f.varint)
+			v.IntValue = &i
+		case 4:
+			d := jsonFloat(f.double())
+			v.DoubleValue = &d
+		case 5:
+			v.ArrayValue = &ArrayValue{}
+			return walk(f.bytes, func(f field) error {
+				if f.num != 1 {
+					return nil
+				}
+				var item AnyValue
+				if err := unmarshalAnyValue(f.bytes, &item); err != nil {
+					return err
+				}
+				v.ArrayValue.Values = append(v.ArrayValue.Values, item)
+				return nil
+			})
+		case 6:
+			v.KvlistValue = &KeyValueList{}
+			return walk(f.bytes, func(f field) error {
+				if f.num != 1 {
+					return nil
+				}
+				return appendKeyValue(f, &v.KvlistValue.Values)
+			})
+		case 7:
+			v.BytesValue = append([]byte{}, f.bytes...)
+		}
+		return nil
+	})
+}
+
+// ExportMetricsServiceResponse с partial_success, если часть точек отклонена
+func marshalResponse(rejected int64, message string) []byte {
+	if rejected == 0 && message == "" {
+		return []byte{}
+	}
+	var partial []byte
+	if rejected != 0 {
+		partial = protowire.AppendTag(partial, 1, protowire.VarintType)
+		partial = protowire.AppendVarint(partial, uint64(rejected))
+	}
+	if message != "" {
+		partial = protowire.AppendTag(partial, 2, protowire.BytesType)
+		partial = protowire.AppendString(partial, message)
+	}
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, partial)
+}
+
+// google.rpc.Status для ответа с ошибкой
+func marshalStatus(code int32, message string) []byte {
+	var b []byte
+	if code != 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(code))
+	}
+	b = protowire.AppendTag(b, 2, protowire.BytesType)
+	return protowire.AppendString(b, message)
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/otlp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// построение protobuf сообщений для тестов
+func bytesField(num protowire.Number, v []byte) []byte {
+	b := protowire.AppendTag(nil, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func stringField(num protowire.Number, v string) []byte {
+	return bytesField(num, []byte(v))
+}
+
+func varintField(num protowire.Number, v uint64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func fixed64Field(num protowire.Number, v uint64) []byte {
+	b := protowire.AppendTag(nil, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func doubleField(num protowire.Number, v float64) []byte {
+	return fixed64Field(num, math.Float64bits(v))
+}
+
+func msg(parts ...[]byte) []byte {
+	var b []byte
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func attr(key, value string) []byte {
+	return msg(stringField(1, key), bytesField(2, stringField(1, value)))
+}
+
+// запрос с одним ресурсом service.name=api и метриками
+func protoRequest(metrics ...[]byte) []byte {
+	scope := msg()
+	for _, m := range metrics {
+		scope = append(scope, bytesField(2, m)...)
+	}
+	resource := bytesField(1, bytesField(1, attr("service.name", "api")))
+	return bytesField(1, msg(resource, bytesField(2, scope)))
+}
+
+func metricValue(t *testing.T, metrics []model.Metrics, id string) model.Metrics {
+	t.Helper()
+	for _, m := range metrics {
+		if m.ID == id {
+			return m
+		}
+	}
+	t.Fatalf("metric %s not found in %v", id, metrics)
+	return model.Metrics{}
+}
+
+func TestDecodeProtobuf(t *testing.T) {
+	started := uint64(time.Now().Add(time.Minute).UnixNano())
+	body := protoRequest(
+		msg(stringField(1, "http.requests"), bytesField(7, msg(
+			bytesField(1, msg(bytesField(7, attr("method", "GET")), fixed64Field(2, started), fixed64Field(6, 5))),
+			varintField(2, uint64(otlp.TemporalityCumulative)),
+			varintField(3, 1),
+		))),
+		msg(stringField(1, "cpu.load"), bytesField(5, bytesField(1, doubleField(4, 0.75)))),
+		msg(stringField(1, "latency"), bytesField(9, msg(
+			bytesField(1, msg(
+				fixed64Field(4, 3),
+				doubleField(5, 0.6),
+				// упакованные bucket_counts и explicit_bounds
+				bytesField(6, msg(protowire.AppendFixed64(nil, 1), protowire.AppendFixed64(nil, 2))),
+				bytesField(7, protowire.AppendFixed64(nil, math.Float64bits(0.5))),
+			)),
+			varintField(2, uint64(otlp.TemporalityDelta)),
+		))),
+	)
+
+	req, err := otlp.Decode(otlp.ContentTypeProtobuf, body)
+	require.NoError(t, err)
+	batch := otlp.NewConverter().Convert(req)
+	assert.Zero(t, batch.Rejected)
+
+	requests := metricValue(t, batch.Metrics, `http_requests{method="GET",service_name="api"}`)
+	assert.Equal(t, model.Counter, requests.MType)
+	assert.Equal(t, int64(5), *requests.Delta)
+
+	load := metricValue(t, batch.Metrics, `cpu_load{service_name="api"}`)
+	assert.Equal(t, 0.75, *load.Value)
+
+	assert.Equal(t, int64(1), *metricValue(t, batch.Metrics, `latency_bucket{le="0.5",service_name="api"}`).Delta)
+	assert.Equal(t, int64(3), *metricValue(t, batch.Metrics, `latency_bucket{le="+Inf",service_name="api"}`).Delta)
+	assert.Equal(t, int64(3), *metricValue(t, batch.Metrics, `latency_count{service_name="api"}`).Delta)
+	assert.Equal(t, 0.6, *metricValue(t, batch.Metrics, `latency_sum{service_name="api"}`).Value)
+}
+
+func TestDecodeProtobuf_Invalid(t *testing.T) {
+	_, err := otlp.Decode(otlp.ContentTypeProtobuf, []byte{0x0a, 0xff})
+	assert.Error(t, err)
+	_, err = otlp.Decode("text/plain", nil)
+	assert.Error(t, err)
+}
+
+const jsonRequest = `{
+  "resourceMetrics": [{
+    "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "api"}}]},
+    "scopeMetrics": [{
+      "metrics": [
+        {"name": "jobs", "sum": {"aggregationTemporality": "AGGREGATION_TEMPORALITY_DELTA", "isMonotonic": true,
+          "dataPoints": [{"asInt": "4", "attributes": [{"key": "queue", "value": {"stringValue": "mail"}}]}]}},
+        {"name": "queue.size", "sum": {"aggregationTemporality": 2, "isMonotonic": false,
+          "dataPoints": [{"asDouble": 12}]}},
+        {"name": "rpc", "summary": {"dataPoints": [{"count": "10", "sum": 2.5,
+          "quantileValues": [{"quantile": 0.99, "value": 0.4}]}]}},
+        {"name": "exp", "exponentialHistogram": {"dataPoints": [{}, {}]}},
+        {"name": "bad", "sum": {"isMonotonic": true, "dataPoints": [{"asInt": "1"}]}}
+      ]
+    }]
+  }]
+}`
+
+func TestDecodeJSON(t *testing.T) {
+	req, err := otlp.Decode(otlp.ContentTypeJSON, []byte(jsonRequest))
+	require.NoError(t, err)
+	batch := otlp.NewConverter().Convert(req)
+
+	jobs := metricValue(t, batch.Metrics, `jobs{queue="mail",service_name="api"}`)
+	assert.Equal(t, int64(4), *jobs.Delta)
+
+	// немонотонная накопительная сумма — текущее значение
+	size := metricValue(t, batch.Metrics, `queue_size{service_name="api"}`)
+	assert.Equal(t, model.Gauge, size.MType)
+	assert.Equal(t, 12.0, *size.Value)
+
+	assert.Equal(t, 0.4, *metricValue(t, batch.Metrics, `rpc{quantile="0.99",service_name="api"}`).Value)
+	assert.Equal(t, 2.5, *metricValue(t, batch.Metrics, `rpc_sum{service_name="api"}`).Value)
+	assert.Equal(t, model.Counter, metricValue(t, batch.Metrics, `rpc_count{service_name="api"}`).MType)
+
+	// две точки экспоненциальной гистограммы и сумма без temporality
+	assert.Equal(t, int64(3), batch.Rejected)
+	assert.Contains(t, batch.Message(), "exponential histograms are not supported")
+	assert.Contains(t, batch.Message(), "unspecified aggregation temporality")
+}
+
+// точка монотонной суммы с заданной temporality
+func sumRequest(temporality otlp.Temporality, start time.Time, value int64) []byte {
+	var startField []byte
+	if !start.IsZero() {
+		startField = fixed64Field(2, uint64(start.UnixNano()))
+	}
+	return protoRequest(msg(stringField(1, "ops"), bytesField(7, msg(
+		bytesField(1, msg(startField, fixed64Field(6, uint64(value)))),
+		varintField(2, uint64(temporality)),
+		varintField(3, 1),
+	))))
+}
+
+func convertDelta(t *testing.T, c *otlp.Converter, body []byte, commit bool) int64 {
+	t.Helper()
+	req, err := otlp.Decode(otlp.ContentTypeProtobuf, body)
+	require.NoError(t, err)
+	batch := c.Convert(req)
+	require.Len(t, batch.Metrics, 1)
+	if commit {
+		c.Commit(batch)
+	}
+	return *batch.Metrics[0].Delta
+}
+
+func TestConverter_Cumulative(t *testing.T) {
+	c := otlp.NewConverter()
+	before := time.Now().Add(-time.Hour)
+
+	// ряд начался до запуска сервера: первая точка только отправная
+	assert.Equal(t, int64(0), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, before, 100), true))
+	assert.Equal(t, int64(20), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, before, 120), true))
+
+	// незаписанный батч не сдвигает состояние
+	assert.Equal(t, int64(10), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, before, 130), false))
+	assert.Equal(t, int64(15), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, before, 135), true))
+
+	// перезапуск источника: новый start_time, значение считается с нуля
+	restarted := time.Now().Add(time.Second)
+	assert.Equal(t, int64(7), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, restarted, 7), true))
+	// уменьшение значения без смены start_time тоже сброс
+	assert.Equal(t, int64(3), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, restarted, 3), true))
+}
+
+func TestConverter_CumulativeStartedAfterServer(t *testing.T) {
+	c := otlp.NewConverter()
+	assert.Equal(t, int64(9), convertDelta(t, c, sumRequest(otlp.TemporalityCumulative, time.Now().Add(time.Second), 9), true))
+}
+
+func TestConverter_Delta(t *testing.T) {
+	c := otlp.NewConverter()
+	assert.Equal(t, int64(5), convertDelta(t, c, sumRequest(otlp.TemporalityDelta, time.Time{}, 5), true))
+	assert.Equal(t, int64(5), convertDelta(t, c, sumRequest(otlp.TemporalityDelta, time.Time{}, 5), true))
+}
+
+func TestEncodeResponse(t *testing.T) {
+	assert.Equal(t, "{}", string(otlp.EncodeResponse(otlp.ContentTypeJSON, 0, "")))
+	assert.Empty(t, otlp.EncodeResponse(otlp.ContentTypeProtobuf, 0, ""))
+	assert.JSONEq(t, `{"partialSuccess":{"rejectedDataPoints":"2","errorMessage":"x"}}`,
+		string(otlp.EncodeResponse(otlp.ContentTypeJSON, 2, "x")))
+	assert.JSONEq(t, `{"code":3,"message":"bad"}`, string(otlp.EncodeStatus(otlp.ContentTypeJSON, 3, "bad")))
+}
@@ -0,0 +1,333 @@
+// Package remotewrite принимает данные Prometheus remote_write 1.0:
+// WriteRequest в protobuf, сжатый snappy, и переводит отсчёты в метрики сервиса.
+//
+// сообщения prometheus/prompb разбираются через protowire, как и OTLP,
+// нативные гистограммы и exemplars пропускаются.
+package remotewrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/cumulative"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// тело после распаковки больше разрешённого
+var ErrTooLarge = errors.New("remote write: decoded body too large")
+
+// prompb.WriteRequest
+type WriteRequest struct {
+	Timeseries []TimeSeries
+	Metadata   []MetricMetadata
+}
+
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+type Sample struct {
+	Value     float64
+	Timestamp int64 // миллисекунды unix
+}
+
+// MetricMetadata: тип семейства метрик
+type MetricMetadata struct {
+	Type             MetricType
+	MetricFamilyName string
+}
+
+type MetricType int32
+
+const (
+	TypeUnknown        MetricType = 0
+	TypeCounter        MetricType = 1
+	TypeGauge          MetricType = 2
+	TypeSummary        MetricType = 3
+	TypeHistogram      MetricType = 4
+	TypeGaugeHistogram MetricType = 5
+	TypeInfo           MetricType = 6
+	TypeStateset       MetricType = 7
+)
+
+// маркер устаревания ряда в Prometheus
+const staleNaN = 0x7ff0000000000002
+
+// распаковывает и разбирает тело запроса. maxSize ограничивает размер после
+// распаковки, 0 — без ограничения; длина известна из заголовка snappy до распаковки.
+func Decode(body []byte, maxSize int64) (*WriteRequest, error) {
+	n, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w", err)
+	}
+	if maxSize > 0 && int64(n) > maxSize {
+		return nil, ErrTooLarge
+	}
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("snappy: %w", err)
+	}
+
+	var req WriteRequest
+	err = walk(data, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			ts, err := unmarshalTimeSeries(b)
+			if err != nil {
+				return err
+			}
+			req.Timeseries = append(req.Timeseries, ts)
+		case num == 3 && typ == protowire.BytesType:
+			var md MetricMetadata
+			err := walk(b, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				switch {
+				case num == 1 && typ == protowire.VarintType:
+					md.Type = MetricType(v)
+				case num == 2 && typ == protowire.BytesType:
+					md.MetricFamilyName = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			req.Metadata = append(req.Metadata, md)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func unmarshalTimeSeries(data []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := walk(data, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			var l Label
+			err := walk(b, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					l.Name = string(b)
+				case num == 2 && typ == protowire.BytesType:
+					l.Value = string(b)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Labels = append(ts.Labels, l)
+		case 2:
+			var s Sample
+			err := walk(b, func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					s.Value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					s.Timestamp = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// обходит поля сообщения; v — значение varint или fixed64, b — байты поля
+func walk(data []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var (
+			v uint64
+			b []byte
+		)
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(data)
+		case protowire.BytesType:
+			b, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := fn(num, typ, v, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// переводит отсчёты в метрики сервиса. ряд становится одной метрикой:
+// gauge — последним отсчётом, counter — приростом накопительного значения.
+//
+// тип берётся из метаданных семейства, которые Prometheus присылает периодически
+// и сервер запоминает. без метаданных counter — это ряды _total и _bucket с le,
+// остальные — gauge. у histogram и summary _bucket и _count — counter,
+// _sum и квантили — gauge, как у агента при скрейпинге.
+type Converter struct {
+	tracker *cumulative.Tracker
+
+	mu    sync.RWMutex
+	types map[string]MetricType
+}
+
+func NewConverter() *Converter {
+	return &Converter{tracker: cumulative.NewTracker(), types: make(map[string]MetricType)}
+}
+
+// результат перевода одного запроса
+type Batch struct {
+	Metrics  []model.Metrics
+	Rejected int      // отклонённых отсчётов
+	Errors   []string // причины отклонения, без повторов
+
+	changes *cumulative.Changes
+}
+
+func (b *Batch) Message() string {
+	return strings.Join(b.Errors, "; ")
+}
+
+func (b *Batch) reject(n int, msg string) {
+	b.Rejected += n
+	for _, e := range b.Errors {
+		if e == msg {
+			return
+		}
+	}
+	b.Errors = append(b.Errors, msg)
+}
+
+// переводит запрос; состояние counter меняется только в Commit
+func (c *Converter) Convert(req *WriteRequest) *Batch {
+	c.learn(req.Metadata)
+
+	b := &Batch{changes: c.tracker.Begin()}
+	for _, ts := range req.Timeseries {
+		var name string
+		labels := make(map[string]string, len(ts.Labels))
+		for _, l := range ts.Labels {
+			if l.Name == "__name__" {
+				name = l.Value
+				continue
+			}
+			labels[l.Name] = l.Value
+		}
+		if name == "" {
+			b.reject(len(ts.Samples), "series without __name__ label")
+			continue
+		}
+		id := model.SeriesID(name, labels)
+		counter := c.isCounter(name, labels)
+
+		samples := append([]Sample(nil), ts.Samples...)
+		sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+
+		var (
+			last  float64
+			valid bool
+		)
+		for _, s := range samples {
+			if math.Float64bits(s.Value) == staleNaN {
+				continue
+			}
+			if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+				b.reject(1, name+": value is not finite")
+				continue
+			}
+			if counter {
+				b.changes.Set(id, 0, s.Value)
+			}
+			last, valid = s.Value, true
+		}
+		if !valid {
+			continue
+		}
+		if counter {
+			delta := b.changes.Increment(id)
+			b.Metrics = append(b.Metrics, model.Metrics{ID: id, MType: model.Counter, Delta: &delta})
+			continue
+		}
+		b.Metrics = append(b.Metrics, model.Metrics{ID: id, MType: model.Gauge, Value: &last})
+	}
+	return b
+}
+
+// запоминает значения counter записанного батча
+func (c *Converter) Commit(b *Batch) {
+	c.tracker.Commit(b.changes)
+}
+
+func (c *Converter) learn(metadata []MetricMetadata) {
+	if len(metadata) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, md := range metadata {
+		if md.MetricFamilyName != "" {
+			c.types[md.MetricFamilyName] = md.Type
+		}
+	}
+}
+
+// суффиксы рядов семейства; метаданные бывают и по полному имени, и по имени семейства
+var familySuffixes = []string{"_total", "_bucket", "_count", "_sum"}
+
+func (c *Converter) isCounter(name string, labels map[string]string) bool {
+	c.mu.RLock()
+	typ, known := c.types[name]
+	for _, suffix := range familySuffixes {
+		if known {
+			break
+		}
+		if family, ok := strings.CutSuffix(name, suffix); ok {
+			typ, known = c.types[family]
+		}
+	}
+	c.mu.RUnlock()
+
+	_, hasLE := labels["le"]
+	switch {
+	case !known || typ == TypeUnknown:
+		return strings.HasSuffix(name, "_total") || strings.HasSuffix(name, "_bucket") && hasLE
+	case typ == TypeCounter:
+		return true
+	case typ == TypeHistogram || typ == TypeSummary:
+		return strings.HasSuffix(name, "_bucket") || strings.HasSuffix(name, "_count")
+	}
+	// gauge histogram — текущее распределение, его бакеты не накопительные
+	return false
+}
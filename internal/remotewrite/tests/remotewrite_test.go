@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/remotewrite"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testdata/write_request_*.snappy — тела remote_write в том виде, в каком их шлёт
+// Prometheus: prompb.WriteRequest, сжатый snappy block format.
+//
+// write_request_1: http_requests_total{job="api",method="GET"} 100 и 110,
+// node_memory_free_bytes{instance="host:9100",job="node"} 1024 и 2048,
+// гистограмма rpc_duration_seconds (_bucket{le="0.5"} 7, _sum 3.5, _count 9),
+// queue_depth{job="api"} с маркером устаревания и метаданные
+// counter, gauge и histogram для трёх семейств.
+//
+// write_request_2: http_requests_total 150, rpc_duration_seconds_count 4 (сброс)
+// и node_memory_free_bytes +Inf.
+func readPayload(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return body
+}
+
+func byID(metrics []model.Metrics) map[string]model.Metrics {
+	out := make(map[string]model.Metrics, len(metrics))
+	for _, m := range metrics {
+		out[m.ID] = m
+	}
+	return out
+}
+
+func TestDecode(t *testing.T) {
+	req, err := remotewrite.Decode(readPayload(t, "write_request_1.snappy"), 0)
+	require.NoError(t, err)
+	require.Len(t, req.Timeseries, 6)
+	require.Len(t, req.Metadata, 3)
+
+	ts := req.Timeseries[0]
+	assert.Equal(t, []remotewrite.Label{{Name: "__name__", Value: "http_requests_total"}, {Name: "job", Value: "api"}, {Name: "method", Value: "GET"}}, ts.Labels)
+	assert.Equal(t, []remotewrite.Sample{{Value: 100, Timestamp: 1760000000000}, {Value: 110, Timestamp: 1760000015000}}, ts.Samples)
+	assert.Equal(t, remotewrite.MetricMetadata{Type: remotewrite.TypeHistogram, MetricFamilyName: "rpc_duration_seconds"}, req.Metadata[2])
+}
+
+func TestDecode_Errors(t *testing.T) {
+	body := readPayload(t, "write_request_1.snappy")
+
+	_, err := remotewrite.Decode(body, 64)
+	assert.ErrorIs(t, err, remotewrite.ErrTooLarge)
+
+	_, err = remotewrite.Decode([]byte("not snappy"), 0)
+	assert.Error(t, err)
+
+	// snappy корректен, protobuf обрезан
+	_, err = remotewrite.Decode(snappy.Encode(nil, []byte{0x0a, 0xff}), 0)
+	assert.Error(t, err)
+}
+
+func TestConverter(t *testing.T) {
+	c := remotewrite.NewConverter()
+
+	req, err := remotewrite.Decode(readPayload(t, "write_request_1.snappy"), 0)
+	require.NoError(t, err)
+	batch := c.Convert(req)
+	assert.Zero(t, batch.Rejected)
+	got := byID(batch.Metrics)
+	require.Len(t, got, 5, "ряд с маркером устаревания пропускается")
+
+	// первая точка неизвестного counter — отправная, прирост считается внутри запроса
+	requests := got[`http_requests_total{job="api",method="GET"}`]
+	assert.Equal(t, model.Counter, requests.MType)
+	assert.Equal(t, int64(10), *requests.Delta)
+	memory := got[`node_memory_free_bytes{instance="host:9100",job="node"}`]
+	assert.Equal(t, model.Gauge, memory.MType)
+	assert.Equal(t, 2048.0, *memory.Value)
+	assert.Equal(t, model.Counter, got[`rpc_duration_seconds_bucket{job="api",le="0.5"}`].MType)
+	assert.Equal(t, model.Counter, got[`rpc_duration_seconds_count{job="api"}`].MType)
+	assert.Equal(t, model.Gauge, got[`rpc_duration_seconds_sum{job="api"}`].MType)
+	c.Commit(batch)
+
+	req, err = remotewrite.Decode(readPayload(t, "write_request_2.snappy"), 0)
+	require.NoError(t, err)
+	batch = c.Convert(req)
+	assert.Equal(t, 1, batch.Rejected)
+	assert.Equal(t, "node_memory_free_bytes: value is not finite", batch.Message())
+	got = byID(batch.Metrics)
+	require.Len(t, got, 2)
+	assert.Equal(t, int64(40), *got[`http_requests_total{job="api",method="GET"}`].Delta)
+	assert.Equal(t, int64(4), *got[`rpc_duration_seconds_count{job="api"}`].Delta, "после сброса весь итог новый")
+}
+
+func TestConverter_NotCommitted(t *testing.T) {
+	c := remotewrite.NewConverter()
+	series := func(v float64) *remotewrite.WriteRequest {
+		return &remotewrite.WriteRequest{Timeseries: []remotewrite.TimeSeries{{
+			Labels:  []remotewrite.Label{{Name: "__name__", Value: "jobs_total"}},
+			Samples: []remotewrite.Sample{{Value: v}},
+		}}}
+	}
+	c.Commit(c.Convert(series(5)))
+
+	// запрос не записан: прирост не потерян
+	_ = c.Convert(series(8))
+	batch := c.Convert(series(9))
+	require.Len(t, batch.Metrics, 1)
+	assert.Equal(t, int64(4), *batch.Metrics[0].Delta)
+}
+
+func TestConverter_TypeWithoutMetadata(t *testing.T) {
+	c := remotewrite.NewConverter()
+	req := &remotewrite.WriteRequest{Timeseries: []remotewrite.TimeSeries{
+		{Labels: []remotewrite.Label{{Name: "__name__", Value: "errors_total"}}, Samples: []remotewrite.Sample{{Value: 1}}},
+		{Labels: []remotewrite.Label{{Name: "__name__", Value: "latency_bucket"}, {Name: "le", Value: "+Inf"}}, Samples: []remotewrite.Sample{{Value: 2}}},
+		{Labels: []remotewrite.Label{{Name: "__name__", Value: "latency_count"}}, Samples: []remotewrite.Sample{{Value: 2}}},
+		{Labels: []remotewrite.Label{{Name: "__name__", Value: "temperature"}}, Samples: []remotewrite.Sample{{Value: 21.5}}},
+		{Labels: []remotewrite.Label{{Name: "job", Value: "x"}}, Samples: []remotewrite.Sample{{Value: 1}, {Value: 2}}},
+		{Labels: []remotewrite.Label{{Name: "__name__", Value: "ratio"}}, Samples: []remotewrite.Sample{{Value: math.NaN()}}},
+	}}
+
+	batch := c.Convert(req)
+	got := byID(batch.Metrics)
+	require.Len(t, got, 4)
+	assert.Equal(t, model.Counter, got["errors_total"].MType)
+	assert.Equal(t, model.Counter, got[`latency_bucket{le="+Inf"}`].MType)
+	assert.Equal(t, model.Gauge, got["latency_count"].MType, "без метаданных семейство неизвестно")
+	assert.Equal(t, model.Gauge, got["temperature"].MType)
+	assert.Equal(t, 3, batch.Rejected)
+	assert.Equal(t, "series without __name__ label; ratio: value is not finite", batch.Message())
+}
@@ -0,0 +1,165 @@
+// Package series ограничивает множество рядов метрик (тип + имя): проверяет имена
+// по шаблону и зарезервированным префиксам, ограничивает общее число рядов и число
+// новых рядов, созданных одним клиентом. уже известные ряды обновляются всегда.
+package series
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+)
+
+var (
+	ErrInvalidName  = errors.New("invalid metric name")
+	ErrReservedName = errors.New("reserved metric name")
+	// ряд не создан из-за лимита, обновления существующих рядов продолжают приниматься
+	ErrLimitExceeded = errors.New("series limit exceeded")
+)
+
+// нулевое значение поля — без ограничения
+type Config struct {
+	NamePattern      string   // регулярное выражение для имени метрики
+	ReservedPrefixes []string // префиксы, занятые собственными метриками сервера
+	MaxSeries        int      // всего рядов
+	MaxPerClient     int      // новых рядов от одного клиента
+}
+
+type Policy struct {
+	cfg     Config
+	pattern *regexp.Regexp
+
+	mu       sync.Mutex
+	known    map[string]struct{}
+	byClient map[string]int
+}
+
+func New(cfg Config) (*Policy, error) {
+	p := &Policy{
+		cfg:      cfg,
+		known:    make(map[string]struct{}),
+		byClient: make(map[string]int),
+	}
+	if cfg.NamePattern != "" {
+		re, err := regexp.Compile(cfg.NamePattern)
+		if err != nil {
+			return nil, fmt.Errorf("series: invalid name pattern: %w", err)
+		}
+		p.pattern = re
+	}
+	return p, nil
+}
+
+// добавляет ряды, уже лежащие в хранилище. они не списываются с бюджета клиентов.
+func (p *Policy) Seed(gauges map[string]float64, counters map[string]int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name := range gauges {
+		p.known[key(model.Gauge, name)] = struct{}{}
+	}
+	for name := range counters {
+		p.known[key(model.Counter, name)] = struct{}{}
+	}
+}
+
+// проверяет имя метрики по шаблону и зарезервированным префиксам
+func (p *Policy) CheckName(name string) error {
+	if p == nil {
+		return nil
+	}
+	for _, prefix := range p.cfg.ReservedPrefixes {
+		if prefix != "" && strings.HasPrefix(name, prefix) {
+			return fmt.Errorf("%w: %q uses reserved prefix %q", ErrReservedName, name, prefix)
+		}
+	}
+	if p.pattern != nil && !p.pattern.MatchString(name) {
+		return fmt.Errorf("%w: %q does not match %s", ErrInvalidName, name, p.pattern)
+	}
+	return nil
+}
+
+// проверяет метрики запроса клиента client и запоминает новые ряды.
+// запрос принимается целиком или отклоняется целиком, чтобы батч не применялся частично.
+func (p *Policy) Admit(client string, metrics []model.Metrics) error {
+	_, err := p.Reserve(client, metrics)
+	return err
+}
+
+// проверяет метрики как Admit и занимает новые ряды до записи в хранилище, чтобы
+// параллельные запросы не превысили лимиты. release освобождает занятые ряды,
+// если запись не удалась: иначе неудачный запрос расходовал бы бюджет клиента.
+func (p *Policy) Reserve(client string, metrics []model.Metrics) (release func(), err error) {
+	release = func() {}
+	if p == nil {
+		return release, nil
+	}
+	for _, m := range metrics {
+		if err := p.CheckName(m.ID); err != nil {
+			return release, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var fresh []string
+	seen := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		k := key(m.MType, m.ID)
+		if _, ok := p.known[k]; ok {
+			continue
+		}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		fresh = append(fresh, k)
+	}
+	if len(fresh) == 0 {
+		return release, nil
+	}
+
+	if p.cfg.MaxSeries > 0 && len(p.known)+len(fresh) > p.cfg.MaxSeries {
+		return release, fmt.Errorf("%w: %d new series, %d of %d in use", ErrLimitExceeded, len(fresh), len(p.known), p.cfg.MaxSeries)
+	}
+	if p.cfg.MaxPerClient > 0 && p.byClient[client]+len(fresh) > p.cfg.MaxPerClient {
+		return release, fmt.Errorf("%w: client %s created %d of %d series", ErrLimitExceeded, client, p.byClient[client], p.cfg.MaxPerClient)
+	}
+
+	for _, k := range fresh {
+		p.known[k] = struct{}{}
+	}
+	p.byClient[client] += len(fresh)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			for _, k := range fresh {
+				delete(p.known, k)
+			}
+			p.byClient[client] -= len(fresh)
+		})
+	}, nil
+}
+
+// число известных рядов
+func (p *Policy) Len() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.known)
+}
+
+func key(mtype, name string) string {
+	return mtype + ":" + name
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/model"
+	"github.com/IvanChernomyrdin/go-musthave-metrics-tpl/internal/series"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gauges(names ...string) []model.Metrics {
+	out := make([]model.Metrics, 0, len(names))
+	for _, n := range names {
+		out = append(out, model.Metrics{ID: n, MType: model.Gauge})
+	}
+	return out
+}
+
+func TestPolicy_InvalidPattern(t *testing.T) {
+	_, err := series.New(series.Config{NamePattern: "("})
+	assert.Error(t, err)
+}
+
+func TestPolicy_Names(t *testing.T) {
+	p, err := series.New(series.Config{
+		NamePattern:      `^[a-zA-Z_][a-zA-Z0-9_]*$`,
+		ReservedPrefixes: []string{"server_self_"},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Admit("ip:1", gauges("Alloc", "agent_self_spool_size")))
+	assert.ErrorIs(t, p.Admit("ip:1", gauges("req-7f3a9c")), series.ErrInvalidName)
+	assert.ErrorIs(t, p.Admit("ip:1", gauges("server_self_requests_rate_limited")), series.ErrReservedName)
+}
+
+func TestPolicy_MaxSeries(t *testing.T) {
+	p, err := series.New(series.Config{MaxSeries: 3})
+	require.NoError(t, err)
+	p.Seed(map[string]float64{"Alloc": 1}, map[string]int64{"PollCount": 1})
+	assert.Equal(t, 2, p.Len())
+
+	assert.NoError(t, p.Admit("ip:1", gauges("HeapInuse")))
+	assert.ErrorIs(t, p.Admit("ip:1", gauges("HeapIdle")), series.ErrLimitExceeded)
+
+	// существующие ряды обновляются и после достижения лимита
+	assert.NoError(t, p.Admit("ip:2", gauges("Alloc", "HeapInuse")))
+	assert.NoError(t, p.Admit("ip:2", []model.Metrics{{ID: "PollCount", MType: model.Counter}}))
+	// тот же ряд другого типа — новый ряд
+	assert.ErrorIs(t, p.Admit("ip:2", []model.Metrics{{ID: "Alloc", MType: model.Counter}}), series.ErrLimitExceeded)
+	assert.Equal(t, 3, p.Len())
+}
+
+func TestPolicy_PerClient(t *testing.T) {
+	p, err := series.New(series.Config{MaxPerClient: 2})
+	require.NoError(t, err)
+
+	assert.NoError(t, p.Admit("token:a", gauges("a1", "a2", "a1")))
+	// батч с новым рядом сверх бюджета отклоняется целиком
+	assert.ErrorIs(t, p.Admit("token:a", gauges("a1", "a3")), series.ErrLimitExceeded)
+	assert.Equal(t, 2, p.Len())
+
+	// ряды, созданные другим клиентом, обновлять можно
+	assert.NoError(t, p.Admit("token:a", gauges("a1", "a2")))
+	assert.NoError(t, p.Admit("token:b", gauges("b1", "a1")))
+}
+
+func TestPolicy_Release(t *testing.T) {
+	p, err := series.New(series.Config{MaxSeries: 2, MaxPerClient: 1})
+	require.NoError(t, err)
+	p.Seed(map[string]float64{"Alloc": 1}, nil)
+
+	// запись не удалась: ряд и бюджет клиента освобождаются
+	release, err := p.Reserve("ip:1", gauges("Alloc", "HeapInuse"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.Len())
+	release()
+	release()
+	assert.Equal(t, 1, p.Len())
+
+	// известный до запроса ряд не забывается
+	release, err = p.Reserve("ip:1", gauges("Alloc"))
+	require.NoError(t, err)
+	release()
+	assert.Equal(t, 1, p.Len())
+
+	assert.NoError(t, p.Admit("ip:1", gauges("HeapIdle")))
+	assert.ErrorIs(t, p.Admit("ip:1", gauges("HeapSys")), series.ErrLimitExceeded)
+}
+
+func TestPolicy_Nil(t *testing.T) {
+	var p *series.Policy
+	assert.NoError(t, p.Admit("ip:1", gauges("anything goes")))
+	release, err := p.Reserve("ip:1", gauges("anything goes"))
+	assert.NoError(t, err)
+	release()
+	assert.Zero(t, p.Len())
+}
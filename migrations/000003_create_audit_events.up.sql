@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    ts TIMESTAMP WITH TIME ZONE NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    metrics JSONB NOT NULL DEFAULT '[]',
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    agent_id VARCHAR(255) NOT NULL DEFAULT '',
+    token_id VARCHAR(64) NOT NULL DEFAULT '',
+    status INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_ts_idx ON audit_events (ts);
+CREATE INDEX IF NOT EXISTS audit_events_metrics_idx ON audit_events USING GIN (metrics jsonb_path_ops);